@@ -0,0 +1,180 @@
+package dbus
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// keys understood for each transport; guid is allowed everywhere
+var addressKeys = map[string][]string{
+	"unix": []string{"path", "abstract", "guid"},
+	"tcp":  []string{"host", "port", "family", "guid"},
+}
+
+// DBusAddress is a parsed server address.
+type DBusAddress struct {
+	Transport string            // "unix" or "tcp"
+	Params    map[string]string // decoded values, without guid
+	GUID      string            // the guid of the server, if given
+}
+
+// ParseAddress parses a single server address such as
+// "unix:path=/var/run/dbus/system_bus_socket", decoding the %XX escapes of
+// the values and checking that the keys the transport needs are present.
+// Errors are *AddressError.
+func ParseAddress(address string) (*DBusAddress, os.Error) {
+	transport, params, err := parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	guid := params["guid"]
+	params["guid"] = "", false
+	return &DBusAddress{transport, params, guid}, nil
+}
+
+// parseAddress splits a single server address into its transport and
+// decoded key/value pairs, checking the keys and values before anything is
+// dialed. Errors are *AddressError.
+func parseAddress(address string) (string, map[string]string, os.Error) {
+	colon := strings.Index(address, ":")
+	if colon < 0 {
+		return "", nil, &AddressError{address, len(address), "missing ':' after the transport"}
+	}
+	transport := address[0:colon]
+	keys, ok := addressKeys[transport]
+	if !ok {
+		return "", nil, &AddressError{address, 0, fmt.Sprintf("unsupported transport %q", transport)}
+	}
+
+	params := make(map[string]string)
+	pos := colon + 1
+	for _, kv := range strings.Split(address[colon+1:], ",", 0) {
+		eq := strings.Index(kv, "=")
+		if eq <= 0 {
+			return "", nil, &AddressError{address, pos, fmt.Sprintf("%q is not key=value", kv)}
+		}
+		key, value := kv[0:eq], kv[eq+1:]
+		decoded, i, reason := unescapeAddressValue(value)
+		if reason != "" {
+			return "", nil, &AddressError{address, pos + eq + 1 + i, reason}
+		}
+		if !contains(keys, key) {
+			return "", nil, &AddressError{address, pos, fmt.Sprintf("unsupported key %q for %s", key, transport)}
+		}
+		if _, dup := params[key]; dup {
+			return "", nil, &AddressError{address, pos, fmt.Sprintf("duplicate key %q", key)}
+		}
+		if i, reason := checkAddressValue(key, decoded); reason != "" {
+			if decoded != value {
+				// offsets in the decoded value do not map to the address
+				i = 0
+			}
+			return "", nil, &AddressError{address, pos + eq + 1 + i, reason}
+		}
+		params[key] = decoded
+		pos += len(kv) + 1
+	}
+
+	_, hasPath := params["path"]
+	_, hasAbstract := params["abstract"]
+	switch {
+	case transport == "unix" && hasPath == hasAbstract:
+		return "", nil, &AddressError{address, colon + 1, "unix needs exactly one of path and abstract"}
+	case transport == "tcp" && (params["host"] == "" || params["port"] == ""):
+		return "", nil, &AddressError{address, colon + 1, "tcp needs host and port"}
+	}
+	return transport, params, nil
+}
+
+// unescapeAddressValue decodes the %XX escapes of value. On error it
+// returns the offset in value of the bad escape and the reason.
+func unescapeAddressValue(value string) (string, int, string) {
+	if strings.Index(value, "%") < 0 {
+		return value, 0, ""
+	}
+	buff := make([]byte, 0, len(value))
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c == '%' {
+			if len(value) < i+3 {
+				return "", i, "incomplete escape"
+			}
+			n, err := strconv.Btoui64(value[i+1:i+3], 16)
+			if err != nil {
+				return "", i, fmt.Sprintf("invalid escape %q", value[i:i+3])
+			}
+			c = byte(n)
+			i += 2
+		}
+		buff = buff[0 : len(buff)+1]
+		buff[len(buff)-1] = c
+	}
+	return string(buff), 0, ""
+}
+
+// checkAddressValue returns the offset in value of the first invalid byte
+// and the reason, or "" if value is valid for key.
+func checkAddressValue(key string, value string) (int, string) {
+	switch key {
+	case "path":
+		if !strings.HasPrefix(value, "/") {
+			return 0, "path must start with '/'"
+		}
+	case "abstract":
+		// the dialer adds the leading NUL
+		if i := strings.Index(value, "\x00"); 0 <= i {
+			return i, "abstract must not contain NUL bytes"
+		}
+	case "port":
+		for i := 0; i < len(value); i++ {
+			if value[i] < '0' || '9' < value[i] {
+				return i, fmt.Sprintf("invalid character %q in port", value[i])
+			}
+		}
+		if n, err := strconv.Atoi(value); err != nil || n < 1 || 65535 < n {
+			return 0, "port must be between 1 and 65535"
+		}
+	case "host":
+		return checkHost(value)
+	}
+	return 0, ""
+}
+
+// checkHost accepts hostnames, IPv4 and IPv6 addresses.
+func checkHost(host string) (int, string) {
+	if strings.Index(host, ":") < 0 {
+		if 253 < len(host) {
+			return 253, "host name is longer than 253 characters"
+		}
+	}
+	label := 0 // start of the current label
+	for i := 0; i < len(host); i++ {
+		c := host[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-':
+			if i == label || i+1 == len(host) || host[i+1] == '.' {
+				return i, "host name labels must not start or end with '-'"
+			}
+		case c == '.' || c == ':':
+			if c == '.' && i == label {
+				return i, "empty label in host name"
+			}
+			label = i + 1
+		default:
+			return i, fmt.Sprintf("invalid character %q in host", c)
+		}
+	}
+	return 0, ""
+}
+
+func contains(strs []string, str string) bool {
+	for _, s := range strs {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
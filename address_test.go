@@ -0,0 +1,75 @@
+package dbus
+
+import (
+	"testing"
+)
+
+type addressCase struct {
+	address string
+	pos     int
+}
+
+func TestParseAddress(t *testing.T) {
+	transport, params, e := parseAddress("unix:abstract=/tmp/dbus-Xy,guid=0123")
+	if e != nil || transport != "unix" || params["abstract"] != "/tmp/dbus-Xy" || params["guid"] != "0123" {
+		t.Error("#1 Failed", e)
+	}
+	for _, addr := range []string{"tcp:host=localhost,port=1", "tcp:host=10.0.0.1,port=65535",
+		"tcp:host=::1,port=80,family=ipv6", "tcp:host=my-host.example.com.,port=80"} {
+		if _, _, e = parseAddress(addr); e != nil {
+			t.Error("#2 Failed", e)
+		}
+	}
+
+	bad := []addressCase{
+		addressCase{"unix", 4},
+		addressCase{"udp:host=a", 0},
+		addressCase{"unix:path=/a,bogus=1", 13},
+		addressCase{"unix:path=/a,path=/b", 13},
+		addressCase{"unix:path=relative", 10},
+		addressCase{"unix:abstract=a\x00b", 15},
+		addressCase{"unix:guid=1", 5},
+		addressCase{"unix:path", 5},
+		addressCase{"tcp:host=a_b,port=1", 10},
+		addressCase{"tcp:host=a..b,port=1", 11},
+		addressCase{"tcp:host=-a,port=1", 9},
+		addressCase{"tcp:host=a,port=1x", 17},
+		addressCase{"tcp:host=a,port=0", 16},
+		addressCase{"tcp:host=a,port=65536", 16},
+		addressCase{"tcp:host=a", 4},
+	}
+	for i, c := range bad {
+		_, _, e := parseAddress(c.address)
+		ae, ok := e.(*AddressError)
+		if !ok || ae.Pos != c.pos {
+			t.Error("#3 Failed", i, e)
+		}
+	}
+}
+
+func TestParseAddressExported(t *testing.T) {
+	addr, e := ParseAddress("unix:path=/tmp/dbus%2dtest%20a,guid=0123")
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	if addr.Transport != "unix" || addr.Params["path"] != "/tmp/dbus-test a" || addr.GUID != "0123" {
+		t.Error("#2 Failed", addr)
+	}
+	if _, ok := addr.Params["guid"]; ok || len(addr.Params) != 1 {
+		t.Error("#3 Failed", addr.Params)
+	}
+
+	bad := []addressCase{
+		addressCase{"unix:path=/a%2", 12},
+		addressCase{"unix:path=/a%zz", 12},
+		addressCase{"unix:path=%61b", 10},
+		addressCase{"tcp:host=a,port=%31x", 16},
+	}
+	for i, c := range bad {
+		_, e := ParseAddress(c.address)
+		ae, ok := e.(*AddressError)
+		if !ok || ae.Pos != c.pos {
+			t.Error("#4 Failed", i, e)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package dbus
+
+// Direction tells whether an audited message was sent or received.
+type Direction int
+
+const (
+	INCOMING Direction = iota
+	OUTGOING
+)
+
+func (d Direction) String() string {
+	if d == OUTGOING {
+		return "outgoing"
+	}
+	return "incoming"
+}
+
+// AddAuditHook makes the connection call fn with every message read from
+// the transport, as INCOMING, and every message it encodes to send, as
+// OUTGOING, for audit logging. fn runs synchronously on the goroutine
+// reading or sending the message, so it must not block, and it must not
+// modify the message. Hooks run in the order added, after the function set
+// with SetOnMessageReceived, and cannot be removed.
+func (p *Connection) AddAuditHook(fn func(direction Direction, msg *Message)) {
+	p.stateMutex.Lock()
+	defer p.stateMutex.Unlock()
+	hooks := make([]func(Direction, *Message), len(p.auditHooks)+1)
+	for i, other := range p.auditHooks {
+		hooks[i] = other
+	}
+	hooks[len(p.auditHooks)] = fn
+	p.auditHooks = hooks
+}
+
+func (p *Connection) audit(direction Direction, msg *Message) {
+	p.stateMutex.Lock()
+	hooks := p.auditHooks
+	p.stateMutex.Unlock()
+	for _, fn := range hooks {
+		fn(direction, msg)
+	}
+}
@@ -0,0 +1,40 @@
+package dbus
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestAuditHook(t *testing.T) {
+	server, p, e := NewMockServer()
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	defer p.Close()
+	server.Expect(MatchMethod("org.example.Foo", "Ping"), nil)
+
+	var mutex sync.Mutex
+	var seen []string
+	p.AddAuditHook(func(direction Direction, msg *Message) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if msg.Type == SIGNAL || msg.Member == "AddMatch" {
+			return
+		}
+		entries := make([]string, len(seen)+1)
+		for i, s := range seen {
+			entries[i] = s
+		}
+		entries[len(seen)] = direction.String() + " " + msg.Member
+		seen = entries
+	})
+	if _, e := p.CallTimeout(int64(1e9), "org.example", "/org/example", "org.example.Foo", "Ping"); e != nil {
+		t.Fatal("#2 Failed", e)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	// the reply carries no member
+	if len(seen) != 2 || seen[0] != "outgoing Ping" || seen[1] != "incoming " {
+		t.Error("#3 Failed", seen)
+	}
+}
@@ -2,11 +2,16 @@
 package dbus
 
 import(
+	"bytes"
 	"strings"
 	"container/list"
+	"crypto/sha1"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
-	"net"
+	"path"
+	"strconv"
 )
 
 var(
@@ -14,17 +19,203 @@ var(
 	ErrAuthFailed = os.NewError("AuthenticationFailed")
 )
 
+// Authenticator runs the SASL handshake over rw and returns the GUID the
+// server sends with OK. The built-in authenticators are ExternalAuth,
+// AnonymousAuth and CookieSHA1Auth; MultiMechanismAuth tries several in
+// order.
 type Authenticator interface{
-	Mechanism() string;
-	Authenticate() string;
+	Authenticate(rw io.ReadWriter) (guid string, err os.Error)
 }
 
-type AuthExternal struct{
+// AuthMechanism is a single SASL mechanism. Authenticators that are also
+// mechanisms can be combined with MultiMechanismAuth, which tries them in
+// one conversation, moving on when the server rejects one.
+type AuthMechanism interface{
+	Mechanism() string
+	// InitialResponse is sent with AUTH, hex encoded, or "" for none.
+	InitialResponse() string
 }
 
-func(p *AuthExternal) Mechanism() string{ return "EXTERNAL"}
-func(p *AuthExternal) Authenticate() string{
-	return fmt.Sprintf("%x", fmt.Sprintf("%d", os.Getuid()))
+// AuthChallenger is an AuthMechanism that answers challenges: each DATA
+// line of the server is passed to Challenge, decoded, and the response is
+// sent back as DATA.
+type AuthChallenger interface{
+	AuthMechanism
+	Challenge(data string) (string, os.Error)
+}
+
+// MultiMechanismAuth tries each of Mechanisms in order until the server
+// accepts one. Every entry must be a built-in authenticator, another
+// AuthMechanism or a MultiMechanismAuth.
+type MultiMechanismAuth struct{
+	Mechanisms []Authenticator
+}
+
+func(p MultiMechanismAuth) Authenticate(rw io.ReadWriter) (string, os.Error){
+	return authenticateWith(rw, p)
+}
+
+// authenticateWith runs a handshake trying the mechanisms of a.
+func authenticateWith(rw io.ReadWriter, a Authenticator) (string, os.Error){
+	auth := new(authState)
+	if err := auth.addAuthenticator(a); err != nil {
+		return "", err
+	}
+	return auth.Authenticate(rw)
+}
+
+// ExternalAuth authenticates with the credentials of the socket.
+type ExternalAuth struct{
+	// UID is sent as the authorization identity; "" means os.Getuid().
+	UID string
+	// NoIdentity sends no identity at all, leaving the server to use the
+	// socket credentials.
+	NoIdentity bool
+}
+
+func(p ExternalAuth) Authenticate(rw io.ReadWriter) (string, os.Error){
+	return authenticateWith(rw, p)
+}
+
+func(p ExternalAuth) Mechanism() string{ return "EXTERNAL"}
+func(p ExternalAuth) InitialResponse() string{
+	if p.NoIdentity {
+		return ""
+	}
+	uid := p.UID
+	if uid == "" {
+		uid = fmt.Sprintf("%d", os.Getuid())
+	}
+	return fmt.Sprintf("%x", uid)
+}
+
+// AnonymousAuth authenticates without credentials, for servers that allow
+// anonymous clients.
+type AnonymousAuth struct{
+	// Trace is an optional message for the server's logs.
+	Trace string
+}
+
+func(p AnonymousAuth) Authenticate(rw io.ReadWriter) (string, os.Error){
+	return authenticateWith(rw, p)
+}
+
+func(p AnonymousAuth) Mechanism() string{ return "ANONYMOUS"}
+func(p AnonymousAuth) InitialResponse() string{ return fmt.Sprintf("%x", p.Trace)}
+
+// CookieSHA1Auth proves that the client can read a secret cookie in the
+// keyring directory of the user the server expects, for servers that do
+// not see socket credentials.
+type CookieSHA1Auth struct{
+	// UID is the identity claimed; "" means os.Getuid().
+	UID string
+	// KeyringDir holds the cookies; "" means $HOME/.dbus-keyrings.
+	KeyringDir string
+}
+
+func(p CookieSHA1Auth) Authenticate(rw io.ReadWriter) (string, os.Error){
+	return authenticateWith(rw, p)
+}
+
+func(p CookieSHA1Auth) Mechanism() string{ return "DBUS_COOKIE_SHA1"}
+func(p CookieSHA1Auth) InitialResponse() string{
+	uid := p.UID
+	if uid == "" {
+		uid = fmt.Sprintf("%d", os.Getuid())
+	}
+	return fmt.Sprintf("%x", uid)
+}
+
+// Challenge answers "context cookie-id server-challenge" with
+// "client-challenge sha1(server-challenge:client-challenge:cookie)".
+func(p CookieSHA1Auth) Challenge(data string) (string, os.Error){
+	fields := strings.Split(data, " ", 0)
+	if len(fields) != 3 {
+		return "", os.NewError("DBUS_COOKIE_SHA1: malformed challenge " + data)
+	}
+	cookie, err := p.cookie(fields[0], fields[1])
+	if err != nil {
+		return "", err
+	}
+	challenge, err := randomHex(16)
+	if err != nil {
+		return "", err
+	}
+	h := sha1.New()
+	h.Write(strings.Bytes(fields[2] + ":" + challenge + ":" + cookie))
+	return fmt.Sprintf("%s %x", challenge, h.Sum()), nil
+}
+
+// cookie returns the cookie id of the keyring context.
+func(p CookieSHA1Auth) cookie(context string, id string) (string, os.Error){
+	if context == "" || strings.Index(context, "/") >= 0 || context[0] == '.' {
+		return "", os.NewError("DBUS_COOKIE_SHA1: invalid keyring " + context)
+	}
+	dir := p.KeyringDir
+	if dir == "" {
+		dir = path.Join(os.Getenv("HOME"), ".dbus-keyrings")
+	}
+	data, err := ioutil.ReadFile(path.Join(dir, context))
+	if err != nil {
+		return "", err
+	}
+	// each line is "id creation-time cookie"
+	for _, line := range strings.Split(string(data), "\n", 0) {
+		fields := strings.Split(strings.TrimSpace(line), " ", 0)
+		if len(fields) == 3 && fields[0] == id {
+			return fields[2], nil
+		}
+	}
+	return "", os.NewError("DBUS_COOKIE_SHA1: no cookie " + id + " in keyring " + context)
+}
+
+// randomHex returns n random bytes in hex.
+func randomHex(n int) (string, os.Error) {
+	f, err := os.Open("/dev/urandom", os.O_RDONLY, 0)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	b := make([]byte, n)
+	if _, err := io.ReadFull(f, b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", b), nil
+}
+
+// decodeHex decodes the hex encoding used for auth data.
+func decodeHex(s string) (string, os.Error) {
+	if len(s)%2 != 0 {
+		return "", os.NewError("odd length hex data")
+	}
+	b := make([]byte, len(s)/2)
+	for i := range b {
+		n, err := strconv.Btoui64(s[2*i:2*i+2], 16)
+		if err != nil {
+			return "", err
+		}
+		b[i] = byte(n)
+	}
+	return string(b), nil
+}
+
+// readProcUID is procUID, replaced by tests to act as in a container.
+var readProcUID = procUID
+
+// procUID returns the real uid listed in /proc/self/status, which can
+// differ from os.Getuid() inside some containers.
+func procUID() (string, os.Error) {
+	data, err := ioutil.ReadFile("/proc/self/status")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n", 0) {
+		if strings.HasPrefix(line, "Uid:") {
+			fields := strings.Split(strings.TrimSpace(line[4:]), "\t", 0)
+			return fields[0], nil
+		}
+	}
+	return "", os.NewError("no Uid in /proc/self/status")
 }
 
 type authStatus int
@@ -42,56 +233,105 @@ const (
 
 type authState struct{
 	status authStatus
-	auth Authenticator
+	auth AuthMechanism
 	authList list.List
-	conn net.Conn
+	conn io.ReadWriter
+	guid string // sent by the server with OK
+	in bytes.Buffer // received but not yet consumed
+	log func(string)
+	maxSize int64 // proposed with NEGOTIATE_SIZE if positive
+	agreedSize int64 // 0 unless the server agreed to a size
 }
 
-func(p *authState) AddAuthenticator(auth Authenticator){
-	p.authList.PushBack(auth)
+// addAuthenticator queues the mechanisms of a, in order.
+func(p *authState) addAuthenticator(a Authenticator) os.Error{
+	switch a := a.(type) {
+	case MultiMechanismAuth:
+		return p.addMechanisms(a.Mechanisms)
+	case *MultiMechanismAuth:
+		return p.addMechanisms(a.Mechanisms)
+	case AuthMechanism:
+		p.authList.PushBack(a)
+		return nil
+	}
+	return os.NewError(fmt.Sprintf("MultiMechanismAuth: %T is not a SASL mechanism", a))
 }
 
-func(p *authState) _NextAuthenticator(){
+func(p *authState) addMechanisms(mechanisms []Authenticator) os.Error{
+	for _, a := range mechanisms {
+		if err := p.addAuthenticator(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func(p *authState) nextAuthenticator(){
 	if p.authList.Len() == 0{
 		p.auth = nil
 		return
 	}
 
-	p.auth,_ = p.authList.Front().Value.(Authenticator)
+	p.auth,_ = p.authList.Front().Value.(AuthMechanism)
 	p.authList.Remove(p.authList.Front())
-	msg := strings.Join([]string{"AUTH", p.auth.Mechanism(), p.auth.Authenticate()}, " ")
-	p._Send(msg)
+	msg := strings.Join([]string{"AUTH", p.auth.Mechanism()}, " ")
+	if resp := p.auth.InitialResponse(); resp != "" {
+		msg = strings.Join([]string{msg, resp}, " ")
+	}
+	if p.log != nil {
+		p.log("auth: " + msg)
+	}
+	p.send(msg)
 }
 
-func(p *authState) _NextMessage() []string{
-	b := make([]byte, 4096)
-	p.conn.Read(b)
-	retstr := string(b)
-	return strings.Split(strings.TrimSpace(retstr), " ", 0)
+// nextMessage returns the next line from the server split into words.
+// Bytes following the line stay in p.in.
+func(p *authState) nextMessage() ([]string, os.Error){
+	for {
+		data := p.in.Bytes()
+		if i := bytes.Index(data, strings.Bytes("\r\n")); 0 <= i {
+			line := string(data[0:i])
+			p.in.Read(make([]byte, i+2)) // remove the line
+			return strings.Split(strings.TrimSpace(line), " ", 0), nil
+		}
+		b := make([]byte, 4096)
+		n, err := p.conn.Read(b)
+		if err != nil {
+			return nil, err
+		}
+		p.in.Write(b[0:n])
+	}
+	return nil, nil
 }
 
-func(p *authState) _Send(msg string){
+func(p *authState) send(msg string){
 	p.conn.Write(strings.Bytes(msg + "\r\n"));
 }
 
-func(p *authState) Authenticate(conn net.Conn) os.Error{
+// Authenticate runs the handshake over conn, which is usually the socket
+// but can be anything carrying the lines, and returns the GUID of the
+// server.
+func(p *authState) Authenticate(conn io.ReadWriter) (string, os.Error){
 	p.conn = conn
 	p.conn.Write(strings.Bytes("\x00"))
-	p._NextAuthenticator()
+	p.nextAuthenticator()
 	p.status = STARTING
 	for ;p.status != AUTHENTICATED;{
-		if nil == p.auth { return ErrAuthFailed}
-		if err := p._NextState(); err != nil{ return err}
+		if nil == p.auth { return "", ErrAuthFailed}
+		if err := p.nextState(); err != nil{ return "", err}
 	}
-	return nil
+	return p.guid, nil
 }
 
-func(p *authState) _NextState() (err os.Error){
-	nextMsg := p._NextMessage()
+func(p *authState) nextState() (err os.Error){
+	nextMsg, err := p.nextMessage()
+	if err != nil {
+		return err
+	}
 	
 	if STARTING == p.status {
 		switch nextMsg[0]{
-		case "CONTINUE":
+		case "CONTINUE", "DATA", "REJECTED", "ERROR":
 			p.status = WAITING_FOR_DATA
 		case "OK":
 			p.status = WAITING_FOR_OK
@@ -100,57 +340,123 @@ func(p *authState) _NextState() (err os.Error){
 
 	switch p.status{
 	case WAITING_FOR_DATA:
-		err = p._WaitingForData(nextMsg)
+		err = p.waitingForData(nextMsg)
 	case WAITING_FOR_OK:
-		err = p._WaitingForOK(nextMsg)
+		err = p.waitingForOK(nextMsg)
 	case WAITING_FOR_REJECT:
-		err = p._WaitingForReject(nextMsg)
+		err = p.waitingForReject(nextMsg)
 	}
 
 	return;
 }
 
-func(p *authState) _WaitingForData(msg []string) os.Error{
+func(p *authState) waitingForData(msg []string) os.Error{
 	switch msg[0]{
 	case "DATA":
-		return ErrAuthUnknownCommand
+		if challenger, ok := p.auth.(AuthChallenger); ok {
+			return p.answer(challenger, msg)
+		}
+		if p.auth.InitialResponse() != "" {
+			return ErrAuthUnknownCommand
+		}
+		// the server asks for the identity we did not send; send none
+		p.send("DATA")
 	case "REJECTED":
-		p._NextAuthenticator()
+		if p.log != nil {
+			p.log("auth: rejected")
+		}
+		p.nextAuthenticator()
 		p.status = WAITING_FOR_DATA
 	case "OK":
-		p._Send("BEGIN")
-		p.status = AUTHENTICATED
+		return p.begin(msg)
+	case "ERROR":
+		p.send("CANCEL")
+		p.status = WAITING_FOR_REJECT
 	default:
-		p._Send("ERROR")
+		p.send("ERROR")
 		p.status = WAITING_FOR_DATA
 	}
 	return nil
 }
 
-func(p *authState) _WaitingForOK(msg []string) os.Error{
+// answer sends the response of challenger to the DATA line msg, or
+// CANCEL if it has none, which makes the server reject the mechanism.
+func(p *authState) answer(challenger AuthChallenger, msg []string) os.Error{
+	data := ""
+	if 1 < len(msg) {
+		var err os.Error
+		if data, err = decodeHex(msg[1]); err != nil {
+			p.send("ERROR")
+			return nil
+		}
+	}
+	resp, err := challenger.Challenge(data)
+	if err != nil {
+		if p.log != nil {
+			p.log("auth: " + err.String())
+		}
+		p.send("CANCEL")
+		return nil
+	}
+	p.send(fmt.Sprintf("DATA %x", resp))
+	return nil
+}
+
+func(p *authState) waitingForOK(msg []string) os.Error{
 	switch msg[0]{
 	case "OK":
-		p._Send("BEGIN")
-		p.status = AUTHENTICATED
-	case "REJECT":
-		p._NextAuthenticator()
+		return p.begin(msg)
+	case "REJECTED":
+		p.nextAuthenticator()
 		p.status = WAITING_FOR_DATA
 	case "DATA", "ERROR":
-		p._Send("CANCEL")
+		p.send("CANCEL")
 		p.status = WAITING_FOR_REJECT
 	default:
-		p._Send("ERROR")
+		p.send("ERROR")
 		p.status = WAITING_FOR_OK
 	}
 
 	return nil
 }
 
-func(p *authState) _WaitingForReject(msg []string) os.Error{
+// begin ends the handshake after the OK line msg, keeping the GUID of the
+// server, and first proposing a maximum message size if
+// maxSize is set. NEGOTIATE_SIZE is an extension for peer-to-peer
+// connections; a server that does not know it answers ERROR and no limit
+// is agreed.
+func(p *authState) begin(msg []string) os.Error{
+	if 1 < len(msg) {
+		p.guid = msg[1]
+	}
+	if 0 < p.maxSize {
+		p.send(fmt.Sprintf("NEGOTIATE_SIZE %d", p.maxSize))
+		msg, err := p.nextMessage()
+		if err != nil {
+			return err
+		}
+		if msg[0] == "AGREE_SIZE" && len(msg) == 2 {
+			if n, err := strconv.Atoi64(msg[1]); err == nil && 0 < n {
+				p.agreedSize = n
+				if p.maxSize < n {
+					p.agreedSize = p.maxSize
+				}
+			}
+		}
+		if p.agreedSize == 0 && p.log != nil {
+			p.log("auth: no message size agreed: " + strings.Join(msg, " "))
+		}
+	}
+	p.send("BEGIN")
+	p.status = AUTHENTICATED
+	return nil
+}
+
+func(p *authState) waitingForReject(msg []string) os.Error{
 	switch msg[0]{
-	case "REJECT":
-		p._NextAuthenticator()
-		p.status = WAITING_FOR_OK
+	case "REJECTED":
+		p.nextAuthenticator()
+		p.status = WAITING_FOR_DATA
 	default:
 		return ErrAuthUnknownCommand
 	}
@@ -0,0 +1,267 @@
+package dbus
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+// fakeAuthServer answers the client's lines with replies, in order, and
+// returns the lines it read.
+func fakeAuthServer(t *testing.T, name string, replies []string, client func(conn net.Conn)) []string {
+	l, path := listenTest(t)
+	defer os.Remove(path)
+	defer l.Close()
+	lines := make(chan []string)
+	go func() {
+		server, e := l.Accept()
+		if e != nil {
+			lines <- nil
+			return
+		}
+		defer server.Close()
+		r := bufio.NewReader(server)
+		read := make([]string, len(replies)+1)
+		for i := range read {
+			line, e := r.ReadString('\n')
+			if e != nil {
+				break
+			}
+			read[i] = strings.TrimSpace(strings.TrimLeft(line, "\x00"))
+			if i < len(replies) {
+				server.Write(strings.Bytes(replies[i] + "\r\n"))
+			}
+		}
+		lines <- read
+	}()
+	conn, e := net.Dial("unix", "", path)
+	if e != nil {
+		t.Fatal("fakeAuthServer "+name+":", e)
+	}
+	defer conn.Close()
+	client(conn)
+	return <-lines
+}
+
+func TestNegotiateSize(t *testing.T) {
+	auth := new(authState)
+	auth.addAuthenticator(ExternalAuth{UID: "0"})
+	auth.maxSize = 8192
+	lines := fakeAuthServer(t, "negotiate-size", []string{"OK 1234", "AGREE_SIZE 4096"}, func(conn net.Conn) {
+		if _, e := auth.Authenticate(conn); e != nil {
+			t.Error("#1 Failed", e)
+		}
+	})
+	if lines[1] != "NEGOTIATE_SIZE 8192" || lines[2] != "BEGIN" || auth.agreedSize != 4096 {
+		t.Error("#2 Failed", lines, auth.agreedSize)
+	}
+
+	// a server without the extension
+	auth = new(authState)
+	auth.addAuthenticator(ExternalAuth{UID: "0"})
+	auth.maxSize = 8192
+	lines = fakeAuthServer(t, "negotiate-size-unknown", []string{"OK 1234", "ERROR"}, func(conn net.Conn) {
+		if _, e := auth.Authenticate(conn); e != nil {
+			t.Error("#3 Failed", e)
+		}
+	})
+	if lines[2] != "BEGIN" || auth.agreedSize != 0 {
+		t.Error("#4 Failed", lines, auth.agreedSize)
+	}
+
+	// no proposal without a size
+	auth = new(authState)
+	auth.addAuthenticator(ExternalAuth{UID: "0"})
+	lines = fakeAuthServer(t, "negotiate-size-off", []string{"OK 1234"}, func(conn net.Conn) {
+		auth.Authenticate(conn)
+	})
+	if lines[1] != "BEGIN" {
+		t.Error("#5 Failed", lines)
+	}
+}
+
+func TestAuthRejected(t *testing.T) {
+	auth := new(authState)
+	auth.addAuthenticator(ExternalAuth{UID: "0"})
+	auth.addAuthenticator(AnonymousAuth{})
+	var guid string
+	lines := fakeAuthServer(t, "rejected", []string{"ERROR", "REJECTED ANONYMOUS", "OK 0123abcd"}, func(conn net.Conn) {
+		var e os.Error
+		if guid, e = auth.Authenticate(conn); e != nil {
+			t.Error("#1 Failed", e)
+		}
+	})
+	if lines[0] != fmt.Sprintf("AUTH EXTERNAL %x", "0") || lines[1] != "CANCEL" || lines[2] != "AUTH ANONYMOUS" || lines[3] != "BEGIN" {
+		t.Error("#2 Failed", lines)
+	}
+	if guid != "0123abcd" {
+		t.Error("#3 Failed", guid)
+	}
+
+	// no mechanism left
+	auth = new(authState)
+	auth.addAuthenticator(AnonymousAuth{})
+	fakeAuthServer(t, "rejected-all", []string{"REJECTED EXTERNAL"}, func(conn net.Conn) {
+		if _, e := auth.Authenticate(conn); e != ErrAuthFailed {
+			t.Error("#4 Failed", e)
+		}
+	})
+}
+
+func TestExternalAuthFallback(t *testing.T) {
+	saved := readProcUID
+	defer func() { readProcUID = saved }()
+	readProcUID = func() (string, os.Error) { return "4242", nil }
+
+	// EXTERNAL with our uid, then with no identity, then with the /proc uid
+	replies := []string{"REJECTED EXTERNAL", "REJECTED EXTERNAL", "OK 1234"}
+	lines := fakeAuthServer(t, "external-fallback", replies, func(conn net.Conn) {
+		p := newConnection("test:", conn)
+		if e := p.authenticate(nil); e != nil {
+			t.Error("#1 Failed", e)
+		}
+		if p.GUID() != "1234" {
+			t.Error("#2 Failed", p.GUID())
+		}
+	})
+	if lines[0] != fmt.Sprintf("AUTH EXTERNAL %x", fmt.Sprintf("%d", os.Getuid())) || lines[1] != "AUTH EXTERNAL" ||
+		lines[2] != fmt.Sprintf("AUTH EXTERNAL %x", "4242") || lines[3] != "BEGIN" {
+		t.Error("#3 Failed", lines)
+	}
+
+	// no third attempt when /proc agrees with os.Getuid()
+	readProcUID = func() (string, os.Error) { return fmt.Sprintf("%d", os.Getuid()), nil }
+	lines = fakeAuthServer(t, "external-fallback-same", []string{"REJECTED EXTERNAL", "REJECTED EXTERNAL"}, func(conn net.Conn) {
+		p := newConnection("test:", conn)
+		if e := p.authenticate(nil); e != ErrAuthFailed {
+			t.Error("#4 Failed", e)
+		}
+	})
+	if lines[1] != "AUTH EXTERNAL" || lines[2] != "" {
+		t.Error("#5 Failed", lines)
+	}
+}
+
+func TestMessageTooLarge(t *testing.T) {
+	p := new(Connection)
+	p.agreedSize = 64
+	msg := NewMessage()
+	msg.Type = SIGNAL
+	msg.Path = "/org/example"
+	msg.Iface = "org.example.Foo"
+	msg.Member = "Bar"
+	if _, e := p.encode(msg); e != nil {
+		t.Error("#1 Failed", e)
+	}
+	msg.Sig = "s"
+	msg.Params.Push(strings.Repeat("x", 64))
+	if _, e := p.encode(msg); e != ErrMessageTooLarge {
+		t.Error("#2 Failed", e)
+	}
+}
+
+func TestCookieSHA1Auth(t *testing.T) {
+	dir := testPath()
+	os.RemoveAll(dir)
+	if e := os.MkdirAll(dir, 0700); e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	defer os.RemoveAll(dir)
+	if e := ioutil.WriteFile(dir+"/org_freedesktop_general", strings.Bytes("7 1300000000 0badc0ffee\n"), 0600); e != nil {
+		t.Fatal("#2 Failed", e)
+	}
+
+	auth := new(authState)
+	auth.addAuthenticator(CookieSHA1Auth{UID: "1000", KeyringDir: dir})
+	challenge := fmt.Sprintf("DATA %x", "org_freedesktop_general 7 serverchallenge")
+	lines := fakeAuthServer(t, "cookie-sha1", []string{challenge, "OK 1234"}, func(conn net.Conn) {
+		if _, e := auth.Authenticate(conn); e != nil {
+			t.Error("#3 Failed", e)
+		}
+	})
+	if lines[0] != fmt.Sprintf("AUTH DBUS_COOKIE_SHA1 %x", "1000") || lines[2] != "BEGIN" {
+		t.Error("#4 Failed", lines)
+	}
+	if !strings.HasPrefix(lines[1], "DATA ") {
+		t.Fatal("#5 Failed", lines[1])
+	}
+	data, e := decodeHex(lines[1][5:])
+	fields := strings.Split(data, " ", 0)
+	if e != nil || len(fields) != 2 {
+		t.Fatal("#5 Failed", lines[1], e)
+	}
+	h := sha1.New()
+	h.Write(strings.Bytes("serverchallenge:" + fields[0] + ":0badc0ffee"))
+	if fields[1] != fmt.Sprintf("%x", h.Sum()) {
+		t.Error("#6 Failed", data)
+	}
+
+	// an unknown cookie cancels the mechanism and the next one is tried
+	auth = new(authState)
+	auth.addAuthenticator(CookieSHA1Auth{UID: "1000", KeyringDir: dir})
+	auth.addAuthenticator(AnonymousAuth{})
+	challenge = fmt.Sprintf("DATA %x", "org_freedesktop_general 8 serverchallenge")
+	lines = fakeAuthServer(t, "cookie-sha1-unknown", []string{challenge, "REJECTED ANONYMOUS", "OK 1234"}, func(conn net.Conn) {
+		if _, e := auth.Authenticate(conn); e != nil {
+			t.Error("#7 Failed", e)
+		}
+	})
+	if lines[1] != "CANCEL" || lines[2] != "AUTH ANONYMOUS" || lines[3] != "BEGIN" {
+		t.Error("#8 Failed", lines)
+	}
+}
+
+// fixedAuth is a custom Authenticator that reads one line and reports guid.
+type fixedAuth struct {
+	guid string
+}
+
+func (p fixedAuth) Authenticate(rw io.ReadWriter) (string, os.Error) {
+	rw.Write(strings.Bytes("\x00AUTH CUSTOM\r\n"))
+	b := make([]byte, 64)
+	if _, e := rw.Read(b); e != nil {
+		return "", e
+	}
+	return p.guid, nil
+}
+
+func TestMultiMechanismAuth(t *testing.T) {
+	auth := MultiMechanismAuth{[]Authenticator{ExternalAuth{UID: "0"}, AnonymousAuth{}}}
+	lines := fakeAuthServer(t, "multi", []string{"REJECTED ANONYMOUS", "OK 1234"}, func(conn net.Conn) {
+		guid, e := auth.Authenticate(conn)
+		if e != nil || guid != "1234" {
+			t.Error("#1 Failed", guid, e)
+		}
+	})
+	if lines[0] != fmt.Sprintf("AUTH EXTERNAL %x", "0") || lines[1] != "AUTH ANONYMOUS" || lines[2] != "BEGIN" {
+		t.Error("#2 Failed", lines)
+	}
+
+	// a custom Authenticator cannot share the conversation
+	auth = MultiMechanismAuth{[]Authenticator{AnonymousAuth{}, fixedAuth{}}}
+	if _, e := auth.Authenticate(new(bytes.Buffer)); e == nil {
+		t.Error("#3 Failed")
+	}
+}
+
+func TestCustomAuthenticator(t *testing.T) {
+	lines := fakeAuthServer(t, "custom", []string{"OK 5678"}, func(conn net.Conn) {
+		p := newConnection("test:", conn)
+		if e := p.authenticate(fixedAuth{"5678"}); e != nil {
+			t.Error("#1 Failed", e)
+		}
+		if p.GUID() != "5678" {
+			t.Error("#2 Failed", p.GUID())
+		}
+	})
+	if lines[0] != "AUTH CUSTOM" {
+		t.Error("#3 Failed", lines)
+	}
+}
@@ -0,0 +1,135 @@
+package dbus
+
+import (
+	"bytes"
+	"container/vector"
+	"os"
+	"sync"
+)
+
+// SignalSpec describes one signal of a batch passed to EmitBatch.
+type SignalSpec struct {
+	Iface *Interface
+	Name  string
+	Args  []interface{}
+}
+
+// EmitBatch emits signals in order with a single write, so no message
+// from another sender on this connection can come between them. Nothing
+// is sent if any of the signals is invalid.
+func (p *Connection) EmitBatch(signals []SignalSpec) os.Error {
+	if err := p.checkReady(); err != nil {
+		return err
+	}
+
+	var buff bytes.Buffer
+	for _, spec := range signals {
+		params := new(vector.Vector)
+		for _, arg := range spec.Args {
+			params.Push(arg)
+		}
+		msg, err := newSignal(spec.Iface, spec.Name, params)
+		if err != nil {
+			return err
+		}
+		p.assignSerial(msg)
+		if err = msg.IsValid(); err != nil {
+			return err
+		}
+		data, err := p.encode(msg)
+		if err != nil {
+			return err
+		}
+		buff.Write(data)
+	}
+	if buff.Len() == 0 {
+		return nil
+	}
+	return p.write(buff.Bytes())
+}
+
+// Batch collects the method calls Atomic sends together.
+type Batch struct {
+	dest     string
+	path     string
+	calls    *vector.Vector // of *MessageBuilder
+	rollback func(*Batch, os.Error)
+	// Replies holds, once Atomic has sent the calls, the reply to each
+	// call in the order they were added, or nil for the calls that failed.
+	Replies []*Message
+}
+
+// Call adds a call of method of iface on the object of the batch. The
+// signature is derived from args as by CallTimeout; arguments can also be
+// added to the returned builder.
+func (p *Batch) Call(iface string, method string, args ...) *MessageBuilder {
+	b := newCallFromArgs(p.dest, p.path, iface, method, args)
+	p.calls.Push(b)
+	return b
+}
+
+// Len returns the number of calls in the batch.
+func (p *Batch) Len() int { return p.calls.Len() }
+
+// OnRollback sets the function Atomic calls when some calls of the batch
+// failed, with the error it is about to return. Replies tells which calls
+// succeeded and may need undoing.
+func (p *Batch) OnRollback(fn func(batch *Batch, err os.Error)) { p.rollback = fn }
+
+// Atomic sends together the method calls fn adds to a batch for the object
+// at path of dest and waits for all the replies. D-Bus has no
+// transactions: if any call fails, the others are not undone, but the
+// rollback function set with Batch.OnRollback is called, and Atomic
+// returns a *BatchError. Calls still running when cancel is closed fail
+// with ErrCanceled. An error returned by fn is returned as is and nothing
+// is sent.
+func (p *Connection) Atomic(cancel <-chan bool, dest string, path string, fn func(batch *Batch) os.Error) os.Error {
+	batch := &Batch{dest: dest, path: path, calls: new(vector.Vector)}
+	if err := fn(batch); err != nil {
+		return err
+	}
+	if batch.Len() == 0 {
+		return nil
+	}
+
+	var mutex sync.Mutex
+	replies := make([]*Message, batch.Len())
+	fns := make([]func(*Connection) os.Error, batch.Len())
+	for i := range fns {
+		call := batch.calls.At(i).(*MessageBuilder)
+		if call.cancel == nil {
+			call.WithCancel(cancel)
+		}
+		fns[i] = func(i int) func(*Connection) os.Error {
+			return func(conn *Connection) os.Error {
+				reply, err := call.Call(conn)
+				mutex.Lock()
+				replies[i] = reply
+				mutex.Unlock()
+				return err
+			}
+		}(i)
+	}
+	errs := p.Pipelined(cancel, fns)
+
+	// calls reported canceled may still be running
+	mutex.Lock()
+	batch.Replies = make([]*Message, len(replies))
+	failed := false
+	for i, err := range errs {
+		if err == nil {
+			batch.Replies[i] = replies[i]
+		} else {
+			failed = true
+		}
+	}
+	mutex.Unlock()
+	if !failed {
+		return nil
+	}
+	err := &BatchError{errs}
+	if batch.rollback != nil {
+		batch.rollback(batch, err)
+	}
+	return err
+}
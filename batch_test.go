@@ -0,0 +1,109 @@
+package dbus
+
+import (
+	"container/vector"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAtomic(t *testing.T) {
+	server, p, e := NewMockServer()
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	defer p.Close()
+	server.Expect(MatchMethod("org.example.Foo", "Set"), nil)
+	failure := NewMessage()
+	failure.Type = ERROR
+	failure.ErrorName = "org.example.Error.Denied"
+	server.Expect(MatchMethod("org.example.Foo", "Lock"), failure)
+
+	e = p.Atomic(nil, "org.example", "/org/example", func(batch *Batch) os.Error {
+		batch.Call("org.example.Foo", "Set", "a", int32(1))
+		batch.Call("org.example.Foo", "Set", "b", int32(2))
+		batch.OnRollback(func(*Batch, os.Error) { t.Error("#2 Failed") })
+		return nil
+	})
+	if e != nil {
+		t.Error("#3 Failed", e)
+	}
+
+	var rolledBack *Batch
+	e = p.Atomic(nil, "org.example", "/org/example", func(batch *Batch) os.Error {
+		batch.Call("org.example.Foo", "Set", "a", int32(1))
+		batch.Call("org.example.Foo", "Lock")
+		batch.OnRollback(func(b *Batch, err os.Error) { rolledBack = b })
+		return nil
+	})
+	berr, ok := e.(*BatchError)
+	if !ok || len(berr.Errors) != 2 || berr.Errors[0] != nil || berr.Errors[1] == nil {
+		t.Fatal("#4 Failed", e)
+	}
+	if rolledBack == nil || rolledBack.Replies[0] == nil || rolledBack.Replies[1] != nil {
+		t.Error("#5 Failed", rolledBack)
+	}
+
+	stop := os.NewError("stop")
+	e = p.Atomic(nil, "org.example", "/org/example", func(batch *Batch) os.Error {
+		batch.Call("org.example.Foo", "Set", "c", int32(3))
+		return stop
+	})
+	if e != stop {
+		t.Error("#6 Failed", e)
+	}
+	calls := 0
+	for _, msg := range server.Received() {
+		if msg.Member == "Set" {
+			calls++
+		}
+	}
+	if calls != 3 {
+		t.Error("#7 Failed", calls)
+	}
+}
+
+func TestEmitBatch(t *testing.T) {
+	server, p, e := NewMockServer()
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	defer p.Close()
+	intro, _ := NewIntrospect(introStr)
+	obj := &Object{dest: "", path: "/org/freedesktop/sample_object", intro: intro}
+	iface, _ := p.Interface(obj, "org.freedesktop.SampleInterface")
+
+	// nothing is sent when a signal is invalid
+	e = p.EmitBatch([]SignalSpec{
+		SignalSpec{iface, "Changed", []interface{}{true}},
+		SignalSpec{iface, "Unknown", nil},
+	})
+	if e == nil {
+		t.Error("#2 Failed")
+	}
+	e = p.EmitBatch([]SignalSpec{
+		SignalSpec{iface, "Changed", []interface{}{true}},
+		SignalSpec{iface, "Changed", []interface{}{false}},
+	})
+	if e != nil {
+		t.Error("#3 Failed", e)
+	}
+
+	signals := new(vector.Vector)
+	for i := 0; i < 100 && signals.Len() < 2; i++ {
+		time.Sleep(10e6)
+		signals = new(vector.Vector)
+		for _, msg := range server.Received() {
+			if msg.Type == SIGNAL {
+				signals.Push(msg)
+			}
+		}
+	}
+	if signals.Len() != 2 {
+		t.Fatal("#4 Failed", signals.Len())
+	}
+	first, second := signals.At(0).(*Message), signals.At(1).(*Message)
+	if first.Params.At(0).(bool) != true || second.Params.At(0).(bool) != false || first.Serial() == second.Serial() {
+		t.Error("#5 Failed", first, second)
+	}
+}
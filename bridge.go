@@ -0,0 +1,122 @@
+package dbus
+
+import (
+	"os"
+	"sync"
+)
+
+// BridgeStats holds counters of a Bridge.
+type BridgeStats struct {
+	Forwarded uint64 // signals sent on the destination
+	Filtered  uint64 // signals the transform dropped
+	Looped    uint64 // signals sent by the bridged connections themselves
+	Failed    uint64 // signals that could not be sent
+	Overflow  int    // signals dropped because the queue was full
+}
+
+// Bridge re-emits the signals matching a rule on one connection on
+// another, e.g. to pass session bus signals on to the system bus.
+type Bridge struct {
+	sub       *Subscription
+	src       *Connection
+	dst       *Connection
+	transform func(*Message) *Message
+	mutex     sync.Mutex
+	stats     BridgeStats
+	done      chan bool
+}
+
+// NewBridge forwards the signals matching mr on src to dst, queueing at
+// most limit signals. transform, if not nil, gets a copy of each signal
+// and returns the signal to emit, after changing its path or interface for
+// instance, or nil to drop it. Signals sent by src or dst themselves are
+// never forwarded, so two bridges in opposite directions do not bounce
+// signals back and forth.
+func NewBridge(src *Connection, mr *MatchRule, dst *Connection, transform func(*Message) *Message, limit int) *Bridge {
+	p := &Bridge{src: src, dst: dst, transform: transform, done: make(chan bool)}
+	p.sub = src.Subscribe(mr, limit)
+	go p.run()
+	return p
+}
+
+func (p *Bridge) run() {
+	for msg := range p.sub.C {
+		p.forward(msg)
+	}
+	close(p.done)
+}
+
+func (p *Bridge) forward(msg *Message) {
+	if msg.Sender != "" && (msg.Sender == p.src.UniqueName() || msg.Sender == p.dst.UniqueName()) {
+		p.count(&p.stats.Looped)
+		return
+	}
+	out := copyMessage(msg)
+	out.serial = getNewSerial()
+	out.Sender = ""
+	out.Dest = ""
+	if p.transform != nil {
+		if out = p.transform(out); out == nil {
+			p.count(&p.stats.Filtered)
+			return
+		}
+	}
+	if err := p.dst.send(out); err != nil {
+		p.dst.logf("bridge: cannot forward %s.%s: %s", out.Iface, out.Member, err)
+		p.count(&p.stats.Failed)
+		return
+	}
+	p.count(&p.stats.Forwarded)
+}
+
+func (p *Bridge) count(counter *uint64) {
+	p.mutex.Lock()
+	*counter++
+	p.mutex.Unlock()
+}
+
+// Stats returns the counters of the bridge.
+func (p *Bridge) Stats() BridgeStats {
+	p.mutex.Lock()
+	stats := p.stats
+	p.mutex.Unlock()
+	stats.Overflow = p.sub.Dropped()
+	return stats
+}
+
+// Close stops the bridge. Signals already queued are still forwarded for
+// up to drain nanoseconds. Close returns when the bridge has stopped.
+func (p *Bridge) Close(drain int64) {
+	p.sub.Remove(drain)
+	<-p.done
+}
+
+// FORWARD_SIGNAL_QUEUE is the number of signals ForwardSignal queues while
+// the destination is slow.
+const FORWARD_SIGNAL_QUEUE = 256
+
+// ForwardSignal re-emits on dst the signals matching filter on src, with
+// the same path, interface, member and body, as sent by p: the sender is
+// the unique name of p, the relay, which is usually src or dst. Calling
+// cancel stops the forwarding; the signals still queued are dropped.
+func (p *Connection) ForwardSignal(src *Connection, filter MatchRule, dst *Connection) (cancel func(), err os.Error) {
+	if err := src.checkReady(); err != nil {
+		return nil, err
+	}
+	if err := dst.checkReady(); err != nil {
+		return nil, err
+	}
+	switch filter.Type {
+	case "":
+		filter.Type = "signal"
+	case "signal":
+	default:
+		return nil, os.NewError("ForwardSignal: the rule does not match signals")
+	}
+	relay := p.UniqueName()
+	bridge := NewBridge(src, &filter, dst, func(msg *Message) *Message {
+		msg.Sender = relay
+		return msg
+	}, FORWARD_SIGNAL_QUEUE)
+	return func() { bridge.Close(0) }, nil
+}
@@ -0,0 +1,227 @@
+package dbus
+
+import (
+	"bytes"
+	"container/vector"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBridge(t *testing.T) {
+	src := fakeService(t, func(call *Message) *Message {
+		msg := NewMessage()
+		msg.Type = METHOD_RETURN
+		return msg
+	})
+	defer src.Close()
+	src.uniqName = ":1.1"
+
+	dst, server := dialTest(t)
+	defer dst.Close()
+	defer server.Close()
+	dst.ready = true
+	dst.uniqName = ":2.1"
+
+	rule := &MatchRule{Type: "signal", Interface: "org.example.Foo"}
+	bridge := NewBridge(src, rule, dst, func(msg *Message) *Message {
+		if msg.Member == "Drop" {
+			return nil
+		}
+		msg.Path = "/bridged" + msg.Path
+		return msg
+	}, 10)
+
+	signal := func(sender, member string) *Message {
+		msg := NewMessage()
+		msg.Type = SIGNAL
+		msg.Sender = sender
+		msg.Dest = ":1.1"
+		msg.Path = "/org/example"
+		msg.Iface = "org.example.Foo"
+		msg.Member = member
+		msg.Sig = "s"
+		msg.Params.Push(member)
+		return msg
+	}
+	src.InjectMessage(signal(":1.9", "Drop"))
+	src.InjectMessage(signal(":1.1", "Echo")) // emitted by src itself
+	src.InjectMessage(signal(":2.1", "Echo")) // emitted by dst on the same bus
+	orig := signal(":1.9", "Bar")
+	orig.serial = 1000 // far from the serials dst has used
+	src.InjectMessage(orig)
+
+	buff := bytes.NewBuffer([]byte{})
+	var msg *Message
+	for msg == nil {
+		var n int
+		var e os.Error
+		if msg, n, e = DecodeMessage(buff.Bytes()); e == nil {
+			buff.Next(n)
+			break
+		}
+		msg = nil
+		chunk := make([]byte, 256)
+		m, e := server.Read(chunk)
+		if e != nil {
+			t.Fatal("#4 Failed", e)
+		}
+		buff.Write(chunk[0:m])
+	}
+	if msg.Member != "Bar" || msg.Path != "/bridged/org/example" || msg.Dest != "" || msg.Params.At(0).(string) != "Bar" {
+		t.Error("#5 Failed", msg)
+	}
+	if msg.Serial() == orig.Serial() || orig.Path != "/org/example" {
+		t.Error("#6 Failed", msg.Serial(), orig.Path)
+	}
+
+	bridge.Close(int64(1e9))
+	stats := bridge.Stats()
+	if stats.Forwarded != 1 || stats.Filtered != 1 || stats.Looped != 2 || stats.Failed != 0 || stats.Overflow != 0 {
+		t.Error("#7 Failed", stats)
+	}
+}
+
+func TestForwardSignal(t *testing.T) {
+	srcServer, src, e := NewMockServer()
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	defer src.Close()
+	dstServer, dst, e := NewMockServer()
+	if e != nil {
+		t.Fatal("#2 Failed", e)
+	}
+	defer dst.Close()
+
+	if _, e := src.ForwardSignal(src, MatchRule{Type: "method_call"}, dst); e == nil {
+		t.Error("#3 Failed")
+	}
+	cancel, e := src.ForwardSignal(src, MatchRule{Interface: "org.example.Foo"}, dst)
+	if e != nil {
+		t.Fatal("#4 Failed", e)
+	}
+	defer cancel()
+
+	signal := NewMessage()
+	signal.Type = SIGNAL
+	signal.Sender = ":1.7"
+	signal.Path = "/org/example"
+	signal.Iface = "org.example.Foo"
+	signal.Member = "Changed"
+	signal.Sig = "s"
+	signal.Params.Push("value")
+	srcServer.Emit(signal)
+
+	var forwarded *Message
+	for i := 0; i < 100 && forwarded == nil; i++ {
+		for _, msg := range dstServer.Received() {
+			if msg.Type == SIGNAL {
+				forwarded = msg
+			}
+		}
+		time.Sleep(10e6)
+	}
+	if forwarded == nil {
+		t.Fatal("#5 Failed")
+	}
+	if forwarded.Sender != src.UniqueName() || forwarded.Path != "/org/example" || forwarded.Member != "Changed" ||
+		forwarded.Params.At(0).(string) != "value" {
+		t.Error("#6 Failed", forwarded)
+	}
+}
+
+// propsSignal returns a PropertiesChanged-like signal with an a{sv} body,
+// which only survives forwarding if its variants are decoded with their
+// signature.
+func propsSignal() *Message {
+	props := new(vector.Vector)
+	props.Push([]interface{}{"Level", Variant{"d", float64(0.5)}})
+	props.Push([]interface{}{"Name", Variant{"s", "battery"}})
+	msg := NewMessage()
+	msg.Type = SIGNAL
+	msg.Sender = ":1.7"
+	msg.Path = "/org/example"
+	msg.Iface = "org.example.Foo"
+	msg.Member = "Changed"
+	msg.Sig = "a{sv}"
+	msg.Params.Push(props)
+	return msg
+}
+
+// receivedSignal waits for server to receive a signal.
+func receivedSignal(server *MockServer) *Message {
+	for i := 0; i < 100; i++ {
+		for _, msg := range server.Received() {
+			if msg.Type == SIGNAL {
+				return msg
+			}
+		}
+		time.Sleep(10e6)
+	}
+	return nil
+}
+
+func checkPropsSignal(t *testing.T, conn *Connection, msg *Message) {
+	if msg == nil {
+		t.Fatal("#props-1 Failed")
+	}
+	var props map[string]interface{}
+	if e := conn.UnmarshalMessage(msg, &props); e != nil || msg.Sig != "a{sv}" {
+		t.Fatal("#props-2 Failed", msg.Sig, e)
+	}
+	if level, ok := props["Level"].(float64); !ok || level != 0.5 {
+		t.Error("#props-3 Failed", props["Level"])
+	}
+	if name, ok := props["Name"].(string); !ok || name != "battery" {
+		t.Error("#props-4 Failed", props["Name"])
+	}
+}
+
+func TestBridgeProperties(t *testing.T) {
+	srcServer, src, e := NewMockServer()
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	defer src.Close()
+	dstServer, dst, e := NewMockServer()
+	if e != nil {
+		t.Fatal("#2 Failed", e)
+	}
+	defer dst.Close()
+
+	bridge := NewBridge(src, &MatchRule{Type: "signal", Interface: "org.example.Foo"}, dst, nil, 10)
+	srcServer.Emit(propsSignal())
+	checkPropsSignal(t, dst, receivedSignal(dstServer))
+
+	bridge.Close(int64(1e9))
+	if stats := bridge.Stats(); stats.Forwarded != 1 || stats.Failed != 0 {
+		t.Error("#3 Failed", stats)
+	}
+}
+
+func TestForwardSignalProperties(t *testing.T) {
+	srcServer, src, e := NewMockServer()
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	defer src.Close()
+	dstServer, dst, e := NewMockServer()
+	if e != nil {
+		t.Fatal("#2 Failed", e)
+	}
+	defer dst.Close()
+
+	cancel, e := src.ForwardSignal(src, MatchRule{Interface: "org.example.Foo"}, dst)
+	if e != nil {
+		t.Fatal("#3 Failed", e)
+	}
+	defer cancel()
+
+	srcServer.Emit(propsSignal())
+	forwarded := receivedSignal(dstServer)
+	checkPropsSignal(t, dst, forwarded)
+	if forwarded.Sender != src.UniqueName() || forwarded.Member != "Changed" {
+		t.Error("#4 Failed", forwarded)
+	}
+}
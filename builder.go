@@ -0,0 +1,129 @@
+package dbus
+
+import (
+	"os"
+	"reflect"
+)
+
+// MessageBuilder builds method calls step by step, computing the signature
+// from the arguments:
+//	msg, err := NewMethodCall(dest, path, iface, member).WithArg(uint32(1)).Build()
+type MessageBuilder struct {
+	msg     *Message
+	cancel  <-chan bool
+	timeout int64
+	retry   *RetryPolicy
+	err     os.Error
+}
+
+// NewMethodCall starts a call of member of iface on the object at path of
+// dest.
+func NewMethodCall(dest string, path string, iface string, member string) *MessageBuilder {
+	msg := NewMessage()
+	msg.Type = METHOD_CALL
+	msg.Dest = dest
+	msg.Path = path
+	msg.Iface = iface
+	msg.Member = member
+	return &MessageBuilder{msg: msg}
+}
+
+// WithArg appends v, whose signature is derived from its type. Values of
+// basic types, Variant and ObjectRef are accepted; use WithTypedArg for
+// containers.
+func (p *MessageBuilder) WithArg(v interface{}) *MessageBuilder {
+	if _, ok := v.(Variant); ok {
+		return p.WithTypedArg("v", v)
+	}
+	sig, err := variantSignature(v)
+	if err != nil {
+		if p.err == nil {
+			p.err = err
+		}
+		return p
+	}
+	return p.WithTypedArg(sig, v)
+}
+
+// WithTypedArg appends v with the signature sig, for values such as arrays
+// (*vector.Vector) and structs ([]interface{}) whose signature cannot be
+// derived.
+func (p *MessageBuilder) WithTypedArg(sig string, v interface{}) *MessageBuilder {
+	p.msg.Sig += sig
+	p.msg.Params.Push(v)
+	return p
+}
+
+// WithCancel makes Call give up with ErrCanceled when cancel is closed.
+func (p *MessageBuilder) WithCancel(cancel <-chan bool) *MessageBuilder {
+	p.cancel = cancel
+	return p
+}
+
+// WithTimeout makes Call give up with ErrTimeout after ns nanoseconds.
+func (p *MessageBuilder) WithTimeout(ns int64) *MessageBuilder {
+	p.timeout = ns
+	return p
+}
+
+// WithRetry makes Call retry as policy says instead of following the
+// policy of the connection; &RetryPolicy{} disables retries.
+func (p *MessageBuilder) WithRetry(policy *RetryPolicy) *MessageBuilder {
+	p.retry = policy
+	return p
+}
+
+// Build returns the message, or the first error met while building it.
+func (p *MessageBuilder) Build() (*Message, os.Error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	if err := p.msg.IsValid(); err != nil {
+		return nil, err
+	}
+	if _, err := p.msg.marshal(); err != nil {
+		return nil, err
+	}
+	return p.msg, nil
+}
+
+// Call builds the message and calls it on conn, honouring the timeout and
+// cancel channel given.
+func (p *MessageBuilder) Call(conn *Connection) (*Message, os.Error) {
+	msg, err := p.Build()
+	if err != nil {
+		return nil, err
+	}
+	return conn.callRetry(msg, p.timeout, p.cancel, p.retry)
+}
+
+func newCallFromArgs(dest string, path string, iface string, method string, args ...) *MessageBuilder {
+	b := NewMethodCall(dest, path, iface, method)
+	v := reflect.NewValue(args).(*reflect.StructValue)
+	for i := 0; i < v.NumField(); i++ {
+		b.WithArg(v.Field(i).Interface())
+	}
+	return b
+}
+
+// CallTimeout calls method of iface on the object at path of dest and
+// returns the reply values, giving up with ErrTimeout after timeout
+// nanoseconds (0 waits forever). The signature is derived from args as by
+// MessageBuilder.WithArg, so no introspection is needed.
+func (p *Connection) CallTimeout(timeout int64, dest string, path string, iface string, method string, args ...) ([]interface{}, os.Error) {
+	reply, err := newCallFromArgs(dest, path, iface, method, args).WithTimeout(timeout).Call(p)
+	if err != nil {
+		return nil, err
+	}
+	return reply.Params.Data(), nil
+}
+
+// CallOnce calls a method returning a single value and stores the value
+// through the pointer reply, as UnmarshalMessage does.
+func (p *Connection) CallOnce(dest string, path string, iface string, method string, reply interface{}, args ...) os.Error {
+	msg, err := newCallFromArgs(dest, path, iface, method, args).Call(p)
+	if err != nil {
+		return err
+	}
+	return p.UnmarshalMessage(msg, reply)
+}
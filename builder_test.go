@@ -0,0 +1,37 @@
+package dbus
+
+import (
+	"container/vector"
+	"testing"
+)
+
+func TestMessageBuilder(t *testing.T) {
+	ary := new(vector.Vector)
+	ary.Push("a")
+	msg, e := NewMethodCall("org.example", "/org/example", "org.example.Foo", "Bar").
+		WithArg(uint32(1)).
+		WithArg("s").
+		WithArg(Variant{"i", int32(3)}).
+		WithTypedArg("as", ary).
+		Build()
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	if msg.Sig != "usvas" || msg.Params.Len() != 4 || msg.Type != METHOD_CALL {
+		t.Error("#2 Failed", msg.Sig)
+	}
+
+	if _, e = NewMethodCall("org.example", "/", "org.example.Foo", "Bar").WithArg(ary).Build(); e == nil {
+		t.Error("#3 Failed")
+	}
+	if _, e = NewMethodCall("org.example", "", "org.example.Foo", "Bar").Build(); e == nil {
+		t.Error("#4 Failed")
+	}
+}
+
+func TestCallFromArgs(t *testing.T) {
+	msg, e := newCallFromArgs("org.example", "/", "org.example.Foo", "Bar", "x", int32(-1)).Build()
+	if e != nil || msg.Sig != "si" || msg.Params.At(1).(int32) != -1 {
+		t.Error("#1 Failed", e)
+	}
+}
@@ -0,0 +1,214 @@
+package dbus
+
+import (
+	"os"
+)
+
+var (
+	ErrNameTaken = os.NewError("NameTaken")
+)
+
+// flags for RequestName
+const (
+	NAME_FLAG_ALLOW_REPLACEMENT = 0x1
+	NAME_FLAG_REPLACE_EXISTING  = 0x2
+	NAME_FLAG_DO_NOT_QUEUE      = 0x4
+)
+
+// replies of RequestName
+const (
+	REQUEST_NAME_REPLY_PRIMARY_OWNER = 1
+	REQUEST_NAME_REPLY_IN_QUEUE      = 2
+	REQUEST_NAME_REPLY_EXISTS        = 3
+	REQUEST_NAME_REPLY_ALREADY_OWNER = 4
+)
+
+// RegisterWellKnownName requests name and keeps track of its ownership.
+// onNameLost (if not nil) is called in its own goroutine when the daemon
+// reports NameLost for name. The name is released when cancel is closed;
+// a nil cancel keeps the name for the lifetime of the connection.
+func (p *Connection) RegisterWellKnownName(cancel <-chan bool, name string, flags uint32, onNameLost func()) os.Error {
+	// subscribe before requesting so that an immediate NameLost is not missed
+	mr := &MatchRule{
+		Type:      "signal",
+		Interface: "org.freedesktop.DBus",
+		Member:    "NameLost",
+		Path:      "/org/freedesktop/DBus"}
+	handler := p.addSignalHandler(mr, func(msg *Message) {
+		if 0 == msg.Params.Len() {
+			return
+		}
+		if lost, ok := msg.Params.At(0).(string); ok && lost == name {
+			p.setOwned(name, false)
+			if onNameLost != nil {
+				go onNameLost()
+			}
+		}
+	})
+
+	ret, err := p.CallMethod(p.proxy, "RequestName", name, flags)
+	if err != nil {
+		p.removeSignalHandler(handler)
+		return err
+	}
+
+	var reply uint32
+	if 0 < len(ret) {
+		reply, _ = ret[0].(uint32)
+	}
+	switch reply {
+	case REQUEST_NAME_REPLY_PRIMARY_OWNER, REQUEST_NAME_REPLY_ALREADY_OWNER:
+	case REQUEST_NAME_REPLY_IN_QUEUE:
+		// do not stay in the queue behind the current owner
+		p.CallMethod(p.proxy, "ReleaseName", name)
+		fallthrough
+	default:
+		p.removeSignalHandler(handler)
+		return ErrNameTaken
+	}
+
+	p.setOwned(name, true)
+	if cancel != nil {
+		go func() {
+			<-cancel
+			p.removeSignalHandler(handler)
+			p.setOwned(name, false)
+			p.CallMethod(p.proxy, "ReleaseName", name)
+		}()
+	}
+	return nil
+}
+
+// NameStatus is the standing of the connection for a name requested with
+// RequestNameOnConflict.
+type NameStatus int
+
+const (
+	// the connection owns the name
+	NAME_STATUS_PRIMARY NameStatus = iota
+	// the connection waits in the queue for the name
+	NAME_STATUS_QUEUED
+	// the connection lost the name and is not in the queue
+	NAME_STATUS_LOST
+)
+
+func (s NameStatus) String() string {
+	switch s {
+	case NAME_STATUS_PRIMARY:
+		return "primary"
+	case NAME_STATUS_QUEUED:
+		return "queued"
+	}
+	return "lost"
+}
+
+// RequestNameOnConflict requests name, waiting in the queue of the daemon
+// if another connection owns it, and returns a channel receiving the
+// status of the connection for the name: first NAME_STATUS_PRIMARY or
+// NAME_STATUS_QUEUED, then each change, such as becoming the owner when
+// the previous one releases the name. When cancel is closed, the name is
+// released and the channel closed; a nil cancel keeps the name for the
+// lifetime of the connection.
+func (p *Connection) RequestNameOnConflict(cancel <-chan bool, name string) (<-chan NameStatus, os.Error) {
+	// subscribe before requesting so that no NameAcquired is missed
+	sub := p.Subscribe(&MatchRule{
+		Type:      "signal",
+		Interface: "org.freedesktop.DBus",
+		Path:      "/org/freedesktop/DBus",
+		Arg0:      name}, NAME_NOTIFIER_QUEUE)
+	ret, err := p.CallMethod(p.proxy, "RequestName", name, uint32(0))
+	if err != nil {
+		sub.Remove(0)
+		return nil, err
+	}
+	var reply uint32
+	if 0 < len(ret) {
+		reply, _ = ret[0].(uint32)
+	}
+	status := NAME_STATUS_QUEUED
+	switch reply {
+	case REQUEST_NAME_REPLY_PRIMARY_OWNER, REQUEST_NAME_REPLY_ALREADY_OWNER:
+		status = NAME_STATUS_PRIMARY
+	case REQUEST_NAME_REPLY_IN_QUEUE:
+	default:
+		sub.Remove(0)
+		return nil, ErrNameTaken
+	}
+	p.setOwned(name, status == NAME_STATUS_PRIMARY)
+
+	out := make(chan NameStatus, NAME_NOTIFIER_QUEUE)
+	out <- status
+	go func() {
+		defer close(out)
+		for msg := range sub.C {
+			next := status
+			switch msg.Member {
+			case "NameAcquired":
+				next = NAME_STATUS_PRIMARY
+			case "NameLost":
+				next = NAME_STATUS_LOST
+			}
+			if next != status {
+				status = next
+				p.setOwned(name, status == NAME_STATUS_PRIMARY)
+				out <- status
+			}
+		}
+	}()
+	if cancel != nil {
+		go func() {
+			<-cancel
+			sub.Remove(0)
+			p.setOwned(name, false)
+			p.CallMethod(p.proxy, "ReleaseName", name)
+		}()
+	}
+	return out, nil
+}
+
+// NAME_NOTIFIER_QUEUE is the number of owner changes AddNameNotifier
+// queues while fn runs.
+const NAME_NOTIFIER_QUEUE = 64
+
+// AddNameNotifier calls fn with the previous and the new unique name of
+// the owner of name each time NameOwnerChanged reports a change; an empty
+// string means no owner. If name already has an owner when the notifier is
+// added, fn is first called with "" and that owner, so a service that
+// appeared just before is not missed. fn runs in its own goroutine, one
+// call at a time, and may still be running when cancel returns.
+func (p *Connection) AddNameNotifier(name string, fn func(oldOwner, newOwner string)) (cancel func(), err os.Error) {
+	// subscribe before asking for the owner so that no change is missed
+	sub := p.Subscribe(&MatchRule{
+		Type:      "signal",
+		Interface: "org.freedesktop.DBus",
+		Member:    "NameOwnerChanged",
+		Path:      "/org/freedesktop/DBus",
+		Arg0:      name}, NAME_NOTIFIER_QUEUE)
+	var owner string
+	if err := p.daemonCall("GetNameOwner", []string{name}, &owner); err != nil {
+		if dbusErr, ok := err.(*Error); !ok || dbusErr.Name != "org.freedesktop.DBus.Error.NameHasNoOwner" {
+			sub.Remove(0)
+			return nil, err
+		}
+		owner = ""
+	}
+	go func() {
+		current := owner
+		if current != "" {
+			fn("", current)
+		}
+		for msg := range sub.C {
+			var changed, oldOwner, newOwner string
+			if p.UnmarshalMessage(msg, &changed, &oldOwner, &newOwner) != nil || changed != name {
+				continue
+			}
+			// a change queued before GetNameOwner answered may already be known
+			if newOwner == current {
+				continue
+			}
+			current = newOwner
+			fn(oldOwner, newOwner)
+		}
+	}()
+	return func() { sub.Remove(0) }, nil
+}
@@ -0,0 +1,139 @@
+package dbus
+
+import (
+	"os"
+	"sync"
+	"testing"
+)
+
+func pendingCount(p *Connection) int {
+	p.replyMutex.Lock()
+	defer p.replyMutex.Unlock()
+	return len(p.methodCallReplies)
+}
+
+func TestCancelStages(t *testing.T) {
+	var mutex sync.Mutex
+	seen := make(map[string]int)
+	received := make(chan bool, 10)
+	release := make(chan bool)
+	p := fakeService(t, func(call *Message) *Message {
+		mutex.Lock()
+		seen[call.Member]++
+		mutex.Unlock()
+		if call.Member == "Hang" {
+			received <- true
+			<-release
+		}
+		msg := NewMessage()
+		msg.Type = METHOD_RETURN
+		return msg
+	})
+	defer p.Close()
+	calls := func(member string) int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return seen[member]
+	}
+	call := func(member string, cancel <-chan bool) os.Error {
+		_, err := NewMethodCall("org.example", "/org/example", "org.example.Foo", member).WithCancel(cancel).Call(p)
+		return err
+	}
+
+	// canceled before it starts: nothing is sent
+	if e := call("Echo", CancelAfter(0)); e != ErrCanceled || calls("Echo") != 0 || pendingCount(p) != 0 {
+		t.Error("#1 Failed", e)
+	}
+
+	// canceled while waiting: the pending call is dropped
+	cancel := make(chan bool)
+	go func() {
+		<-received
+		close(cancel)
+	}()
+	if e := call("Hang", cancel); e != ErrCanceled || pendingCount(p) != 0 {
+		t.Error("#2 Failed", e, pendingCount(p))
+	}
+
+	// a deadline bounds the call the same way
+	if e := call("Hang", CancelAfter(1e7)); e != ErrCanceled || pendingCount(p) != 0 {
+		t.Error("#3 Failed", e)
+	}
+	// let the server answer both, too late
+	release <- true
+	release <- true
+
+	// canceled after completing: the result stands
+	cancel = make(chan bool)
+	if e := call("Echo", cancel); e != nil || calls("Echo") != 1 {
+		t.Error("#4 Failed", e)
+	}
+	close(cancel)
+	if pendingCount(p) != 0 || p.Err() != nil {
+		t.Error("#5 Failed", p.Err())
+	}
+
+	// walks and object managers stop at the first call
+	if _, e := p.WalkObjectsCancel(CancelAfter(0), "org.example", "/"); e != ErrCanceled || calls("GetManagedObjects") != 0 {
+		t.Error("#6 Failed", e)
+	}
+	if _, e := p.GetObjectManagerCancel(CancelAfter(0), "org.example", "/"); e != ErrCanceled {
+		t.Error("#7 Failed", e)
+	}
+	// the match rules it added are removed
+	if calls("AddMatch") != 2 || calls("RemoveMatch") != 2 || handlerCount(p) != 0 {
+		t.Error("#8 Failed", calls("AddMatch"), calls("RemoveMatch"))
+	}
+}
+
+func TestHandshakeCancel(t *testing.T) {
+	p, server := dialTest(t)
+	defer server.Close()
+
+	// the server never answers the authentication
+	if e := p.HandshakeCancel(CancelAfter(1e7), nil); e != ErrCanceled {
+		t.Error("#4 Failed", e)
+	}
+	if p.Err() != ErrCanceled {
+		t.Error("#5 Failed", p.Err())
+	}
+}
+
+func TestIntrospectCancel(t *testing.T) {
+	received := make(chan bool, 1)
+	release := make(chan bool)
+	p := fakeService(t, func(call *Message) *Message {
+		received <- true
+		<-release
+		msg := NewMessage()
+		msg.Type = METHOD_RETURN
+		msg.Sig = "s"
+		msg.Params.Push(introStr)
+		return msg
+	})
+	defer p.Close()
+
+	if _, e := p.IntrospectCancel(CancelAfter(0), "org.example", "/"); e != ErrCanceled || len(received) != 0 {
+		t.Error("#1 Failed", e)
+	}
+
+	// an unresponsive service
+	cancel := make(chan bool)
+	go func() {
+		<-received
+		close(cancel)
+	}()
+	if obj, e := p.GetObjectCancel(cancel, "org.example", "/"); e != ErrCanceled || obj != nil || pendingCount(p) != 0 {
+		t.Error("#2 Failed", e)
+	}
+	release <- true
+
+	go func() {
+		<-received
+		release <- true
+	}()
+	intro, e := p.IntrospectCancel(make(chan bool), "org.example", "/")
+	if e != nil || intro.GetInterfaceData("org.freedesktop.SampleInterface") == nil {
+		t.Error("#3 Failed", e)
+	}
+}
@@ -0,0 +1,375 @@
+// dbusgen generates typed Go client proxies from D-Bus introspection data.
+//
+// Usage:
+//	dbusgen [-package name] [-o file] [-snake] introspect.xml
+//	dbusgen [-package name] [-o file] [-snake] -dest org.example.Service -path /org/example/Object
+//
+// To regenerate a checked-in proxy, keep a comment next to it such as
+//	//go:generate dbusgen -package example -o example_proxy.go example.xml
+//
+// For every interface the output has a Go interface type and a proxy struct
+// implementing it on top of dbus.Connection.CallMethod. Member names are
+// mapped to Go names by dbus.NameMapper; -snake converts snake_case names.
+package main
+
+import (
+	"bytes"
+	"container/vector"
+	"dbus"
+	"flag"
+	"fmt"
+	"go/parser"
+	"go/printer"
+	"io/ioutil"
+	"os"
+	"strings"
+	"xml"
+)
+
+var (
+	pkgName = flag.String("package", "proxy", "package name of the generated file")
+	output  = flag.String("o", "", "output file (default: standard output)")
+	dest    = flag.String("dest", "", "introspect this destination on the session bus instead of reading a file")
+	objPath = flag.String("path", "/", "object path to introspect with -dest")
+	snake   = flag.Bool("snake", false, "convert snake_case and kebab-case member names to CamelCase")
+)
+
+var mapper = dbus.DefaultNameMapper
+
+type argData struct {
+	Name      string "attr"
+	Type      string "attr"
+	Direction string "attr"
+}
+
+type methodData struct {
+	Name string "attr"
+	Arg  []argData
+}
+
+type signalData struct {
+	Name string "attr"
+	Arg  []argData
+}
+
+type interfaceData struct {
+	Name   string "attr"
+	Method []methodData
+	Signal []signalData
+}
+
+type node struct {
+	Name      string "attr"
+	Interface []interfaceData
+}
+
+var keywords = map[string]bool{
+	"break": true, "case": true, "chan": true, "const": true, "continue": true,
+	"default": true, "defer": true, "else": true, "fallthrough": true, "for": true,
+	"func": true, "go": true, "goto": true, "if": true, "import": true,
+	"interface": true, "map": true, "package": true, "range": true, "return": true,
+	"select": true, "struct": true, "switch": true, "type": true, "var": true,
+	"err": true, "ret": true, "ok": true, "p": true,
+}
+
+// goType returns the Go type the dbus package uses for the first complete
+// type of sig, and the rest of sig.
+func goType(sig string) (string, string) {
+	if len(sig) == 0 {
+		return "interface{}", ""
+	}
+	switch sig[0] {
+	case 'y':
+		return "byte", sig[1:]
+	case 'b':
+		return "bool", sig[1:]
+	case 'n':
+		return "int16", sig[1:]
+	case 'q':
+		return "uint16", sig[1:]
+	case 'i':
+		return "int32", sig[1:]
+	case 'u':
+		return "uint32", sig[1:]
+	case 'x':
+		return "int64", sig[1:]
+	case 't':
+		return "uint64", sig[1:]
+	case 'd':
+		return "float64", sig[1:]
+	case 's', 'o', 'g':
+		return "string", sig[1:]
+	case 'a':
+		// arrays travel as *vector.Vector
+		_, rest := goType(sig[1:])
+		return "*vector.Vector", rest
+	case '(', '{':
+		// structs and dict entries are []interface{} when sent
+		depth := 0
+		for i := 0; i < len(sig); i++ {
+			switch sig[i] {
+			case '(', '{':
+				depth++
+			case ')', '}':
+				depth--
+				if depth == 0 {
+					return "[]interface{}", sig[i+1:]
+				}
+			}
+		}
+	}
+	return "interface{}", sig[1:]
+}
+
+// resultType returns the Go type of a decoded value of Go type t as goType
+// gives it: structs and dict entries are received as *vector.Vector.
+func resultType(t string) string {
+	if t == "[]interface{}" {
+		return "*vector.Vector"
+	}
+	return t
+}
+
+// exportedName turns the last element of a dotted or snake_case name into
+// an exported Go identifier.
+func exportedName(name string) string {
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	buff := bytes.NewBuffer([]byte{})
+	for _, part := range strings.Split(name, "_", 0) {
+		if part != "" {
+			buff.WriteString(strings.ToUpper(part[0:1]) + part[1:])
+		}
+	}
+	return buff.String()
+}
+
+func argName(arg argData, i int) string {
+	name := strings.Join(strings.Split(arg.Name, "-", 0), "_")
+	if name == "" || keywords[name] {
+		return fmt.Sprintf("arg%d", i)
+	}
+	return name
+}
+
+// method is the Go form of an introspected method.
+type method struct {
+	name     string
+	member   string // D-Bus name
+	params   string // Go parameter list
+	results  string // Go result list
+	callArgs string // arguments passed on to CallMethod
+	outNames []string
+	outTypes []string
+}
+
+func newMethod(m methodData, name string) *method {
+	in := new(vector.StringVector)
+	call := new(vector.StringVector)
+	out := new(vector.StringVector)
+	outNames := new(vector.StringVector)
+	outTypes := new(vector.StringVector)
+	for i, arg := range m.Arg {
+		t, _ := goType(arg.Type)
+		name := argName(arg, i)
+		if strings.ToUpper(arg.Direction) == "OUT" {
+			t = resultType(t)
+			outNames.Push(name)
+			outTypes.Push(t)
+			out.Push(name + " " + t)
+		} else {
+			in.Push(name + " " + t)
+			call.Push(", " + name)
+		}
+	}
+	out.Push("err os.Error")
+	return &method{
+		name:     name,
+		member:   m.Name,
+		params:   strings.Join(in.Data(), ", "),
+		results:  strings.Join(out.Data(), ", "),
+		callArgs: strings.Join(call.Data(), ""),
+		outNames: outNames.Data(),
+		outTypes: outTypes.Data(),
+	}
+}
+
+func (m *method) signature() string {
+	return fmt.Sprintf("%s(%s) (%s)", m.name, m.params, m.results)
+}
+
+func writeMethod(buff *bytes.Buffer, typeName string, ifaceName string, m *method) {
+	fmt.Fprintf(buff, "\n// %s calls %s.%s.\n", m.name, ifaceName, m.member)
+	fmt.Fprintf(buff, "func (p *%sProxy) %s {\n", typeName, m.signature())
+	fmt.Fprintf(buff, "ret, err := p.conn.CallMethod(p.iface, %q%s)\n", m.member, m.callArgs)
+	fmt.Fprintf(buff, "if err != nil {\nreturn\n}\n")
+	if n := len(m.outNames); n > 0 {
+		fmt.Fprintf(buff, "if len(ret) < %d {\nerr = os.NewError(\"%s: short reply\")\nreturn\n}\n", n, m.name)
+		fmt.Fprintf(buff, "var ok bool\n")
+		for i, name := range m.outNames {
+			fmt.Fprintf(buff, "if %s, ok = ret[%d].(%s); !ok {\n", name, i, m.outTypes[i])
+			fmt.Fprintf(buff, "err = os.NewError(\"%s: result %d is not %s\")\nreturn\n}\n", m.name, i, m.outTypes[i])
+		}
+	} else {
+		fmt.Fprintf(buff, "_ = ret\n")
+	}
+	fmt.Fprintf(buff, "return\n}\n")
+}
+
+// memberNames maps the methods and signals of iface to Go names.
+func memberNames(iface interfaceData) (map[string]string, os.Error) {
+	names := new(vector.StringVector)
+	for _, m := range iface.Method {
+		names.Push(m.Name)
+	}
+	for _, s := range iface.Signal {
+		names.Push(s.Name)
+	}
+	goNames, err := mapper.MapNames(names.Data())
+	if err != nil {
+		return nil, os.NewError(iface.Name + ": " + err.String())
+	}
+	return goNames, nil
+}
+
+func writeInterface(buff *bytes.Buffer, iface interfaceData) os.Error {
+	typeName := exportedName(iface.Name)
+	goNames, err := memberNames(iface)
+	if err != nil {
+		return err
+	}
+	methods := make([]*method, len(iface.Method))
+	for i, m := range iface.Method {
+		methods[i] = newMethod(m, goNames[m.Name])
+	}
+
+	fmt.Fprintf(buff, "\n// %s is the D-Bus interface %s.\n", typeName, iface.Name)
+	fmt.Fprintf(buff, "type %s interface {\n", typeName)
+	for _, m := range methods {
+		fmt.Fprintf(buff, "%s\n", m.signature())
+	}
+	fmt.Fprintf(buff, "}\n")
+
+	fmt.Fprintf(buff, "\n// %sProxy calls %s on a remote object.\n", typeName, iface.Name)
+	fmt.Fprintf(buff, "type %sProxy struct {\nconn *dbus.Connection\niface *dbus.Interface\n}\n", typeName)
+	fmt.Fprintf(buff, "\n// New%sProxy returns a proxy for %s of obj.\n", typeName, iface.Name)
+	fmt.Fprintf(buff, "func New%sProxy(conn *dbus.Connection, obj *dbus.Object) (*%sProxy, os.Error) {\n", typeName, typeName)
+	fmt.Fprintf(buff, "iface, err := conn.GetInterface(obj, %q)\n", iface.Name)
+	fmt.Fprintf(buff, "if err != nil {\nreturn nil, err\n}\n")
+	fmt.Fprintf(buff, "return &%sProxy{conn, iface}, nil\n}\n", typeName)
+
+	for _, m := range methods {
+		writeMethod(buff, typeName, iface.Name, m)
+	}
+	for _, s := range iface.Signal {
+		fmt.Fprintf(buff, "\n// %s%sSignal is the member name of the %s.%s signal.\n", typeName, goNames[s.Name], iface.Name, s.Name)
+		fmt.Fprintf(buff, "const %s%sSignal = %q\n", typeName, goNames[s.Name], s.Name)
+	}
+	return nil
+}
+
+func generate(source string, introXML string) ([]byte, os.Error) {
+	root := new(node)
+	if err := xml.Unmarshal(bytes.NewBufferString(introXML), root); err != nil {
+		return nil, err
+	}
+
+	buff := new(bytes.Buffer)
+	fmt.Fprintf(buff, "// Generated by dbusgen from %s. DO NOT EDIT.\n\n", source)
+	fmt.Fprintf(buff, "package %s\n\n", *pkgName)
+	fmt.Fprintf(buff, "import (\n\"container/vector\"\n\"dbus\"\n\"os\"\n)\n")
+	fmt.Fprintf(buff, "\nvar _ *vector.Vector\n")
+	ifaces := make(map[string]string)
+	for _, iface := range root.Interface {
+		typeName := exportedName(iface.Name)
+		if other, ok := ifaces[typeName]; ok {
+			return nil, os.NewError(fmt.Sprintf("%s and %s both map to %s", other, iface.Name, typeName))
+		}
+		ifaces[typeName] = iface.Name
+	}
+	for _, iface := range root.Interface {
+		if err := writeInterface(buff, iface); err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := parser.ParseFile(source, buff.Bytes(), parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	formatted := new(bytes.Buffer)
+	if err = printer.Fprint(formatted, file); err != nil {
+		return nil, err
+	}
+	return formatted.Bytes(), nil
+}
+
+func fetch(dest string, path string) (string, os.Error) {
+	conn, err := dbus.NewSessionBus()
+	if err != nil {
+		return "", err
+	}
+	if err = conn.Initialize(); err != nil {
+		return "", err
+	}
+	obj := conn.GetObject(dest, path)
+	iface, err := conn.GetInterface(obj, "org.freedesktop.DBus.Introspectable")
+	if err != nil {
+		return "", err
+	}
+	ret, err := conn.CallMethod(iface, "Introspect")
+	if err != nil {
+		return "", err
+	}
+	if len(ret) == 0 {
+		return "", os.NewError("empty Introspect reply")
+	}
+	str, _ := ret[0].(string)
+	return str, nil
+}
+
+func main() {
+	flag.Parse()
+	if *snake {
+		mapper = &dbus.NameMapper{SnakeCase: true}
+	}
+
+	var source, introXML string
+	switch {
+	case *dest != "":
+		source = *dest + " " + *objPath
+		str, err := fetch(*dest, *objPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "dbusgen: %s\n", err)
+			os.Exit(1)
+		}
+		introXML = str
+	case flag.NArg() == 1:
+		source = flag.Arg(0)
+		data, err := ioutil.ReadFile(source)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "dbusgen: %s\n", err)
+			os.Exit(1)
+		}
+		introXML = string(data)
+	default:
+		fmt.Fprintf(os.Stderr, "usage: dbusgen [-package name] [-o file] [-snake] (introspect.xml | -dest name -path path)\n")
+		os.Exit(2)
+	}
+
+	code, err := generate(source, introXML)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dbusgen: %s\n", err)
+		os.Exit(1)
+	}
+
+	if *output == "" {
+		os.Stdout.Write(code)
+		return
+	}
+	if err = ioutil.WriteFile(*output, code, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "dbusgen: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"dbus"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// normalize drops the alignment go/printer adds, which depends on its
+// configuration, and keeps the tokens of each line.
+func normalize(code string) string {
+	buff := bytes.NewBuffer([]byte{})
+	for _, line := range strings.Split(code, "\n", 0) {
+		buff.WriteString(strings.Join(strings.Fields(line), " "))
+		buff.WriteString("\n")
+	}
+	return buff.String()
+}
+
+func TestGenerateGolden(t *testing.T) {
+	introXML, e := ioutil.ReadFile("testdata/battery.xml")
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	golden, e := ioutil.ReadFile("testdata/battery.golden")
+	if e != nil {
+		t.Fatal("#2 Failed", e)
+	}
+	code, e := generate("testdata/battery.xml", string(introXML))
+	if e != nil {
+		t.Fatal("#3 Failed", e)
+	}
+	if normalize(string(code)) != normalize(string(golden)) {
+		t.Error("#4 Failed", string(code))
+	}
+}
+
+func TestGenerateCollisions(t *testing.T) {
+	ifaces := `<node>
+  <interface name="org.example.foo_bar"/>
+  <interface name="org.other.FooBar"/>
+</node>`
+	if _, e := generate("ifaces", ifaces); e == nil || strings.Index(e.String(), "org.example.foo_bar") < 0 {
+		t.Error("#1 Failed", e)
+	}
+
+	members := `<node>
+  <interface name="org.example.Foo">
+    <method name="power_state"/>
+    <method name="PowerState"/>
+  </interface>
+</node>`
+	saved := mapper
+	defer func() { mapper = saved }()
+	mapper = &dbus.NameMapper{SnakeCase: true}
+	if _, e := generate("members", members); e == nil {
+		t.Error("#2 Failed")
+	}
+}
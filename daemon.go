@@ -0,0 +1,421 @@
+package dbus
+
+import (
+	"container/vector"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrDaemonUnsupported = os.NewError("Not supported by the bus daemon")
+)
+
+func newDaemonCall(iface string, member string) *Message {
+	msg := NewMessage()
+	msg.Type = METHOD_CALL
+	msg.Path = "/org/freedesktop/DBus"
+	msg.Dest = "org.freedesktop.DBus"
+	msg.Iface = iface
+	msg.Member = member
+	return msg
+}
+
+// isUnsupported reports whether err is the daemon saying it does not know
+// or does not support the method or interface called.
+func isUnsupported(err os.Error) bool {
+	if e, ok := err.(*Error); ok {
+		switch e.Name {
+		case "org.freedesktop.DBus.Error.UnknownMethod",
+			"org.freedesktop.DBus.Error.UnknownInterface",
+			"org.freedesktop.DBus.Error.UnknownObject",
+			"org.freedesktop.DBus.Error.NotSupported":
+			return true
+		}
+	}
+	return false
+}
+
+// GetAllDaemonMatchRules returns the match rules registered with the daemon
+// by every connection, keyed by unique name, using
+// org.freedesktop.DBus.Debug.Stats.GetAllMatchRules. It returns
+// ErrDaemonUnsupported if the daemon does not implement it.
+func (p *Connection) GetAllDaemonMatchRules() (map[string][]string, os.Error) {
+	msg := newDaemonCall("org.freedesktop.DBus.Debug.Stats", "GetAllMatchRules")
+	reply, err := p.call(msg, 0, nil)
+	if isUnsupported(err) {
+		return nil, ErrDaemonUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rules map[string][]string
+	if err = p.UnmarshalReply(reply.Params.Data(), &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// SetApplicationName gives the connection a human-readable name for
+// monitoring tools, through org.freedesktop.DBus.SetConnectionName where
+// the daemon implements it. The name also prefixes the messages passed to
+// the logger. A daemon without the method is not an error.
+func (p *Connection) SetApplicationName(name string) os.Error {
+	msg := newDaemonCall("org.freedesktop.DBus", "SetConnectionName")
+	msg.Sig = "s"
+	msg.Params.Push(name)
+	if _, err := p.call(msg, 0, nil); err != nil && !isUnsupported(err) {
+		return err
+	}
+	p.stateMutex.Lock()
+	p.appName = name
+	p.stateMutex.Unlock()
+	return nil
+}
+
+// ApplicationName returns the name set with SetApplicationName.
+func (p *Connection) ApplicationName() string {
+	p.stateMutex.Lock()
+	defer p.stateMutex.Unlock()
+	return p.appName
+}
+
+// busPropertyFallbacks are the values of the bus properties that daemons
+// predating org.freedesktop.DBus.Properties on the bus itself imply.
+var busPropertyFallbacks = map[string]func() interface{}{
+	"Features":   func() interface{} { return new(vector.Vector) },
+	"Interfaces": func() interface{} { return new(vector.Vector) },
+}
+
+// GetBusProperty returns the value of property name of the daemon, such as
+// Features or Interfaces, read through org.freedesktop.DBus.Properties on
+// the first call and cached for the connection. For a daemon without bus
+// properties, Features and Interfaces are empty arrays, as that daemon
+// has no optional features or interfaces; other properties then give
+// ErrDaemonUnsupported.
+func (p *Connection) GetBusProperty(name string) (interface{}, os.Error) {
+	p.stateMutex.Lock()
+	value, ok := p.busProperties[name]
+	p.stateMutex.Unlock()
+	if ok {
+		return copyValue(value), nil
+	}
+
+	bus := &Object{dest: "org.freedesktop.DBus", path: "/org/freedesktop/DBus"}
+	value, err := p.GetProperty(bus, "org.freedesktop.DBus", name)
+	if isUnsupported(err) {
+		fallback, ok := busPropertyFallbacks[name]
+		if !ok {
+			return nil, ErrDaemonUnsupported
+		}
+		value, err = fallback(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.stateMutex.Lock()
+	if p.busProperties == nil {
+		p.busProperties = make(map[string]interface{})
+	}
+	p.busProperties[name] = value
+	p.stateMutex.Unlock()
+	return copyValue(value), nil
+}
+
+// ServiceInfo describes a name on the bus. UniqueName, PID and UID are
+// zero for activatable names that are not running.
+type ServiceInfo struct {
+	Name          string
+	UniqueName    string
+	PID           uint32
+	UID           uint32
+	IsActivatable bool
+}
+
+// at most this many daemon calls of ListServices are in flight
+const LIST_SERVICES_CONCURRENCY = 8
+
+// ListNames returns the names currently owned on the bus.
+func (p *Connection) ListNames() ([]string, os.Error) {
+	var names []string
+	err := p.daemonCall("ListNames", nil, &names)
+	return names, err
+}
+
+// ListActivatableNames returns the names the bus can start services for.
+func (p *Connection) ListActivatableNames() ([]string, os.Error) {
+	var names []string
+	err := p.daemonCall("ListActivatableNames", nil, &names)
+	return names, err
+}
+
+// ListServices returns every owned or activatable name with its owner and
+// the process ID and user ID of the owner, sorted by name. The owners are
+// queried concurrently, with at most LIST_SERVICES_CONCURRENCY calls to
+// the daemon at a time.
+func (p *Connection) ListServices() ([]ServiceInfo, os.Error) {
+	names, err := p.ListNames()
+	if err != nil {
+		return nil, err
+	}
+	activatable, err := p.ListActivatableNames()
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]*ServiceInfo)
+	for _, name := range names {
+		byName[name] = &ServiceInfo{Name: name}
+	}
+	for _, name := range activatable {
+		if _, ok := byName[name]; !ok {
+			byName[name] = &ServiceInfo{Name: name}
+		}
+		byName[name].IsActivatable = true
+	}
+	infos := make([]ServiceInfo, len(byName))
+	i := 0
+	for _, info := range byName {
+		infos[i] = *info
+		i++
+	}
+	sort.Sort(servicesByName(infos))
+
+	work := make(chan int, len(infos))
+	for i := range infos {
+		work <- i
+	}
+	close(work)
+	var errMutex sync.Mutex
+	var firstErr os.Error
+	done := make(chan bool)
+	workers := LIST_SERVICES_CONCURRENCY
+	if len(infos) < workers {
+		workers = len(infos)
+	}
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range work {
+				if err := p.describeService(&infos[i]); err != nil {
+					errMutex.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					errMutex.Unlock()
+				}
+			}
+			done <- true
+		}()
+	}
+	for w := 0; w < workers; w++ {
+		<-done
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return infos, nil
+}
+
+// at most this many properties are read at a time by FindServicesByProperty
+const FIND_SERVICES_CONCURRENCY = 8
+
+// FindServicesByProperty returns the well-known names on the bus whose
+// object at the conventional path, the name with dots turned into slashes
+// (/org/freedesktop/NetworkManager for org.freedesktop.NetworkManager), has
+// property prop of iface printing as value, sorted. Names whose object
+// lacks the property are skipped. The properties are read concurrently,
+// with at most FIND_SERVICES_CONCURRENCY calls at a time; it gives up with
+// ErrCanceled when cancel is closed.
+func (p *Connection) FindServicesByProperty(cancel <-chan bool, iface string, prop string, value string) ([]string, os.Error) {
+	names, err := p.ListNames()
+	if err != nil {
+		return nil, err
+	}
+	wellKnown := new(vector.StringVector)
+	for _, name := range names {
+		if !strings.HasPrefix(name, ":") {
+			wellKnown.Push(name)
+		}
+	}
+	candidates := wellKnown.Data()
+	found := make([]bool, len(candidates))
+
+	work := make(chan int, len(candidates))
+	for i := range candidates {
+		work <- i
+	}
+	close(work)
+	var canceled bool
+	var cancelMutex sync.Mutex
+	done := make(chan bool)
+	workers := FIND_SERVICES_CONCURRENCY
+	if len(candidates) < workers {
+		workers = len(candidates)
+	}
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range work {
+				obj := &Object{dest: candidates[i], path: "/" + strings.Join(strings.Split(candidates[i], ".", 0), "/")}
+				v, err := p.getProperty(cancel, obj, iface, prop)
+				if err == ErrCanceled {
+					cancelMutex.Lock()
+					canceled = true
+					cancelMutex.Unlock()
+				}
+				found[i] = err == nil && fmt.Sprint(v) == value
+			}
+			done <- true
+		}()
+	}
+	for w := 0; w < workers; w++ {
+		<-done
+	}
+	if canceled {
+		return nil, ErrCanceled
+	}
+
+	matches := new(vector.StringVector)
+	for i, name := range candidates {
+		if found[i] {
+			matches.Push(name)
+		}
+	}
+	return sortedStrings(matches), nil
+}
+
+// describeService fills in the owner of info.Name. A name without an owner
+// is not an error for activatable names.
+func (p *Connection) describeService(info *ServiceInfo) os.Error {
+	err := p.daemonCall("GetNameOwner", []string{info.Name}, &info.UniqueName)
+	if e, ok := err.(*Error); ok && e.Name == "org.freedesktop.DBus.Error.NameHasNoOwner" && info.IsActivatable {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err = p.daemonCall("GetConnectionUnixProcessID", []string{info.UniqueName}, &info.PID); err != nil {
+		return err
+	}
+	return p.daemonCall("GetConnectionUnixUser", []string{info.UniqueName}, &info.UID)
+}
+
+// OurPID returns the process ID the daemon sees for this connection, for
+// checking the credentials it attributes to us.
+func (p *Connection) OurPID() (uint32, os.Error) {
+	var pid uint32
+	err := p.daemonCall("GetConnectionUnixProcessID", []string{p.UniqueName()}, &pid)
+	return pid, err
+}
+
+// OurUID returns the user ID the daemon sees for this connection.
+func (p *Connection) OurUID() (uint32, os.Error) {
+	var uid uint32
+	err := p.daemonCall("GetConnectionUnixUser", []string{p.UniqueName()}, &uid)
+	return uid, err
+}
+
+// UpdateActivationEnvironment adds env to the environment the daemon gives
+// the services it activates, through
+// org.freedesktop.DBus.UpdateActivationEnvironment.
+func (p *Connection) UpdateActivationEnvironment(env map[string]string) os.Error {
+	msg := newDaemonCall("org.freedesktop.DBus", "UpdateActivationEnvironment")
+	keys := new(vector.StringVector)
+	for k, _ := range env {
+		keys.Push(k)
+	}
+	entries := new(vector.Vector)
+	for _, k := range sortedStrings(keys) {
+		entries.Push([]interface{}{k, env[k]})
+	}
+	msg.Sig = "a{ss}"
+	msg.Params.Push(entries)
+	if _, err := p.call(msg, 0, nil); err != nil {
+		return err
+	}
+	p.stateMutex.Lock()
+	defer p.stateMutex.Unlock()
+	if p.activationEnv == nil {
+		p.activationEnv = make(map[string]string)
+	}
+	for k, v := range env {
+		p.activationEnv[k] = v
+	}
+	return nil
+}
+
+// GetActivationEnvironment returns the environment of activated services
+// as far as it can be known: the Environment of the systemd manager on the
+// bus of the connection, which dbus-daemon forwards its updates to, merged
+// with the variables set with UpdateActivationEnvironment on this
+// connection, which take precedence; the daemon itself cannot be asked. A
+// bus without systemd only contributes the latter.
+func (p *Connection) GetActivationEnvironment() (map[string]string, os.Error) {
+	env := make(map[string]string)
+	manager := &Object{dest: "org.freedesktop.systemd1", path: "/org/freedesktop/systemd1"}
+	value, err := p.GetProperty(manager, "org.freedesktop.systemd1.Manager", "Environment")
+	if err == nil {
+		var vars []string
+		if err = p.UnmarshalReply([]interface{}{value}, &vars); err != nil {
+			return nil, err
+		}
+		for _, kv := range vars {
+			if i := strings.Index(kv, "="); 0 < i {
+				env[kv[0:i]] = kv[i+1:]
+			}
+		}
+	} else if e, ok := err.(*Error); !ok || e.Name != "org.freedesktop.DBus.Error.ServiceUnknown" && !isUnsupported(err) {
+		return nil, err
+	}
+
+	p.stateMutex.Lock()
+	for k, v := range p.activationEnv {
+		env[k] = v
+	}
+	p.stateMutex.Unlock()
+	return env, nil
+}
+
+// senderOf returns the unique name of the owner of name, with which the
+// signals of name are sent. Unique names, and the daemon's own name, are
+// returned unchanged, as is "" for a peer-to-peer connection.
+func (p *Connection) senderOf(cancel <-chan bool, name string) (string, os.Error) {
+	if name == "" || name[0] == ':' || name == "org.freedesktop.DBus" {
+		return name, nil
+	}
+	msg := newDaemonCall("org.freedesktop.DBus", "GetNameOwner")
+	msg.Sig = "s"
+	msg.Params.Push(name)
+	reply, err := p.call(msg, 0, cancel)
+	if err != nil {
+		return "", err
+	}
+	var owner string
+	if err = p.UnmarshalReply(reply.Params.Data(), &owner); err != nil {
+		return "", err
+	}
+	return owner, nil
+}
+
+// daemonCall calls member of org.freedesktop.DBus with the string
+// arguments args and stores the single reply value through dest.
+func (p *Connection) daemonCall(member string, args []string, dest interface{}) os.Error {
+	msg := newDaemonCall("org.freedesktop.DBus", member)
+	for _, arg := range args {
+		msg.Sig += "s"
+		msg.Params.Push(arg)
+	}
+	reply, err := p.call(msg, 0, nil)
+	if err != nil {
+		return err
+	}
+	return p.UnmarshalReply(reply.Params.Data(), dest)
+}
+
+type servicesByName []ServiceInfo
+
+func (p servicesByName) Len() int           { return len(p) }
+func (p servicesByName) Less(i, j int) bool { return p[i].Name < p[j].Name }
+func (p servicesByName) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
@@ -0,0 +1,360 @@
+package dbus
+
+import (
+	"container/vector"
+	"reflect"
+	"testing"
+)
+
+func TestListServices(t *testing.T) {
+	owners := map[string]string{"org.example.B": ":1.2", "org.example.A": ":1.1", ":1.1": ":1.1", ":1.2": ":1.2"}
+	p := fakeService(t, func(call *Message) *Message {
+		msg := NewMessage()
+		msg.Type = METHOD_RETURN
+		var arg string
+		if 0 < call.Params.Len() {
+			arg = call.Params.At(0).(string)
+		}
+		switch call.Member {
+		case "ListNames":
+			msg.Sig = "as"
+			msg.Params.Push(sliceToVector([]interface{}{"org.example.B", ":1.1", "org.example.A", ":1.2"}))
+		case "ListActivatableNames":
+			msg.Sig = "as"
+			msg.Params.Push(sliceToVector([]interface{}{"org.example.C", "org.example.A"}))
+		case "GetNameOwner":
+			owner, ok := owners[arg]
+			if !ok {
+				msg.Type = ERROR
+				msg.ErrorName = "org.freedesktop.DBus.Error.NameHasNoOwner"
+				break
+			}
+			msg.Sig = "s"
+			msg.Params.Push(owner)
+		case "GetConnectionUnixProcessID":
+			msg.Sig = "u"
+			msg.Params.Push(uint32(100 + arg[3] - '0'))
+		case "GetConnectionUnixUser":
+			msg.Sig = "u"
+			msg.Params.Push(uint32(1000))
+		}
+		return msg
+	})
+	defer p.Close()
+
+	infos, e := p.ListServices()
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	want := []ServiceInfo{
+		ServiceInfo{":1.1", ":1.1", 101, 1000, false},
+		ServiceInfo{":1.2", ":1.2", 102, 1000, false},
+		ServiceInfo{"org.example.A", ":1.1", 101, 1000, true},
+		ServiceInfo{"org.example.B", ":1.2", 102, 1000, false},
+		ServiceInfo{"org.example.C", "", 0, 0, true},
+	}
+	if len(infos) != len(want) {
+		t.Fatal("#2 Failed", infos)
+	}
+	for i := range want {
+		if !reflect.DeepEqual(infos[i], want[i]) {
+			t.Error("#3 Failed", i, infos[i])
+		}
+	}
+}
+
+func TestAddNameNotifier(t *testing.T) {
+	server, p, e := NewMockServer()
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	defer p.Close()
+	owner := NewMessage()
+	owner.Sig = "s"
+	owner.Params.Push(":1.5")
+	server.Expect(MatchMethod("org.freedesktop.DBus", "GetNameOwner"), owner)
+
+	changes := make(chan [2]string, 10)
+	cancel, e := p.AddNameNotifier("org.example.Foo", func(oldOwner, newOwner string) {
+		changes <- [2]string{oldOwner, newOwner}
+	})
+	if e != nil {
+		t.Fatal("#2 Failed", e)
+	}
+	changed := func(name, oldOwner, newOwner string) {
+		msg := NewMessage()
+		msg.Type = SIGNAL
+		msg.Path = "/org/freedesktop/DBus"
+		msg.Iface = "org.freedesktop.DBus"
+		msg.Member = "NameOwnerChanged"
+		msg.Sig = "sss"
+		msg.Params.Push(name)
+		msg.Params.Push(oldOwner)
+		msg.Params.Push(newOwner)
+		server.Emit(msg)
+	}
+	changed("org.example.Foo", "", ":1.5") // already reported by GetNameOwner
+	changed("org.example.Bar", "", ":1.6")
+	changed("org.example.Foo", ":1.5", "")
+
+	if c := <-changes; c[0] != "" || c[1] != ":1.5" {
+		t.Error("#3 Failed", c)
+	}
+	if c := <-changes; c[0] != ":1.5" || c[1] != "" {
+		t.Error("#4 Failed", c)
+	}
+	cancel()
+	if len(changes) != 0 {
+		t.Error("#5 Failed", <-changes)
+	}
+}
+
+func TestActivationEnvironment(t *testing.T) {
+	server, p, e := NewMockServer()
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	defer p.Close()
+	server.Expect(MatchMethod("org.freedesktop.DBus", "UpdateActivationEnvironment"), nil)
+
+	// no systemd on the bus
+	if e := p.UpdateActivationEnvironment(map[string]string{"LANG": "C", "DISPLAY": ":0"}); e != nil {
+		t.Fatal("#2 Failed", e)
+	}
+	env, e := p.GetActivationEnvironment()
+	if e != nil || len(env) != 2 || env["DISPLAY"] != ":0" {
+		t.Error("#3 Failed", env, e)
+	}
+	for _, msg := range server.Received() {
+		if msg.Member == "UpdateActivationEnvironment" {
+			entries := msg.Params.At(0).(*vector.Vector)
+			if entries.Len() != 2 || entries.At(0).(*vector.Vector).At(0).(string) != "DISPLAY" {
+				t.Error("#4 Failed", entries)
+			}
+		}
+	}
+
+	vars := new(vector.Vector)
+	vars.Push("LANG=en_US.UTF-8")
+	vars.Push("PATH=/usr/bin:/bin")
+	reply := NewMessage()
+	reply.Sig = "v"
+	reply.Params.Push(Variant{"as", vars})
+	server.Expect(MatchMethod(PROPERTIES_INTERFACE, "Get"), reply)
+	env, e = p.GetActivationEnvironment()
+	if e != nil || len(env) != 3 || env["LANG"] != "C" || env["PATH"] != "/usr/bin:/bin" {
+		t.Error("#5 Failed", env, e)
+	}
+}
+
+func TestSetApplicationName(t *testing.T) {
+	server, p, e := NewMockServer()
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	defer p.Close()
+	var logged string
+	p.SetLogger(func(msg string) { logged = msg })
+
+	// the mock daemon does not know SetConnectionName
+	if e = p.SetApplicationName("monitor"); e != nil || p.ApplicationName() != "monitor" {
+		t.Error("#2 Failed", p.ApplicationName(), e)
+	}
+	p.logf("hello %d", 1)
+	if logged != "monitor: hello 1" {
+		t.Error("#3 Failed", logged)
+	}
+
+	denied := NewMessage()
+	denied.Type = ERROR
+	denied.ErrorName = "org.freedesktop.DBus.Error.AccessDenied"
+	server.Expect(MatchMethod("org.freedesktop.DBus", "SetConnectionName"), denied)
+	if e = p.SetApplicationName("other"); e == nil || p.ApplicationName() != "monitor" {
+		t.Error("#4 Failed", p.ApplicationName(), e)
+	}
+}
+
+func TestRequestNameOnConflict(t *testing.T) {
+	server, p, e := NewMockServer()
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	defer p.Close()
+	queued := NewMessage()
+	queued.Sig = "u"
+	queued.Params.Push(uint32(REQUEST_NAME_REPLY_IN_QUEUE))
+	server.Expect(MatchMethod("org.freedesktop.DBus", "RequestName"), queued)
+	server.Expect(MatchMethod("org.freedesktop.DBus", "ReleaseName"), nil)
+
+	cancel := make(chan bool)
+	statuses, e := p.RequestNameOnConflict(cancel, "org.example.Foo")
+	if e != nil {
+		t.Fatal("#2 Failed", e)
+	}
+	if s := <-statuses; s != NAME_STATUS_QUEUED {
+		t.Error("#3 Failed", s)
+	}
+	signal := func(member string, name string) {
+		msg := NewMessage()
+		msg.Type = SIGNAL
+		msg.Path = "/org/freedesktop/DBus"
+		msg.Iface = "org.freedesktop.DBus"
+		msg.Member = member
+		msg.Sig = "s"
+		msg.Params.Push(name)
+		server.Emit(msg)
+	}
+	signal("NameAcquired", "org.example.Bar")
+	signal("NameAcquired", "org.example.Foo")
+	if s := <-statuses; s != NAME_STATUS_PRIMARY {
+		t.Error("#4 Failed", s)
+	}
+	signal("NameLost", "org.example.Foo")
+	if s := <-statuses; s != NAME_STATUS_LOST {
+		t.Error("#5 Failed", s)
+	}
+
+	close(cancel)
+	for _ = range statuses {
+		t.Error("#6 Failed")
+	}
+}
+
+func TestGetBusProperty(t *testing.T) {
+	server, p, e := NewMockServer()
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	defer p.Close()
+
+	// the mock daemon has no bus properties
+	features, e := p.GetBusProperty("Features")
+	if v, ok := features.(*vector.Vector); e != nil || !ok || v.Len() != 0 {
+		t.Error("#2 Failed", features, e)
+	}
+	if _, e = p.GetBusProperty("Other"); e != ErrDaemonUnsupported {
+		t.Error("#3 Failed", e)
+	}
+
+	ifaces := new(vector.Vector)
+	ifaces.Push("org.freedesktop.DBus.Monitoring")
+	reply := NewMessage()
+	reply.Sig = "v"
+	reply.Params.Push(Variant{"as", ifaces})
+	server.Expect(MatchMethod(PROPERTIES_INTERFACE, "Get"), reply)
+	for i := 0; i < 2; i++ {
+		value, e := p.GetBusProperty("Interfaces")
+		if v, ok := value.(*vector.Vector); e != nil || !ok || v.Len() != 1 || v.At(0).(string) != "org.freedesktop.DBus.Monitoring" {
+			t.Error("#4 Failed", i, value, e)
+		}
+	}
+	// Features was cached before the daemon was given bus properties
+	if features, e = p.GetBusProperty("Features"); e != nil || features.(*vector.Vector).Len() != 0 {
+		t.Error("#5 Failed", features, e)
+	}
+	gets := 0
+	for _, msg := range server.Received() {
+		if msg.Member == "Get" {
+			gets++
+		}
+	}
+	if gets != 3 {
+		t.Error("#6 Failed", gets)
+	}
+}
+
+func TestFindServicesByProperty(t *testing.T) {
+	server, p, e := NewMockServer()
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	defer p.Close()
+	names := NewMessage()
+	names.Sig = "as"
+	list := new(vector.Vector)
+	for _, name := range []string{"org.freedesktop.DBus", ":1.1", "org.example.B", "org.example.A", "org.example.C"} {
+		list.Push(name)
+	}
+	names.Params.Push(list)
+	server.Expect(MatchMethod("org.freedesktop.DBus", "ListNames"), names)
+	deviceType := func(path string, v uint32) {
+		reply := NewMessage()
+		reply.Sig = "v"
+		reply.Params.Push(Variant{"u", v})
+		server.Expect(func(call *Message) bool {
+			return call.Member == "Get" && call.Path == path
+		}, reply)
+	}
+	deviceType("/org/example/A", 2)
+	deviceType("/org/example/B", 1)
+	deviceType("/org/example/C", 2)
+
+	found, e := p.FindServicesByProperty(nil, "org.example.Device", "DeviceType", "2")
+	if e != nil || len(found) != 2 || found[0] != "org.example.A" || found[1] != "org.example.C" {
+		t.Error("#2 Failed", found, e)
+	}
+	for _, msg := range server.Received() {
+		if msg.Member == "Get" && msg.Dest == ":1.1" {
+			t.Error("#3 Failed", msg)
+		}
+	}
+	if _, e = p.FindServicesByProperty(CancelAfter(0), "org.example.Device", "DeviceType", "2"); e != ErrCanceled {
+		t.Error("#4 Failed", e)
+	}
+}
+
+func TestOurCredentials(t *testing.T) {
+	server, p, e := NewMockServer()
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	defer p.Close()
+	credential := func(member string, v uint32) {
+		reply := NewMessage()
+		reply.Sig = "u"
+		reply.Params.Push(v)
+		server.Expect(func(call *Message) bool {
+			return call.Member == member && call.Params.Len() == 1 && call.Params.At(0).(string) == MOCK_UNIQUE_NAME
+		}, reply)
+	}
+	credential("GetConnectionUnixProcessID", 1234)
+	credential("GetConnectionUnixUser", 1000)
+
+	if pid, e := p.OurPID(); e != nil || pid != 1234 {
+		t.Error("#2 Failed", pid, e)
+	}
+	if uid, e := p.OurUID(); e != nil || uid != 1000 {
+		t.Error("#3 Failed", uid, e)
+	}
+}
+
+func TestGetAllDaemonMatchRules(t *testing.T) {
+	server, p, e := NewMockServer()
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	defer p.Close()
+
+	if _, e = p.GetAllDaemonMatchRules(); e != ErrDaemonUnsupported {
+		t.Error("#2 Failed", e)
+	}
+
+	rules := new(vector.Vector)
+	rules.Push("type='signal'")
+	rules.Push("type='signal',member='Foo'")
+	entries := new(vector.Vector)
+	entries.Push([]interface{}{":1.7", rules})
+	entries.Push([]interface{}{":1.8", new(vector.Vector)})
+	resp := NewMessage()
+	resp.Sig = "a{sas}"
+	resp.Params.Push(entries)
+	server.Expect(MatchMethod("org.freedesktop.DBus.Debug.Stats", "GetAllMatchRules"), resp)
+	got, e := p.GetAllDaemonMatchRules()
+	want := map[string][]string{
+		":1.7": []string{"type='signal'", "type='signal',member='Foo'"},
+		":1.8": []string{},
+	}
+	if e != nil || !reflect.DeepEqual(got, want) {
+		t.Error("#3 Failed", got, e)
+	}
+}
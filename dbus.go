@@ -1,14 +1,23 @@
+// Package dbus is a client for the D-Bus message bus.
+//
+// Connect with NewSessionBus or NewSystemBus followed by Initialize.
+// GetObject and GetInterface look up remote objects through
+// introspection; CallMethod calls them, EmitSignal emits signals and
+// AddSignalHandler subscribes to signals selected by a MatchRule, which
+// AddSignalFilter can narrow down by content.
+// EncodeMessage, DecodeMessage and Parse expose the wire format for tools.
 package dbus
 
 import (
 	"net"
-	"regexp"
 	"os"
 	"fmt"
 	"container/vector"
-//	"strings"
+	"strings"
 	"bytes"
 	"reflect"
+	"sync"
+	"time"
 )
 
 const dbusXMLIntro = `
@@ -90,136 +99,637 @@ const dbusXMLIntro = `
   </interface>
 </node>`
 
+var (
+	ErrTimeout         = os.NewError("Timeout")
+	ErrCanceled        = os.NewError("Canceled")
+	ErrClosed          = os.NewError("Connection closed")
+	ErrNotInitialized  = os.NewError("Connection not initialized: call Initialize (or Handshake) first")
+	ErrMessageTooLarge = os.NewError("Message larger than the negotiated size")
+	// ErrNotReady is the former name of ErrNotInitialized.
+	ErrNotReady = ErrNotInitialized
+)
+
 type signalHandler struct{
 	mr MatchRule
 	proc func(*Message)
+	filters []func(*Message) bool // replaced, never modified, under handlerMutex
+	matched bool // the rule was counted by AddMatch
+	pooled bool // added by AddSignalHandler, so may run off the message loop
 }
 
+// Handle identifies a signal handler added with AddSignalHandler.
+type Handle struct {
+	handler *signalHandler
+}
+
+// Connection is a connection to a message bus.
 type Connection struct {
 	path              string
 	uniqName          string
-	guid              string
-	methodCallReplies map[uint32](func(msg *Message))
+	guid              string // see GUID; under stateMutex
+	auth              Authenticator // set by DialAuth; nil means the default
+	methodCallReplies map[uint32]*pendingCall
+	replyMutex        sync.Mutex
 	signalMatchRules  *vector.Vector
+	dispatchers       []*SignalDispatcher // under handlerMutex
+	signalPool        *signalPool         // under handlerMutex; nil runs handlers on the loop
+	signalWorkers     int
+	signalOverflow    SignalOverflow
+	signalQueue       int
+	signalsDropped    uint64 // by replaced pools
+	matchRefs         map[string]int // AddMatch count per rule string
+	matchMutex        sync.Mutex     // held across the daemon calls for matchRefs
+	introCache        map[string]Introspect
+	introMutex        sync.Mutex
+	handlerMutex      sync.Mutex
 	conn              net.Conn
+	stateMutex        sync.Mutex
+	failure           os.Error
+	logger            func(string)
+	appName           string // see SetApplicationName
+	onReceived        func(*Message)
+	panicRecovery     bool // see SetPanicRecovery; under stateMutex
+	nameMapper        *NameMapper // see SetNameMapper; under stateMutex
+	tracer            func(RoundTripTrace)
+	auditHooks        []func(Direction, *Message) // replaced, never modified, under stateMutex
+	serialSource      func() uint32
+	serial            uint32 // the last serial sent; see nextSerial
+	serialMutex       sync.Mutex
+	encodeOptions     EncodeOptions
+	serializers       []Serializer // replaced, never modified, under stateMutex
+	maxMessageSize    int64 // proposed during the handshake
+	agreedSize        int64 // agreed during the handshake; 0 means no limit
+	strictReplies     bool
+	localCalls        bool
+	retryPolicy       *RetryPolicy
+	ownedNames        map[string]bool // well-known names held, for local calls
+	activationEnv     map[string]string // set with UpdateActivationEnvironment; under stateMutex
+	busProperties     map[string]interface{} // cached by GetBusProperty; under stateMutex
+	clock             func() int64
+	timer             func(int64) <-chan bool
 	buffer            *bytes.Buffer
+	readMutex         sync.Mutex
+	writeMutex        sync.Mutex
+	writeQueue        chan []byte // nil unless SetWriteQueueSize enabled it
+	writeQueueHigh    chan []byte // of PRIORITY_HIGH messages, next to writeQueue
+	writeDone         chan bool   // closed when the writer of writeQueue exits
+	writeQueueMutex   sync.RWMutex
+	msgChan           chan *Message
+	loop              loopState
+	history           messageHistory
 	proxy             *Interface
+	ready             bool
+	limiter           *callLimiter
+	exports           map[string]map[string]*exportedMethod // by path+" "+iface, then member
+	exportMutex       sync.Mutex
+	propertyStructs   map[string]*ExportedPropertyStruct // by path+" "+iface
+	objectManagers    map[string]bool                    // paths given to ExportObjectManager
+	closing           bool      // CloseWait refuses new calls; under stateMutex
+	runningCalls      int       // incoming calls being handled; under stateMutex
+	callsIdle         chan bool // closed when runningCalls drops to 0 while closing
+	abandoned         chan bool // closed when the connection fails; see Message.Done
 }
 
+// Object is a remote object, identified by its destination and path.
 type Object struct {
 	dest  string
 	path  string
 	intro Introspect
 }
 
+// ObjectRef names an object by destination and path. Object paths in
+// replies are only meaningful together with the sender of the reply, so
+// UnmarshalMessage fills in Dest when storing an 'o' into an ObjectRef.
+// An ObjectRef may be passed wherever an object path argument is expected.
+type ObjectRef struct {
+	Dest string
+	Path string
+}
+
+// Ref returns the reference to p.
+func (p *Object) Ref() ObjectRef { return ObjectRef{p.dest, p.path} }
+
+// Interface is an interface of an Object.
 type Interface struct {
 	obj   *Object
 	name  string
 	intro InterfaceData
 }
 
-func NewSessionBus() (*Connection, os.Error){
+// Dial connects to the bus at address (such as
+// "unix:path=/var/run/dbus/system_bus_socket") without authenticating, so
+// that socket options or checks can be applied to the transport first.
+// Call Handshake (or Initialize) before sending messages.
+//
+// address may list several addresses separated by ';'; they are tried in
+// order and the first that connects is used. If none does, the error is a
+// *MultiDialError.
+func Dial(address string) (*Connection, os.Error) {
+	var conn net.Conn
+	var err os.Error
+	addresses := strings.Split(address, ";", 0)
+	errs := new(vector.Vector)
+	tried := new(vector.StringVector)
+	for _, addr := range addresses {
+		if addr == "" {
+			continue
+		}
+		conn, err = dialAddress(addr)
+		if err == nil {
+			address = addr
+			break
+		}
+		tried.Push(addr)
+		errs.Push(err)
+	}
+	if conn == nil {
+		if errs.Len() == 1 {
+			return nil, errs.At(0).(os.Error)
+		}
+		merr := &MultiDialError{tried.Data(), make([]os.Error, errs.Len())}
+		for i := 0; i < errs.Len(); i++ {
+			merr.Errors[i] = errs.At(i).(os.Error)
+		}
+		return nil, merr
+	}
+
+	return newConnection(address, conn), nil
+}
+
+// DialAuth is Dial with the Authenticator that Handshake (or Initialize)
+// uses unless its options name another.
+func DialAuth(address string, auth Authenticator) (*Connection, os.Error) {
+	p, err := Dial(address)
+	if err != nil {
+		return nil, err
+	}
+	p.auth = auth
+	return p, nil
+}
+
+// newConnection returns a Connection over conn, ready for Handshake.
+func newConnection(address string, conn net.Conn) *Connection {
 	bus := new(Connection)
-	bus.path = os.Getenv("DBUS_SESSION_BUS_ADDRESS")
+	bus.path = address
+	bus.conn = conn
+	bus.methodCallReplies = make(map[uint32]*pendingCall)
+	bus.signalMatchRules = new(vector.Vector)
+	bus.introCache = make(map[string]Introspect)
+	bus.proxy = bus.getProxy()
+	bus.buffer = bytes.NewBuffer([]byte{})
+	bus.msgChan = make(chan *Message)
+	bus.limiter = newCallLimiter(CallLimits{})
+	bus.abandoned = make(chan bool)
+	return bus
+}
 
-	var re *regexp.Regexp
-	re, _ = regexp.Compile("^unix:abstract=(.*),guid=(.*)")
+func dialAddress(address string) (net.Conn, os.Error) {
+	transport, params, err := parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
 
-	m := re.ExecuteString(bus.path)
-	if nil != m {
-		abPath := bus.path[m[2]:m[3]] // get regexp 1st group
-		addr, _ := net.ResolveUnixAddr("unix", "\x00"+abPath)
+	switch transport {
+	case "unix":
+		path := params["path"]
+		if abstract, isAbstract := params["abstract"]; isAbstract {
+			path = "\x00" + abstract
+		}
+		addr, err := net.ResolveUnixAddr("unix", path)
+		if err != nil {
+			return nil, err
+		}
 		conn, err := net.DialUnix("unix", nil, addr)
-		if err != nil{
+		if err != nil {
 			return nil, err
 		}
-		bus.conn = conn
-		return bus,nil
+		return conn, nil
+	case "tcp":
+		host := params["host"]
+		if 0 <= strings.Index(host, ":") {
+			host = "[" + host + "]"
+		}
+		return net.Dial("tcp", "", host+":"+params["port"])
 	}
+	return nil, os.NewError("Unsupported address: " + address)
+}
 
-	return nil, os.NewError("NewSessionBus Failed")
+// NewSessionBus connects to the session bus. Call Initialize before use.
+func NewSessionBus() (*Connection, os.Error){
+	address := os.Getenv("DBUS_SESSION_BUS_ADDRESS")
+	if address == "" {
+		return nil, os.NewError("NewSessionBus Failed: DBUS_SESSION_BUS_ADDRESS not set")
+	}
+	return Dial(address)
 }
 
+// NewSystemBus connects to the system bus. Call Initialize before use.
 func NewSystemBus() (*Connection, os.Error){
-	bus := new(Connection)
-	bus.path = "unix:path=/var/run/dbus/system_bus_socket"
+	return Dial("unix:path=/var/run/dbus/system_bus_socket")
+}
 
-	addr, _ := net.ResolveUnixAddr("unix", "/var/run/dbus/system_bus_socket")
-	conn, err := net.DialUnix("unix", nil, addr)
-	if err != nil{
-		return nil, err
-	}
-	bus.conn = conn
-	return bus,nil
+// HandshakeOptions configures Handshake.
+type HandshakeOptions struct {
+	// Auth authenticates the connection; nil means the Authenticator
+	// given to DialAuth, or AUTH EXTERNAL with the usual fallbacks. File
+	// descriptor passing is not supported, so NEGOTIATE_UNIX_FD is never
+	// sent.
+	Auth Authenticator
+	// Limits bounds the incoming method calls handled at once; the zero
+	// value means no limits.
+	Limits CallLimits
 }
 
+// Initialize authenticates, starts the message loop and registers the
+// connection with the bus. It is Handshake with default options.
 func (p *Connection) Initialize() os.Error {
-	p.methodCallReplies = make(map[uint32]func(*Message))
-	p.signalMatchRules = new(vector.Vector)
-	p.proxy = p._GetProxy()
-	p.buffer = bytes.NewBuffer([]byte{})
-	p._Auth()
-	go p._RunLoop()
-	p._SendHello()
+	return p.Handshake(nil)
+}
+
+// Handshake authenticates a connection returned by Dial, starts the
+// message loop and registers the connection with the bus. Bytes the bus
+// sends right after authentication are kept for the message loop.
+func (p *Connection) Handshake(opts *HandshakeOptions) os.Error {
+	return p.HandshakeCancel(nil, opts)
+}
+
+// HandshakeCancel is Handshake giving up when cancel is closed, in which
+// case it closes the connection and returns ErrCanceled.
+func (p *Connection) HandshakeCancel(cancel <-chan bool, opts *HandshakeOptions) os.Error {
+	if p.ready {
+		return os.NewError("Handshake: already done")
+	}
+	auth := p.auth
+	if opts != nil {
+		if opts.Auth != nil {
+			auth = opts.Auth
+		}
+		p.limiter = newCallLimiter(opts.Limits)
+	}
+	done := make(chan os.Error, 1)
+	go func() { done <- p.authenticate(auth) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			return err
+		}
+	case <-cancel:
+		// closing the transport ends the authentication
+		p.fail(ErrCanceled)
+		<-done
+		return ErrCanceled
+	}
+	p.ready = true
+	go p.runLoop()
+	if err := p.sendHello(cancel); err != nil {
+		if err == ErrCanceled {
+			p.fail(ErrCanceled)
+		}
+		return err
+	}
 	return nil
 }
 
-func (p *Connection) _Auth() os.Error {
+func (p *Connection) authenticate(a Authenticator) os.Error {
+	if a == nil {
+		// containers may map our uid to nobody; let the daemon use the
+		// socket credentials, then try the uid the kernel reports
+		mechanisms := []Authenticator{ExternalAuth{}, ExternalAuth{NoIdentity: true}}
+		if uid, err := readProcUID(); err == nil && uid != fmt.Sprintf("%d", os.Getuid()) {
+			mechanisms = []Authenticator{mechanisms[0], mechanisms[1], ExternalAuth{UID: uid}}
+		}
+		a = MultiMechanismAuth{mechanisms}
+	}
+	switch a.(type) {
+	case AuthMechanism, MultiMechanismAuth, *MultiMechanismAuth:
+	default:
+		// a custom Authenticator talks to the transport itself
+		guid, err := a.Authenticate(p.conn)
+		if err != nil {
+			return err
+		}
+		p.stateMutex.Lock()
+		p.guid = guid
+		p.stateMutex.Unlock()
+		return nil
+	}
+
 	auth := new(authState)
-	auth.AddAuthenticator(new(AuthExternal))
+	auth.log = func(msg string) { p.logf("%s", msg) }
+	if err := auth.addAuthenticator(a); err != nil {
+		return err
+	}
 
-	return auth.Authenticate(p.conn)
+	p.stateMutex.Lock()
+	auth.maxSize = p.maxMessageSize
+	p.stateMutex.Unlock()
+	guid, err := auth.Authenticate(p.conn)
+	if err != nil {
+		return err
+	}
+	p.stateMutex.Lock()
+	p.guid = guid
+	p.agreedSize = auth.agreedSize
+	p.stateMutex.Unlock()
+	// anything read past the end of the handshake is message data
+	p.buffer.Write(auth.in.Bytes())
+	return nil
+}
+
+// SetMaxNegotiatedSize makes the handshake propose n bytes as the largest
+// message either side sends; call it before Initialize. It only applies to
+// peer-to-peer connections whose server supports the NEGOTIATE_SIZE
+// extension; otherwise the connection proceeds without a limit.
+func (p *Connection) SetMaxNegotiatedSize(n int64) {
+	p.stateMutex.Lock()
+	p.maxMessageSize = n
+	p.stateMutex.Unlock()
+}
+
+// NegotiatedSize returns the largest message size agreed during the
+// handshake, or 0 if none was. Larger messages are not sent;
+// ErrMessageTooLarge is returned instead.
+func (p *Connection) NegotiatedSize() int64 {
+	p.stateMutex.Lock()
+	defer p.stateMutex.Unlock()
+	return p.agreedSize
+}
+
+// SetLogger sets the function diagnostic messages are passed to; nil
+// disables logging.
+func (p *Connection) SetLogger(logger func(msg string)) {
+	p.stateMutex.Lock()
+	p.logger = logger
+	p.stateMutex.Unlock()
 }
 
-func (p *Connection) _MessageReceiver(msgChan chan *Message) {
+func (p *Connection) logf(format string, args ...) {
+	p.stateMutex.Lock()
+	logger := p.logger
+	name := p.appName
+	p.stateMutex.Unlock()
+	if logger != nil && name != "" {
+		logger(name + ": " + fmt.Sprintf(format, args))
+	} else if logger != nil {
+		logger(fmt.Sprintf(format, args))
+	}
+}
+
+// SetOnMessageReceived makes the connection call fn with every message
+// read from the transport, before it is dispatched. fn must not modify the
+// message. Injected messages are not passed to fn. Only one fn is kept; nil
+// removes it.
+func (p *Connection) SetOnMessageReceived(fn func(msg *Message)) {
+	p.stateMutex.Lock()
+	p.onReceived = fn
+	p.stateMutex.Unlock()
+}
+
+func (p *Connection) received(msg *Message) {
+	p.remember(msg)
+	p.stateMutex.Lock()
+	fn := p.onReceived
+	p.stateMutex.Unlock()
+	if fn != nil {
+		fn(msg)
+	}
+	p.audit(INCOMING, msg)
+}
+
+func (p *Connection) messageReceiver() {
 	for {
-		msg, e := p._PopMessage()
+		msg, e := p.readMessage()
+		if e != nil {
+			p.fail(e)
+			p.enqueue(nil) // tell the run loop to stop
+			return
+		}
+		p.received(msg)
+		p.enqueue(msg)
+	}
+}
+
+// readMessage reads the next valid message from the transport.
+func (p *Connection) readMessage() (*Message, os.Error) {
+	p.readMutex.Lock()
+	defer p.readMutex.Unlock()
+	for {
+		msg, e := p.popMessage()
 		if e == nil {
-			msgChan <- msg
-			continue // might be another msg in p.buffer
+			// fd passing is not negotiated, so no descriptors arrive
+			if _, e = checkUnixFds(msg, nil); e != nil {
+				p.rejectMessage(msg, e)
+				continue
+			}
+			if isLocal(msg) {
+				p.logf("dropping message spoofing %s", LOCAL_INTERFACE)
+				p.rejectMessage(msg, os.NewError("message uses the local namespace"))
+				continue
+			}
+			return msg, nil
+		}
+		if e = p.updateBuffer(); e != nil {
+			return nil, e
 		}
-		p._UpdateBuffer()
 	}
+	return nil, nil
 }
 
-func (p *Connection) _RunLoop() {
-	msgChan := make(chan *Message)
-	go p._MessageReceiver(msgChan)
+// ReadMessage reads exactly one message from the transport, blocking until
+// it is complete. It is meant for peer-to-peer connections whose owner runs
+// its own read loop; messages read this way are not dispatched. It is safe
+// to call while the dispatch loop is running, although the two then compete
+// for incoming messages.
+func (p *Connection) ReadMessage() (*Message, os.Error) {
+	if p.buffer == nil {
+		return nil, os.NewError("ReadMessage: connection not initialized")
+	}
+	if err := p.Err(); err != nil {
+		return nil, err
+	}
+	return p.readMessage()
+}
+
+func (p *Connection) runLoop() {
+	go p.messageReceiver()
 	for {
 		select {
-		case msg := <-msgChan:
-			p._MessageDispatch(msg)
+		case msg := <-p.msgChan:
+			p.progress(true)
+			if msg == nil {
+				return
+			}
+			p.messageDispatch(msg)
+			p.progress(false)
 		}
 	}
 }
 
-func (p *Connection) _MessageDispatch(msg *Message) {
+// InjectMessage hands msg to the dispatch loop as if it had been received
+// from the bus, so signal handlers can be tested without a daemon.
+//
+// FOR TESTING ONLY.
+func (p *Connection) InjectMessage(msg *Message) os.Error {
+	if p.msgChan == nil {
+		return os.NewError("InjectMessage: connection not initialized")
+	}
+	if msg == nil {
+		return os.NewError("InjectMessage: nil message")
+	}
+	if err := p.Err(); err != nil {
+		return err
+	}
+	p.enqueue(msg)
+	return nil
+}
+
+// fail marks the connection as failed with err and closes the transport.
+// Only the first failure is recorded.
+func (p *Connection) fail(err os.Error) {
+	p.stateMutex.Lock()
+	if p.failure != nil {
+		p.stateMutex.Unlock()
+		return
+	}
+	p.failure = err
+	if p.abandoned != nil {
+		close(p.abandoned)
+	}
+	p.stateMutex.Unlock()
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	p.stopWriteQueue()
+	p.completeAll(err)
+	p.messageDispatch(newDisconnected())
+	p.stopSignalPool()
+}
+
+// Err returns the reason the connection failed or was closed, or nil while
+// it is usable.
+func (p *Connection) Err() os.Error {
+	p.stateMutex.Lock()
+	defer p.stateMutex.Unlock()
+	return p.failure
+}
+
+// Close closes the connection.
+func (p *Connection) Close() os.Error {
+	p.fail(ErrClosed)
+	return nil
+}
+
+// CloseWait closes the connection gracefully: incoming method calls are
+// answered with org.freedesktop.DBus.Error.Disconnected, and the
+// connection is closed once the calls already being handled have replied.
+// If cancel is closed first, the connection is closed at once, the
+// handlers still running see Message.Done fire, and ErrCanceled is
+// returned.
+func (p *Connection) CloseWait(cancel <-chan bool) os.Error {
+	p.stateMutex.Lock()
+	p.closing = true
+	if p.runningCalls == 0 {
+		p.stateMutex.Unlock()
+		return p.Close()
+	}
+	if p.callsIdle == nil {
+		p.callsIdle = make(chan bool)
+	}
+	idle := p.callsIdle
+	p.stateMutex.Unlock()
+
+	select {
+	case <-idle:
+		return p.Close()
+	case <-cancel:
+	}
+	p.Close()
+	return ErrCanceled
+}
+
+// startCall counts an incoming call about to be handled; it returns false
+// if CloseWait refuses new calls.
+func (p *Connection) startCall(msg *Message) bool {
+	p.stateMutex.Lock()
+	defer p.stateMutex.Unlock()
+	if p.closing {
+		return false
+	}
+	p.runningCalls++
+	msg.done = p.abandoned
+	return true
+}
+
+// endCall counts a call answered.
+func (p *Connection) endCall() {
+	p.stateMutex.Lock()
+	p.runningCalls--
+	if p.runningCalls == 0 && p.callsIdle != nil {
+		close(p.callsIdle)
+		p.callsIdle = nil
+	}
+	p.stateMutex.Unlock()
+}
+
+func (p *Connection) messageDispatch(msg *Message) {
 	if msg == nil {
 		return
 	}
 
 	switch msg.Type {
-	case METHOD_RETURN:
-		rs := msg.replySerial
-		if replyFunc, ok := p.methodCallReplies[rs]; ok {
-			replyFunc(msg)
-			p.methodCallReplies[rs] = nil, false
-		}
-  case SIGNAL:
-		for v := range p.signalMatchRules.Iter() {
-			handler := v.(signalHandler)
-			if handler.mr._Match(msg) {
+	case METHOD_CALL:
+		p.dispatchCall(msg)
+	case METHOD_RETURN, ERROR:
+		p.complete(msg.replySerial, msg, nil)
+	case SIGNAL:
+		p.handlerMutex.Lock()
+		for _, d := range p.dispatchers {
+			d.enqueue(msg)
+		}
+		if p.signalMatchRules == nil {
+			p.handlerMutex.Unlock()
+			return
+		}
+		handlers := p.signalMatchRules.Data()
+		filters := make([][]func(*Message) bool, len(handlers))
+		for i, v := range handlers {
+			filters[i] = v.(*signalHandler).filters
+		}
+		pool := p.signalPool
+		p.handlerMutex.Unlock()
+		pooled := make([]*signalHandler, 0, len(handlers))
+		for i, v := range handlers {
+			handler := v.(*signalHandler)
+			if !handler.mr.match(msg) || !passFilters(filters[i], msg) {
+				continue
+			}
+			if pool != nil && handler.pooled {
+				pooled = pooled[0 : len(pooled)+1]
+				pooled[len(pooled)-1] = handler
+			} else {
 				handler.proc(msg)
 			}
 		}
-	case ERROR:
-		fmt.Println("ERROR")
-		fmt.Printf("%#v\n", msg)
+		if 0 < len(pooled) {
+			pool.run(func() {
+				for _, handler := range pooled {
+					handler.proc(msg)
+				}
+			})
+		}
 	}
 }
 
-func (p *Connection) _PopMessage() (*Message, os.Error) {
-	msg, n, err := _Unmarshal(p.buffer.Bytes())
+// rejectMessage drops an invalid incoming message. A caller waiting for it
+// as a reply gets err instead of hanging.
+func (p *Connection) rejectMessage(msg *Message, err os.Error) {
+	if msg.Type == METHOD_RETURN || msg.Type == ERROR {
+		p.complete(msg.replySerial, nil, err)
+	}
+}
+
+func (p *Connection) popMessage() (*Message, os.Error) {
+	msg, n, err := unmarshal(p.buffer.Bytes())
 	if err != nil {
 		return nil, err
 	}
@@ -227,7 +737,7 @@ func (p *Connection) _PopMessage() (*Message, os.Error) {
 	return msg, nil
 }
 
-func (p *Connection) _UpdateBuffer() os.Error {
+func (p *Connection) updateBuffer() os.Error {
 	//	_, e := p.buffer.ReadFrom(p.conn);
 	buff := make([]byte, 4096)
 	n, e := p.conn.Read(buff)
@@ -235,26 +745,219 @@ func (p *Connection) _UpdateBuffer() os.Error {
 	return e
 }
 
-func (p *Connection) _SendSync(msg *Message, callback func(*Message)) os.Error {
-	seri := uint32(msg.serial)
-	recvChan := make(chan int)
-	p.methodCallReplies[seri] = func(rmsg *Message) {
-		callback(rmsg)
-		recvChan <- 0
+// after returns a channel that receives once ns nanoseconds have passed.
+// A non-positive ns never fires.
+func after(ns int64) <-chan bool {
+	if ns <= 0 {
+		return nil
 	}
+	ch := make(chan bool, 1)
+	go func() {
+		time.Sleep(ns)
+		ch <- true
+	}()
+	return ch
+}
+
+// CancelAfter returns a channel closed once ns nanoseconds have passed, or
+// already closed if ns is not positive. Passed as the cancel argument of
+// an operation, it sets a deadline for the whole operation however many
+// calls it makes; one channel may bound several operations.
+func CancelAfter(ns int64) <-chan bool {
+	if ns <= 0 {
+		return closedChan
+	}
+	ch := make(chan bool)
+	go func() {
+		<-after(ns)
+		close(ch)
+	}()
+	return ch
+}
+
+// checkReady returns ErrNotInitialized before Handshake and the failure of
+// the connection after it.
+func (p *Connection) checkReady() os.Error {
+	if !p.ready {
+		return ErrNotInitialized
+	}
+	return p.Err()
+}
+
+// SendWithPriority sends msg without waiting for a reply. With a write
+// queue (see SetWriteQueueSize), a message of Priority PRIORITY_HIGH is
+// written before the queued PRIORITY_NORMAL ones, so that, for instance,
+// calls stay responsive while many signals are emitted. Method calls to
+// the daemon always have the high priority. The priority is not sent on
+// the wire and does not change the order of messages of equal priority.
+func (p *Connection) SendWithPriority(msg *Message) os.Error {
+	return p.send(msg)
+}
+
+// priorityOf returns the write queue msg goes to.
+func priorityOf(msg *Message) uint8 {
+	if msg.Type == METHOD_CALL && msg.Dest == "org.freedesktop.DBus" {
+		return PRIORITY_HIGH
+	}
+	return msg.Priority
+}
+
+// send writes msg without waiting for a reply.
+func (p *Connection) send(msg *Message) os.Error {
+	if err := p.checkReady(); err != nil {
+		return err
+	}
+	p.assignSerial(msg)
+	if err := msg.IsValid(); err != nil {
+		return err
+	}
+	buff, err := p.encode(msg)
+	if err != nil {
+		return err
+	}
+	if p.isLocalCall(msg) {
+		p.dispatchLocal(buff)
+		return nil
+	}
+	return p.writePriority(buff, priorityOf(msg))
+}
+
+// SetEncodeOptions sets how the connection encodes the messages it sends.
+func (p *Connection) SetEncodeOptions(opts EncodeOptions) {
+	p.stateMutex.Lock()
+	p.encodeOptions = opts
+	p.stateMutex.Unlock()
+}
 
-	buff, _ := msg._Marshal()
-	p.conn.Write(buff)
-	<-recvChan // synchronize
+// encode returns the wire format of msg under the connection's options.
+func (p *Connection) encode(msg *Message) ([]byte, os.Error) {
+	p.stateMutex.Lock()
+	opts := p.encodeOptions
+	limit := p.agreedSize
+	serializers := p.serializers
+	p.stateMutex.Unlock()
+	original := msg
+	if 0 < len(serializers) {
+		params, err := serializeParams(serializers, msg.Params)
+		if err != nil {
+			return nil, err
+		}
+		serialized := *msg
+		serialized.Params = params
+		msg = &serialized
+	}
+	buff, err := msg.marshalWith(opts)
+	if err == nil && 0 < limit && limit < int64(len(buff)) {
+		return nil, ErrMessageTooLarge
+	}
+	if err == nil {
+		p.audit(OUTGOING, original)
+	}
+	return buff, err
+}
+
+// write writes buff to the connection in one piece, so that messages
+// written by concurrent senders never interleave.
+func (p *Connection) write(buff []byte) os.Error {
+	return p.writePriority(buff, PRIORITY_NORMAL)
+}
+
+// writePriority is write putting buff in the write queue of priority.
+func (p *Connection) writePriority(buff []byte, priority uint8) os.Error {
+	if queued, err := p.enqueueWrite(buff, priority); queued {
+		return err
+	}
+	return p.writeNow(buff)
+}
+
+func (p *Connection) writeNow(buff []byte) os.Error {
+	p.writeMutex.Lock()
+	defer p.writeMutex.Unlock()
+	_, err := p.conn.Write(buff)
+	return err
+}
+
+// call sends msg and waits for its reply. An ERROR reply is returned as
+// an *Error.
+func (p *Connection) call(msg *Message, timeout int64, cancel <-chan bool) (*Message, os.Error) {
+	return p.callRetry(msg, timeout, cancel, nil)
+}
+
+// callOnce is call without retries.
+func (p *Connection) callOnce(msg *Message, timeout int64, cancel <-chan bool) (*Message, os.Error) {
+	select {
+	case <-cancel:
+		// canceled before it was sent
+		return nil, ErrCanceled
+	default:
+	}
+	return p.traceCall(msg, func() (*Message, os.Error) {
+		call, err := p.sendAsync(msg)
+		if err != nil {
+			return nil, err
+		}
+		reply, err := p.waitReply(call, timeout, cancel)
+		if err != nil {
+			return nil, err
+		}
+		if reply.Type == ERROR {
+			return reply, errorFromMessage(reply)
+		}
+		return reply, nil
+	})
+}
+
+func (p *Connection) sendSync(msg *Message, callback func(*Message)) os.Error {
+	// an error reply is passed to callback, as is any reply
+	reply, err := p.call(msg, 0, nil)
+	if reply == nil {
+		return err
+	}
+	callback(reply)
 	return nil
 }
 
-func (p *Connection) _SendHello() os.Error {
-	p.CallMethod(p.proxy, "Hello")
+func (p *Connection) sendHello(cancel <-chan bool) os.Error {
+	msg, err := newMethodCall(p.proxy, "Hello")
+	if err != nil {
+		return err
+	}
+	reply, err := p.call(msg, 0, cancel)
+	if err != nil {
+		return err
+	}
+	if 0 < reply.Params.Len() {
+		p.uniqName, _ = reply.Params.At(0).(string)
+	}
 	return nil
 }
 
-func (p *Connection) _GetIntrospect(dest string, path string) Introspect {
+// UniqueName returns the unique name the bus assigned to the connection.
+func (p *Connection) UniqueName() string { return p.uniqName }
+
+// GUID returns the GUID the server sent at the end of authentication,
+// which identifies the bus instance, or "" before Handshake.
+func (p *Connection) GUID() string {
+	p.stateMutex.Lock()
+	defer p.stateMutex.Unlock()
+	return p.guid
+}
+
+func (p *Connection) getIntrospect(dest string, path string) Introspect {
+	intro, _ := p.introspect(nil, dest, path)
+	return intro
+}
+
+// IntrospectCancel introspects path of dest, giving up with ErrCanceled
+// when cancel is closed; nothing is sent if it is closed already. Use
+// CancelAfter for a timeout.
+func (p *Connection) IntrospectCancel(cancel <-chan bool, dest string, path string) (Introspect, os.Error) {
+	return p.introspect(cancel, dest, path)
+}
+
+// introspect introspects path of dest unless cancel is closed first. The
+// result is nil if path cannot be introspected.
+func (p *Connection) introspect(cancel <-chan bool, dest string, path string) (Introspect, os.Error) {
 	msg := NewMessage()
 	msg.Type = METHOD_CALL
 	msg.Path = path
@@ -262,40 +965,80 @@ func (p *Connection) _GetIntrospect(dest string, path string) Introspect {
 	msg.Iface = "org.freedesktop.DBus.Introspectable"
 	msg.Member = "Introspect"
 
-	var intro Introspect
-
-	p._SendSync(msg, func(reply *Message) {
-		if v, ok := reply.Params.At(0).(string); ok {
-			if i, err := NewIntrospect(v); err == nil {
-				intro = i
-			}
-		}
-	})
+	reply, err := p.call(msg, 0, cancel)
+	if err != nil {
+		return nil, err
+	}
+	v, ok := reply.Params.At(0).(string)
+	if !ok {
+		return nil, ErrNoIntrospection
+	}
+	intro, err := NewIntrospect(v)
+	if err != nil {
+		return nil, err
+	}
 
-	return intro
+	p.introMutex.Lock()
+	p.introCache[dest+" "+path] = intro
+	p.introMutex.Unlock()
+	return intro, nil
 }
 
-func (p *Connection) Interface(obj *Object, name string) *Interface {
-
-	if obj == nil || obj.intro == nil {
-		return nil
+// pathsWithInterface returns the paths of dest other than exclude already
+// introspected on this connection that implement name. It only consults the
+// cache and never talks to the bus.
+func (p *Connection) pathsWithInterface(dest string, name string, exclude string) []string {
+	paths := new(vector.StringVector)
+	p.introMutex.Lock()
+	for key, intro := range p.introCache {
+		if !strings.HasPrefix(key, dest+" ") {
+			continue
+		}
+		path := key[len(dest)+1:]
+		if path != exclude && intro.GetInterfaceData(name) != nil {
+			paths.Push(path)
+		}
 	}
+	p.introMutex.Unlock()
+	return paths.Data()
+}
 
-	iface := new(Interface)
-	iface.obj = obj
-	iface.name = name
+// GetInterface returns the interface name of obj. When obj does not
+// implement it the error is an *InterfaceError describing what obj does
+// implement and where else on the same destination name was seen.
+func (p *Connection) GetInterface(obj *Object, name string) (*Interface, os.Error) {
+	if err := p.checkReady(); err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return nil, os.NewError("nil Object")
+	}
+	if obj.intro == nil {
+		return nil, &InterfaceError{Reason: ErrNoIntrospection, Dest: obj.dest, Path: obj.path, Name: name}
+	}
 
 	data := obj.intro.GetInterfaceData(name)
 	if nil == data {
-		return nil
+		err := &InterfaceError{Reason: ErrInterfaceNotFound, Dest: obj.dest, Path: obj.path, Name: name}
+		err.Available = interfaceNames(obj.intro)
+		err.OtherPaths = p.pathsWithInterface(obj.dest, name, obj.path)
+		return nil, err
 	}
 
+	iface := new(Interface)
+	iface.obj = obj
+	iface.name = name
 	iface.intro = data
 
-	return iface
+	return iface, nil
 }
 
-func _ArgToVector(args ...) *vector.Vector {
+// Interface is GetInterface.
+func (p *Connection) Interface(obj *Object, name string) (*Interface, os.Error) {
+	return p.GetInterface(obj, name)
+}
+
+func argToVector(args ...) *vector.Vector {
 	vec := new(vector.Vector)
 	v := reflect.NewValue(args).(*reflect.StructValue)
 	for i := 0; i < v.NumField(); i++ {
@@ -308,7 +1051,7 @@ func _ArgToVector(args ...) *vector.Vector {
 	return vec
 }
 
-func (p *Connection) _GetProxy() *Interface {
+func (p *Connection) getProxy() *Interface {
 	obj := new(Object)
 	obj.path = "/org/freedesktop/DBus"
 	obj.dest = "org.freedesktop.DBus"
@@ -322,11 +1065,14 @@ func (p *Connection) _GetProxy() *Interface {
 	return iface
 }
 
-func (p *Connection) CallMethod(iface *Interface, name string, args ...) ([]interface{}, os.Error) {
-
+func newMethodCall(iface *Interface, name string, args ...) (*Message, os.Error) {
+	if iface == nil {
+		return nil, os.NewError("nil Interface (see GetInterface for the reason)")
+	}
 	method := iface.intro.GetMethodData(name)
 	if nil == method {
-		return nil, os.NewError("Invalid Method")
+		return nil, os.NewError(fmt.Sprintf("Invalid Method: %s has no method %s (methods: %s)",
+			iface.name, name, strings.Join(methodNames(iface.intro), ", ")))
 	}
 
 	msg := NewMessage()
@@ -337,21 +1083,84 @@ func (p *Connection) CallMethod(iface *Interface, name string, args ...) ([]inte
 	msg.Dest = iface.obj.dest
 	msg.Member = name
 	msg.Sig = method.GetInSignature()
-	msg.Params.AppendVector(_ArgToVector(args))
+	msg.Params.AppendVector(argToVector(args))
+
+	return msg, nil
+}
+
+// CallMethod calls method name of iface with args and returns the reply
+// values. An error reply is returned as an *Error.
+func (p *Connection) CallMethod(iface *Interface, name string, args ...) ([]interface{}, os.Error) {
+	if err := p.checkReady(); err != nil {
+		return nil, err
+	}
+
+	msg, e := newMethodCall(iface, name, args)
+	if e != nil {
+		return nil, e
+	}
 
 	var ret []interface{}
-	p._SendSync(msg, func(reply *Message) { 
-		fmt.Println("CallMethodRet: " , reply.Params.Data())
+	var err os.Error
+	p.sendSync(msg, func(reply *Message) { 
+		if reply.Type == ERROR {
+			err = errorFromMessage(reply)
+			return
+		}
+		err = p.checkReplySignature(iface, name, reply)
 		ret = reply.Params.Data()})
 
-	return ret,nil
+	return ret,err
 }
 
+// SyncCall calls method name of iface like CallMethod but returns the
+// reply message itself, for callers that need its header fields (serial,
+// sender) or forward it. An error reply is returned along with its *Error.
+func (p *Connection) SyncCall(iface *Interface, name string, args ...) (*Message, os.Error) {
+	if err := p.checkReady(); err != nil {
+		return nil, err
+	}
+	msg, err := newMethodCall(iface, name, args)
+	if err != nil {
+		return nil, err
+	}
+	return p.call(msg, 0, nil)
+}
+
+// checkReplySignature compares the signature of reply with the out
+// signature of method name of iface.
+func (p *Connection) checkReplySignature(iface *Interface, name string, reply *Message) os.Error {
+	declared := iface.intro.GetMethodData(name).GetOutSignature()
+	err := &ReplySignatureError{iface.name, name, declared, reply.Sig}
+	switch compareReplySignature(declared, reply.Sig) {
+	case REPLY_SIGNATURE_EXTRA:
+		if p.isStrict() {
+			return err
+		}
+		p.logf("ignoring extra values: %s", err)
+	case REPLY_SIGNATURE_INCOMPATIBLE:
+		return err
+	}
+	return nil
+}
+
+// EmitSignal emits signal name of iface with args.
 func (p *Connection) EmitSignal(iface *Interface, name string, args ...) os.Error{
+	if err := p.checkReady(); err != nil {
+		return err
+	}
+
+	msg, err := newSignal(iface, name, argToVector(args))
+	if err != nil {
+		return err
+	}
+	return p.send(msg)
+}
 
+func newSignal(iface *Interface, name string, params *vector.Vector) (*Message, os.Error) {
 	signal := iface.intro.GetSignalData(name)
 	if nil == signal {
-		return os.NewError("Invalid Signalx")
+		return nil, os.NewError("Invalid Signalx")
 	}
 
 	msg := NewMessage()
@@ -362,25 +1171,151 @@ func (p *Connection) EmitSignal(iface *Interface, name string, args ...) os.Erro
 	msg.Dest = iface.obj.dest
 	msg.Member = name
 	msg.Sig = signal.GetSignature()
-	msg.Params.AppendVector(_ArgToVector(args))
+	msg.Params.AppendVector(params)
 
-	buff, _ := msg._Marshal()
-	_,err := p.conn.Write(buff)
-
-	return err
+	return msg, nil
 }
 
+// GetObject returns the object at path of dest, introspecting it.
 func(p *Connection) GetObject(dest string, path string) *Object{
 
 	obj := new(Object)
 	obj.path = path
 	obj.dest = dest
-	obj.intro = p._GetIntrospect(dest, path)
+	obj.intro = p.getIntrospect(dest, path)
 
 	return obj
 }
 
-func(p *Connection) AddSignalHandler(mr *MatchRule, proc func(*Message)) {
-	p.signalMatchRules.Push(signalHandler{*mr, proc})
-	p.CallMethod(p.proxy, "AddMatch", mr._ToString())
+// GetObjectCancel is GetObject giving up with ErrCanceled when cancel is
+// closed, for services that may not answer. Unlike GetObject, it also
+// returns the error when the object cannot be introspected.
+func (p *Connection) GetObjectCancel(cancel <-chan bool, dest string, path string) (*Object, os.Error) {
+	intro, err := p.introspect(cancel, dest, path)
+	if err != nil {
+		return nil, err
+	}
+	return &Object{dest: dest, path: path, intro: intro}, nil
+}
+
+// GetObjectRef returns the object ref names, introspecting it.
+func (p *Connection) GetObjectRef(ref ObjectRef) *Object {
+	return p.GetObject(ref.Dest, ref.Path)
+}
+
+// AddSignalHandler calls proc for every signal matching mr.
+func(p *Connection) AddSignalHandler(mr *MatchRule, proc func(*Message)) Handle {
+	return Handle{p.addHandler(&signalHandler{mr: *mr, proc: proc, pooled: true})}
+}
+
+// RemoveSignalHandler removes the handler h and its match rule.
+func (p *Connection) RemoveSignalHandler(h Handle) {
+	p.removeSignalHandler(h.handler)
+}
+
+// AddSignalFilter makes the handler h skip signals for which fn returns
+// false. Unlike a MatchRule, fn can look at the arguments of the signal;
+// it runs in the dispatch goroutine after the bus has delivered the
+// signal, so it should be quick. All filters of a handler must pass.
+func (p *Connection) AddSignalFilter(h Handle, fn func(*Message) bool) {
+	p.handlerMutex.Lock()
+	defer p.handlerMutex.Unlock()
+	old := h.handler.filters
+	filters := make([]func(*Message) bool, len(old)+1)
+	for i, f := range old {
+		filters[i] = f
+	}
+	filters[len(old)] = fn
+	h.handler.filters = filters
+}
+
+func passFilters(filters []func(*Message) bool, msg *Message) bool {
+	for _, fn := range filters {
+		if !fn(msg) {
+			return false
+		}
+	}
+	return true
+}
+
+func(p *Connection) addSignalHandler(mr *MatchRule, proc func(*Message)) *signalHandler {
+	return p.addHandler(&signalHandler{mr: *mr, proc: proc})
+}
+
+func(p *Connection) addHandler(handler *signalHandler) *signalHandler {
+	mr := &handler.mr
+	p.handlerMutex.Lock()
+	if p.signalMatchRules == nil {
+		p.signalMatchRules = new(vector.Vector)
+	}
+	p.signalMatchRules.Push(handler)
+	p.handlerMutex.Unlock()
+	if err := p.AddMatch(mr); err != nil {
+		p.logf("AddMatch %s: %s", mr, err)
+	} else {
+		handler.matched = true
+	}
+	return handler
+}
+
+func(p *Connection) removeSignalHandler(handler *signalHandler) os.Error {
+	found := false
+	p.handlerMutex.Lock()
+	for i := 0; p.signalMatchRules != nil && i < p.signalMatchRules.Len(); i++ {
+		if p.signalMatchRules.At(i).(*signalHandler) == handler {
+			p.signalMatchRules.Delete(i)
+			found = true
+			break
+		}
+	}
+	p.handlerMutex.Unlock()
+	if found && handler.matched {
+		if err := p.RemoveMatch(&handler.mr); err != nil {
+			p.logf("RemoveMatch %s: %s", &handler.mr, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// AddMatch asks the bus to route the messages matching mr to the
+// connection. Rules are counted: the bus is only asked the first time a
+// rule is added, by AddMatch or a signal handler, and each successful
+// AddMatch must be undone by one RemoveMatch.
+func (p *Connection) AddMatch(mr *MatchRule) os.Error {
+	rule := mr.toString()
+	p.matchMutex.Lock()
+	defer p.matchMutex.Unlock()
+	if p.matchRefs == nil {
+		p.matchRefs = make(map[string]int)
+	}
+	if p.matchRefs[rule] == 0 && !mr.isLocal() {
+		if _, err := p.CallMethod(p.proxy, "AddMatch", rule); err != nil {
+			return err
+		}
+	}
+	p.matchRefs[rule]++
+	return nil
+}
+
+// RemoveMatch undoes one AddMatch of mr; the bus is asked to drop the rule
+// when the last one is undone.
+func (p *Connection) RemoveMatch(mr *MatchRule) os.Error {
+	rule := mr.toString()
+	p.matchMutex.Lock()
+	defer p.matchMutex.Unlock()
+	n := p.matchRefs[rule]
+	if n == 0 {
+		return os.NewError("RemoveMatch: rule was not added: " + rule)
+	}
+	if 1 < n {
+		p.matchRefs[rule] = n - 1
+		return nil
+	}
+	p.matchRefs[rule] = 0, false
+	if mr.isLocal() {
+		return nil
+	}
+	_, err := p.CallMethod(p.proxy, "RemoveMatch", rule)
+	return err
 }
@@ -2,10 +2,64 @@
 package dbus
 
 import (
+	"bytes"
+	"container/vector"
 	"testing"
 	"fmt"
+	"math"
+	"net"
+	"os"
+	"sync"
+	"time"
 )
 
+var testPathMutex sync.Mutex
+var testPaths int
+
+// testPath returns a temporary path unique to this process and call, so
+// that tests running at the same time do not collide.
+func testPath() string {
+	testPathMutex.Lock()
+	testPaths++
+	n := testPaths
+	testPathMutex.Unlock()
+	dir := os.Getenv("TMPDIR")
+	if dir == "" {
+		dir = "/tmp"
+	}
+	return fmt.Sprintf("%s/dbus-test-%d-%d", dir, os.Getpid(), n)
+}
+
+// listenTest listens on a unix socket at a testPath. The caller closes
+// the listener and removes the path.
+func listenTest(t *testing.T) (net.Listener, string) {
+	path := testPath()
+	os.Remove(path)
+	l, e := net.Listen("unix", path)
+	if e != nil {
+		t.Fatal("listenTest:", e)
+	}
+	return l, path
+}
+
+// dialTest returns a connection dialed to a fresh socket, not yet
+// authenticated, and the server end of it. The socket is gone by then.
+func dialTest(t *testing.T) (*Connection, net.Conn) {
+	l, path := listenTest(t)
+	defer os.Remove(path)
+	defer l.Close()
+	p, e := Dial("unix:path=" + path)
+	if e != nil {
+		t.Fatal("dialTest:", e)
+	}
+	server, e := l.Accept()
+	if e != nil {
+		p.Close()
+		t.Fatal("dialTest:", e)
+	}
+	return p, server
+}
+
 func TestDbus(t *testing.T){
 	con,_ := NewSessionBus()
 	e := con.Initialize()
@@ -14,11 +68,270 @@ func TestDbus(t *testing.T){
 
 	obj := con.GetObject("org.freedesktop.Notifications", "/org/freedesktop/Notifications")
 
-	inf := con.Interface(obj,"org.freedesktop.Notifications")
-	if inf == nil { t.Error("Failed #3")}
+	inf, e := con.Interface(obj,"org.freedesktop.Notifications")
+	if e != nil { t.Error("Failed #3", e)}
 
 	ret,_ := con.CallMethod(inf, "Notify", "dbus.go", uint32(0), "info", "test", "test_body", []string{}, map[uint32] interface{}{}, int32(2000))
 	fmt.Println(ret)
 
 	
 }
+
+func TestDialFallback(t *testing.T) {
+	_, e := Dial("unix:path=/nonexistent/bus1;unix:path=/nonexistent/bus2")
+	merr, ok := e.(*MultiDialError)
+	if !ok {
+		t.Fatal("#1 Failed", e)
+	}
+	if len(merr.Errors) != 2 || merr.Addresses[1] != "unix:path=/nonexistent/bus2" {
+		t.Error("#2 Failed", merr)
+	}
+
+	if _, e = Dial("unix:path=/nonexistent/bus1"); e == nil {
+		t.Error("#3 Failed")
+	}
+	if _, ok = e.(*MultiDialError); ok {
+		t.Error("#4 Failed")
+	}
+}
+
+func TestSignalFilter(t *testing.T) {
+	p := new(Connection)
+	p.signalMatchRules = new(vector.Vector)
+	count := 0
+	handler := &signalHandler{mr: MatchRule{Type: "signal"}, proc: func(*Message) { count++ }}
+	p.signalMatchRules.Push(handler)
+	h := Handle{handler}
+
+	msg := NewMessage()
+	msg.Type = SIGNAL
+	msg.Params.Push(uint32(70))
+	p.messageDispatch(msg)
+	if count != 1 {
+		t.Error("#1 Failed", count)
+	}
+
+	p.AddSignalFilter(h, func(msg *Message) bool { return msg.Params.At(0).(uint32) == 70 })
+	p.messageDispatch(msg)
+	if count != 2 {
+		t.Error("#2 Failed", count)
+	}
+
+	p.AddSignalFilter(h, func(msg *Message) bool { return false })
+	p.messageDispatch(msg)
+	if count != 2 {
+		t.Error("#3 Failed", count)
+	}
+}
+
+func TestOnMessageReceived(t *testing.T) {
+	p := new(Connection)
+	msg := NewMessage()
+	var got *Message
+	p.SetOnMessageReceived(func(msg *Message) { got = msg })
+	p.received(msg)
+	if got != msg {
+		t.Error("#1 Failed")
+	}
+
+	got = nil
+	p.SetOnMessageReceived(nil)
+	p.received(msg)
+	if got != nil {
+		t.Error("#2 Failed")
+	}
+}
+
+func checkNotInitialized(t *testing.T, p *Connection, label string) {
+	obj := p.GetObject("org.example", "/")
+	if _, e := p.GetInterface(obj, "org.example.Foo"); e != ErrNotInitialized {
+		t.Error(label+" #1 Failed", e)
+	}
+	if _, e := p.CallMethod(nil, "Foo"); e != ErrNotInitialized {
+		t.Error(label+" #2 Failed", e)
+	}
+	if e := p.EmitSignal(nil, "Foo"); e != ErrNotInitialized {
+		t.Error(label+" #3 Failed", e)
+	}
+	if e := p.EmitBatch([]SignalSpec{}); e != ErrNotInitialized {
+		t.Error(label+" #4 Failed", e)
+	}
+	if _, e := p.CallTimeout(0, "org.example", "/", "org.example.Foo", "Foo"); e != ErrNotInitialized {
+		t.Error(label+" #5 Failed", e)
+	}
+	if _, e := p.PingTimed(nil, "org.example"); e != ErrNotInitialized {
+		t.Error(label+" #6 Failed", e)
+	}
+	h := p.AddSignalHandler(&MatchRule{Type: "signal"}, func(*Message) {})
+	p.RemoveSignalHandler(h)
+	p.Stats()
+	if e := p.Close(); e != nil && e != ErrClosed {
+		t.Error(label+" #7 Failed", e)
+	}
+}
+
+func TestNotInitialized(t *testing.T) {
+	checkNotInitialized(t, &Connection{}, "raw")
+
+	p, server := dialTest(t)
+	defer server.Close()
+	checkNotInitialized(t, p, "dialed")
+}
+
+func TestInterfaceError(t *testing.T) {
+	p := new(Connection)
+	p.ready = true
+	obj := &Object{dest: "org.example", path: "/"}
+	_, e := p.Interface(obj, "org.example.Foo")
+	if ie, ok := e.(*InterfaceError); !ok || ie.Reason != ErrNoIntrospection {
+		t.Error("#1 Failed", e)
+	}
+
+	obj.intro, _ = NewIntrospect(introStr)
+	_, e = p.Interface(obj, "org.example.Foo")
+	if ie, ok := e.(*InterfaceError); !ok || ie.Reason != ErrInterfaceNotFound {
+		t.Error("#2 Failed", e)
+	}
+	if iface, e := p.Interface(obj, "org.freedesktop.SampleInterface"); e != nil || iface == nil {
+		t.Error("#3 Failed", e)
+	}
+}
+
+func TestSerialWrapAround(t *testing.T) {
+	p, server := dialTest(t)
+	defer p.Close()
+	defer server.Close()
+	p.ready = true
+
+	p.serialMutex.Lock()
+	p.serial = math.MaxUint32 - 1
+	p.serialMutex.Unlock()
+	for i := 0; i < 2; i++ {
+		msg := NewMessage()
+		msg.Type = SIGNAL
+		msg.Path = "/org/example"
+		msg.Iface = "org.example.Foo"
+		msg.Member = "Bar"
+		if e := p.send(msg); e != nil {
+			t.Fatal("#4 Failed", e)
+		}
+	}
+
+	want := []uint32{math.MaxUint32, 1}
+	buff := bytes.NewBuffer([]byte{})
+	for i := 0; i < len(want); {
+		msg, n, e := DecodeMessage(buff.Bytes())
+		if e == nil {
+			if msg.Serial() != want[i] {
+				t.Error("#5 Failed", i, msg.Serial())
+			}
+			buff.Next(n)
+			i++
+			continue
+		}
+		chunk := make([]byte, 256)
+		m, e := server.Read(chunk)
+		if e != nil {
+			t.Fatal("#6 Failed", e)
+		}
+		buff.Write(chunk[0:m])
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	p, server := dialTest(t)
+	defer p.Close()
+	defer server.Close()
+
+	signal := func(member string) []byte {
+		msg := NewMessage()
+		msg.Type = SIGNAL
+		msg.Path = "/org/example"
+		msg.Iface = "org.example.Foo"
+		msg.Member = member
+		msg.Sig = "s"
+		msg.Params.Push(member)
+		buff, e := EncodeMessage(msg)
+		if e != nil {
+			t.Fatal("#1 Failed", e)
+		}
+		return buff
+	}
+	first := signal("First")
+	second := signal("Second")
+	go func() {
+		// a message cut in the header and in the body, then one whole
+		server.Write(first[0:10])
+		time.Sleep(10e6)
+		server.Write(first[10 : len(first)-2])
+		time.Sleep(10e6)
+		server.Write(first[len(first)-2:])
+		server.Write(second)
+	}()
+	for _, member := range []string{"First", "Second"} {
+		msg, e := p.ReadMessage()
+		if e != nil || msg.Member != member || msg.Params.At(0).(string) != member {
+			t.Error("#2 Failed", member, msg, e)
+		}
+	}
+	if p.buffer.Len() != 0 {
+		t.Error("#3 Failed", p.buffer.Len())
+	}
+}
+
+func TestSyncCall(t *testing.T) {
+	p := fakeService(t, func(call *Message) *Message {
+		if call.Member == "Mogrify" {
+			return newErrorReply(call, "org.example.Error.Busy", "busy")
+		}
+		msg := newMethodReturn(call)
+		msg.Sender = ":1.9"
+		msg.Sig = "sa{us}"
+		msg.Params.Push("bar")
+		msg.Params.Push(new(vector.Vector))
+		return msg
+	})
+	defer p.Close()
+	intro, _ := NewIntrospect(introStr)
+	obj := &Object{dest: "org.freedesktop.Sample", path: "/org/freedesktop/sample_object", intro: intro}
+	iface, _ := p.Interface(obj, "org.freedesktop.SampleInterface")
+
+	reply, e := p.SyncCall(iface, "Frobate", int32(1))
+	if e != nil || reply.Type != METHOD_RETURN || reply.Sender != ":1.9" || reply.ReplySerial() == 0 ||
+		reply.Params.At(0).(string) != "bar" {
+		t.Error("#1 Failed", e)
+	}
+	reply, e = p.SyncCall(iface, "Mogrify", []interface{}{int32(1), int32(2), new(vector.Vector)})
+	if err, ok := e.(*Error); !ok || err.Name != "org.example.Error.Busy" || reply == nil || reply.Type != ERROR {
+		t.Error("#2 Failed", e)
+	}
+}
+
+func TestSerialsPerConnection(t *testing.T) {
+	p, server := dialTest(t)
+	defer p.Close()
+	defer server.Close()
+	q, other := dialTest(t)
+	defer q.Close()
+	defer other.Close()
+	p.ready = true
+	q.ready = true
+
+	// a connection numbers its messages alone, whatever others send
+	p.serialMutex.Lock()
+	p.serial = math.MaxUint32
+	p.serialMutex.Unlock()
+	for i, conn := range []*Connection{q, p, q} {
+		msg := NewMessage()
+		msg.Type = SIGNAL
+		msg.Path = "/org/example"
+		msg.Iface = "org.example.Foo"
+		msg.Member = "Bar"
+		if e := conn.send(msg); e != nil {
+			t.Fatal("#1 Failed", i, e)
+		}
+		if want := []uint32{1, 1, 2}[i]; msg.Serial() != want {
+			t.Error("#2 Failed", i, msg.Serial(), want)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package dbus
+
+import (
+	"container/vector"
+	"reflect"
+	"sync"
+)
+
+// Deduper drops signals that repeat the last signal seen from the same
+// sender, object, interface and member, such as a PropertiesChanged with
+// the values already announced. Its Filter method fits AddSignalFilter and
+// Subscription.AddFilter.
+//
+// Bodies are compared by value: integers of different widths holding the
+// same number are equal, variants compare by their value, and dicts
+// compare regardless of the order of their entries.
+type Deduper struct {
+	mutex sync.Mutex
+	limit int
+	key   func(*Message) interface{}
+	last  map[string]*dedupeEntry
+	order *vector.StringVector // cache keys, least recently seen first
+}
+
+type dedupeEntry struct {
+	sig  string
+	body interface{}
+}
+
+// NewDeduper returns a Deduper remembering at most limit signals; the
+// least recently seen are forgotten first. key, if not nil, maps a signal
+// to the value compared instead of its body, for instance to ignore a
+// timestamp argument.
+func NewDeduper(limit int, key func(*Message) interface{}) *Deduper {
+	return &Deduper{limit: limit, key: key, last: make(map[string]*dedupeEntry), order: new(vector.StringVector)}
+}
+
+// Filter returns false if msg repeats the previous signal with the same
+// sender, path, interface and member, and true otherwise.
+func (p *Deduper) Filter(msg *Message) bool {
+	name := msg.Sender + " " + msg.Path + " " + msg.Iface + " " + msg.Member
+	entry := &dedupeEntry{msg.Sig, msg.Params.Data()}
+	if p.key != nil {
+		entry = &dedupeEntry{"", p.key(msg)}
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	prev, ok := p.last[name]
+	if ok {
+		p.forget(name)
+	}
+	p.last[name] = entry
+	p.order.Push(name)
+	for 0 < p.limit && p.limit < p.order.Len() {
+		p.forget(p.order.At(0))
+	}
+	if !ok || prev.sig != entry.sig {
+		return true
+	}
+	if entry.sig != "" {
+		return !bodyEqual(entry.sig, prev.body.([]interface{}), entry.body.([]interface{}))
+	}
+	return !valueEqual(prev.body, entry.body)
+}
+
+// forget drops name from the cache.
+func (p *Deduper) forget(name string) {
+	p.last[name] = nil, false
+	for i := 0; i < p.order.Len(); i++ {
+		if p.order.At(i) == name {
+			p.order.Delete(i)
+			return
+		}
+	}
+}
+
+// Reset forgets every signal seen, so the next of each kind passes.
+func (p *Deduper) Reset() {
+	p.mutex.Lock()
+	p.last = make(map[string]*dedupeEntry)
+	p.order = new(vector.StringVector)
+	p.mutex.Unlock()
+}
+
+// bodyEqual compares two decoded bodies of signature sig.
+func bodyEqual(sig string, a []interface{}, b []interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		block, err := getSigBlock(sig, 0)
+		if err != nil {
+			return valueEqual(a[i:], b[i:])
+		}
+		if !sigValueEqual(block, a[i], b[i]) {
+			return false
+		}
+		sig = sig[len(block):]
+	}
+	return true
+}
+
+// sigValueEqual compares two decoded values of the single complete type
+// sig. Unlike valueEqual it knows which arrays are dicts.
+func sigValueEqual(sig string, a interface{}, b interface{}) bool {
+	switch {
+	case len(sig) < 2:
+		return valueEqual(a, b)
+	case sig[0] == '(':
+		x, ok1 := sequence(a)
+		y, ok2 := sequence(b)
+		if !ok1 || !ok2 {
+			return valueEqual(a, b)
+		}
+		return bodyEqual(sig[1:len(sig)-1], x, y)
+	case sig[0] == 'a' && sig[1] == '{':
+		return dictEqual(sig[1:], a, b)
+	case sig[0] == 'a':
+		x, ok1 := sequence(a)
+		y, ok2 := sequence(b)
+		if !ok1 || !ok2 {
+			return valueEqual(a, b)
+		}
+		if len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !sigValueEqual(sig[1:], x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return valueEqual(a, b)
+}
+
+// dictEqual compares two decoded dicts whose entries have signature sig
+// ("{kv}") regardless of the order of the entries.
+func dictEqual(sig string, a interface{}, b interface{}) bool {
+	x, ok1 := sequence(a)
+	y, ok2 := sequence(b)
+	if !ok1 || !ok2 {
+		return valueEqual(a, b)
+	}
+	if len(x) != len(y) {
+		return false
+	}
+	entrySig := sig[1 : len(sig)-1]
+	used := make([]bool, len(y))
+	for _, ex := range x {
+		found := false
+		for j, ey := range y {
+			if !used[j] && sigValueEqual("("+entrySig+")", ex, ey) {
+				used[j] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// valueEqual compares two decoded or to be encoded values: numbers by
+// value whatever their width, variants by their value, and arrays, structs
+// and maps element by element.
+func valueEqual(a interface{}, b interface{}) bool {
+	if v, ok := a.(Variant); ok {
+		a = v.Value
+	}
+	if v, ok := b.(Variant); ok {
+		b = v.Value
+	}
+	if x, ok := sequence(a); ok {
+		y, ok := sequence(b)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !valueEqual(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if equal, ok := numberEqual(a, b); ok {
+		return equal
+	}
+	if ma, ok := reflect.NewValue(a).(*reflect.MapValue); ok {
+		mb, ok := reflect.NewValue(b).(*reflect.MapValue)
+		if !ok || ma.Len() != mb.Len() {
+			return false
+		}
+		for _, k := range ma.Keys() {
+			if !mapHas(mb, k.Interface(), ma.Elem(k).Interface()) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// mapHas reports whether m has a key equal to key with a value equal to
+// value.
+func mapHas(m *reflect.MapValue, key interface{}, value interface{}) bool {
+	for _, k := range m.Keys() {
+		if valueEqual(k.Interface(), key) {
+			return valueEqual(m.Elem(k).Interface(), value)
+		}
+	}
+	return false
+}
+
+// sequence returns the elements of an array or struct value.
+func sequence(v interface{}) ([]interface{}, bool) {
+	switch x := v.(type) {
+	case *vector.Vector:
+		if x == nil {
+			return nil, true
+		}
+		return x.Data(), true
+	case []interface{}:
+		return x, true
+	case []byte:
+		return nil, false
+	}
+	s, ok := reflect.NewValue(v).(*reflect.SliceValue)
+	if !ok {
+		return nil, false
+	}
+	elems := make([]interface{}, s.Len())
+	for i := range elems {
+		elems[i] = s.Elem(i).Interface()
+	}
+	return elems, true
+}
+
+// numberEqual compares two numbers by value; ok is false unless both are
+// integers or both are floats. NaN equals NaN, so a repeated NaN is not a
+// change.
+func numberEqual(a interface{}, b interface{}) (equal bool, ok bool) {
+	if x, ok := floatValue(a); ok {
+		y, ok := floatValue(b)
+		if !ok {
+			return false, false
+		}
+		return x == y || x != x && y != y, true
+	}
+	ia, aSigned, ok := integerValue(a)
+	if !ok {
+		return false, false
+	}
+	ib, bSigned, ok := integerValue(b)
+	if !ok {
+		return false, false
+	}
+	// a negative signed value equals no unsigned one
+	if aSigned != bSigned && (aSigned && int64(ia) < 0 || bSigned && int64(ib) < 0) {
+		return false, true
+	}
+	return ia == ib, true
+}
+
+func floatValue(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case float32:
+		return float64(x), true
+	case float64:
+		return x, true
+	}
+	return 0, false
+}
+
+// integerValue returns the bits of an integer, sign-extended if it is signed.
+func integerValue(v interface{}) (bits uint64, signed bool, ok bool) {
+	switch x := v.(type) {
+	case byte:
+		return uint64(x), false, true
+	case uint16:
+		return uint64(x), false, true
+	case uint32:
+		return uint64(x), false, true
+	case uint64:
+		return x, false, true
+	case uint:
+		return uint64(x), false, true
+	case int16:
+		return uint64(x), true, true
+	case int32:
+		return uint64(x), true, true
+	case int64:
+		return uint64(x), true, true
+	case int:
+		return uint64(x), true, true
+	}
+	return 0, false, false
+}
@@ -0,0 +1,100 @@
+package dbus
+
+import (
+	"container/vector"
+	"math"
+	"testing"
+)
+
+type valueCase struct {
+	a, b  interface{}
+	equal bool
+}
+
+func TestValueEqual(t *testing.T) {
+	nan := math.NaN()
+	cases := []valueCase{
+		valueCase{int32(5), int64(5), true},
+		valueCase{uint32(5), int16(5), true},
+		valueCase{int32(-1), uint32(math.MaxUint32), false},
+		valueCase{int64(-1), uint64(math.MaxUint64), false},
+		valueCase{int32(5), float64(5), false},
+		valueCase{nan, nan, true},
+		valueCase{Variant{"u", uint32(7)}, int64(7), true},
+		valueCase{[]interface{}{int32(1), "a"}, vectorOf(int64(1), "a"), true},
+		valueCase{vectorOf(int32(1)), vectorOf(int32(1), int32(2)), false},
+		valueCase{map[string]interface{}{"a": int32(1)}, map[string]interface{}{"a": uint8(1)}, true},
+		valueCase{map[string]interface{}{"a": int32(1)}, map[string]interface{}{"b": int32(1)}, false},
+		valueCase{"x", "x", true},
+		valueCase{nil, nil, true},
+		valueCase{nil, "x", false},
+	}
+	for i, c := range cases {
+		if valueEqual(c.a, c.b) != c.equal {
+			t.Error("#1 Failed", i, c.a, c.b)
+		}
+	}
+}
+
+func vectorOf(values ...) *vector.Vector {
+	return argToVector(values)
+}
+
+func TestBodyEqualDicts(t *testing.T) {
+	a := vectorOf(vectorOf("x", int32(1)), vectorOf("y", int32(2)))
+	b := vectorOf(vectorOf("y", int32(2)), vectorOf("x", int32(1)))
+	if !bodyEqual("sa{si}", []interface{}{"i", a}, []interface{}{"i", b}) {
+		t.Error("#1 Failed")
+	}
+	// an array of structs is ordered
+	if bodyEqual("sa(si)", []interface{}{"i", a}, []interface{}{"i", b}) {
+		t.Error("#2 Failed")
+	}
+	c := vectorOf(vectorOf("x", int32(1)), vectorOf("y", int32(3)))
+	if bodyEqual("sa{si}", []interface{}{"i", a}, []interface{}{"i", c}) {
+		t.Error("#3 Failed")
+	}
+}
+
+func TestDeduper(t *testing.T) {
+	changed := func(sender string, value interface{}) *Message {
+		msg := NewMessage()
+		msg.Type = SIGNAL
+		msg.Sender = sender
+		msg.Path = "/org/example"
+		msg.Iface = PROPERTIES_INTERFACE
+		msg.Member = "PropertiesChanged"
+		msg.Sig = "sa{sv}as"
+		msg.Params.Push("org.example.Foo")
+		msg.Params.Push(vectorOf(vectorOf("Name", value)))
+		msg.Params.Push(new(vector.Vector))
+		return msg
+	}
+	d := NewDeduper(2, nil)
+	if !d.Filter(changed(":1.1", "a")) {
+		t.Error("#1 Failed")
+	}
+	if d.Filter(changed(":1.1", "a")) {
+		t.Error("#2 Failed")
+	}
+	if !d.Filter(changed(":1.1", "b")) || !d.Filter(changed(":1.2", "b")) {
+		t.Error("#3 Failed")
+	}
+	// :1.3 evicts :1.1, the least recently seen
+	if !d.Filter(changed(":1.3", "b")) || !d.Filter(changed(":1.1", "b")) {
+		t.Error("#4 Failed")
+	}
+	if d.Filter(changed(":1.1", "b")) {
+		t.Error("#5 Failed")
+	}
+	d.Reset()
+	if !d.Filter(changed(":1.1", "b")) {
+		t.Error("#6 Failed")
+	}
+
+	// a key function decides what counts as a change
+	d = NewDeduper(0, func(msg *Message) interface{} { return msg.Params.At(0) })
+	if !d.Filter(changed(":1.1", "a")) || d.Filter(changed(":1.1", "b")) {
+		t.Error("#7 Failed")
+	}
+}
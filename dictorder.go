@@ -0,0 +1,174 @@
+package dbus
+
+import (
+	"container/vector"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+)
+
+// EncodeOptions control how messages are encoded.
+//
+// SortDicts encodes the entries of every dict, at any depth and inside
+// variants, in ascending key order, so that the same values always give
+// the same bytes. Dicts made from Go maps are otherwise encoded in map
+// iteration order. Sorting costs a copy of each container, so it is off
+// by default.
+type EncodeOptions struct {
+	SortDicts bool
+}
+
+// SortDicts returns a copy of value, of the single complete type sig, in
+// which the entries of every dict are in ascending key order. Numbers
+// compare by value (NaN first), false before true, and strings, object
+// paths and signatures bytewise. value is not modified.
+func SortDicts(sig string, value interface{}) (interface{}, os.Error) {
+	sorted, n, e := sortDicts(sig, value)
+	if e != nil {
+		return value, e
+	}
+	if n != len(sig) {
+		return value, os.NewError(fmt.Sprintf("%q is not a single complete type", sig))
+	}
+	return sorted, nil
+}
+
+// sortDictParams applies SortDicts to each value of params.
+func sortDictParams(sig string, params *vector.Vector) (*vector.Vector, os.Error) {
+	sorted := new(vector.Vector)
+	for sigOffset, i := 0, 0; sigOffset < len(sig); i++ {
+		if params.Len() <= i {
+			return nil, os.NewError(fmt.Sprintf("%d values for signature %q", params.Len(), sig))
+		}
+		v, n, e := sortDicts(sig[sigOffset:], params.At(i))
+		if e != nil {
+			return nil, e
+		}
+		sorted.Push(v)
+		sigOffset += n
+	}
+	return sorted, nil
+}
+
+// sortDicts sorts the value of the first complete type of sig and returns
+// the length of that type in sig. Values the encoder would reject are
+// returned unchanged for appendValue to report.
+func sortDicts(sig string, val interface{}) (interface{}, int, os.Error) {
+	block, e := getSigBlock(sig, 0)
+	if e != nil {
+		return nil, 0, e
+	}
+	switch TypeCode(block[0]) {
+	case TypeVariant:
+		if variant, ok := val.(Variant); ok {
+			v, _, e := sortDicts(variant.Sig, variant.Value)
+			if e != nil {
+				return nil, 0, e
+			}
+			val = Variant{variant.Sig, v}
+		}
+
+	case TypeArray:
+		vec, ok := val.(*vector.Vector)
+		if !ok || vec == nil {
+			break
+		}
+		elemSig := block[1:]
+		sorted := new(vector.Vector)
+		for v := range vec.Iter() {
+			s, _, e := sortDicts(elemSig, v)
+			if e != nil {
+				return nil, 0, e
+			}
+			sorted.Push(s)
+		}
+		if TypeCode(elemSig[0]) == TypeDictEntryBegin {
+			sort.Sort(dictEntries{sorted})
+		}
+		val = sorted
+
+	case TypeStructBegin, TypeDictEntryBegin:
+		fields, ok := val.([]interface{})
+		if vec, isVec := val.(*vector.Vector); isVec {
+			fields, ok = vec.Data(), true
+		}
+		if !ok {
+			break
+		}
+		sorted, e := sortDictParams(block[1:len(block)-1], sliceToVector(fields))
+		if e != nil {
+			return nil, 0, e
+		}
+		val = sorted.Data()
+	}
+	return val, len(block), nil
+}
+
+// dictEntries sorts dict entries, each a []interface{} or *vector.Vector
+// holding the key and the value, by key.
+type dictEntries struct {
+	*vector.Vector
+}
+
+func (p dictEntries) Less(i, j int) bool {
+	return keyLess(entryKey(p.At(i)), entryKey(p.At(j)))
+}
+
+func entryKey(entry interface{}) interface{} {
+	switch e := entry.(type) {
+	case []interface{}:
+		if 0 < len(e) {
+			return e[0]
+		}
+	case *vector.Vector:
+		if 0 < e.Len() {
+			return e.At(0)
+		}
+	}
+	return nil
+}
+
+// keyLess orders dict keys, which are of one basic type within a dict.
+// Keys of different types keep their order.
+func keyLess(a, b interface{}) bool {
+	switch x := a.(type) {
+	case byte:
+		y, ok := b.(byte)
+		return ok && x < y
+	case bool:
+		y, ok := b.(bool)
+		return ok && !x && y
+	case int16:
+		y, ok := b.(int16)
+		return ok && x < y
+	case uint16:
+		y, ok := b.(uint16)
+		return ok && x < y
+	case int32:
+		y, ok := b.(int32)
+		return ok && x < y
+	case uint32:
+		y, ok := b.(uint32)
+		return ok && x < y
+	case int64:
+		y, ok := b.(int64)
+		return ok && x < y
+	case uint64:
+		y, ok := b.(uint64)
+		return ok && x < y
+	case float64:
+		y, ok := b.(float64)
+		if !ok || math.IsNaN(y) {
+			return false
+		}
+		return math.IsNaN(x) || x < y
+	case string:
+		y, ok := b.(string)
+		return ok && x < y
+	case ObjectRef:
+		y, ok := b.(ObjectRef)
+		return ok && x.Path < y.Path
+	}
+	return false
+}
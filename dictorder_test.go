@@ -0,0 +1,66 @@
+package dbus
+
+import (
+	"container/vector"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestSortDicts(t *testing.T) {
+	m := map[string]interface{}{
+		"b": uint32(2),
+		"c": Variant{"a{ib}", wireValue(reflect.NewValue(map[int32]bool{3: true, -1: false, 0: true}))},
+		"a": uint32(1),
+	}
+	value := wireValue(reflect.NewValue(m))
+	sorted, e := SortDicts("a{sv}", value)
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	data, e := EncodeValue(nil, "a{sv}", sorted, binary.LittleEndian)
+	if e != nil {
+		t.Fatal("#2 Failed", e)
+	}
+	val, _, e := DecodeValue(data, 0, "a{sv}", binary.LittleEndian)
+	if e != nil {
+		t.Fatal("#3 Failed", e)
+	}
+	dict := val.(*vector.Vector)
+	if "a" != vecRef(dict, 0, 0) || "b" != vecRef(dict, 1, 0) || "c" != vecRef(dict, 2, 0) {
+		t.Error("#4 Failed", dict.Data())
+	}
+	inner := vecRef(dict, 2, 1).(Variant).Value.(*vector.Vector)
+	if int32(-1) != vecRef(inner, 0, 0) || int32(0) != vecRef(inner, 1, 0) || int32(3) != vecRef(inner, 2, 0) {
+		t.Error("#5 Failed", inner.Data())
+	}
+	// the input is left alone
+	if value.(*vector.Vector).Len() != 3 {
+		t.Error("#6 Failed")
+	}
+
+	msg := NewMessage()
+	msg.Type = SIGNAL
+	msg.Path = "/org/example"
+	msg.Iface = "org.example.Foo"
+	msg.Member = "Bar"
+	msg.Sig = "a{sv}"
+	msg.Params.Push(value)
+	buff, e := EncodeMessageWith(msg, EncodeOptions{SortDicts: true})
+	if e != nil {
+		t.Fatal("#7 Failed", e)
+	}
+	got, _, e := DecodeMessage(buff)
+	if e != nil || "a" != vecRef(got.Params, 0, 0, 0) || "c" != vecRef(got.Params, 0, 2, 0) {
+		t.Error("#8 Failed", e)
+	}
+
+	if !keyLess(math.NaN(), float64(-1)) || keyLess(float64(1), math.NaN()) || !keyLess(false, true) ||
+		!keyLess(ObjectRef{"", "/a"}, ObjectRef{"", "/b"}) || keyLess("a", int32(1)) {
+		t.Error("#9 Failed")
+	}
+	if _, e = SortDicts("a{sv}i", value); e == nil {
+		t.Error("#10 Failed")
+	}
+}
@@ -0,0 +1,201 @@
+package dbus
+
+import (
+	"os"
+	"sync"
+)
+
+// DISPATCH_QUEUE is the number of signals a SignalDispatcher queues for its
+// workers; signals arriving while the queue is full are counted in Dropped
+// and discarded, so the message loop never waits for the workers.
+const DISPATCH_QUEUE = 256
+
+// SignalDispatcher delivers signals to channels from a pool of worker
+// goroutines instead of the message loop, so slow receivers do not hold up
+// method replies. With more than one worker, signals may be delivered out
+// of order.
+type SignalDispatcher struct {
+	conn    *Connection
+	input   chan *Message
+	mutex   sync.Mutex
+	subs    []*DispatchSubscription
+	dropped int
+	closed  bool
+	done    chan bool
+	workers int
+}
+
+// DispatchSubscription is a subscription of a SignalDispatcher.
+type DispatchSubscription struct {
+	mr       MatchRule
+	ch       chan<- *Message
+	mutex    sync.Mutex
+	blocking bool
+	dropped  int
+}
+
+// CreateSignalDispatcher starts a dispatcher with workers goroutines (at
+// least one) delivering the signals received by the connection.
+func (p *Connection) CreateSignalDispatcher(workers int) *SignalDispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+	d := &SignalDispatcher{conn: p, input: make(chan *Message, DISPATCH_QUEUE), done: make(chan bool),
+		workers: workers}
+	for i := 0; i < workers; i++ {
+		go d.work()
+	}
+	p.handlerMutex.Lock()
+	dispatchers := make([]*SignalDispatcher, len(p.dispatchers)+1)
+	for i, other := range p.dispatchers {
+		dispatchers[i] = other
+	}
+	dispatchers[len(p.dispatchers)] = d
+	p.dispatchers = dispatchers
+	p.handlerMutex.Unlock()
+	return d
+}
+
+// enqueue is called by the message loop under handlerMutex.
+func (p *SignalDispatcher) enqueue(msg *Message) {
+	select {
+	case p.input <- msg:
+	default:
+		p.mutex.Lock()
+		p.dropped++
+		p.mutex.Unlock()
+	}
+}
+
+func (p *SignalDispatcher) work() {
+	for msg := range p.input {
+		p.mutex.Lock()
+		subs := p.subs
+		p.mutex.Unlock()
+		for _, sub := range subs {
+			if sub.mr.match(msg) {
+				sub.deliver(msg)
+			}
+		}
+	}
+	p.done <- true
+}
+
+// Subscribe sends the signals matching filter on ch, adding the match rule
+// to the bus. By default a signal is dropped when ch is not ready to
+// receive it; see DispatchSubscription.SetBlocking.
+func (p *SignalDispatcher) Subscribe(filter *MatchRule, ch chan<- *Message) (*DispatchSubscription, os.Error) {
+	if err := p.conn.AddMatch(filter); err != nil {
+		return nil, err
+	}
+	sub := &DispatchSubscription{mr: *filter, ch: ch}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	// workers iterate over a snapshot, so the slice is replaced, never
+	// modified
+	subs := make([]*DispatchSubscription, len(p.subs)+1)
+	for i, other := range p.subs {
+		subs[i] = other
+	}
+	subs[len(p.subs)] = sub
+	p.subs = subs
+	return sub, nil
+}
+
+// Unsubscribe stops sub and removes its match rule. A worker may still be
+// delivering a signal to it.
+func (p *SignalDispatcher) Unsubscribe(sub *DispatchSubscription) os.Error {
+	p.mutex.Lock()
+	subs := make([]*DispatchSubscription, 0, len(p.subs))
+	found := false
+	for _, other := range p.subs {
+		if other == sub {
+			found = true
+		} else {
+			subs = subs[0 : len(subs)+1]
+			subs[len(subs)-1] = other
+		}
+	}
+	p.subs = subs
+	p.mutex.Unlock()
+	if !found {
+		return os.NewError("Unsubscribe: not a subscription of the dispatcher")
+	}
+	return p.conn.RemoveMatch(&sub.mr)
+}
+
+// Dropped returns the number of signals discarded because the queue of the
+// dispatcher was full.
+func (p *SignalDispatcher) Dropped() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.dropped
+}
+
+// Close unsubscribes every subscription and stops the workers once they
+// have delivered the queued signals; a blocking subscription whose channel
+// is not read holds it up.
+func (p *SignalDispatcher) Close() {
+	p.conn.handlerMutex.Lock()
+	n := 0
+	for _, d := range p.conn.dispatchers {
+		if d != p {
+			p.conn.dispatchers[n] = d
+			n++
+		}
+	}
+	p.conn.dispatchers = p.conn.dispatchers[0:n]
+	p.conn.handlerMutex.Unlock()
+
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return
+	}
+	p.closed = true
+	subs := p.subs
+	p.mutex.Unlock()
+
+	// the message loop no longer sends on input
+	close(p.input)
+	for i := 0; i < p.workers; i++ {
+		<-p.done
+	}
+	for _, sub := range subs {
+		p.Unsubscribe(sub)
+	}
+}
+
+// SetBlocking chooses what happens when the channel is not ready to
+// receive a signal: with on, the worker waits for it, holding up the
+// signals behind; otherwise, the default, the signal is dropped.
+func (p *DispatchSubscription) SetBlocking(on bool) {
+	p.mutex.Lock()
+	p.blocking = on
+	p.mutex.Unlock()
+}
+
+// Dropped returns the number of signals dropped because the channel was
+// not ready.
+func (p *DispatchSubscription) Dropped() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.dropped
+}
+
+func (p *DispatchSubscription) deliver(msg *Message) {
+	p.mutex.Lock()
+	blocking := p.blocking
+	p.mutex.Unlock()
+	if blocking {
+		p.ch <- msg
+		return
+	}
+	select {
+	case p.ch <- msg:
+	default:
+		p.mutex.Lock()
+		p.dropped++
+		p.mutex.Unlock()
+	}
+}
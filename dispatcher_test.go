@@ -0,0 +1,77 @@
+package dbus
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSignalDispatcher(t *testing.T) {
+	var mutex sync.Mutex
+	matches := 0
+	p := fakeService(t, func(call *Message) *Message {
+		mutex.Lock()
+		switch call.Member {
+		case "AddMatch":
+			matches++
+		case "RemoveMatch":
+			matches--
+		}
+		mutex.Unlock()
+		msg := NewMessage()
+		msg.Type = METHOD_RETURN
+		return msg
+	})
+	defer p.Close()
+	signal := func(member string) *Message {
+		msg := NewMessage()
+		msg.Type = SIGNAL
+		msg.Path = "/org/example"
+		msg.Iface = "org.example.Foo"
+		msg.Member = member
+		return msg
+	}
+
+	d := p.CreateSignalDispatcher(2)
+	foo := make(chan *Message, 10)
+	if _, e := d.Subscribe(&MatchRule{Type: "signal", Member: "Foo"}, foo); e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	bar := make(chan *Message)
+	barSub, _ := d.Subscribe(&MatchRule{Type: "signal", Member: "Bar"}, bar)
+
+	// nobody reads bar: the signal is dropped, Foo still arrives
+	p.InjectMessage(signal("Bar"))
+	p.InjectMessage(signal("Foo"))
+	if msg := <-foo; msg.Member != "Foo" {
+		t.Error("#2 Failed", msg)
+	}
+	for i := 0; barSub.Dropped() == 0 && i < 1000; i++ {
+		time.Sleep(1e6)
+	}
+	if barSub.Dropped() != 1 {
+		t.Error("#3 Failed", barSub.Dropped())
+	}
+
+	barSub.SetBlocking(true)
+	p.InjectMessage(signal("Bar"))
+	if msg := <-bar; msg.Member != "Bar" {
+		t.Error("#4 Failed", msg)
+	}
+
+	if e := d.Unsubscribe(barSub); e != nil {
+		t.Error("#5 Failed", e)
+	}
+	if e := d.Unsubscribe(barSub); e == nil {
+		t.Error("#6 Failed")
+	}
+	d.Close()
+	mutex.Lock()
+	if matches != 0 {
+		t.Error("#7 Failed", matches)
+	}
+	mutex.Unlock()
+	if d.Dropped() != 0 {
+		t.Error("#8 Failed", d.Dropped())
+	}
+}
@@ -0,0 +1,130 @@
+package dbus
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Error is a D-Bus error reply returned by a remote peer.
+type Error struct {
+	Name    string
+	Message string
+}
+
+func (p *Error) String() string {
+	if p.Message == "" {
+		return p.Name
+	}
+	return p.Name + ": " + p.Message
+}
+
+// GetErrorReply returns the *Error an ERROR message carries, or nil if
+// msg is not an error, for replies handled without CallMethod.
+func (p *Connection) GetErrorReply(msg *Message) os.Error {
+	if msg == nil || msg.Type != ERROR {
+		return nil
+	}
+	return errorFromMessage(msg)
+}
+
+func errorFromMessage(msg *Message) os.Error {
+	err := &Error{Name: msg.ErrorName}
+	if 0 < msg.Params.Len() {
+		if str, ok := msg.Params.At(0).(string); ok {
+			err.Message = str
+		}
+	}
+	return err
+}
+
+var (
+	ErrNoIntrospection   = os.NewError("no introspection data")
+	ErrInterfaceNotFound = os.NewError("interface not found")
+	ErrAlreadyAnswered   = os.NewError("the call has already been answered")
+)
+
+// InterfaceError is returned by GetInterface when an object does not
+// implement the requested interface. Reason is ErrNoIntrospection when the
+// object could not be introspected and ErrInterfaceNotFound otherwise.
+type InterfaceError struct {
+	Reason os.Error
+	Dest   string
+	Path   string
+	Name   string
+	// interfaces the object does implement
+	Available []string
+	// other introspected paths of Dest that implement Name
+	OtherPaths []string
+}
+
+func (p *InterfaceError) String() string {
+	str := fmt.Sprintf("%s %s does not implement %s", p.Dest, p.Path, p.Name)
+	if p.Available == nil {
+		return str + " (no introspection data)"
+	}
+	str += " (available: " + strings.Join(p.Available, ", ") + ")"
+	if 0 < len(p.OtherPaths) {
+		str += "; it is implemented at " + strings.Join(p.OtherPaths, ", ")
+	}
+	return str
+}
+
+// MultiDialError is returned by Dial when none of several addresses could be
+// connected to. Errors[i] is the failure for Addresses[i].
+type MultiDialError struct {
+	Addresses []string
+	Errors    []os.Error
+}
+
+func (p *MultiDialError) String() string {
+	strs := make([]string, len(p.Errors))
+	for i, err := range p.Errors {
+		strs[i] = p.Addresses[i] + ": " + err.String()
+	}
+	return "no address could be connected to (" + strings.Join(strs, "; ") + ")"
+}
+
+// BatchError is returned by Atomic when calls of the batch failed.
+// Errors[i] is the error of the i-th call, nil if it succeeded.
+type BatchError struct {
+	Errors []os.Error
+}
+
+func (p *BatchError) String() string {
+	strs := make([]string, 0, len(p.Errors))
+	for i, err := range p.Errors {
+		if err != nil {
+			strs = strs[0 : len(strs)+1]
+			strs[len(strs)-1] = fmt.Sprintf("call %d: %s", i, err)
+		}
+	}
+	return fmt.Sprintf("%d of %d calls failed (%s)", len(strs), len(p.Errors), strings.Join(strs, "; "))
+}
+
+// ReplySignatureError is returned by CallMethod when the reply does not
+// have the out signature the introspection data declares. Replies that
+// only add values after the declared ones are accepted unless the
+// connection is strict (see SetStrictReplies).
+type ReplySignatureError struct {
+	Iface    string
+	Method   string
+	Declared string
+	Got      string
+}
+
+func (p *ReplySignatureError) String() string {
+	return fmt.Sprintf("%s.%s replied with signature %q, declared %q", p.Iface, p.Method, p.Got, p.Declared)
+}
+
+// AddressError is returned by Dial for a malformed address. Pos is the
+// byte offset in Address where the problem was found.
+type AddressError struct {
+	Address string
+	Pos     int
+	Reason  string
+}
+
+func (p *AddressError) String() string {
+	return fmt.Sprintf("invalid address %q at position %d: %s", p.Address, p.Pos, p.Reason)
+}
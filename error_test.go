@@ -0,0 +1,22 @@
+package dbus
+
+import (
+	"testing"
+)
+
+func TestGetErrorReply(t *testing.T) {
+	p := new(Connection)
+	msg := NewMessage()
+	msg.Type = METHOD_RETURN
+	if p.GetErrorReply(msg) != nil || p.GetErrorReply(nil) != nil {
+		t.Error("#1 Failed")
+	}
+
+	msg.Type = ERROR
+	msg.ErrorName = "org.example.Error.Busy"
+	msg.Params.Push("try later")
+	e, ok := p.GetErrorReply(msg).(*Error)
+	if !ok || e.Name != "org.example.Error.Busy" || e.Message != "try later" {
+		t.Error("#2 Failed", e)
+	}
+}
@@ -0,0 +1,713 @@
+package dbus
+
+import (
+	"container/vector"
+	"fmt"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// CallLimits bounds the incoming method calls a Connection handles.
+type CallLimits struct {
+	// MaxConcurrent is the number of calls handled at once; further calls
+	// wait for a free slot. 0 means no limit.
+	MaxConcurrent int
+	// MaxPerSender is the number of calls from one sender that may be
+	// waiting or running at once. Calls over it are answered with
+	// org.freedesktop.DBus.Error.LimitsExceeded. 0 means no limit.
+	MaxPerSender int
+}
+
+// Stats holds counters of a Connection.
+type Stats struct {
+	CallsReceived  uint64 // incoming method calls
+	CallsThrottled uint64 // incoming method calls refused by CallLimits
+	CallsRetried   uint64 // outgoing method calls sent again by a RetryPolicy
+	SignalsDropped uint64 // signals whose handlers SIGNAL_OVERFLOW_DROP skipped
+}
+
+type callLimiter struct {
+	limits    CallLimits
+	mutex     sync.Mutex
+	perSender map[string]int
+	slots     chan bool // one value per running call; nil without a limit
+	stats     Stats
+}
+
+func newCallLimiter(limits CallLimits) *callLimiter {
+	l := &callLimiter{limits: limits, perSender: make(map[string]int)}
+	if 0 < limits.MaxConcurrent {
+		l.slots = make(chan bool, limits.MaxConcurrent)
+	}
+	return l
+}
+
+// admit counts a call from sender and reports whether it may be handled.
+// An admitted call must be ended with done.
+func (p *callLimiter) admit(sender string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.stats.CallsReceived++
+	n := p.perSender[sender]
+	if 0 < p.limits.MaxPerSender && p.limits.MaxPerSender <= n {
+		p.stats.CallsThrottled++
+		return false
+	}
+	p.perSender[sender] = n + 1
+	return true
+}
+
+// start waits for a free slot.
+func (p *callLimiter) start() {
+	if p.slots != nil {
+		p.slots <- true
+	}
+}
+
+// done releases the slot of a started call from sender.
+func (p *callLimiter) done(sender string) {
+	if p.slots != nil {
+		<-p.slots
+	}
+	p.mutex.Lock()
+	if n := p.perSender[sender] - 1; n == 0 {
+		p.perSender[sender] = 0, false
+	} else {
+		p.perSender[sender] = n
+	}
+	p.mutex.Unlock()
+}
+
+// retried counts an outgoing call sent again.
+func (p *callLimiter) retried() {
+	p.mutex.Lock()
+	p.stats.CallsRetried++
+	p.mutex.Unlock()
+}
+
+func (p *callLimiter) getStats() Stats {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.stats
+}
+
+// Stats returns the counters of the connection.
+func (p *Connection) Stats() Stats {
+	var stats Stats
+	if p.limiter != nil {
+		stats = p.limiter.getStats()
+	}
+	stats.SignalsDropped = p.droppedSignals()
+	return stats
+}
+
+// dispatchCall handles an incoming method call in its own goroutine, so
+// that a slow handler or a flooding sender does not hold up the message
+// loop.
+func (p *Connection) dispatchCall(msg *Message) { p.dispatchCallTo(msg, p.sendReply) }
+
+// dispatchCallTo is dispatchCall with answer delivering the reply.
+func (p *Connection) dispatchCallTo(msg *Message, answer func(call *Message, reply *Message) os.Error) {
+	if !p.startCall(msg) {
+		answer(msg, newErrorReply(msg, "org.freedesktop.DBus.Error.Disconnected", "the connection is closing"))
+		return
+	}
+	if !p.limiter.admit(msg.Sender) {
+		p.logf("throttling call %s.%s from %s", msg.Iface, msg.Member, msg.Sender)
+		answer(msg, newErrorReply(msg, "org.freedesktop.DBus.Error.LimitsExceeded",
+			"too many calls from "+msg.Sender))
+		p.endCall()
+		return
+	}
+	msg.answer = answer
+	go func() {
+		p.limiter.start()
+		reply := p.handleCall(msg)
+		p.limiter.done(msg.Sender)
+		// unless the handler answered with SendError
+		if msg.claimAnswer() {
+			answer(msg, reply)
+		}
+		p.endCall()
+	}()
+}
+
+// MethodSpec declares a method exported with Export. Handler is a function
+// taking the in arguments and returning the out arguments followed by an
+// os.Error, such as
+//	func(name string) (string, []uint32, map[string]Variant, os.Error)
+// InSig and OutSig, if not empty, must agree with the signatures derived
+// from Handler.
+type MethodSpec struct {
+	Name    string
+	InSig   string
+	OutSig  string
+	Handler interface{}
+}
+
+// MethodHandlerFunc handles a method call registered with
+// RegisterMethodHandler. It returns the reply, or an os.Error (an *Error
+// keeps its name) to answer with an ERROR. The reply may be nil for an
+// empty one; its type, destination and reply serial are filled in.
+type MethodHandlerFunc func(msg *Message) (*Message, os.Error)
+
+type exportedMethod struct {
+	name   string
+	raw    MethodHandlerFunc // if set, the other fields are unused
+	fn     *reflect.FuncValue
+	in     []reflect.Type
+	inSig  string
+	outSig string
+}
+
+func newExportedMethod(spec MethodSpec) (*exportedMethod, os.Error) {
+	fn, ok := reflect.NewValue(spec.Handler).(*reflect.FuncValue)
+	if !ok || fn.IsNil() {
+		return nil, os.NewError(spec.Name + ": handler is not a function")
+	}
+	ft := fn.Type().(*reflect.FuncType)
+	if ft.NumOut() == 0 || ft.Out(ft.NumOut()-1) != errorType {
+		return nil, os.NewError(spec.Name + ": handler must return os.Error last")
+	}
+
+	m := &exportedMethod{name: spec.Name, fn: fn, in: make([]reflect.Type, ft.NumIn())}
+	for i := 0; i < ft.NumIn(); i++ {
+		sig, err := SignatureFromType(ft.In(i))
+		if err != nil {
+			return nil, os.NewError(fmt.Sprintf("%s: argument %d: %s", spec.Name, i, err))
+		}
+		m.in[i] = ft.In(i)
+		m.inSig += sig
+	}
+	for i := 0; i < ft.NumOut()-1; i++ {
+		sig, err := SignatureFromType(ft.Out(i))
+		if err != nil {
+			return nil, os.NewError(fmt.Sprintf("%s: result %d: %s", spec.Name, i, err))
+		}
+		m.outSig += sig
+	}
+	if spec.InSig != "" && spec.InSig != m.inSig {
+		return nil, os.NewError(fmt.Sprintf("%s: declared in signature %q, handler takes %q", spec.Name, spec.InSig, m.inSig))
+	}
+	if spec.OutSig != "" && spec.OutSig != m.outSig {
+		return nil, os.NewError(fmt.Sprintf("%s: declared out signature %q, handler returns %q", spec.Name, spec.OutSig, m.outSig))
+	}
+	return m, nil
+}
+
+// call runs the handler with the arguments of msg and returns the reply.
+func (p *exportedMethod) call(msg *Message) *Message {
+	if p.raw != nil {
+		return p.callRaw(msg)
+	}
+	if msg.Sig != p.inSig || msg.Params.Len() != len(p.in) {
+		return newErrorReply(msg, "org.freedesktop.DBus.Error.InvalidArgs",
+			fmt.Sprintf("%s takes %q, not %q", p.name, p.inSig, msg.Sig))
+	}
+	args := make([]reflect.Value, len(p.in))
+	for i, t := range p.in {
+		args[i] = reflect.MakeZero(t)
+		if err := storeValue(args[i], msg.Params.At(i), msg.Sender); err != nil {
+			return newErrorReply(msg, "org.freedesktop.DBus.Error.InvalidArgs", err.String())
+		}
+	}
+
+	results := p.fn.Call(args)
+	if errv := results[len(results)-1].(*reflect.InterfaceValue); !errv.IsNil() {
+		return errorReplyFor(msg, errv.Interface().(os.Error))
+	}
+
+	reply := newMethodReturn(msg)
+	reply.Sig = p.outSig
+	for _, v := range results[0 : len(results)-1] {
+		reply.Params.Push(wireValue(v))
+	}
+	return reply
+}
+
+func (p *exportedMethod) callRaw(msg *Message) *Message {
+	reply, err := p.raw(msg)
+	if err != nil {
+		return errorReplyFor(msg, err)
+	}
+	if reply == nil {
+		return newMethodReturn(msg)
+	}
+	if reply.Type != ERROR {
+		reply.Type = METHOD_RETURN
+	}
+	reply.replySerial = msg.serial
+	reply.Dest = msg.Sender
+	return reply
+}
+
+// errorReplyFor answers call with err, keeping the name of an *Error.
+func errorReplyFor(call *Message, err os.Error) *Message {
+	if e, ok := err.(*Error); ok {
+		return newErrorReply(call, e.Name, e.Message)
+	}
+	return newErrorReply(call, "org.freedesktop.DBus.Error.Failed", err.String())
+}
+
+// Export makes methods callable as members of iface on the object at path.
+// Handlers run in their own goroutines, within the CallLimits of the
+// connection. Exporting the same path and iface again replaces the
+// methods. Errors in the specs are reported here rather than when the
+// first call arrives, as are member names rejected by the NameMapper of the
+// connection or colliding once mapped.
+func (p *Connection) Export(path string, iface string, methods []MethodSpec) os.Error {
+	before := p.interfacesAt(path)
+	if err := p.exportMethods(path, iface, methods, true); err != nil {
+		return err
+	}
+	if !isStandardInterface(iface) {
+		if err := p.ExportStandardInterfaces(path); err != nil {
+			return err
+		}
+	}
+	p.announceInterfaces(path, before)
+	return nil
+}
+
+// SetNameMapper sets the naming policy Export checks member names with:
+// names the mapper rejects, and names mapping to the same Go name, are an
+// error. The default is DefaultNameMapper.
+func (p *Connection) SetNameMapper(mapper *NameMapper) {
+	p.stateMutex.Lock()
+	p.nameMapper = mapper
+	p.stateMutex.Unlock()
+}
+
+func (p *Connection) getNameMapper() *NameMapper {
+	p.stateMutex.Lock()
+	defer p.stateMutex.Unlock()
+	if p.nameMapper == nil {
+		return DefaultNameMapper
+	}
+	return p.nameMapper
+}
+
+// exportMethods exports methods as iface at path, unless iface is already
+// exported there and replace is false.
+func (p *Connection) exportMethods(path string, iface string, methods []MethodSpec, replace bool) os.Error {
+	table, err := p.methodTable(path, iface, methods)
+	if err != nil {
+		return err
+	}
+	p.exportMutex.Lock()
+	defer p.exportMutex.Unlock()
+	if p.exports == nil {
+		p.exports = make(map[string]map[string]*exportedMethod)
+	}
+	if _, ok := p.exports[path+" "+iface]; ok && !replace {
+		return nil
+	}
+	p.exports[path+" "+iface] = table
+	return nil
+}
+
+// methodTable checks what Export checks and returns the exported methods
+// of methods by name.
+func (p *Connection) methodTable(path string, iface string, methods []MethodSpec) (map[string]*exportedMethod, os.Error) {
+	if !isValidObjectPath(path) {
+		return nil, os.NewError(fmt.Sprintf("Export %s: invalid object path %q", iface, path))
+	}
+	if !isValidInterfaceName(iface) {
+		return nil, os.NewError(fmt.Sprintf("Export: invalid interface name %q", iface))
+	}
+	table := make(map[string]*exportedMethod)
+	names := make([]string, len(methods))
+	for i, spec := range methods {
+		if _, ok := table[spec.Name]; ok {
+			return nil, os.NewError(fmt.Sprintf("Export %s: %q is declared twice", iface, spec.Name))
+		}
+		m, err := newExportedMethod(spec)
+		if err != nil {
+			return nil, os.NewError("Export " + iface + "." + err.String())
+		}
+		table[spec.Name] = m
+		names[i] = spec.Name
+	}
+	if _, err := p.getNameMapper().MapNames(names); err != nil {
+		return nil, os.NewError("Export " + iface + ": " + err.String())
+	}
+	return table, nil
+}
+
+// isValidObjectPath reports whether path is "/" or "/"-separated non-empty
+// elements of [A-Za-z0-9_].
+func isValidObjectPath(path string) bool {
+	if path == "/" {
+		return true
+	}
+	if path == "" || path[0] != '/' {
+		return false
+	}
+	for _, elem := range strings.Split(path[1:], "/", 0) {
+		if elem == "" || !isNameElement(elem) {
+			return false
+		}
+	}
+	return true
+}
+
+// isValidInterfaceName reports whether iface is at least two "."-separated
+// elements of [A-Za-z0-9_], not starting with a digit, and at most 255
+// bytes long.
+func isValidInterfaceName(iface string) bool {
+	if len(iface) > 255 {
+		return false
+	}
+	elems := strings.Split(iface, ".", 0)
+	if len(elems) < 2 {
+		return false
+	}
+	for _, elem := range elems {
+		if elem == "" || ('0' <= elem[0] && elem[0] <= '9') || !isNameElement(elem) {
+			return false
+		}
+	}
+	return true
+}
+
+func isNameElement(elem string) bool {
+	for i := 0; i < len(elem); i++ {
+		c := elem[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_' || '0' <= c && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// Unexport removes the methods of iface at path, and the standard
+// interfaces with the last other interface or property struct.
+func (p *Connection) Unexport(path string, iface string) {
+	before := p.interfacesAt(path)
+	p.unexport(path, iface)
+	p.announceInterfaces(path, before)
+}
+
+func (p *Connection) unexport(path string, iface string) {
+	p.exportMutex.Lock()
+	defer p.exportMutex.Unlock()
+	if p.exports == nil {
+		return
+	}
+	p.exports[path+" "+iface] = nil, false
+	if iface == "org.freedesktop.DBus.ObjectManager" && p.objectManagers != nil {
+		p.objectManagers[path] = false, false
+	}
+	for key, _ := range p.exports {
+		if strings.HasPrefix(key, path+" ") && !isStandardInterface(key[len(path)+1:]) {
+			return
+		}
+	}
+	for key, _ := range p.propertyStructs {
+		if strings.HasPrefix(key, path+" ") {
+			return
+		}
+	}
+	for _, std := range standardInterfaces {
+		p.exports[path+" "+std] = nil, false
+	}
+}
+
+// ObjectSpec declares the interfaces of an object exported with
+// ExportObjectTree, mapping interface names to their methods.
+type ObjectSpec map[string][]MethodSpec
+
+// ExportObjectTree exports a hierarchy of objects below root at once. The
+// keys of tree are paths relative to root, such as "dev0" or "dev0/sub";
+// "/" is root itself. Each object is exported as with Export, and root
+// answers Introspect with its child nodes even if tree has no object for
+// it. Everything is checked as Export checks it before anything is
+// exported, so nothing is exported if any object is in error.
+func (p *Connection) ExportObjectTree(root string, tree map[string]ObjectSpec) os.Error {
+	if !isValidObjectPath(root) {
+		return os.NewError(fmt.Sprintf("ExportObjectTree: invalid object path %q", root))
+	}
+	for rel, obj := range tree {
+		for iface, methods := range obj {
+			if _, err := p.methodTable(treePath(root, rel), iface, methods); err != nil {
+				return os.NewError("ExportObjectTree " + treePath(root, rel) + ": " + err.String())
+			}
+		}
+	}
+	for rel, obj := range tree {
+		for iface, methods := range obj {
+			if err := p.Export(treePath(root, rel), iface, methods); err != nil {
+				return err
+			}
+		}
+	}
+	return p.ExportStandardInterfaces(root)
+}
+
+// UnexportObjectTree removes everything exported at root and below it.
+func (p *Connection) UnexportObjectTree(root string) {
+	p.exportMutex.Lock()
+	prefix := childPath(root, "")
+	inTree := func(key string) bool {
+		path := key[0:strings.Index(key, " ")]
+		return path == root || strings.HasPrefix(path, prefix)
+	}
+	// what an object manager above root has to announce as removed
+	before := make(map[string][]string)
+	for key, _ := range p.exports {
+		path := key[0:strings.Index(key, " ")]
+		if _, ok := before[path]; !ok && inTree(key) {
+			before[path] = p.exportedInterfaces(path)
+		}
+	}
+	for key, _ := range p.exports {
+		if inTree(key) {
+			p.exports[key] = nil, false
+		}
+	}
+	for key, _ := range p.propertyStructs {
+		if inTree(key) {
+			p.propertyStructs[key] = nil, false
+		}
+	}
+	for manager, _ := range p.objectManagers {
+		if manager == root || strings.HasPrefix(manager, prefix) {
+			p.objectManagers[manager] = false, false
+		}
+	}
+	p.exportMutex.Unlock()
+	for path, ifaces := range before {
+		p.announceInterfaces(path, ifaces)
+	}
+}
+
+// treePath returns the path of the ExportObjectTree key rel below root.
+func treePath(root string, rel string) string {
+	rel = strings.Trim(rel, "/")
+	if rel == "" {
+		return root
+	}
+	return childPath(root, rel)
+}
+
+// RegisterMethodHandler makes fn handle calls of method of iface on the
+// object at path, alongside the methods exported with Export or registered
+// for the same path and iface. fn gets the call as received; it runs in its
+// own goroutine, within the CallLimits of the connection.
+func (p *Connection) RegisterMethodHandler(path string, iface string, method string, fn MethodHandlerFunc) os.Error {
+	if path == "" || path[0] != '/' || iface == "" || method == "" {
+		return os.NewError(fmt.Sprintf("RegisterMethodHandler: invalid method %q.%q at %q", iface, method, path))
+	}
+	if fn == nil {
+		return os.NewError("RegisterMethodHandler: nil handler")
+	}
+	p.exportMutex.Lock()
+	defer p.exportMutex.Unlock()
+	if p.exports == nil {
+		p.exports = make(map[string]map[string]*exportedMethod)
+	}
+	table, ok := p.exports[path+" "+iface]
+	if !ok {
+		table = make(map[string]*exportedMethod)
+		p.exports[path+" "+iface] = table
+	}
+	table[method] = &exportedMethod{name: method, raw: fn}
+	return nil
+}
+
+// UnregisterMethodHandler removes the handler of method of iface at path,
+// whether registered with RegisterMethodHandler or exported with Export.
+func (p *Connection) UnregisterMethodHandler(path string, iface string, method string) {
+	p.exportMutex.Lock()
+	defer p.exportMutex.Unlock()
+	if p.exports == nil {
+		return
+	}
+	table, ok := p.exports[path+" "+iface]
+	if !ok {
+		return
+	}
+	table[method] = nil, false
+	if len(table) == 0 {
+		p.exports[path+" "+iface] = nil, false
+	}
+}
+
+// ExportMethodTable makes the handlers of table, by method name, the
+// methods of iface on the object at path, replacing those exported there
+// before, and exports the standard interfaces at path. The table can be
+// changed later with UpdateMethodTable; Introspect always describes the
+// methods of the moment.
+func (p *Connection) ExportMethodTable(path string, iface string, table map[string]MethodHandlerFunc) os.Error {
+	methods := make(map[string]*exportedMethod)
+	for method, fn := range table {
+		if fn == nil {
+			return os.NewError(fmt.Sprintf("ExportMethodTable: nil handler for %q", method))
+		}
+		methods[method] = &exportedMethod{name: method, raw: fn}
+	}
+	p.exportMutex.Lock()
+	if p.exports == nil {
+		p.exports = make(map[string]map[string]*exportedMethod)
+	}
+	p.exports[path+" "+iface] = methods
+	p.exportMutex.Unlock()
+	if !isStandardInterface(iface) {
+		return p.ExportStandardInterfaces(path)
+	}
+	return nil
+}
+
+// UpdateMethodTable sets the handler of method of iface at path to fn, or
+// removes the method if fn is nil, as RegisterMethodHandler and
+// UnregisterMethodHandler do.
+func (p *Connection) UpdateMethodTable(path string, iface string, method string, fn MethodHandlerFunc) os.Error {
+	if fn == nil {
+		p.UnregisterMethodHandler(path, iface, method)
+		return nil
+	}
+	return p.RegisterMethodHandler(path, iface, method, fn)
+}
+
+func (p *Connection) lookupMethod(path string, iface string, member string) *exportedMethod {
+	p.exportMutex.Lock()
+	defer p.exportMutex.Unlock()
+	if p.exports == nil {
+		return nil
+	}
+	if iface != "" {
+		return p.exports[path+" "+iface][member]
+	}
+	// without an interface, any exported member of that name will do
+	for key, table := range p.exports {
+		if strings.HasPrefix(key, path+" ") {
+			if m, ok := table[member]; ok {
+				return m
+			}
+		}
+	}
+	return nil
+}
+
+// handleCall returns the reply to an incoming method call.
+func (p *Connection) handleCall(msg *Message) *Message {
+	if m := p.lookupMethod(msg.Path, msg.Iface, msg.Member); m != nil {
+		p.stateMutex.Lock()
+		recovering := p.panicRecovery
+		p.stateMutex.Unlock()
+		if recovering {
+			return p.callRecovering(m, msg)
+		}
+		return m.call(msg)
+	}
+	return newErrorReply(msg, "org.freedesktop.DBus.Error.UnknownMethod",
+		fmt.Sprintf("No such method '%s' on interface '%s' at object path '%s'",
+			msg.Member, msg.Iface, msg.Path))
+}
+
+// SetPanicRecovery makes the connection recover from panics in exported
+// method handlers when enabled: the caller gets an
+// org.freedesktop.DBus.Error.Failed reply with the panic value, the stack
+// is logged, and the connection stays usable. By default a panicking
+// handler crashes the program.
+func (p *Connection) SetPanicRecovery(enabled bool) {
+	p.stateMutex.Lock()
+	p.panicRecovery = enabled
+	p.stateMutex.Unlock()
+}
+
+// callRecovering is m.call answering a panic with an error.
+func (p *Connection) callRecovering(m *exportedMethod, msg *Message) (reply *Message) {
+	defer func() {
+		if x := recover(); x != nil {
+			// the stack stays out of the reply, which any peer may read
+			p.logf("panic in %s.%s at %s: %v\n%s", msg.Iface, msg.Member, msg.Path, x, callStack(2))
+			reply = newErrorReply(msg, "org.freedesktop.DBus.Error.Failed", fmt.Sprint(x))
+		}
+	}()
+	return m.call(msg)
+}
+
+// callStack describes the stack of the calling goroutine, skipping skip
+// frames.
+func callStack(skip int) string {
+	lines := new(vector.StringVector)
+	for i := skip + 1; ; i++ {
+		pc, file, line, ok := runtime.Caller(i)
+		if !ok {
+			break
+		}
+		name := "?"
+		if fn := runtime.FuncForPC(pc); fn != nil {
+			name = fn.Name()
+		}
+		lines.Push(fmt.Sprintf("\t%s:%d %s", file, line, name))
+	}
+	return strings.Join(lines.Data(), "\n")
+}
+
+// sendReply sends reply to call unless the caller asked for no reply.
+func (p *Connection) sendReply(call *Message, reply *Message) os.Error {
+	if reply == nil || call.Flags&NO_REPLY_EXPECTED != 0 {
+		return nil
+	}
+	err := p.send(reply)
+	if err != nil {
+		p.logf("cannot reply to %s: %s", call.Sender, err.String())
+	}
+	return err
+}
+
+// SendError answers call, received by a handler, with an ERROR named
+// errName whose body is errMsg followed by args, whose signatures are
+// derived as by MessageBuilder.WithArg. Nothing is sent if the caller
+// asked for no reply. The call counts as answered: what the handler
+// returns afterwards is not sent, and answering it again gives
+// ErrAlreadyAnswered.
+func (p *Connection) SendError(call *Message, errName string, errMsg string, args ...) os.Error {
+	reply := newErrorReply(call, errName, errMsg)
+	v := reflect.NewValue(args).(*reflect.StructValue)
+	for i := 0; i < v.NumField(); i++ {
+		arg := v.Field(i).Interface()
+		sig := "v"
+		if _, ok := arg.(Variant); !ok {
+			var err os.Error
+			if sig, err = variantSignature(arg); err != nil {
+				return err
+			}
+		}
+		reply.Sig += sig
+		reply.Params.Push(arg)
+	}
+	if !call.claimAnswer() {
+		return ErrAlreadyAnswered
+	}
+	if call.answer != nil {
+		return call.answer(call, reply)
+	}
+	return p.sendReply(call, reply)
+}
+
+// newMethodReturn returns an empty METHOD_RETURN answering call.
+func newMethodReturn(call *Message) *Message {
+	msg := NewMessage()
+	msg.Type = METHOD_RETURN
+	msg.replySerial = call.serial
+	msg.Dest = call.Sender
+	return msg
+}
+
+// newErrorReply returns an ERROR message named name answering call.
+func newErrorReply(call *Message, name string, text string) *Message {
+	msg := NewMessage()
+	msg.Type = ERROR
+	msg.ErrorName = name
+	msg.replySerial = call.serial
+	msg.Dest = call.Sender
+	msg.Sig = "s"
+	msg.Params.Push(text)
+	return msg
+}
@@ -0,0 +1,470 @@
+package dbus
+
+import (
+	"container/vector"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCallLimiter(t *testing.T) {
+	l := newCallLimiter(CallLimits{MaxConcurrent: 2, MaxPerSender: 2})
+
+	// a flooding sender is throttled once it has two calls pending
+	for i := 0; i < 2; i++ {
+		if !l.admit(":1.1") {
+			t.Error("#1 Failed", i)
+		}
+	}
+	if l.admit(":1.1") {
+		t.Error("#2 Failed")
+	}
+	// other senders are still admitted
+	if !l.admit(":1.2") {
+		t.Error("#3 Failed")
+	}
+
+	l.start()
+	l.done(":1.1")
+	if !l.admit(":1.1") {
+		t.Error("#4 Failed")
+	}
+
+	stats := l.getStats()
+	if stats.CallsReceived != 5 || stats.CallsThrottled != 1 {
+		t.Error("#5 Failed", stats)
+	}
+}
+
+func TestCallLimiterConcurrency(t *testing.T) {
+	l := newCallLimiter(CallLimits{MaxConcurrent: 1})
+	l.admit(":1.1")
+	l.admit(":1.2")
+	l.start()
+
+	started := make(chan bool)
+	go func() {
+		l.start()
+		started <- true
+	}()
+	select {
+	case <-started:
+		t.Error("#1 Failed")
+	default:
+	}
+	l.done(":1.1")
+	<-started
+	l.done(":1.2")
+	if len(l.perSender) != 0 {
+		t.Error("#2 Failed", l.perSender)
+	}
+}
+
+func TestNewErrorReply(t *testing.T) {
+	call := NewMessage()
+	call.Type = METHOD_CALL
+	call.Sender = ":1.7"
+	reply := newErrorReply(call, "org.freedesktop.DBus.Error.UnknownMethod", "no")
+	if reply.Type != ERROR || reply.Dest != ":1.7" || reply.ReplySerial() != call.Serial() {
+		t.Error("#1 Failed", reply)
+	}
+	if err := reply.IsValid(); err != nil {
+		t.Error("#2 Failed", err)
+	}
+}
+
+func TestSendError(t *testing.T) {
+	server, p, e := NewMockServer()
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	defer p.Close()
+	call := NewMessage()
+	call.Type = METHOD_CALL
+	call.Path = "/org/example"
+	call.Iface = "org.example.Foo"
+	call.Member = "Work"
+	call.Sender = ":1.9"
+	call.serial = 42
+	if e = p.SendError(call, "org.example.Error.Busy", "busy", uint32(3)); e != nil {
+		t.Fatal("#2 Failed", e)
+	}
+	if e = p.SendError(call, "org.example.Error.Busy", "busy", make(chan int)); e == nil {
+		t.Error("#3 Failed")
+	}
+	if e = p.SendError(call, "org.example.Error.Busy", "again"); e != ErrAlreadyAnswered {
+		t.Error("#3 Failed", e)
+	}
+	quiet := copyMessage(call)
+	quiet.answered = false
+	quiet.Flags = NO_REPLY_EXPECTED
+	if e = p.SendError(quiet, "org.example.Error.Ignored", "ignored"); e != nil {
+		t.Error("#3 Failed", e)
+	}
+
+	var reply *Message
+	for i := 0; reply == nil && i < 100; i++ {
+		for _, msg := range server.Received() {
+			if msg.Type == ERROR {
+				reply = msg
+			}
+		}
+		time.Sleep(1e6)
+	}
+	if reply == nil {
+		t.Fatal("#4 Failed")
+	}
+	if reply.ErrorName != "org.example.Error.Busy" || reply.ReplySerial() != 42 || reply.Dest != ":1.9" ||
+		reply.Sig != "su" || reply.Params.At(0).(string) != "busy" || reply.Params.At(1).(uint32) != 3 {
+		t.Error("#5 Failed", reply)
+	}
+	time.Sleep(10e6)
+	for _, msg := range server.Received() {
+		if msg.ErrorName == "org.example.Error.Ignored" {
+			t.Error("#6 Failed", msg)
+		}
+	}
+}
+
+func TestSendErrorFromHandler(t *testing.T) {
+	server, p, e := NewMockServer()
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	defer p.Close()
+	p.RegisterMethodHandler("/org/example", "org.example.Foo", "Work", func(msg *Message) (*Message, os.Error) {
+		if e := p.SendError(msg, "org.example.Error.Busy", "busy"); e != nil {
+			t.Error("#2 Failed", e)
+		}
+		return nil, nil
+	})
+	call := NewMessage()
+	call.Type = METHOD_CALL
+	call.Path = "/org/example"
+	call.Iface = "org.example.Foo"
+	call.Member = "Work"
+	call.Dest = MOCK_UNIQUE_NAME
+	call.Sender = ":1.9"
+	call.serial = 42
+	if e = server.Emit(call); e != nil {
+		t.Fatal("#3 Failed", e)
+	}
+
+	// wait for the error, then for a stray METHOD_RETURN
+	replies := func() []*Message {
+		msgs := make([]*Message, 0, 2)
+		for _, msg := range server.Received() {
+			if msg.ReplySerial() == 42 && len(msgs) < cap(msgs) {
+				msgs = msgs[0 : len(msgs)+1]
+				msgs[len(msgs)-1] = msg
+			}
+		}
+		return msgs
+	}
+	for i := 0; len(replies()) == 0 && i < 100; i++ {
+		time.Sleep(1e6)
+	}
+	// once the handler is done, its return value would have been written
+	p.CloseWait(CancelAfter(1e9))
+	time.Sleep(10e6)
+	if r := replies(); len(r) != 1 || r[0].Type != ERROR || r[0].ErrorName != "org.example.Error.Busy" {
+		t.Error("#4 Failed", r)
+	}
+}
+
+func exportCall(p *Connection, member string, sig string, args ...) *Message {
+	msg := NewMessage()
+	msg.Type = METHOD_CALL
+	msg.Path = "/org/example"
+	msg.Iface = "org.example.Foo"
+	msg.Member = member
+	msg.Sig = sig
+	msg.Params.AppendVector(argToVector(args))
+	return p.handleCall(msg)
+}
+
+func TestExportMultipleOuts(t *testing.T) {
+	p := new(Connection)
+	e := p.Export("/org/example", "org.example.Foo", []MethodSpec{
+		MethodSpec{"Info", "s", "saua{sv}", func(name string) (string, []uint32, map[string]Variant, os.Error) {
+			if name == "" {
+				return "", nil, nil, nil
+			}
+			return "hello " + name, []uint32{1, 2}, map[string]Variant{"k": Variant{"u", uint32(3)}}, nil
+		}},
+		MethodSpec{"Fail", "", "", func() os.Error { return &Error{"org.example.Error.Nope", "nope"} }},
+	})
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+
+	reply := exportCall(p, "Info", "s", "bob")
+	if reply.Type != METHOD_RETURN || reply.Sig != "saua{sv}" || reply.Params.Len() != 3 {
+		t.Fatal("#2 Failed", reply)
+	}
+	if reply.Params.At(0).(string) != "hello bob" || reply.Params.At(1).(*vector.Vector).Len() != 2 {
+		t.Error("#3 Failed")
+	}
+	if _, e = EncodeMessage(reply); e != nil {
+		t.Error("#4 Failed", e)
+	}
+
+	// zero values and empty containers
+	reply = exportCall(p, "Info", "s", "")
+	if reply.Params.Len() != 3 || reply.Params.At(1).(*vector.Vector).Len() != 0 || reply.Params.At(2).(*vector.Vector).Len() != 0 {
+		t.Error("#5 Failed", reply.Params)
+	}
+
+	reply = exportCall(p, "Fail", "")
+	if reply.Type != ERROR || reply.ErrorName != "org.example.Error.Nope" {
+		t.Error("#6 Failed", reply)
+	}
+	reply = exportCall(p, "Info", "u", uint32(1))
+	if reply.Type != ERROR || reply.ErrorName != "org.freedesktop.DBus.Error.InvalidArgs" {
+		t.Error("#7 Failed", reply)
+	}
+	reply = exportCall(p, "Missing", "")
+	if reply.Type != ERROR || reply.ErrorName != "org.freedesktop.DBus.Error.UnknownMethod" {
+		t.Error("#8 Failed", reply)
+	}
+}
+
+func TestExportSpecMismatch(t *testing.T) {
+	p := new(Connection)
+	e := p.Export("/", "org.example.Foo", []MethodSpec{
+		MethodSpec{"Info", "s", "sas", func(name string) (string, []uint32, os.Error) { return "", nil, nil }},
+	})
+	if e == nil {
+		t.Error("#1 Failed")
+	}
+	e = p.Export("/", "org.example.Foo", []MethodSpec{
+		MethodSpec{"Info", "", "", func(name string) string { return "" }},
+	})
+	if e == nil {
+		t.Error("#2 Failed")
+	}
+}
+
+func TestExportNameCollision(t *testing.T) {
+	p := new(Connection)
+	ping := func() os.Error { return nil }
+	e := p.Export("/", "org.example.Foo", []MethodSpec{
+		MethodSpec{"Ping", "", "", ping},
+		MethodSpec{"Ping", "", "", ping},
+	})
+	if e == nil {
+		t.Error("#1 Failed")
+	}
+	if e = p.Export("/", "org.example.Foo", []MethodSpec{MethodSpec{"ping", "", "", ping}}); e == nil {
+		t.Error("#2 Failed")
+	}
+
+	p.SetNameMapper(&NameMapper{SnakeCase: true})
+	e = p.Export("/", "org.example.Foo", []MethodSpec{
+		MethodSpec{"power_state", "", "", ping},
+		MethodSpec{"PowerState", "", "", ping},
+	})
+	if e == nil || strings.Index(e.String(), `"power_state"`) < 0 || strings.Index(e.String(), `"PowerState"`) < 0 {
+		t.Error("#3 Failed", e)
+	}
+	if p.lookupMethod("/", "org.example.Foo", "PowerState") != nil {
+		t.Error("#4 Failed")
+	}
+	if e = p.Export("/", "org.example.Foo", []MethodSpec{MethodSpec{"ping", "", "", ping}}); e != nil {
+		t.Error("#5 Failed", e)
+	}
+}
+
+func TestRegisterMethodHandler(t *testing.T) {
+	p := new(Connection)
+	p.Export("/org/example", "org.example.Foo", []MethodSpec{
+		MethodSpec{Name: "Ping", Handler: func() os.Error { return nil }},
+	})
+	e := p.RegisterMethodHandler("/org/example", "org.example.Foo", "Echo", func(msg *Message) (*Message, os.Error) {
+		reply := NewMessage()
+		reply.Sig = msg.Sig
+		reply.Params.AppendVector(msg.Params)
+		return reply, nil
+	})
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	p.RegisterMethodHandler("/org/example", "org.example.Foo", "Fail", func(msg *Message) (*Message, os.Error) {
+		return nil, &Error{"org.example.Error.Nope", "nope"}
+	})
+	p.RegisterMethodHandler("/org/example", "org.example.Foo", "Empty", func(msg *Message) (*Message, os.Error) {
+		return nil, nil
+	})
+
+	reply := exportCall(p, "Echo", "su", "a", uint32(1))
+	if reply.Type != METHOD_RETURN || reply.Sig != "su" || reply.Params.At(0).(string) != "a" {
+		t.Error("#2 Failed", reply)
+	}
+	if reply = exportCall(p, "Fail", ""); reply.Type != ERROR || reply.ErrorName != "org.example.Error.Nope" {
+		t.Error("#3 Failed", reply)
+	}
+	if reply = exportCall(p, "Empty", ""); reply.Type != METHOD_RETURN || reply.Params.Len() != 0 {
+		t.Error("#4 Failed", reply)
+	}
+	// exported methods of the same interface are kept
+	if reply = exportCall(p, "Ping", ""); reply.Type != METHOD_RETURN {
+		t.Error("#5 Failed", reply)
+	}
+
+	p.UnregisterMethodHandler("/org/example", "org.example.Foo", "Echo")
+	if reply = exportCall(p, "Echo", "s", "a"); reply.ErrorName != "org.freedesktop.DBus.Error.UnknownMethod" {
+		t.Error("#6 Failed", reply)
+	}
+	if reply = exportCall(p, "Fail", ""); reply.ErrorName != "org.example.Error.Nope" {
+		t.Error("#7 Failed", reply)
+	}
+
+	if e = p.RegisterMethodHandler("org/example", "org.example.Foo", "Echo", nil); e == nil {
+		t.Error("#8 Failed")
+	}
+}
+
+func TestExportMethodTable(t *testing.T) {
+	p := new(Connection)
+	empty := func(msg *Message) (*Message, os.Error) { return nil, nil }
+	if e := p.ExportMethodTable("/org/example", "org.example.Plugin", map[string]MethodHandlerFunc{"A": empty}); e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	methods := func() []string {
+		reply := standardCall(p, "/org/example", INTROSPECTABLE_INTERFACE, "Introspect", "")
+		intro, e := NewIntrospect(reply.Params.At(0).(string))
+		if e != nil {
+			t.Fatal("#2 Failed", e)
+		}
+		return methodNames(intro.GetInterfaceData("org.example.Plugin"))
+	}
+	if names := methods(); len(names) != 1 || names[0] != "A" {
+		t.Error("#3 Failed", names)
+	}
+
+	p.UpdateMethodTable("/org/example", "org.example.Plugin", "B", empty)
+	p.UpdateMethodTable("/org/example", "org.example.Plugin", "A", nil)
+	if names := methods(); len(names) != 1 || names[0] != "B" {
+		t.Error("#4 Failed", names)
+	}
+	if reply := standardCall(p, "/org/example", "org.example.Plugin", "B", ""); reply.Type != METHOD_RETURN {
+		t.Error("#5 Failed", reply)
+	}
+	if e := p.ExportMethodTable("/org/example", "org.example.Plugin", map[string]MethodHandlerFunc{"C": nil}); e == nil {
+		t.Error("#6 Failed")
+	}
+}
+
+func TestCloseWait(t *testing.T) {
+	call := func(server *MockServer) {
+		msg := NewMessage()
+		msg.Type = METHOD_CALL
+		msg.Sender = ":1.9"
+		msg.Dest = MOCK_UNIQUE_NAME
+		msg.Path = "/org/example"
+		msg.Iface = "org.example.Foo"
+		msg.Member = "Slow"
+		server.Emit(msg)
+	}
+	replies := func(server *MockServer, n int) []*Message {
+		for i := 0; i < 100; i++ {
+			msgs := make([]*Message, 0, n)
+			for _, msg := range server.Received() {
+				if (msg.Type == METHOD_RETURN || msg.Type == ERROR) && len(msgs) < n {
+					msgs = msgs[0 : len(msgs)+1]
+					msgs[len(msgs)-1] = msg
+				}
+			}
+			if len(msgs) == n {
+				return msgs
+			}
+			time.Sleep(10e6)
+		}
+		return nil
+	}
+
+	server, p, e := NewMockServer()
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	started := make(chan bool, 1)
+	release := make(chan bool)
+	p.RegisterMethodHandler("/org/example", "org.example.Foo", "Slow", func(msg *Message) (*Message, os.Error) {
+		started <- true
+		<-release
+		return nil, nil
+	})
+	call(server)
+	<-started
+	closed := make(chan os.Error, 1)
+	go func() { closed <- p.CloseWait(nil) }()
+	// wait for CloseWait to refuse calls
+	for i := 0; i < 100; i++ {
+		p.stateMutex.Lock()
+		closing := p.closing
+		p.stateMutex.Unlock()
+		if closing {
+			break
+		}
+		time.Sleep(1e6)
+	}
+	call(server)
+	if msgs := replies(server, 1); msgs == nil || msgs[0].Type != ERROR || msgs[0].ErrorName != "org.freedesktop.DBus.Error.Disconnected" {
+		t.Error("#2 Failed", msgs)
+	}
+	if len(closed) != 0 || p.Err() != nil {
+		t.Error("#3 Failed", p.Err())
+	}
+	release <- true
+	if e := <-closed; e != nil || p.Err() != ErrClosed {
+		t.Error("#4 Failed", e, p.Err())
+	}
+	if msgs := replies(server, 2); msgs == nil || msgs[1].Type != METHOD_RETURN {
+		t.Error("#5 Failed", msgs)
+	}
+
+	// giving up closes at once and tells the handler
+	server, p, e = NewMockServer()
+	if e != nil {
+		t.Fatal("#6 Failed", e)
+	}
+	abandoned := make(chan bool, 1)
+	p.RegisterMethodHandler("/org/example", "org.example.Foo", "Slow", func(msg *Message) (*Message, os.Error) {
+		started <- true
+		<-msg.Done()
+		abandoned <- true
+		return nil, nil
+	})
+	call(server)
+	<-started
+	if e := p.CloseWait(CancelAfter(int64(10e6))); e != ErrCanceled || p.Err() != ErrClosed {
+		t.Error("#7 Failed", e, p.Err())
+	}
+	<-abandoned
+}
+
+func TestPanicRecovery(t *testing.T) {
+	p := new(Connection)
+	var logged []string
+	p.SetLogger(func(msg string) {
+		logged = logged[0 : len(logged)+1]
+		logged[len(logged)-1] = msg
+	})
+	logged = make([]string, 0, 4)
+	p.SetPanicRecovery(true)
+	p.Export("/org/example", "org.example.Foo", []MethodSpec{
+		MethodSpec{Name: "Crash", Handler: func() os.Error { panic("boom") }},
+	})
+	reply := standardCall(p, "/org/example", "org.example.Foo", "Crash", "")
+	if reply.ErrorName != "org.freedesktop.DBus.Error.Failed" || reply.Params.At(0).(string) != "boom" {
+		t.Error("#1 Failed", reply)
+	}
+	if len(logged) != 1 || !strings.HasPrefix(logged[0], "panic in org.example.Foo.Crash") {
+		t.Error("#2 Failed", logged)
+	}
+	// the connection still dispatches calls
+	if reply = standardCall(p, "/org/example", PEER_INTERFACE, "Ping", ""); reply.Type != METHOD_RETURN {
+		t.Error("#3 Failed", reply)
+	}
+}
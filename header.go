@@ -0,0 +1,92 @@
+package dbus
+
+// headerField describes a header field: its code and value signature on
+// the wire, the message types that require it, and the Message field it
+// maps to. get returns nil when the field is absent; set reports whether
+// the value had the right type. Encoding, decoding and IsValid are all
+// driven by headerFields, so a new field is one more entry.
+type headerField struct {
+	code     byte
+	sig      byte
+	name     string
+	required []MessageType
+	get      func(*Message) interface{}
+	set      func(*Message, interface{}) bool
+}
+
+// headerFields is in code order, which is the order fields are encoded in.
+var headerFields = []headerField{
+	stringHeader(1, 'o', "PATH", []MessageType{METHOD_CALL, SIGNAL}, func(p *Message) *string { return &p.Path }),
+	stringHeader(2, 's', "INTERFACE", []MessageType{SIGNAL}, func(p *Message) *string { return &p.Iface }),
+	stringHeader(3, 's', "MEMBER", []MessageType{METHOD_CALL, SIGNAL}, func(p *Message) *string { return &p.Member }),
+	stringHeader(4, 's', "ERROR_NAME", []MessageType{ERROR}, func(p *Message) *string { return &p.ErrorName }),
+	uint32Header(5, "REPLY_SERIAL", []MessageType{METHOD_RETURN, ERROR}, func(p *Message) *uint32 { return &p.replySerial }),
+	stringHeader(6, 's', "DESTINATION", nil, func(p *Message) *string { return &p.Dest }),
+	stringHeader(7, 's', "SENDER", nil, func(p *Message) *string { return &p.Sender }),
+	stringHeader(8, 'g', "SIGNATURE", nil, func(p *Message) *string { return &p.Sig }),
+	uint32Header(9, "UNIX_FDS", nil, func(p *Message) *uint32 { return &p.unixFds }),
+}
+
+func stringHeader(code byte, sig byte, name string, required []MessageType, field func(*Message) *string) headerField {
+	return headerField{code, sig, name, required,
+		func(p *Message) interface{} {
+			if s := *field(p); s != "" {
+				return s
+			}
+			return nil
+		},
+		func(p *Message, v interface{}) bool {
+			s, ok := v.(string)
+			*field(p) = s
+			return ok
+		}}
+}
+
+func uint32Header(code byte, name string, required []MessageType, field func(*Message) *uint32) headerField {
+	return headerField{code, 'u', name, required,
+		func(p *Message) interface{} {
+			if u := *field(p); u != 0 {
+				return u
+			}
+			return nil
+		},
+		func(p *Message, v interface{}) bool {
+			u, ok := v.(uint32)
+			*field(p) = u
+			return ok
+		}}
+}
+
+func headerFieldByCode(code byte) *headerField {
+	for i, _ := range headerFields {
+		if headerFields[i].code == code {
+			return &headerFields[i]
+		}
+	}
+	return nil
+}
+
+func (p *headerField) requiredBy(t MessageType) bool {
+	for _, r := range p.required {
+		if r == t {
+			return true
+		}
+	}
+	return false
+}
+
+// joinNames joins names as "A", "A and B" or "A, B and C".
+func joinNames(names []string) string {
+	switch len(names) {
+	case 0:
+		return ""
+	case 1:
+		return names[0]
+	}
+	last := len(names) - 1
+	str := names[0]
+	for _, name := range names[1:last] {
+		str += ", " + name
+	}
+	return str + " and " + names[last]
+}
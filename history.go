@@ -0,0 +1,101 @@
+package dbus
+
+import (
+	"container/vector"
+	"sync"
+)
+
+// messageHistory keeps the last messages received in a ring buffer.
+type messageHistory struct {
+	mutex sync.Mutex
+	ring  []*Message
+	next  int // index the next message is stored at
+	full  bool
+}
+
+// SetMessageHistory makes the connection keep copies of the last n
+// messages read from the transport, for debugging. 0, the default, keeps
+// none; changing n discards the history.
+func (p *Connection) SetMessageHistory(n int) {
+	p.history.mutex.Lock()
+	defer p.history.mutex.Unlock()
+	p.history.ring = nil
+	if 0 < n {
+		p.history.ring = make([]*Message, n)
+	}
+	p.history.next = 0
+	p.history.full = false
+}
+
+// MessageHistory returns copies of the kept messages, oldest first.
+func (p *Connection) MessageHistory() []*Message {
+	p.history.mutex.Lock()
+	defer p.history.mutex.Unlock()
+	h := &p.history
+	if !h.full {
+		msgs := make([]*Message, h.next)
+		for i := range msgs {
+			msgs[i] = copyMessage(h.ring[i])
+		}
+		return msgs
+	}
+	msgs := make([]*Message, len(h.ring))
+	for i := range msgs {
+		msgs[i] = copyMessage(h.ring[(h.next+i)%len(h.ring)])
+	}
+	return msgs
+}
+
+// remember stores a copy of msg if a history is kept.
+func (p *Connection) remember(msg *Message) {
+	p.history.mutex.Lock()
+	defer p.history.mutex.Unlock()
+	h := &p.history
+	if len(h.ring) == 0 {
+		return
+	}
+	h.ring[h.next] = copyMessage(msg)
+	h.next++
+	if h.next == len(h.ring) {
+		h.next = 0
+		h.full = true
+	}
+}
+
+// copyMessage returns a copy of msg that shares no containers with it.
+func copyMessage(msg *Message) *Message {
+	c := new(Message)
+	*c = *msg
+	c.Params = new(vector.Vector)
+	for v := range msg.Params.Iter() {
+		c.Params.Push(copyValue(v))
+	}
+	return c
+}
+
+// copyValue copies the containers of a decoded or to be encoded value.
+func copyValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case *vector.Vector:
+		c := new(vector.Vector)
+		for e := range x.Iter() {
+			c.Push(copyValue(e))
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(x))
+		for i, e := range x {
+			c[i] = copyValue(e)
+		}
+		return c
+	case []byte:
+		c := make([]byte, len(x))
+		for i, b := range x {
+			c[i] = b
+		}
+		return c
+	case Variant:
+		return Variant{x.Sig, copyValue(x.Value)}
+	}
+	return v
+}
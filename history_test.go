@@ -0,0 +1,46 @@
+package dbus
+
+import (
+	"container/vector"
+	"testing"
+)
+
+func TestMessageHistory(t *testing.T) {
+	p := new(Connection)
+	p.received(NewMessage())
+	if len(p.MessageHistory()) != 0 {
+		t.Error("#1 Failed")
+	}
+
+	p.SetMessageHistory(3)
+	msgs := make([]*Message, 5)
+	for i := range msgs {
+		msgs[i] = NewMessage()
+		ary := new(vector.Vector)
+		ary.Push(int32(i))
+		msgs[i].Params.Push(ary)
+		p.received(msgs[i])
+	}
+	got := p.MessageHistory()
+	if len(got) != 3 {
+		t.Fatal("#2 Failed", len(got))
+	}
+	for i, msg := range got {
+		if msg.Serial() != msgs[i+2].Serial() || msg == msgs[i+2] {
+			t.Error("#3 Failed", i)
+		}
+	}
+
+	// the history is not changed through the originals or the snapshot
+	msgs[4].Params.At(0).(*vector.Vector).Set(0, int32(-1))
+	got[2].Params.At(0).(*vector.Vector).Set(0, int32(-2))
+	if p.MessageHistory()[2].Params.At(0).(*vector.Vector).At(0).(int32) != 4 {
+		t.Error("#4 Failed")
+	}
+
+	p.SetMessageHistory(0)
+	p.received(NewMessage())
+	if len(p.MessageHistory()) != 0 {
+		t.Error("#5 Failed")
+	}
+}
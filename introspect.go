@@ -29,10 +29,17 @@ type signalData struct {
 	Arg  []argData
 }
 
-type interfaceData struct {
+type propertyData struct {
 	Name   string "attr"
-	Method []methodData
-	Signal []signalData
+	Type   string "attr"
+	Access string "attr"
+}
+
+type interfaceData struct {
+	Name     string "attr"
+	Method   []methodData
+	Signal   []signalData
+	Property []propertyData
 }
 
 type introspect struct {
@@ -43,12 +50,44 @@ type introspect struct {
 
 type Introspect interface {
 	GetInterfaceData(name string) InterfaceData
+	// Interfaces returns the interfaces of the node, in document order.
+	Interfaces() []InterfaceData
+	// Interface returns the interface name of the node, if it has it.
+	Interface(name string) (InterfaceData, bool)
+	// Children returns the names of the child nodes, relative to the node.
+	Children() []string
+	// NodeName returns the name attribute of the node, usually empty.
+	NodeName() string
+	// FindProperty returns the properties called name of all interfaces.
+	FindProperty(name string) []PropertyLocation
+	// FindMethod returns the methods called name of all interfaces.
+	FindMethod(name string) []MethodLocation
 }
 
 type InterfaceData interface {
 	GetMethodData(name string) MethodData
 	GetSignalData(name string) SignalData
+	GetPropertyData(name string) PropertyData
+	GetName() string
+}
+
+type PropertyData interface {
 	GetName() string
+	GetType() string
+	// GetAccess returns "read", "write" or "readwrite".
+	GetAccess() string
+}
+
+// PropertyLocation is a property found by Introspect.FindProperty.
+type PropertyLocation struct {
+	Interface string
+	Property  PropertyData
+}
+
+// MethodLocation is a method found by Introspect.FindMethod.
+type MethodLocation struct {
+	Interface string
+	Method    MethodData
 }
 
 type MethodData interface {
@@ -61,6 +100,7 @@ type SignalData interface {
 	GetSignature() string
 }
 
+// NewIntrospect parses introspection XML.
 func NewIntrospect(xmlIntro string) (Introspect, os.Error) {
 	intro := new(introspect)
 	buff := bytes.NewBuffer(strings.Bytes(xmlIntro))
@@ -81,6 +121,51 @@ func (p introspect) GetInterfaceData(name string) InterfaceData {
 	return nil
 }
 
+func (p introspect) Interfaces() []InterfaceData {
+	ifaces := make([]InterfaceData, len(p.Interface))
+	for i, v := range p.Interface {
+		ifaces[i] = v
+	}
+	return ifaces
+}
+
+func (p introspect) Interface(name string) (InterfaceData, bool) {
+	data := p.GetInterfaceData(name)
+	return data, data != nil
+}
+
+func (p introspect) Children() []string {
+	names := make([]string, len(p.Node))
+	for i, v := range p.Node {
+		names[i] = v.Name
+	}
+	return names
+}
+
+func (p introspect) NodeName() string { return p.Name }
+
+func (p introspect) FindProperty(name string) []PropertyLocation {
+	found := make([]PropertyLocation, 0, len(p.Interface))
+	for _, iface := range p.Interface {
+		if prop := iface.GetPropertyData(name); prop != nil {
+			found = found[0 : len(found)+1]
+			found[len(found)-1] = PropertyLocation{iface.Name, prop}
+		}
+	}
+	return found
+}
+
+func (p introspect) FindMethod(name string) []MethodLocation {
+	found := make([]MethodLocation, 0, len(p.Interface))
+	for _, iface := range p.Interface {
+		if method := iface.GetMethodData(name); method != nil {
+			found = found[0 : len(found)+1]
+			found[len(found)-1] = MethodLocation{iface.Name, method}
+		}
+	}
+	return found
+}
+
 func (p interfaceData) GetMethodData(name string) MethodData {
 	for _, v := range p.Method {
 		if v.GetName() == name {
@@ -99,8 +184,21 @@ func (p interfaceData) GetSignalData(name string) SignalData {
 	return nil
 }
 
+func (p interfaceData) GetPropertyData(name string) PropertyData {
+	for _, v := range p.Property {
+		if v.Name == name {
+			return v
+		}
+	}
+	return nil
+}
+
 func (p interfaceData) GetName() string { return p.Name }
 
+func (p propertyData) GetName() string   { return p.Name }
+func (p propertyData) GetType() string   { return p.Type }
+func (p propertyData) GetAccess() string { return p.Access }
+
 func (p methodData) GetInSignature() (sig string) {
 	for _, v := range p.Arg {
 		if strings.ToUpper(v.Direction) == "IN" {
@@ -129,3 +227,46 @@ func (p signalData) GetSignature() (sig string) {
 }
 
 func (p signalData) GetName() string { return p.Name }
+
+func interfaceNames(intro Introspect) []string {
+	if intro == nil {
+		return []string{}
+	}
+	ifaces := intro.Interfaces()
+	names := make([]string, len(ifaces))
+	for i, v := range ifaces {
+		names[i] = v.GetName()
+	}
+	return names
+}
+
+func methodNames(data InterfaceData) []string {
+	p, ok := data.(interfaceData)
+	if !ok {
+		return []string{}
+	}
+	names := make([]string, len(p.Method))
+	for i, v := range p.Method {
+		names[i] = v.Name
+	}
+	return names
+}
+
+func signalNames(data InterfaceData) []string {
+	p, ok := data.(interfaceData)
+	if !ok {
+		return []string{}
+	}
+	names := make([]string, len(p.Signal))
+	for i, v := range p.Signal {
+		names[i] = v.Name
+	}
+	return names
+}
+
+func childNames(intro Introspect) []string {
+	if intro == nil {
+		return []string{}
+	}
+	return intro.Children()
+}
@@ -75,4 +75,76 @@ func TestIntrospect(t *testing.T) {
 		t.Error("Failed #4-3")
 	}
 
+	names := interfaceNames(intro)
+	if len(names) != 1 || names[0] != "org.freedesktop.SampleInterface" {
+		t.Error("Failed #5-1", names)
+	}
+	methods := methodNames(intf)
+	if len(methods) != 3 || methods[0] != "Frobate" || methods[2] != "Mogrify" {
+		t.Error("Failed #5-2", methods)
+	}
+}
+
+func TestIntrospectAccessors(t *testing.T) {
+	intro, e := NewIntrospect(introStr)
+	if e != nil {
+		t.Fatal("Failed #1", e)
+	}
+	if name := intro.NodeName(); name != "/org/freedesktop/sample_object" {
+		t.Error("Failed #2", name)
+	}
+	ifaces := intro.Interfaces()
+	if len(ifaces) != 1 || ifaces[0].GetName() != "org.freedesktop.SampleInterface" {
+		t.Error("Failed #3", ifaces)
+	}
+	if data, ok := intro.Interface("org.freedesktop.SampleInterface"); !ok || data.GetMethodData("Frobate") == nil {
+		t.Error("Failed #4")
+	}
+	if data, ok := intro.Interface("org.example.Missing"); ok || data != nil {
+		t.Error("Failed #5", data)
+	}
+	children := intro.Children()
+	if len(children) != 2 || children[0] != "child_of_sample_object" || children[1] != "another_child_of_sample_object" {
+		t.Error("Failed #6", children)
+	}
+}
+
+func TestFindPropertyAndMethod(t *testing.T) {
+	intro, e := NewIntrospect(`<node>
+	  <interface name="org.example.A">
+	    <method name="Reset"/>
+	    <property name="Name" type="s" access="read"/>
+	  </interface>
+	  <interface name="org.example.B">
+	    <method name="Reset"><arg name="hard" type="b" direction="in"/></method>
+	    <property name="Name" type="s" access="readwrite"/>
+	    <property name="Size" type="u" access="read"/>
+	  </interface>
+	</node>`)
+	if e != nil {
+		t.Fatal("Failed #1", e)
+	}
+	props := intro.FindProperty("Name")
+	if len(props) != 2 || props[0].Interface != "org.example.A" || props[1].Interface != "org.example.B" {
+		t.Fatal("Failed #2", props)
+	}
+	if props[1].Property.GetType() != "s" || props[1].Property.GetAccess() != "readwrite" {
+		t.Error("Failed #3", props[1].Property)
+	}
+	if props := intro.FindProperty("Size"); len(props) != 1 || props[0].Interface != "org.example.B" {
+		t.Error("Failed #4", props)
+	}
+	if props := intro.FindProperty("Missing"); len(props) != 0 {
+		t.Error("Failed #5", props)
+	}
+	methods := intro.FindMethod("Reset")
+	if len(methods) != 2 || methods[1].Interface != "org.example.B" || methods[1].Method.GetInSignature() != "b" {
+		t.Error("Failed #6", methods)
+	}
+
+	// the sample declares Bar
+	sample, _ := NewIntrospect(introStr)
+	if props := sample.FindProperty("Bar"); len(props) != 1 || props[0].Property.GetType() != "y" {
+		t.Error("Failed #7", props)
+	}
 }
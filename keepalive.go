@@ -0,0 +1,35 @@
+package dbus
+
+import (
+	"os"
+	"time"
+)
+
+// ErrKeepalive is recorded as the connection failure when a keepalive ping
+// gets no reply.
+var ErrKeepalive = os.NewError("Keepalive ping failed")
+
+// EnableKeepalive pings the bus daemon every interval nanoseconds. If a ping
+// fails or is not answered within interval, the connection is marked as
+// failed (see Err) and closed. This notices dead transports earlier than
+// TCP keepalive does. Pinging stops once the connection is closed.
+func (p *Connection) EnableKeepalive(interval int64) {
+	go func() {
+		for p.Err() == nil {
+			time.Sleep(interval)
+			if p.Err() != nil {
+				return
+			}
+			msg := newPeerCall("org.freedesktop.DBus", "Ping")
+			msg.Path = "/org/freedesktop/DBus"
+			if _, err := p.call(msg, interval, nil); err != nil {
+				if _, ok := err.(*Error); ok {
+					// the daemon answered, so the transport is alive
+					continue
+				}
+				p.fail(ErrKeepalive)
+				return
+			}
+		}
+	}()
+}
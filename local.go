@@ -0,0 +1,30 @@
+package dbus
+
+// The local namespace is reserved for events generated by the library
+// itself; messages using it never cross the wire.
+const (
+	LOCAL_PATH      = "/org/freedesktop/DBus/Local"
+	LOCAL_INTERFACE = "org.freedesktop.DBus.Local"
+)
+
+// isLocal reports whether msg uses the local path or interface.
+func isLocal(msg *Message) bool {
+	return msg.Path == LOCAL_PATH || msg.Iface == LOCAL_INTERFACE
+}
+
+// isLocal reports whether the rule selects only local events, which the bus
+// daemon need not know about.
+func (p *MatchRule) isLocal() bool {
+	return p.Path == LOCAL_PATH || p.Interface == LOCAL_INTERFACE
+}
+
+// newDisconnected returns the Disconnected signal delivered to handlers
+// selecting it when the connection fails or is closed.
+func newDisconnected() *Message {
+	msg := NewMessage()
+	msg.Type = SIGNAL
+	msg.Path = LOCAL_PATH
+	msg.Iface = LOCAL_INTERFACE
+	msg.Member = "Disconnected"
+	return msg
+}
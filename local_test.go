@@ -0,0 +1,51 @@
+package dbus
+
+import (
+	"container/vector"
+	"testing"
+)
+
+func TestLocalNamespace(t *testing.T) {
+	msg := NewMessage()
+	msg.Type = SIGNAL
+	msg.Path = LOCAL_PATH
+	msg.Iface = "org.example"
+	msg.Member = "Disconnected"
+	if msg.IsValid() == nil {
+		t.Error("#1 Failed")
+	}
+	msg.Path = "/"
+	msg.Iface = LOCAL_INTERFACE
+	if msg.IsValid() == nil {
+		t.Error("#2 Failed")
+	}
+	// a decoded spoof is dropped by readMessage
+	if !isLocal(msg) {
+		t.Error("#3 Failed")
+	}
+	msg.Iface = "org.example"
+	if isLocal(msg) {
+		t.Error("#4 Failed")
+	}
+}
+
+func TestDisconnectedSignal(t *testing.T) {
+	p := new(Connection)
+	p.signalMatchRules = new(vector.Vector)
+	p.methodCallReplies = make(map[uint32]*pendingCall)
+	got := 0
+	mr := &MatchRule{Type: "signal", Interface: LOCAL_INTERFACE, Member: "Disconnected"}
+	if !mr.isLocal() {
+		t.Error("#1 Failed")
+	}
+	p.signalMatchRules.Push(&signalHandler{mr: *mr, proc: func(*Message) { got++ }})
+
+	p.Close()
+	if got != 1 {
+		t.Error("#2 Failed", got)
+	}
+	p.Close()
+	if got != 1 {
+		t.Error("#3 Failed", got)
+	}
+}
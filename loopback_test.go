@@ -0,0 +1,70 @@
+package dbus
+
+import (
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestLocalCalls(t *testing.T) {
+	var mutex sync.Mutex
+	remote := 0
+	p := fakeService(t, func(call *Message) *Message {
+		mutex.Lock()
+		remote++
+		mutex.Unlock()
+		return newErrorReply(call, "org.example.Error.Remote", "")
+	})
+	defer p.Close()
+	p.uniqName = ":1.5"
+	ran := make(chan ObjectRef, 1)
+	p.Export("/org/example", "org.example.Foo", []MethodSpec{
+		MethodSpec{Name: "Who", Handler: func(ref ObjectRef) (string, os.Error) {
+			ran <- ref
+			return ref.Dest, nil
+		}},
+	})
+	remoteCalls := func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return remote
+	}
+
+	// off by default
+	if _, e := p.CallTimeout(0, ":1.5", "/org/example", "org.example.Foo", "Who", ObjectRef{"", "/a"}); e == nil || remoteCalls() != 1 {
+		t.Error("#1 Failed", e)
+	}
+
+	p.SetLocalCalls(true)
+	ret, e := p.CallTimeout(0, ":1.5", "/org/example", "org.example.Foo", "Who", ObjectRef{"", "/a"})
+	if e != nil || len(ret) != 1 || ret[0].(string) != ":1.5" || remoteCalls() != 1 {
+		t.Error("#2 Failed", e, ret)
+	}
+	<-ran
+
+	// errors are the same as through the bus
+	_, e = p.CallTimeout(0, ":1.5", "/org/example", "org.example.Foo", "Nope")
+	if err, ok := e.(*Error); !ok || err.Name != "org.freedesktop.DBus.Error.UnknownMethod" {
+		t.Error("#3 Failed", e)
+	}
+
+	// owned names take the fast path too, and NO_REPLY_EXPECTED calls run
+	// the handler without leaving a reply behind
+	p.setOwned("org.example.Self", true)
+	msg, _ := NewMethodCall("org.example.Self", "/org/example", "org.example.Foo", "Who").WithArg(ObjectRef{"", "/b"}).Build()
+	msg.Flags = NO_REPLY_EXPECTED
+	if e = p.send(msg); e != nil {
+		t.Error("#4 Failed", e)
+	}
+	if ref := <-ran; ref.Path != "/b" || ref.Dest != ":1.5" {
+		t.Error("#5 Failed", ref)
+	}
+	if remoteCalls() != 1 {
+		t.Error("#6 Failed")
+	}
+
+	p.setOwned("org.example.Self", false)
+	if _, e = p.CallTimeout(0, "org.example.Self", "/org/example", "org.example.Foo", "Who", ObjectRef{"", "/c"}); e == nil || remoteCalls() != 2 {
+		t.Error("#7 Failed", e)
+	}
+}
@@ -0,0 +1,66 @@
+package dbus
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ErrNoMachineID is returned by MachineID when no machine id file exists,
+// which is common in minimal containers.
+var ErrNoMachineID = os.NewError("No machine id")
+
+// files searched for the machine id, in order
+var machineIDPaths = []string{"/etc/machine-id", "/var/lib/dbus/machine-id"}
+
+var (
+	machineIDMutex sync.Mutex
+	machineID      string
+)
+
+// MachineID returns the id of the local machine as used by
+// org.freedesktop.DBus.Peer.GetMachineId. The value is cached after the
+// first successful lookup.
+func MachineID() (string, os.Error) {
+	machineIDMutex.Lock()
+	defer machineIDMutex.Unlock()
+	if machineID != "" {
+		return machineID, nil
+	}
+	id, err := readMachineID(machineIDPaths)
+	if err != nil {
+		return "", err
+	}
+	machineID = id
+	return id, nil
+}
+
+func readMachineID(paths []string) (string, os.Error) {
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		id := strings.TrimSpace(string(data))
+		if !isMachineID(id) {
+			return "", os.NewError("invalid machine id in " + path)
+		}
+		return id, nil
+	}
+	return "", ErrNoMachineID
+}
+
+// isMachineID reports whether id is 32 lower-case hex digits.
+func isMachineID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
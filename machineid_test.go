@@ -0,0 +1,30 @@
+package dbus
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestReadMachineID(t *testing.T) {
+	dir := "/tmp"
+	missing := dir + "/dbus-test-no-machine-id"
+	valid := dir + "/dbus-test-machine-id"
+	invalid := dir + "/dbus-test-bad-machine-id"
+	os.Remove(missing)
+	ioutil.WriteFile(valid, []byte("0123456789abcdef0123456789abcdef\n"), 0644)
+	ioutil.WriteFile(invalid, []byte("not-a-machine-id\n"), 0644)
+	defer os.Remove(valid)
+	defer os.Remove(invalid)
+
+	id, e := readMachineID([]string{missing, valid})
+	if e != nil || id != "0123456789abcdef0123456789abcdef" {
+		t.Error("#1 Failed", id, e)
+	}
+	if _, e = readMachineID([]string{missing}); e != ErrNoMachineID {
+		t.Error("#2 Failed", e)
+	}
+	if _, e = readMachineID([]string{invalid, valid}); e == nil {
+		t.Error("#3 Failed")
+	}
+}
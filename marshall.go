@@ -4,12 +4,38 @@ import (
 	"bytes"
 	"strings"
 	"encoding/binary"
+	"math"
 	"os"
 	"container/vector"
 	"fmt"
 )
 
-func _Align(length int, index int) int {
+// TypeCode is a character of a D-Bus signature.
+type TypeCode byte
+
+const (
+	TypeByte           TypeCode = 'y'
+	TypeBoolean        TypeCode = 'b'
+	TypeInt16          TypeCode = 'n'
+	TypeUint16         TypeCode = 'q'
+	TypeInt32          TypeCode = 'i'
+	TypeUint32         TypeCode = 'u'
+	TypeInt64          TypeCode = 'x'
+	TypeUint64         TypeCode = 't'
+	TypeDouble         TypeCode = 'd'
+	TypeString         TypeCode = 's'
+	TypeObjectPath     TypeCode = 'o'
+	TypeSignature      TypeCode = 'g'
+	TypeUnixFd         TypeCode = 'h' // index of a file descriptor sent along
+	TypeArray          TypeCode = 'a'
+	TypeVariant        TypeCode = 'v'
+	TypeStructBegin    TypeCode = '('
+	TypeStructEnd      TypeCode = ')'
+	TypeDictEntryBegin TypeCode = '{'
+	TypeDictEntryEnd   TypeCode = '}'
+)
+
+func align(length int, index int) int {
 	switch length {
 	case 1:
 		return index
@@ -21,201 +47,412 @@ func _Align(length int, index int) int {
 	return -1
 }
 
-func _AppendAlign(length int, buff *bytes.Buffer) {
-	padno := _Align(length, buff.Len()) - buff.Len()
+func appendAlign(length int, buff *bytes.Buffer) {
+	padno := align(length, buff.Len()) - buff.Len()
 	for i := 0; i < padno; i++ {
 		buff.WriteByte(0)
 	}
 }
 
-func _AppendString(buff *bytes.Buffer, str string) {
-	_AppendAlign(4, buff)
-	binary.Write(buff, binary.LittleEndian, int32(len(str)))
+func appendString(buff *bytes.Buffer, str string, order binary.ByteOrder) {
+	appendAlign(4, buff)
+	binary.Write(buff, order, int32(len(str)))
 	buff.Write(strings.Bytes(str))
 	buff.WriteByte(0)
 }
 
-func _AppendSignature(buff *bytes.Buffer, sig string) {
-	_AppendByte(buff, byte(len(sig)))
+func appendSignature(buff *bytes.Buffer, sig string) {
+	appendByte(buff, byte(len(sig)))
 	buff.Write(strings.Bytes(sig))
 	buff.WriteByte(0)
 }
 
-func _AppendByte(buff *bytes.Buffer, b byte) { binary.Write(buff, binary.LittleEndian, b) }
+func appendByte(buff *bytes.Buffer, b byte) { buff.WriteByte(b) }
+
+// appendNumber aligns buff to size and writes n, which must be size bytes
+// long.
+func appendNumber(buff *bytes.Buffer, size int, n interface{}, order binary.ByteOrder) {
+	appendAlign(size, buff)
+	binary.Write(buff, order, n)
+}
 
-func _AppendUint32(buff *bytes.Buffer, ui uint32) {
-	_AppendAlign(4, buff)
-	binary.Write(buff, binary.LittleEndian, ui)
+func appendUint32(buff *bytes.Buffer, ui uint32, order binary.ByteOrder) {
+	appendNumber(buff, 4, ui, order)
 }
 
-func _AppendInt32(buff *bytes.Buffer, i int32) {
-	_AppendAlign(4, buff)
-	binary.Write(buff, binary.LittleEndian, i)
+func appendInt32(buff *bytes.Buffer, i int32, order binary.ByteOrder) {
+	appendNumber(buff, 4, i, order)
 }
 
-func _AppendArray(buff *bytes.Buffer, align int, proc func(b *bytes.Buffer)) {
-	_AppendAlign(4, buff)
-	_AppendAlign(align, buff)
+// appendArray appends an array whose elements, written by proc, are
+// aligned to alignment. The length excludes the padding before the first
+// element.
+func appendArray(buff *bytes.Buffer, alignment int, proc func(b *bytes.Buffer), order binary.ByteOrder) {
+	appendAlign(4, buff)
+	start := buff.Len()
 	b := bytes.NewBuffer(buff.Bytes())
 	b.Write(strings.Bytes("ABCD")) // "ABCD" will be replaced with array-size.
+	appendAlign(alignment, b)
 	pos1 := b.Len()
 	proc(b)
 	pos2 := b.Len()
-	binary.Write(buff, binary.LittleEndian, int32(pos2-pos1))
-	buff.Write(b.Bytes()[pos1:pos2])
+	binary.Write(buff, order, int32(pos2-pos1))
+	buff.Write(b.Bytes()[start+4 : pos2])
+}
+
+// alignOf returns the alignment of the type starting sig.
+func alignOf(sig string) int {
+	switch TypeCode(sig[0]) {
+	case TypeInt16, TypeUint16:
+		return 2
+	case TypeBoolean, TypeInt32, TypeUint32, TypeUnixFd, TypeString, TypeObjectPath, TypeArray:
+		return 4
+	case TypeInt64, TypeUint64, TypeDouble, TypeStructBegin, TypeDictEntryBegin:
+		return 8
+	}
+	return 1 // y, g, v
 }
 
-func _AppendValue(buff *bytes.Buffer, sig string, val interface{}) (sigOffset int, e os.Error) {
+func typeError(sig string, val interface{}) os.Error {
+	return os.NewError(fmt.Sprintf("cannot encode %T as %s", val, sig))
+}
+
+// EncodeValue appends value, of the single complete type sig, to data in
+// byte order order and returns the extended slice. Alignment is relative
+// to the start of data, so data should start where a message starts.
+// Arrays and dicts are given as *vector.Vector, structs and dict entries as
+// []interface{} or *vector.Vector, and variants as Variant or a basic value.
+// DecodeValue returns variants as Variant, so anything it returns can be
+// encoded again with the same signature.
+func EncodeValue(data []byte, sig string, value interface{}, order binary.ByteOrder) ([]byte, os.Error) {
+	buff := bytes.NewBuffer(data)
+	n, e := appendValue(buff, sig, value, order)
+	if e != nil {
+		return data, e
+	}
+	if n != len(sig) {
+		return data, os.NewError(fmt.Sprintf("%q is not a single complete type", sig))
+	}
+	return buff.Bytes(), nil
+}
+
+// appendValue appends the value of the first complete type of sig and
+// returns the length of that type in sig.
+func appendValue(buff *bytes.Buffer, sig string, val interface{}, order binary.ByteOrder) (sigOffset int, e os.Error) {
 	if len(sig) == 0 {
 		return 0, os.NewError("Invalid Signature")
 	}
 
 	e = nil
+	sigOffset = 1
+
+	switch TypeCode(sig[0]) {
+	case TypeByte:
+		y, ok := val.(byte)
+		if !ok {
+			return 0, typeError(sig[0:1], val)
+		}
+		appendByte(buff, y)
+
+	case TypeBoolean:
+		b, ok := val.(bool)
+		if !ok {
+			return 0, typeError(sig[0:1], val)
+		}
+		if b {
+			appendUint32(buff, 1, order)
+		} else {
+			appendUint32(buff, 0, order)
+		}
+
+	case TypeInt16:
+		n, ok := val.(int16)
+		if !ok {
+			return 0, typeError(sig[0:1], val)
+		}
+		appendNumber(buff, 2, n, order)
+
+	case TypeUint16:
+		q, ok := val.(uint16)
+		if !ok {
+			return 0, typeError(sig[0:1], val)
+		}
+		appendNumber(buff, 2, q, order)
+
+	case TypeInt32:
+		i, ok := val.(int32)
+		if !ok {
+			return 0, typeError(sig[0:1], val)
+		}
+		appendInt32(buff, i, order)
 
-	switch sig[0] {
-	case 'y': // byte
-		_AppendByte(buff, val.(byte))
-		sigOffset =1
-
-	case 's': // string
-		_AppendString(buff, val.(string))
-		sigOffset = 1
-
-	case 'u': // uint32
-		_AppendUint32(buff, val.(uint32))
-		sigOffset = 1
-
-	case 'i': // int32
-		_AppendInt32(buff, val.(int32))
-		sigOffset = 1
-
-	case 'a': // ary
-		sigBlock, _ := _GetSigBlock(sig, 1)
-		_AppendArray(buff, 1, func(b *bytes.Buffer) {
-			if vec, ok := val.(*vector.Vector); ok && vec != nil {
-				for v := range vec.Iter() {
-					_AppendValue(b, sigBlock, v)
+	case TypeUint32, TypeUnixFd:
+		u, ok := val.(uint32)
+		if !ok {
+			return 0, typeError(sig[0:1], val)
+		}
+		appendUint32(buff, u, order)
+
+	case TypeInt64:
+		x, ok := val.(int64)
+		if !ok {
+			return 0, typeError(sig[0:1], val)
+		}
+		appendNumber(buff, 8, x, order)
+
+	case TypeUint64:
+		t, ok := val.(uint64)
+		if !ok {
+			return 0, typeError(sig[0:1], val)
+		}
+		appendNumber(buff, 8, t, order)
+
+	case TypeDouble:
+		d, ok := val.(float64)
+		if !ok {
+			return 0, typeError(sig[0:1], val)
+		}
+		appendNumber(buff, 8, math.Float64bits(d), order)
+
+	case TypeString, TypeObjectPath:
+		if ref, ok := val.(ObjectRef); ok && TypeCode(sig[0]) == TypeObjectPath {
+			val = ref.Path
+		}
+		s, ok := val.(string)
+		if !ok {
+			return 0, typeError(sig[0:1], val)
+		}
+		appendString(buff, s, order)
+
+	case TypeSignature:
+		g, ok := val.(string)
+		if !ok {
+			return 0, typeError(sig[0:1], val)
+		}
+		appendSignature(buff, g)
+
+	case TypeVariant:
+		variant, ok := val.(Variant)
+		if !ok {
+			variant.Value = val
+			variant.Sig, e = variantSignature(val)
+			if e != nil {
+				return 0, e
+			}
+		}
+		appendSignature(buff, variant.Sig)
+		if _, e = appendValue(buff, variant.Sig, variant.Value, order); e != nil {
+			return 0, e
+		}
+
+	case TypeArray:
+		var sigBlock string
+		if sigBlock, e = getSigBlock(sig, 1); e != nil {
+			return 0, e
+		}
+		vec, ok := val.(*vector.Vector)
+		if !ok {
+			return 0, typeError(sig[0:1+len(sigBlock)], val)
+		}
+		appendArray(buff, alignOf(sigBlock), func(b *bytes.Buffer) {
+			if vec == nil {
+				return
+			}
+			for v := range vec.Iter() {
+				if _, err := appendValue(b, sigBlock, v, order); err != nil && e == nil {
+					e = err
 				}
 			}
-		})
+		}, order)
+		if e != nil {
+			return 0, e
+		}
 		sigOffset = 1 + len(sigBlock)
 
-	case '(': // struct FIXME: nested struct not support
-		_AppendAlign(8, buff)
-		structSig, _ := _GetStructSig(sig, 0)
-		for i, s := range structSig {
-			_AppendValue(buff, string(s), val.([]interface{})[i])
+	case TypeStructBegin:
+		appendAlign(8, buff)
+		structSig, _ := getStructSig(sig, 0)
+		fields, ok := val.([]interface{})
+		if vec, isVec := val.(*vector.Vector); isVec {
+			fields, ok = vec.Data(), true
+		}
+		if !ok {
+			return 0, typeError(sig[0:len(structSig)+2], val)
 		}
+		e = appendParamsData(buff, structSig, sliceToVector(fields), order)
 		sigOffset = 2 + len(structSig)
 
-	case '{':
-		_AppendAlign(8, buff)
-		dictSig, _ := _GetDictSig(sig, 0)
-		for i, s := range dictSig {
-			_AppendValue(buff, string(s), val.([]interface{})[i])
+	case TypeDictEntryBegin:
+		appendAlign(8, buff)
+		dictSig, _ := getDictSig(sig, 0)
+		entry, ok := val.([]interface{})
+		if vec, isVec := val.(*vector.Vector); isVec {
+			entry, ok = vec.Data(), true
 		}
+		if !ok {
+			return 0, typeError(sig[0:len(dictSig)+2], val)
+		}
+		e = appendParamsData(buff, dictSig, sliceToVector(entry), order)
 		sigOffset = 2 + len(dictSig)
+
+	default:
+		return 0, os.NewError(fmt.Sprintf("unknown type %q", sig[0:1]))
 	}
 
 	return
 }
 
-func _AppendParamsData(buff *bytes.Buffer, sig string, params *vector.Vector) {
+// Variant is a value sent with its signature, for arguments of type 'v'.
+// Plain Go values of basic types are also accepted for 'v'. Decoded
+// variants are always Variant, since arrays, dicts and structs all decode
+// to *vector.Vector and their signature cannot be told from the value.
+type Variant struct {
+	Sig   string
+	Value interface{}
+}
+
+func variantSignature(val interface{}) (string, os.Error) {
+	switch val.(type) {
+	case byte:
+		return "y", nil
+	case bool:
+		return "b", nil
+	case int16:
+		return "n", nil
+	case uint16:
+		return "q", nil
+	case int32:
+		return "i", nil
+	case uint32:
+		return "u", nil
+	case int64:
+		return "x", nil
+	case uint64:
+		return "t", nil
+	case float64:
+		return "d", nil
+	case string:
+		return "s", nil
+	case ObjectRef:
+		return "o", nil
+	}
+	return "", os.NewError(fmt.Sprintf("no variant signature for %T; use Variant", val))
+}
+
+// unwrapVariant returns the value inside val, looking through nested
+// variants, or val itself if it is not a Variant.
+func unwrapVariant(val interface{}) interface{} {
+	for {
+		variant, ok := val.(Variant)
+		if !ok {
+			return val
+		}
+		val = variant.Value
+	}
+	return val
+}
+
+func sliceToVector(values []interface{}) *vector.Vector {
+	vec := new(vector.Vector)
+	for _, v := range values {
+		vec.Push(v)
+	}
+	return vec
+}
+
+// appendParamsData appends params, one value per complete type of sig.
+func appendParamsData(buff *bytes.Buffer, sig string, params *vector.Vector, order binary.ByteOrder) os.Error {
 	sigOffset := 0
-	prmsOffset := 0
-	for ; sigOffset < len(sig); prmsOffset++ {
-		offset, _ := _AppendValue(buff, sig[sigOffset:len(sig)], params.At(prmsOffset))
+	for prmsOffset := 0; sigOffset < len(sig); prmsOffset++ {
+		if params.Len() <= prmsOffset {
+			return os.NewError(fmt.Sprintf("%d values for signature %q", params.Len(), sig))
+		}
+		offset, e := appendValue(buff, sig[sigOffset:len(sig)], params.At(prmsOffset), order)
+		if e != nil {
+			return e
+		}
 		sigOffset += offset
 	}
+	return nil
 }
 
-func _GetByte(buff []byte, index int) (byte, os.Error) {
+func getByte(buff []byte, index int) (byte, os.Error) {
 	if len(buff) <= index {
 		return 0, os.NewError("index error")
 	}
 	return buff[index], nil
 }
 
-func _GetInt16(buff []byte, index int) (int16, os.Error) {
-	if len(buff) <= index+2-1 {
-		return 0, os.NewError("index error")
+// getNumber reads the size byte number at index of buff into n, which
+// must point to a number of that size.
+func getNumber(buff []byte, index int, size int, n interface{}, order binary.ByteOrder) os.Error {
+	if index < 0 || len(buff) < index+size {
+		return os.NewError("index error")
 	}
+	return binary.Read(bytes.NewBuffer(buff[index:index+size]), order, n)
+}
+
+func getInt16(buff []byte, index int, order binary.ByteOrder) (int16, os.Error) {
 	var n int16
-	e := binary.Read(bytes.NewBuffer(buff[index:len(buff)]), binary.LittleEndian, &n)
-	if e != nil {
-		return 0, e
-	}
-	return n, nil
+	e := getNumber(buff, index, 2, &n, order)
+	return n, e
 }
 
-func _GetUint16(buff []byte, index int) (uint16, os.Error) {
-	if len(buff) <= index+2-1 {
-		return 0, os.NewError("index error")
-	}
+func getUint16(buff []byte, index int, order binary.ByteOrder) (uint16, os.Error) {
 	var q uint16
-	e := binary.Read(bytes.NewBuffer(buff[index:len(buff)]), binary.LittleEndian, &q)
-	if e != nil {
-		return 0, e
-	}
-	return q, nil
+	e := getNumber(buff, index, 2, &q, order)
+	return q, e
 }
 
-func _GetInt32(buff []byte, index int) (int32, os.Error) {
-	if len(buff) <= index+4-1 {
-		return 0, os.NewError("index error")
-	}
-	var l int32
-	e := binary.Read(bytes.NewBuffer(buff[index:len(buff)]), binary.LittleEndian, &l)
-	if e != nil {
-		return 0, e
-	}
-	return l, nil
+func getInt32(buff []byte, index int, order binary.ByteOrder) (int32, os.Error) {
+	var i int32
+	e := getNumber(buff, index, 4, &i, order)
+	return i, e
 }
 
-func _GetUint32(buff []byte, index int) (uint32, os.Error) {
-	if len(buff) <= index+4-1 {
-		return 0, os.NewError("index error")
-	}
+func getUint32(buff []byte, index int, order binary.ByteOrder) (uint32, os.Error) {
 	var u uint32
-	e := binary.Read(bytes.NewBuffer(buff[index:len(buff)]), binary.LittleEndian, &u)
-	if e != nil {
-		return 0, e
-	}
-	return u, nil
+	e := getNumber(buff, index, 4, &u, order)
+	return u, e
 }
 
-func _GetBoolean(buff []byte, index int) (bool, os.Error) {
-	if len(buff) <= index+4-1 {
-		return false, os.NewError("index error")
-	}
-	var v int32
-	e := binary.Read(bytes.NewBuffer(buff[index:len(buff)]), binary.LittleEndian, &v)
-	if e != nil {
-		return false, e
-	}
-	return 0 != v, nil
+func getInt64(buff []byte, index int, order binary.ByteOrder) (int64, os.Error) {
+	var x int64
+	e := getNumber(buff, index, 8, &x, order)
+	return x, e
 }
 
-func _GetString(buff []byte, index int, size int) (string, os.Error) {
+func getUint64(buff []byte, index int, order binary.ByteOrder) (uint64, os.Error) {
+	var t uint64
+	e := getNumber(buff, index, 8, &t, order)
+	return t, e
+}
+
+func getBoolean(buff []byte, index int, order binary.ByteOrder) (bool, os.Error) {
+	v, e := getUint32(buff, index, order)
+	return 0 != v, e
+}
+
+func getString(buff []byte, index int, size int) (string, os.Error) {
 	if len(buff) <= (index + size - 1) {
 		return "", os.NewError("index error")
 	}
 	return string(buff[index : index+size]), nil
 }
 
-func _GetStructSig(sig string, startIdx int) (string, os.Error) {
-	if len(sig) <= startIdx || '(' != sig[startIdx] {
+func getStructSig(sig string, startIdx int) (string, os.Error) {
+	if len(sig) <= startIdx || TypeCode(sig[startIdx]) != TypeStructBegin {
 		return "<nil>", os.NewError("index error")
 	}
 	sigIdx := startIdx + 1
 	for depth := 0; sigIdx < len(sig); sigIdx++ {
-		switch sig[sigIdx] {
-		case ')':
+		switch TypeCode(sig[sigIdx]) {
+		case TypeStructEnd:
 			if depth == 0 {
 				return sig[startIdx+1 : sigIdx], nil
 			}
 			depth--
-		case '(':
+		case TypeStructBegin:
 			depth++
 		}
 	}
@@ -223,19 +460,19 @@ func _GetStructSig(sig string, startIdx int) (string, os.Error) {
 	return "<nil>", os.NewError("parse error")
 }
 
-func _GetDictSig(sig string, startIdx int) (string, os.Error) {
-	if len(sig) <= startIdx || '{' != sig[startIdx] {
+func getDictSig(sig string, startIdx int) (string, os.Error) {
+	if len(sig) <= startIdx || TypeCode(sig[startIdx]) != TypeDictEntryBegin {
 		return "<nil>", os.NewError("index error")
 	}
 	sigIdx := startIdx + 1
 	for depth := 0; sigIdx < len(sig); sigIdx++ {
-		switch sig[sigIdx] {
-		case '}':
+		switch TypeCode(sig[sigIdx]) {
+		case TypeDictEntryEnd:
 			if depth == 0 {
 				return sig[startIdx+1 : sigIdx], nil
 			}
 			depth--
-		case '{':
+		case TypeDictEntryBegin:
 			depth++
 		}
 	}
@@ -243,217 +480,225 @@ func _GetDictSig(sig string, startIdx int) (string, os.Error) {
 	return "<nil>", os.NewError("parse error")
 }
 
-func _GetSigBlock(sig string, index int) (string, os.Error) {
-	switch sig[index] {
-	case '(':
-		str, e := _GetStructSig(sig, index)
+func getSigBlock(sig string, index int) (string, os.Error) {
+	if len(sig) <= index {
+		return "", os.NewError("incomplete signature " + sig)
+	}
+	switch TypeCode(sig[index]) {
+	case TypeStructBegin:
+		str, e := getStructSig(sig, index)
 		if e != nil {
 			return "", e
 		}
 		return strings.Join([]string{"(", str, ")"}, ""), nil
 
-	case '{':
-		str, e := _GetDictSig(sig, index)
+	case TypeDictEntryBegin:
+		str, e := getDictSig(sig, index)
 		if e != nil {
 			return "", e
 		}
 		return strings.Join([]string{"{", str, "}"}, ""), nil
 
+	case TypeArray:
+		str, e := getSigBlock(sig, index+1)
+		if e != nil {
+			return "", e
+		}
+		return "a" + str, nil
 	}
 
 	// default
 	return sig[index : index+1], nil
 }
 
-func _GetVariant(buff []byte, index int) (valvec *vector.Vector, retidx int, e os.Error) {
+func getVariant(buff []byte, index int, order binary.ByteOrder) (sig string, valvec *vector.Vector, retidx int, e os.Error) {
 	retidx = index
-	sigSize := int(buff[retidx])
+	size, e := getByte(buff, retidx)
+	if e != nil {
+		return
+	}
 	retidx++
-	sig := string(buff[retidx : retidx+sigSize])
-	valvec, retidx, e = Parse(buff, sig, retidx+sigSize+1)
+	sig, e = getString(buff, retidx, int(size))
+	if e != nil {
+		return
+	}
+	valvec, retidx, e = parse(buff, sig, retidx+int(size)+1, order)
 	return
 }
 
-
+// Parse decodes values of signature sig from little-endian buff starting at
+// index. It returns the values and the index following them.
 func Parse(buff []byte, sig string, index int) (vec *vector.Vector, bufIdx int, err os.Error) {
+	return parse(buff, sig, index, binary.LittleEndian)
+}
+
+func parse(buff []byte, sig string, index int, order binary.ByteOrder) (vec *vector.Vector, bufIdx int, err os.Error) {
 	vec = new(vector.Vector)
 	bufIdx = index
 	for sigIdx := 0; sigIdx < len(sig); {
-		switch sig[sigIdx] {
-		case 'b': // bool
-			bufIdx = _Align(4, bufIdx)
-			b, e := _GetBoolean(buff, bufIdx)
-			if e != nil {
-				err = e
-				return
-			}
-			vec.Push(b)
-			bufIdx += 4
-			sigIdx++
-
-		case 'y': // byte
-			v, e := _GetByte(buff, bufIdx)
-			if e != nil {
-				err = e
-				return
-			}
-			vec.Push(v)
-			bufIdx++
-			sigIdx++
-
-		case 'n': // int16
-			bufIdx = _Align(2, bufIdx)
-			n, e := _GetInt16(buff, bufIdx)
-			if e != nil {
-				err = e
-				return
-			}
-
-			vec.Push(n)
-			bufIdx += 2
-			sigIdx++
-
-		case 'q': // uint16
-			bufIdx = _Align(2, bufIdx)
-			q, e := _GetUint16(buff, bufIdx)
-			if e != nil {
-				err = e
-				return
-			}
-
-			vec.Push(q)
-			bufIdx += 2
-			sigIdx++
-
-		case 'u': // uint32
-			bufIdx = _Align(4, bufIdx)
-
-			u, e := _GetUint32(buff, bufIdx)
-			if e != nil {
-				err = e
-				return
-			}
-
-			vec.Push(u)
-			bufIdx += 4
-			sigIdx++
-
-		case 's', 'o': // string, object
-			bufIdx = _Align(4, bufIdx)
-
-			size, e := _GetInt32(buff, bufIdx)
-			if e != nil {
-				err = e
-				return
-			}
-
-			str, e := _GetString(buff, bufIdx+4, int(size))
-			if e != nil {
-				err = e
-				return
-			}
-
-			vec.Push(str)
-			bufIdx += (4 + int(size) + 1)
-			sigIdx++
-
-		case 'g': // signature
-			size, e := _GetByte(buff, bufIdx)
-			if e != nil {
-				err = e
-				return
-			}
-
-			str, e := _GetString(buff, bufIdx+1, int(size))
-			if e != nil {
-				err = e
-				return
-			}
-			vec.Push(str)
-			bufIdx += (1 + int(size) + 1)
-			sigIdx++
-
-		case 'a': // array
-			startIdx := _Align(4, bufIdx)
-			arySize, e := _GetInt32(buff, startIdx)
-			if e != nil {
-				err = e
-				return
-			}
-
-			sigBlock, e := _GetSigBlock(sig, sigIdx+1)
-			if e != nil {
-				err = e
-				return
-			}
-
-			aryIdx := startIdx + 4
-			aryVec := new(vector.Vector)
-			for aryIdx < (startIdx+4)+int(arySize) {
-				retvec, retidx, e := Parse(buff, sigBlock, aryIdx)
-				if e != nil {
-					err = e
-					return
-				}
-
-				aryVec.AppendVector(retvec)
-				aryIdx = retidx
-			}
-			bufIdx = aryIdx
-			sigIdx += (1 + len(sigBlock))
-			vec.Push(aryVec)
-
-		case '(': // struct
-			idx := _Align(8, bufIdx)
-			stSig, e := _GetStructSig(sig, sigIdx)
-			if e != nil {
-				err = e
-				return
-			}
+		val, next, n, e := decodeValue(buff, bufIdx, sig[sigIdx:], order)
+		if e != nil {
+			return nil, index, e
+		}
+		vec.Push(val)
+		bufIdx = next
+		sigIdx += n
+	}
+	return
+}
 
-			retvec, retidx, e := Parse(buff, stSig, idx)
-			if e != nil {
-				err = e
-				return
-			}
+// DecodeValue decodes the value of the single complete type sig at offset
+// of data, in byte order order. Alignment is relative to the start of data,
+// so data should start where a message starts. It returns the value, in the
+// form Parse returns it, and the offset following it.
+func DecodeValue(data []byte, offset int, sig string, order binary.ByteOrder) (interface{}, int, os.Error) {
+	val, next, n, e := decodeValue(data, offset, sig, order)
+	if e != nil {
+		return nil, offset, e
+	}
+	if n != len(sig) {
+		return nil, offset, os.NewError(fmt.Sprintf("%q is not a single complete type", sig))
+	}
+	return val, next, nil
+}
 
-			bufIdx = retidx
-			sigIdx += (len(stSig) + 2)
-			vec.Push(retvec)
+// decodeValue decodes the value of the first complete type of sig. It
+// returns the value, the index following it and the length of the type in
+// sig.
+func decodeValue(buff []byte, index int, sig string, order binary.ByteOrder) (val interface{}, bufIdx int, sigLen int, err os.Error) {
+	if len(sig) == 0 {
+		return nil, index, 0, os.NewError("Invalid Signature")
+	}
+	bufIdx = index
+	sigLen = 1
+	switch TypeCode(sig[0]) {
+	case TypeByte:
+		val, err = getByte(buff, bufIdx)
+		bufIdx++
+
+	case TypeBoolean:
+		bufIdx = align(4, bufIdx)
+		val, err = getBoolean(buff, bufIdx, order)
+		bufIdx += 4
+
+	case TypeInt16:
+		bufIdx = align(2, bufIdx)
+		val, err = getInt16(buff, bufIdx, order)
+		bufIdx += 2
+
+	case TypeUint16:
+		bufIdx = align(2, bufIdx)
+		val, err = getUint16(buff, bufIdx, order)
+		bufIdx += 2
+
+	case TypeInt32:
+		bufIdx = align(4, bufIdx)
+		val, err = getInt32(buff, bufIdx, order)
+		bufIdx += 4
+
+	case TypeUint32, TypeUnixFd:
+		bufIdx = align(4, bufIdx)
+		val, err = getUint32(buff, bufIdx, order)
+		bufIdx += 4
+
+	case TypeInt64:
+		bufIdx = align(8, bufIdx)
+		val, err = getInt64(buff, bufIdx, order)
+		bufIdx += 8
+
+	case TypeUint64:
+		bufIdx = align(8, bufIdx)
+		val, err = getUint64(buff, bufIdx, order)
+		bufIdx += 8
+
+	case TypeDouble:
+		bufIdx = align(8, bufIdx)
+		bits, e := getUint64(buff, bufIdx, order)
+		val, err = math.Float64frombits(bits), e
+		bufIdx += 8
+
+	case TypeString, TypeObjectPath:
+		bufIdx = align(4, bufIdx)
+		size, e := getUint32(buff, bufIdx, order)
+		if e != nil {
+			return nil, index, 0, e
+		}
+		// compared before any conversion to int, which may be 32 bits wide
+		if uint32(len(buff)-bufIdx-4) <= size {
+			return nil, index, 0, os.NewError("index error")
+		}
+		val, err = getString(buff, bufIdx+4, int(size))
+		bufIdx += 4 + int(size) + 1
 
-		case '{': // dict
-			idx := _Align(8, bufIdx)
-			stSig, e := _GetDictSig(sig, sigIdx)
-			if e != nil {
-				err = e
-				return
-			}
+	case TypeSignature:
+		size, e := getByte(buff, bufIdx)
+		if e != nil {
+			return nil, index, 0, e
+		}
+		val, err = getString(buff, bufIdx+1, int(size))
+		bufIdx += 1 + int(size) + 1
+
+	case TypeArray:
+		startIdx := align(4, bufIdx)
+		var arySize uint32
+		var sigBlock string
+		if arySize, err = getUint32(buff, startIdx, order); err != nil {
+			return nil, index, 0, err
+		}
+		if sigBlock, err = getSigBlock(sig, 1); err != nil {
+			return nil, index, 0, err
+		}
 
-			retvec, retidx, e := Parse(buff, stSig, idx)
-			if e != nil {
-				err = e
-				return
+		// the length does not count the padding before the first element
+		aryIdx := align(alignOf(sigBlock), startIdx+4)
+		if len(buff) < aryIdx || uint32(len(buff)-aryIdx) < arySize {
+			return nil, index, 0, os.NewError("index error")
+		}
+		end := aryIdx + int(arySize)
+		aryVec := new(vector.Vector)
+		for aryIdx < end {
+			var elem interface{}
+			if elem, aryIdx, _, err = decodeValue(buff, aryIdx, sigBlock, order); err != nil {
+				return nil, index, 0, err
 			}
+			aryVec.Push(elem)
+		}
+		val = aryVec
+		bufIdx = aryIdx
+		sigLen = 1 + len(sigBlock)
 
-			bufIdx = retidx
-			sigIdx += (len(stSig) + 2)
-			vec.Push(retvec)
-
-		case 'v': // variant
-			val, idx, e := _GetVariant(buff, bufIdx)
-			if e != nil {
-				err = e
-				return
-			}
+	case TypeStructBegin:
+		stSig, e := getStructSig(sig, 0)
+		if e != nil {
+			return nil, index, 0, e
+		}
+		val, bufIdx, err = parse(buff, stSig, align(8, bufIdx), order)
+		sigLen = len(stSig) + 2
 
-			bufIdx = idx
-			sigIdx++
-			vec.AppendVector(val)
+	case TypeDictEntryBegin:
+		stSig, e := getDictSig(sig, 0)
+		if e != nil {
+			return nil, index, 0, e
+		}
+		val, bufIdx, err = parse(buff, stSig, align(8, bufIdx), order)
+		sigLen = len(stSig) + 2
 
-		default:
-			fmt.Println(sig[sigIdx])
-			return nil, index, os.NewError("unknown type")
+	case TypeVariant:
+		vsig, vec, next, e := getVariant(buff, bufIdx, order)
+		if e != nil {
+			return nil, index, 0, e
 		}
+		if vec.Len() != 1 {
+			return nil, index, 0, os.NewError("variant does not hold a single value")
+		}
+		val = Variant{vsig, vec.At(0)}
+		bufIdx = next
+
+	default:
+		return nil, index, 0, os.NewError(fmt.Sprintf("unknown type %q", sig[0:1]))
+	}
+	if err != nil {
+		return nil, index, 0, err
 	}
 	return
 }
@@ -3,6 +3,7 @@ package dbus
 import (
 	"testing"
 	"bytes"
+	"encoding/binary"
 	"strings"
 	"container/vector"
 	"reflect"
@@ -10,13 +11,13 @@ import (
 )
 
 func TestAlign(t *testing.T) {
-	if 4 != _Align(4, 1) {
+	if 4 != align(4, 1) {
 		t.Error("#1: Failed")
 	}
-	if 8 != _Align(8, 3) {
+	if 8 != align(8, 3) {
 		t.Error("#2: Failed")
 	}
-	if 24 != _Align(8, 17) {
+	if 24 != align(8, 17) {
 		t.Error("#3: Failed")
 	}
 
@@ -24,7 +25,7 @@ func TestAlign(t *testing.T) {
 
 func checkAppendAlign(t *testing.T, input string, align int, expected string) {
 	buff := bytes.NewBufferString(input)
-	_AppendAlign(align, buff)
+	appendAlign(align, buff)
 	if !bytes.Equal(strings.Bytes(expected), buff.Bytes()) {
 		t.Error("Failed")
 	}
@@ -39,7 +40,7 @@ func TestAppendAlign(t *testing.T) {
 func checkAppendString(t *testing.T, input []string, expected string) {
 	buff := bytes.NewBuffer([]byte{})
 	for _, str := range input {
-		_AppendString(buff, str)
+		appendString(buff, str, binary.LittleEndian)
 	}
 	if !bytes.Equal(strings.Bytes(expected), buff.Bytes()) {
 		t.Error("Failed:expected", strings.Bytes(expected), ", actual:", buff.Bytes())
@@ -53,11 +54,11 @@ func TestAppendString(t *testing.T) {
 
 func TestAppendByte(t *testing.T) {
 	buff := bytes.NewBuffer([]byte{})
-	_AppendByte(buff, 1)
+	appendByte(buff, 1)
 	if !bytes.Equal(strings.Bytes("\x01"), buff.Bytes()) {
 		t.Error("#1 Failed")
 	}
-	_AppendByte(buff, 2)
+	appendByte(buff, 2)
 	if !bytes.Equal(strings.Bytes("\x01\x02"), buff.Bytes()) {
 		t.Error("#2 Failed")
 	}
@@ -65,12 +66,12 @@ func TestAppendByte(t *testing.T) {
 
 func TestAppendUint32(t *testing.T) {
 	buff := bytes.NewBuffer([]byte{})
-	_AppendUint32(buff, 1)
+	appendUint32(buff, 1, binary.LittleEndian)
 	if !bytes.Equal(strings.Bytes("\x01\x00\x00\x00"), buff.Bytes()) {
 		t.Error("#1 Failed")
 	}
-	_AppendByte(buff, 2)
-	_AppendUint32(buff, 0xffffffff)
+	appendByte(buff, 2)
+	appendUint32(buff, 0xffffffff, binary.LittleEndian)
 	if !bytes.Equal(strings.Bytes("\x01\x00\x00\x00\x02\x00\x00\x00\xff\xff\xff\xff"), buff.Bytes()) {
 		t.Error("#2 Failed")
 	}
@@ -78,7 +79,7 @@ func TestAppendUint32(t *testing.T) {
 
 func TestAppendInt32(t *testing.T) {
 	buff := bytes.NewBuffer([]byte{})
-	_AppendInt32(buff, int32(-1))
+	appendInt32(buff, int32(-1), binary.LittleEndian)
 	if !bytes.Equal(strings.Bytes("\xff\xff\xff\xff"), buff.Bytes()) {
 		t.Error("#1 Failed")
 	}
@@ -94,20 +95,20 @@ func TestAppendArray(t *testing.T) {
 	teststr := "\x01\x02\x03\x04\x05\x00\x00\x00\x05\x00\x00\x00\x00\x00\x00\x00\x02"
 
 	buff := bytes.NewBuffer([]byte{})
-	_AppendByte(buff, 1)
-	_AppendByte(buff, 2)
-	_AppendByte(buff, 3)
-	_AppendByte(buff, 4)
-	_AppendByte(buff, 5)
+	appendByte(buff, 1)
+	appendByte(buff, 2)
+	appendByte(buff, 3)
+	appendByte(buff, 4)
+	appendByte(buff, 5)
 
-	_AppendArray(buff, 1,
+	appendArray(buff, 1,
 		func(b *bytes.Buffer) {
 			t.Log(b.Bytes())
-			_AppendAlign(8, b)
+			appendAlign(8, b)
 			t.Log(b.Bytes())
-			_AppendByte(b, 2)
+			appendByte(b, 2)
 			t.Log(b.Bytes())
-		})
+		}, binary.LittleEndian)
 
 	if teststr != string(buff.Bytes()) {
 		t.Error("#1 Failed\n", buff.Bytes(), strings.Bytes(teststr))
@@ -117,8 +118,8 @@ func TestAppendArray(t *testing.T) {
 func TestAppendValue(t *testing.T) {
 	buff := bytes.NewBuffer([]byte{})
 
-	_AppendValue(buff, "s", "string")
-	_AppendValue(buff, "s", "test2")
+	appendValue(buff, "s", "string", binary.LittleEndian)
+	appendValue(buff, "s", "test2", binary.LittleEndian)
 	if !bytes.Equal(strings.Bytes("\x06\x00\x00\x00string\x00\x00\x05\x00\x00\x00test2\x00"), buff.Bytes()) {
 		t.Error("#1 Failed")
 	}
@@ -127,41 +128,41 @@ func TestAppendValue(t *testing.T) {
 	vec.Push([]interface{}{"test1", uint32(1)})
 	vec.Push([]interface{}{"test2", uint32(2)})
 	vec.Push([]interface{}{"test3", uint32(3)})
-	_AppendValue(buff, "a(su)", vec)
-	if !bytes.Equal(strings.Bytes("\x34\x00\x00\x00\x00\x00\x00\x00\x05\x00\x00\x00test1\x00\x00\x00\x01\x00\x00\x00\x05\x00\x00\x00test2\x00\x00\x00\x02\x00\x00\x00\x05\x00\x00\x00test3\x00\x00\x00\x03\x00\x00\x00"), buff.Bytes()) {
+	appendValue(buff, "a(su)", vec, binary.LittleEndian)
+	if !bytes.Equal(strings.Bytes("\x30\x00\x00\x00\x00\x00\x00\x00\x05\x00\x00\x00test1\x00\x00\x00\x01\x00\x00\x00\x05\x00\x00\x00test2\x00\x00\x00\x02\x00\x00\x00\x05\x00\x00\x00test3\x00\x00\x00\x03\x00\x00\x00"), buff.Bytes()) {
 		t.Error("#2 Failed", buff.Bytes())
 	}
 }
 
 func TestGetByte(t *testing.T) {
-	if b, _ := _GetByte(strings.Bytes("\x00\x11"), 1); b != 0x11 {
+	if b, _ := getByte(strings.Bytes("\x00\x11"), 1); b != 0x11 {
 		t.Errorf("#1 Failed 0x%X != 0x11", b)
 	}
-	if _, e := _GetByte(strings.Bytes("\x00\x11"), 2); e == nil {
+	if _, e := getByte(strings.Bytes("\x00\x11"), 2); e == nil {
 		t.Errorf("#2 Failed")
 	}
 }
 
 func TestGetBoolean(t *testing.T) {
-	b, e := _GetBoolean(strings.Bytes("\x01\x00\x00\x00"), 0)
+	b, e := getBoolean(strings.Bytes("\x01\x00\x00\x00"), 0, binary.LittleEndian)
 	if e != nil {
 		t.Error("#1-1 Failed")
 	}
 	if true != b {
 		t.Error("#1-2 Failed")
 	}
-	_, e = _GetBoolean(strings.Bytes("\x01\x00\x00\x00"), 1)
+	_, e = getBoolean(strings.Bytes("\x01\x00\x00\x00"), 1, binary.LittleEndian)
 	if e == nil {
 		t.Error("#2 Failed")
 	}
 }
 
 func TestGetString(t *testing.T) {
-	s, e := _GetString(strings.Bytes("\x00\x00test"), 2, 4)
+	s, e := getString(strings.Bytes("\x00\x00test"), 2, 4)
 	if e != nil || s != "test" {
 		t.Error("#1 Failed")
 	}
-	s, e = _GetString(strings.Bytes("1234"), 3, 1)
+	s, e = getString(strings.Bytes("1234"), 3, 1)
 	if e != nil || s != "4" {
 		t.Error("#2 Failed")
 	}
@@ -170,32 +171,32 @@ func TestGetString(t *testing.T) {
 func TestGetStructSig(t *testing.T) {
 	var str string
 	var e os.Error
-	str, _ = _GetStructSig("(yyy)(yyy)", 0)
+	str, _ = getStructSig("(yyy)(yyy)", 0)
 	if "yyy" != str {
 		t.Error("#1 Failed:", str)
 	}
 
-	str, _ = _GetStructSig("(y(ppp))yy", 0)
+	str, _ = getStructSig("(y(ppp))yy", 0)
 	if "y(ppp)" != str {
 		t.Error("#2 Failed:", str)
 	}
 
-	str, _ = _GetStructSig("((test))yy", 0)
+	str, _ = getStructSig("((test))yy", 0)
 	if "(test)" != str {
 		t.Error("#3 Failed:", str)
 	}
 
-	str, _ = _GetStructSig("123((test))yy", 3)
+	str, _ = getStructSig("123((test))yy", 3)
 	if "(test)" != str {
 		t.Error("#4 Failed:", str)
 	}
 
-	_, e = _GetStructSig("((test)(test)", 0)
+	_, e = getStructSig("((test)(test)", 0)
 	if e == nil {
 		t.Error("#5 Failed")
 	}
 
-	_, e = _GetStructSig("((test(test", 0)
+	_, e = getStructSig("((test(test", 0)
 	if e == nil {
 		t.Error("#6 Failed")
 	}
@@ -204,14 +205,21 @@ func TestGetStructSig(t *testing.T) {
 
 func TestGetSigBlock(t *testing.T) {
 	var str string
-	str, _ = _GetSigBlock("123a3", 3)
-	if "a" != str {
+	str, _ = getSigBlock("123ai", 3)
+	if "ai" != str {
 		t.Error("#1 Failed:", str)
 	}
-	str, _ = _GetSigBlock("123(abc)", 3)
+	str, _ = getSigBlock("123(abc)", 3)
 	if "(abc)" != str {
 		t.Error("#2 Failed:", str)
 	}
+	str, _ = getSigBlock("aa{sv}i", 0)
+	if "aa{sv}" != str {
+		t.Error("#3 Failed:", str)
+	}
+	if _, e := getSigBlock("aa", 0); e == nil {
+		t.Error("#4 Failed")
+	}
 
 }
 
@@ -282,7 +290,10 @@ func TestParse(t *testing.T) {
 }
 
 func TestGetVariant(t *testing.T) {
-	val, index, _ := _GetVariant(strings.Bytes("\x00\x00\x01s\x00\x00\x00\x00\x04\x00\x00\x00test\x00"), 2)
+	sig, val, index, _ := getVariant(strings.Bytes("\x00\x00\x01s\x00\x00\x00\x00\x04\x00\x00\x00test\x00"), 2, binary.LittleEndian)
+	if "s" != sig {
+		t.Error("#1-0 Failed", sig)
+	}
 	str, ok := val.At(0).(string)
 	if !ok {
 		t.Error("#1-1 Failed")
@@ -300,14 +311,14 @@ func TestParseVariant(t *testing.T) {
 	if nil != e {
 		t.Error("#1 Failed")
 	}
-	if "test" != vec.At(0).(string) {
-		t.Error("#2 Failed")
+	if !reflect.DeepEqual(Variant{"s", "test"}, vec.At(0)) {
+		t.Error("#2 Failed", vec.At(0))
 	}
-	if 3 != vec.At(1).(byte) {
-		t.Error("#3 Failed")
+	if !reflect.DeepEqual(Variant{"y", byte(3)}, vec.At(1)) {
+		t.Error("#3 Failed", vec.At(1))
 	}
-	if 4 != vec.At(2).(uint32) {
-		t.Error("#4 Failed", vec.At(2).(uint32))
+	if !reflect.DeepEqual(Variant{"u", uint32(4)}, vec.At(2)) {
+		t.Error("#4 Failed", vec.At(2))
 	}
 }
 
@@ -322,7 +333,7 @@ func TestParseNumber(t *testing.T) {
 }
 
 func TestGetUint32(t *testing.T) {
-	u, e := _GetUint32(strings.Bytes("\x04\x00\x00\x00"), 0)
+	u, e := getUint32(strings.Bytes("\x04\x00\x00\x00"), 0, binary.LittleEndian)
 	if e != nil {
 		t.Error("Failed", e.String())
 	}
@@ -332,7 +343,7 @@ func TestGetUint32(t *testing.T) {
 }
 
 func TestGetInt32(t *testing.T) {
-	i, e := _GetInt32(strings.Bytes("\x04\x00\x00\x00"), 0)
+	i, e := getInt32(strings.Bytes("\x04\x00\x00\x00"), 0, binary.LittleEndian)
 	if e != nil {
 		t.Error("Failed")
 	}
@@ -340,3 +351,190 @@ func TestGetInt32(t *testing.T) {
 		t.Error("#1 Failed", i)
 	}
 }
+
+type codecCase struct {
+	sig   string
+	value interface{}
+	data  string
+}
+
+func TestCodecGolden(t *testing.T) {
+	ary := new(vector.Vector)
+	ary.Push(int32(1))
+	ary.Push(int32(2))
+	dict := new(vector.Vector)
+	dict.Push([]interface{}{byte(1), uint32(2)})
+	props := new(vector.Vector)
+	props.Push([]interface{}{"k", Variant{"u", uint32(5)}})
+
+	cases := []codecCase{
+		codecCase{"y", byte(7), "\x07"},
+		codecCase{"b", true, "\x01\x00\x00\x00"},
+		codecCase{"n", int16(-2), "\xfe\xff"},
+		codecCase{"q", uint16(0x102), "\x02\x01"},
+		codecCase{"i", int32(-1), "\xff\xff\xff\xff"},
+		codecCase{"u", uint32(0x01020304), "\x04\x03\x02\x01"},
+		codecCase{"x", int64(-2), "\xfe\xff\xff\xff\xff\xff\xff\xff"},
+		codecCase{"t", uint64(1 << 32), "\x00\x00\x00\x00\x01\x00\x00\x00"},
+		codecCase{"d", float64(1), "\x00\x00\x00\x00\x00\x00\xf0\x3f"},
+		codecCase{"h", uint32(1), "\x01\x00\x00\x00"},
+		codecCase{"s", "ab", "\x02\x00\x00\x00ab\x00"},
+		codecCase{"o", "/a", "\x02\x00\x00\x00/a\x00"},
+		codecCase{"g", "ai", "\x02ai\x00"},
+		codecCase{"v", Variant{"u", uint32(5)}, "\x01u\x00\x00\x05\x00\x00\x00"},
+		codecCase{"v", Variant{"d", float64(1)}, "\x01d\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\xf0\x3f"},
+		codecCase{"v", Variant{"x", int64(-2)}, "\x01x\x00\x00\x00\x00\x00\x00\xfe\xff\xff\xff\xff\xff\xff\xff"},
+		codecCase{"v", Variant{"ai", ary}, "\x02ai\x00\x08\x00\x00\x00\x01\x00\x00\x00\x02\x00\x00\x00"},
+		codecCase{"v", Variant{"a{sv}", props}, "\x05a{sv}\x00\x00\x10\x00\x00\x00\x00\x00\x00\x00\x01\x00\x00\x00k\x00\x01u\x00\x00\x00\x00\x05\x00\x00\x00"},
+		codecCase{"ai", ary, "\x08\x00\x00\x00\x01\x00\x00\x00\x02\x00\x00\x00"},
+		codecCase{"(yu)", []interface{}{byte(1), uint32(2)}, "\x01\x00\x00\x00\x02\x00\x00\x00"},
+		codecCase{"a(yu)", new(vector.Vector), "\x00\x00\x00\x00\x00\x00\x00\x00"},
+		codecCase{"a{yu}", dict, "\x08\x00\x00\x00\x00\x00\x00\x00\x01\x00\x00\x00\x02\x00\x00\x00"},
+	}
+	for _, c := range cases {
+		data, e := EncodeValue(nil, c.sig, c.value, binary.LittleEndian)
+		if e != nil || string(data) != c.data {
+			t.Error("#1 Failed", c.sig, data, e)
+			continue
+		}
+		val, next, e := DecodeValue(data, 0, c.sig, binary.LittleEndian)
+		if e != nil || next != len(data) {
+			t.Error("#2 Failed", c.sig, next, e)
+			continue
+		}
+		again, e := EncodeValue(nil, c.sig, val, binary.LittleEndian)
+		if e != nil || string(again) != c.data {
+			t.Error("#3 Failed", c.sig, again, e)
+		}
+	}
+
+	data, _ := EncodeValue(nil, "u", uint32(0x01020304), binary.BigEndian)
+	if string(data) != "\x01\x02\x03\x04" {
+		t.Error("#4 Failed", data)
+	}
+	if val, _, e := DecodeValue(data, 0, "u", binary.BigEndian); e != nil || val.(uint32) != 0x01020304 {
+		t.Error("#5 Failed", val, e)
+	}
+	if _, e := EncodeValue(nil, "uu", uint32(1), binary.LittleEndian); e == nil {
+		t.Error("#6 Failed")
+	}
+	if _, e := EncodeValue(nil, "u", "1", binary.LittleEndian); e == nil {
+		t.Error("#7 Failed")
+	}
+	if _, _, e := DecodeValue([]byte{1, 0}, 0, "u", binary.LittleEndian); e == nil {
+		t.Error("#8 Failed")
+	}
+}
+
+func TestDecodeHugeLengths(t *testing.T) {
+	cases := []codecCase{
+		codecCase{"s", nil, "\xff\xff\xff\xffab\x00"},
+		codecCase{"o", nil, "\xff\xff\xff\xff/a\x00"},
+		codecCase{"ay", nil, "\xff\xff\xff\xff\x01\x02"},
+		codecCase{"a(yu)", nil, "\xff\xff\xff\xff\x00\x00\x00\x00\x01"},
+		codecCase{"v", nil, "\x01s\x00\x00\xff\xff\xff\xffab\x00"},
+	}
+	for _, c := range cases {
+		if _, _, e := DecodeValue(strings.Bytes(c.data), 0, c.sig, binary.LittleEndian); e == nil {
+			t.Error("#1 Failed", c.sig)
+		}
+	}
+}
+
+func TestNestedArrays(t *testing.T) {
+	ints := [][]int32{[]int32{1, 2}, []int32{}, []int32{3}}
+	dicts := []map[string]interface{}{
+		map[string]interface{}{"a": uint32(1), "b": "x"},
+		map[string]interface{}{},
+	}
+	bytess := [][][]byte{[][]byte{[]byte{1}, []byte{2, 3}}, [][]byte{}}
+
+	data, e := EncodeValue(nil, "aai", wireValue(reflect.NewValue(ints)), binary.LittleEndian)
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	// outer length 0x18: inner arrays of 12, 4 and 8 bytes, 4-aligned
+	if data[0] != 0x18 {
+		t.Error("#2 Failed", data)
+	}
+	val, next, e := DecodeValue(data, 0, "aai", binary.LittleEndian)
+	if e != nil || next != len(data) {
+		t.Fatal("#3 Failed", e)
+	}
+	var gotInts [][]int32
+	if e = storeValue(reflect.NewValue(&gotInts).(*reflect.PtrValue).Elem(), val, ""); e != nil {
+		t.Fatal("#4 Failed", e)
+	}
+	if !reflect.DeepEqual(ints, gotInts) {
+		t.Error("#5 Failed", gotInts)
+	}
+
+	data, e = EncodeValue(nil, "aa{sv}", wireValue(reflect.NewValue(dicts)), binary.LittleEndian)
+	if e != nil {
+		t.Fatal("#6 Failed", e)
+	}
+	val, next, e = DecodeValue(data, 0, "aa{sv}", binary.LittleEndian)
+	if e != nil || next != len(data) {
+		t.Fatal("#7 Failed", e)
+	}
+	var gotDicts []map[string]interface{}
+	if e = storeValue(reflect.NewValue(&gotDicts).(*reflect.PtrValue).Elem(), val, ""); e != nil {
+		t.Fatal("#8 Failed", e)
+	}
+	if !reflect.DeepEqual(dicts, gotDicts) {
+		t.Error("#9 Failed", gotDicts)
+	}
+
+	data, e = EncodeValue(nil, "aaay", wireValue(reflect.NewValue(bytess)), binary.LittleEndian)
+	if e != nil {
+		t.Fatal("#10 Failed", e)
+	}
+	val, next, e = DecodeValue(data, 0, "aaay", binary.LittleEndian)
+	if e != nil || next != len(data) {
+		t.Fatal("#11 Failed", e)
+	}
+	var gotBytes [][][]byte
+	if e = storeValue(reflect.NewValue(&gotBytes).(*reflect.PtrValue).Elem(), val, ""); e != nil {
+		t.Fatal("#12 Failed", e)
+	}
+	if !reflect.DeepEqual(bytess, gotBytes) {
+		t.Error("#13 Failed", gotBytes)
+	}
+}
+
+func TestEncodeArrayErrors(t *testing.T) {
+	strs := new(vector.Vector)
+	strs.Push("a")
+	strs.Push(uint32(1))
+	if _, e := EncodeValue(nil, "as", strs, binary.LittleEndian); e == nil {
+		t.Error("#1 Failed")
+	}
+	if _, e := EncodeValue(nil, "as", []string{"a"}, binary.LittleEndian); e == nil {
+		t.Error("#2 Failed")
+	}
+	// the error is not lost inside a nested array
+	outer := new(vector.Vector)
+	outer.Push(strs)
+	if _, e := EncodeValue(nil, "aas", outer, binary.LittleEndian); e == nil {
+		t.Error("#3 Failed")
+	}
+	if data, e := EncodeValue(nil, "as", (*vector.Vector)(nil), binary.LittleEndian); e != nil || len(data) != 4 {
+		t.Error("#4 Failed", data, e)
+	}
+}
+
+func TestVariantSignature(t *testing.T) {
+	values := []interface{}{int64(-2), uint64(1 << 40), float64(0.5)}
+	sigs := []string{"x", "t", "d"}
+	for i, v := range values {
+		data, e := EncodeValue(nil, "v", v, binary.LittleEndian)
+		if e != nil {
+			t.Error("#1 Failed", sigs[i], e)
+			continue
+		}
+		val, _, e := DecodeValue(data, 0, "v", binary.LittleEndian)
+		if e != nil || !reflect.DeepEqual(val, Variant{sigs[i], v}) {
+			t.Error("#2 Failed", sigs[i], val, e)
+		}
+	}
+}
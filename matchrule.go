@@ -16,14 +16,21 @@ var typeMap = map[MessageType] string{
   ERROR : "error",
 }
 	
+// MatchRule selects the messages a signal handler receives. Empty fields
+// match anything.
 type MatchRule struct{
 	Type string
+	Sender string // a unique name, to take signals from one peer only
 	Interface string
 	Member string
 	Path string
+	Arg0 string // the first argument, which must be a string
 }
 
-func(p *MatchRule) _ToString() string{
+// String returns the rule in the form passed to the daemon's AddMatch.
+func(p *MatchRule) String() string{ return p.toString() }
+
+func(p *MatchRule) toString() string{
 	svec := new(vector.StringVector)
 
 	v := reflect.Indirect(reflect.NewValue(p)).(*reflect.StructValue)
@@ -38,11 +45,16 @@ func(p *MatchRule) _ToString() string{
 	return strings.Join(svec.Data(),",")
 }
 
-func(p *MatchRule) _Match(msg *Message) bool{
+func(p *MatchRule) match(msg *Message) bool{
 	if p.Type != "" && p.Type != typeMap[msg.Type]{ return false}
+	if p.Sender != "" && p.Sender != msg.Sender { return false}
 	if p.Interface != "" && p.Interface != msg.Iface { return false}
 	if p.Member != "" && p.Member != msg.Member { return false}
 	if p.Path != "" && p.Path != msg.Path { return false}
+	if p.Arg0 != "" {
+		if msg.Params.Len() == 0 { return false}
+		if arg0, ok := msg.Params.At(0).(string); !ok || arg0 != p.Arg0 { return false}
+	}
 	return true
 }
 
@@ -2,6 +2,9 @@
 package dbus
 
 import(
+	"container/vector"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -14,5 +17,96 @@ func TestToString(t *testing.T){
   	Member:"Foo",
 	  Path:"/bar/foo"}
 
-	if mr._ToString() != verifyStr { t.Error("#1 Failed")}
+	if mr.String() != verifyStr { t.Error("#1 Failed")}
+}
+
+func TestMatchRefCount(t *testing.T) {
+	var mutex sync.Mutex
+	calls := new(vector.StringVector)
+	p := fakeService(t, func(call *Message) *Message {
+		rule := call.Params.At(0).(string)
+		mutex.Lock()
+		calls.Push(call.Member + " " + rule)
+		mutex.Unlock()
+		if strings.Index(rule, "Refused") >= 0 {
+			return newErrorReply(call, "org.freedesktop.DBus.Error.MatchRuleInvalid", "")
+		}
+		return newMethodReturn(call)
+	})
+	defer p.Close()
+	callCount := func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return calls.Len()
+	}
+
+	mr := &MatchRule{Type: "signal", Member: "Foo"}
+	if e := p.AddMatch(mr); e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	h := p.AddSignalHandler(&MatchRule{Type: "signal", Member: "Foo"}, func(*Message) {})
+	if e := p.AddMatch(mr); e != nil || callCount() != 1 {
+		t.Error("#2 Failed", e, calls.Data())
+	}
+	p.RemoveSignalHandler(h)
+	p.RemoveMatch(mr)
+	if callCount() != 1 {
+		t.Error("#3 Failed", calls.Data())
+	}
+	if e := p.RemoveMatch(mr); e != nil || callCount() != 2 || calls.At(1) != "RemoveMatch "+mr.String() {
+		t.Error("#4 Failed", e, calls.Data())
+	}
+	if e := p.RemoveMatch(mr); e == nil || callCount() != 2 {
+		t.Error("#5 Failed")
+	}
+
+	// a refused rule is not counted, so it is asked for again
+	bad := &MatchRule{Type: "signal", Member: "Refused"}
+	if e := p.AddMatch(bad); e == nil {
+		t.Error("#6 Failed")
+	}
+	h = p.AddSignalHandler(bad, func(*Message) {})
+	p.RemoveSignalHandler(h)
+	if callCount() != 4 {
+		t.Error("#7 Failed", calls.Data())
+	}
+}
+
+func TestMatchArg0(t *testing.T) {
+	mr := MatchRule{Type: "signal", Member: "NameOwnerChanged", Arg0: "org.example.Foo"}
+	if mr.String() != "type='signal',member='NameOwnerChanged',arg0='org.example.Foo'" {
+		t.Error("#1 Failed", mr.String())
+	}
+	msg := NewMessage()
+	msg.Type = SIGNAL
+	msg.Member = "NameOwnerChanged"
+	if mr.match(msg) {
+		t.Error("#2 Failed")
+	}
+	msg.Params.Push("org.example.Foo")
+	if !mr.match(msg) {
+		t.Error("#3 Failed")
+	}
+	msg.Params.Set(0, "org.example.Bar")
+	if mr.match(msg) {
+		t.Error("#4 Failed")
+	}
+}
+
+func TestMatchSender(t *testing.T) {
+	mr := &MatchRule{Type: "signal", Sender: ":1.42", Member: "Foo"}
+	if mr.String() != "type='signal',sender=':1.42',member='Foo'" {
+		t.Error("#1 Failed", mr.String())
+	}
+	msg := NewMessage()
+	msg.Type = SIGNAL
+	msg.Member = "Foo"
+	msg.Sender = ":1.99"
+	if mr.match(msg) {
+		t.Error("#2 Failed")
+	}
+	msg.Sender = ":1.42"
+	if !mr.match(msg) {
+		t.Error("#3 Failed")
+	}
 }
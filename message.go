@@ -2,10 +2,14 @@ package dbus
 
 import (
 	"container/vector"
+	"encoding/binary"
 	"os"
 	"bytes"
 	"sync"
-	//"fmt";
+	"syscall"
+	"fmt"
+	"math"
+	"strings"
 )
 
 type MessageType int
@@ -25,6 +29,15 @@ const (
 	NO_AUTO_START     = 0x2
 )
 
+// values of Message.Priority
+const (
+	PRIORITY_NORMAL = 0
+	PRIORITY_HIGH   = 1
+)
+
+// Message is a D-Bus message. Path, Iface, Member, ErrorName, Dest, Sender
+// and Sig are the header fields of the same name (empty when absent);
+// Params holds the body values, which must match Sig.
 type Message struct {
 	Type        MessageType
 	Flags       MessageFlag
@@ -36,27 +49,74 @@ type Message struct {
 	Member      string
 	Sig         string
 	Params      *vector.Vector
-	serial      int
+	serial      uint32
 	replySerial uint32
 	ErrorName   string
-	//	Sender;
+	unixFds     uint32
+	Sender      string
+	done        <-chan bool // of an incoming call; see Done
+	Priority    uint8       // client-side only; see SendWithPriority
+	answer      func(call *Message, reply *Message) os.Error // of an incoming call
+	answered    bool        // under answerMutex
+}
+
+// answerMutex guards Message.answered, which a handler may set from
+// another goroutine through SendError.
+var answerMutex sync.Mutex
+
+// claimAnswer marks the incoming call p as answered and reports whether
+// it was not already.
+func (p *Message) claimAnswer() bool {
+	answerMutex.Lock()
+	defer answerMutex.Unlock()
+	if p.answered {
+		return false
+	}
+	p.answered = true
+	return true
 }
 
 var serialMutex sync.Mutex
-var messageSerial = int(0)
+var messageSerial = uint32(0)
 
-func _GetNewSerial() int {
+// getNewSerial returns a serial number for a message not yet sent; the
+// connection sending it numbers it again with nextSerial. Serials are
+// uint32 and 0 is not a valid serial, so the counter wraps from
+// math.MaxUint32 to 1.
+func getNewSerial() uint32 {
 	serialMutex.Lock()
-	messageSerial++
+	if messageSerial == math.MaxUint32 {
+		messageSerial = 1
+	} else {
+		messageSerial++
+	}
 	serial := messageSerial
 	serialMutex.Unlock()
 	return serial
 }
 
+// nextSerial returns the serial of the next message the connection sends,
+// wrapping from math.MaxUint32 to 1 like getNewSerial.
+func (p *Connection) nextSerial() uint32 {
+	p.serialMutex.Lock()
+	defer p.serialMutex.Unlock()
+	if p.serial == math.MaxUint32 {
+		p.resetSerial()
+	} else {
+		p.serial++
+	}
+	return p.serial
+}
+
+// resetSerial restarts the serials of the connection at 1. serialMutex
+// must be held.
+func (p *Connection) resetSerial() { p.serial = 1 }
+
+// NewMessage returns an empty message with a fresh serial number.
 func NewMessage() *Message {
 	msg := new(Message)
 
-	msg.serial = _GetNewSerial()
+	msg.serial = getNewSerial()
 	msg.replySerial = 0
 	msg.Flags = 0
 	msg.Protocol = 1
@@ -66,8 +126,48 @@ func NewMessage() *Message {
 	return msg
 }
 
-func (p *Message) _BufferToMessage(buff []byte) (int, os.Error) {
-	vec, bufIdx, e := Parse(buff, "yyyyuua(yv)", 0)
+// Serial returns the serial number of the message.
+func (p *Message) Serial() uint32 { return p.serial }
+
+// ReplySerial returns the serial of the message p replies to, or 0.
+func (p *Message) ReplySerial() uint32 { return p.replySerial }
+
+// Done returns, for a method call being handled, a channel closed when the
+// connection no longer waits for the reply: it was closed, or CloseWait
+// gave up waiting. Long handlers may select on it to stop early. For other
+// messages it is nil, which never fires.
+func (p *Message) Done() <-chan bool { return p.done }
+
+// UnixFds returns the value of the UNIX_FDS header field, the number of
+// file descriptors that accompany the message.
+func (p *Message) UnixFds() uint32 { return p.unixFds }
+
+// checkUnixFds verifies that the descriptors received with msg match its
+// UNIX_FDS header. On a mismatch the message must be rejected; all fds are
+// closed since nothing will own them. On success the fds for msg are
+// returned and any surplus descriptors are closed.
+func checkUnixFds(msg *Message, fds []int) ([]int, os.Error) {
+	want := int(msg.unixFds)
+	if len(fds) < want {
+		closeFds(fds)
+		return nil, os.NewError(fmt.Sprintf("UNIX_FDS is %d but %d fds were received", want, len(fds)))
+	}
+	closeFds(fds[want:])
+	return fds[0:want], nil
+}
+
+func closeFds(fds []int) {
+	for _, fd := range fds {
+		syscall.Close(fd)
+	}
+}
+
+func (p *Message) bufferToMessage(buff []byte) (int, os.Error) {
+	var order binary.ByteOrder = binary.LittleEndian
+	if 0 < len(buff) && buff[0] == 'B' {
+		order = binary.BigEndian
+	}
+	vec, bufIdx, e := parse(buff, "yyyyuua(yv)", 0, order)
 	if e != nil {
 		return 0, e
 	}
@@ -75,120 +175,123 @@ func (p *Message) _BufferToMessage(buff []byte) (int, os.Error) {
 	p.Type = MessageType(vec.At(1).(byte))
 	p.Flags = MessageFlag(vec.At(2).(byte))
 	p.Protocol = int(vec.At(3).(byte))
-	p.bodyLength = int(vec.At(4).(uint32))
-	p.serial = int(vec.At(5).(uint32))
+	bodyLength := vec.At(4).(uint32)
+	p.serial = vec.At(5).(uint32)
 
 	for v := range vec.At(6).(*vector.Vector).Iter() {
-		t := int(v.(*vector.Vector).At(0).(byte))
-		val := v.(*vector.Vector).At(1)
-
-		switch t {
-		case 1:
-			p.Path = val.(string)
-		case 2:
-			p.Iface = val.(string)
-		case 3:
-			p.Member = val.(string)
-		case 4:
-			p.ErrorName = val.(string)
-		case 5:
-			p.replySerial = val.(uint32)
-		case 6:
-			p.Dest = val.(string)
-		case 7:
-			// FIXME
-		case 8:
-			p.Sig = val.(string)
+		code := v.(*vector.Vector).At(0).(byte)
+		val := unwrapVariant(v.(*vector.Vector).At(1))
+		f := headerFieldByCode(code)
+		if f == nil {
+			continue // unknown fields must be ignored
+		}
+		if !f.set(p, val) {
+			return 0, os.NewError(fmt.Sprintf("bad value for header field %s", f.name))
 		}
 	}
-	idx := _Align(8, bufIdx)
+	idx := align(8, bufIdx)
+	if len(buff) < idx || uint32(len(buff)-idx) < bodyLength {
+		return 0, os.NewError("incomplete message")
+	}
+	p.bodyLength = int(bodyLength)
 	if 0 < p.bodyLength {
-		vec, idx, _ = Parse(buff, p.Sig, idx)
+		vec, _, e = parse(buff[0:idx+p.bodyLength], p.Sig, idx, order)
+		if e != nil {
+			return 0, e
+		}
 		p.Params.AppendVector(vec)
 	}
-	return idx, nil
+	return idx + p.bodyLength, nil
 }
 
-func _Unmarshal(buff []byte) (*Message, int, os.Error) {
+func unmarshal(buff []byte) (*Message, int, os.Error) {
 	msg := NewMessage()
-	idx, e := msg._BufferToMessage(buff)
+	idx, e := msg.bufferToMessage(buff)
 	if e != nil {
 		return nil, 0, e
 	}
 	return msg, idx, nil
 }
 
-func (p *Message) _Marshal() ([]byte, os.Error) {
-	buff := bytes.NewBuffer([]byte{})
-	_AppendByte(buff, byte('l')) // little Endian
-	_AppendByte(buff, byte(p.Type))
-	_AppendByte(buff, byte(p.Flags))
-	_AppendByte(buff, byte(p.Protocol))
+// IsValid reports why msg cannot be sent, or returns nil if it can: the
+// type must be known, the serial set, the header fields required by the
+// type present, and the local namespace unused.
+func (p *Message) IsValid() os.Error {
+	if p.serial == 0 {
+		return os.NewError("invalid message: serial must not be 0")
+	}
+	if isLocal(p) {
+		return os.NewError("invalid message: " + LOCAL_INTERFACE + " is reserved for local events")
+	}
+	if _, ok := typeMap[p.Type]; !ok || p.Type == INVALID {
+		return os.NewError(fmt.Sprintf("invalid message: unknown type %d", p.Type))
+	}
+	missing := new(vector.StringVector)
+	for _, f := range headerFields {
+		if f.requiredBy(p.Type) && f.get(p) == nil {
+			missing.Push(f.name)
+		}
+	}
+	if 0 < missing.Len() {
+		typeName := strings.Join(strings.Split(typeMap[p.Type], "_", 0), " ")
+		return os.NewError(fmt.Sprintf("invalid message: %s needs %s", typeName, joinNames(missing.Data())))
+	}
+	return nil
+}
 
-	tmpBuff := bytes.NewBuffer([]byte{})
-	_AppendParamsData(tmpBuff, p.Sig, p.Params)
-	_AppendUint32(buff, uint32(len(tmpBuff.Bytes())))
-	_AppendUint32(buff, uint32(p.serial))
+// EncodeMessage returns the wire format of msg.
+func EncodeMessage(msg *Message) ([]byte, os.Error) { return msg.marshal() }
 
-	_AppendArray(buff, 1,
-		func(b *bytes.Buffer) {
-			if p.Path != "" {
-				_AppendAlign(8, b)
-				_AppendByte(b, 1) // path
-				_AppendByte(b, 1) // signature size
-				_AppendByte(b, 'o')
-				_AppendByte(b, 0)
-				_AppendString(b, p.Path)
-			}
+// EncodeMessageWith returns the wire format of msg, encoded as opts asks.
+func EncodeMessageWith(msg *Message, opts EncodeOptions) ([]byte, os.Error) {
+	return msg.marshalWith(opts)
+}
 
-			if p.Iface != "" {
-				_AppendAlign(8, b)
-				_AppendByte(b, 2) // interface
-				_AppendByte(b, 1) // signature size
-				_AppendByte(b, 's')
-				_AppendByte(b, 0)
-				_AppendString(b, p.Iface)
-			}
+// DecodeMessage decodes the message at the start of buff. It also returns
+// the number of bytes of buff the message occupied.
+func DecodeMessage(buff []byte) (*Message, int, os.Error) { return unmarshal(buff) }
 
-			if p.Member != "" {
-				_AppendAlign(8, b)
-				_AppendByte(b, 3) // member
-				_AppendByte(b, 1) // signature size
-				_AppendByte(b, 's')
-				_AppendByte(b, 0)
-				_AppendString(b, p.Member)
-			}
+func (p *Message) marshal() ([]byte, os.Error) { return p.marshalWith(EncodeOptions{}) }
 
-			if p.replySerial != 0 {
-				_AppendAlign(8, b)
-				_AppendByte(b, 5) // reply serial
-				_AppendByte(b, 1) // signature size
-				_AppendByte(b, 'u')
-				_AppendByte(b, 0)
-				_AppendUint32(b, uint32(p.replySerial))
-			}
+func (p *Message) marshalWith(opts EncodeOptions) ([]byte, os.Error) {
+	params := p.Params
+	if opts.SortDicts {
+		var e os.Error
+		if params, e = sortDictParams(p.Sig, params); e != nil {
+			return nil, e
+		}
+	}
 
-			if p.Dest != "" {
-				_AppendAlign(8, b)
-				_AppendByte(b, 6) // destination
-				_AppendByte(b, 1) // signature size
-				_AppendByte(b, 's')
-				_AppendByte(b, 0)
-				_AppendString(b, p.Dest)
-			}
+	buff := bytes.NewBuffer([]byte{})
+	appendByte(buff, byte('l')) // little Endian
+	appendByte(buff, byte(p.Type))
+	appendByte(buff, byte(p.Flags))
+	appendByte(buff, byte(p.Protocol))
 
-			if p.Sig != "" {
-				_AppendAlign(8, b)
-				_AppendByte(b, 8) // signature
-				_AppendByte(b, 1) // signature size
-				_AppendByte(b, 'g')
-				_AppendByte(b, 0)
-				_AppendSignature(b, p.Sig)
+	order := binary.LittleEndian
+	body := bytes.NewBuffer([]byte{})
+	if e := appendParamsData(body, p.Sig, params, order); e != nil {
+		return nil, e
+	}
+	appendUint32(buff, uint32(body.Len()), order)
+	appendUint32(buff, p.serial, order)
+
+	appendArray(buff, 8,
+		func(b *bytes.Buffer) {
+			for _, f := range headerFields {
+				if v := f.get(p); v != nil {
+					appendAlign(8, b)
+					appendByte(b, f.code)
+					appendSignature(b, string([]byte{f.sig}))
+					appendValue(b, string([]byte{f.sig}), v, order)
+				}
 			}
-		})
+		}, order)
 
-	_AppendAlign(8, buff)
-	_AppendParamsData(buff, p.Sig, p.Params)
+	// the body starts 8-aligned, so its encoding does not depend on
+	// where it starts
+	appendAlign(8, buff)
+	buff.Write(body.Bytes())
 
 	return buff.Bytes(), nil
 }
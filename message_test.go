@@ -3,14 +3,17 @@ package dbus
 import "testing"
 
 import (
+	"math"
+	"os"
 	"strings"
+	"syscall"
 )
 
 func TestUnmarshal(t *testing.T) {
 
 	teststr := "l\x01\x00\x01\x00\x00\x00\x00\x01\x00\x00\x00m\x00\x00\x00\x01\x01o\x00\x15\x00\x00\x00/org/freedesktop/DBus\x00\x00\x00\x02\x01s\x00\x14\x00\x00\x00org.freedesktop.DBus\x00\x00\x00\x00\x03\x01s\x00\x05\x00\x00\x00Hello\x00\x00\x00\x06\x01s\x00\x14\x00\x00\x00org.freedesktop.DBus\x00\x00\x00\x00"
 
-	msg, _, e := _Unmarshal(strings.Bytes(teststr))
+	msg, _, e := DecodeMessage(strings.Bytes(teststr))
 	if nil != e {
 		t.Error("Unmarshal Failed")
 	}
@@ -31,6 +34,34 @@ func TestUnmarshal(t *testing.T) {
 	}
 }
 
+func TestUnmarshalLengths(t *testing.T) {
+	msg := NewMessage()
+	msg.Type = SIGNAL
+	msg.Path = "/org/example"
+	msg.Iface = "org.example.Foo"
+	msg.Member = "Bar"
+	msg.Sig = "s"
+	msg.serial = 1
+	msg.Params.Push("text")
+	buff, e := EncodeMessage(msg)
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+
+	if _, _, e = DecodeMessage(buff[0 : len(buff)-1]); e == nil {
+		t.Error("#2 Failed")
+	}
+	// a body length the int conversion would make negative on 32 bits
+	huge := make([]byte, len(buff))
+	for i := 0; i < len(buff); i++ {
+		huge[i] = buff[i]
+	}
+	huge[4], huge[5], huge[6], huge[7] = 0xff, 0xff, 0xff, 0xff
+	if _, _, e = DecodeMessage(huge); e == nil {
+		t.Error("#3 Failed")
+	}
+}
+
 func TestMarshal(t *testing.T) {
 	teststr := "l\x01\x00\x01\x00\x00\x00\x00\x01\x00\x00\x00m\x00\x00\x00\x01\x01o\x00\x15\x00\x00\x00/org/freedesktop/DBus\x00\x00\x00\x02\x01s\x00\x14\x00\x00\x00org.freedesktop.DBus\x00\x00\x00\x00\x03\x01s\x00\x05\x00\x00\x00Hello\x00\x00\x00\x06\x01s\x00\x14\x00\x00\x00org.freedesktop.DBus\x00\x00\x00\x00"
 
@@ -43,8 +74,138 @@ func TestMarshal(t *testing.T) {
 	msg.Member = "Hello"
 	msg.serial = 1
 
-	buff, _ := msg._Marshal()
+	buff, _ := EncodeMessage(msg)
 	if teststr != string(buff) {
 		t.Error("#1 Failed\n", buff, "\n", strings.Bytes(teststr))
 	}
 }
+
+func TestCheckUnixFds(t *testing.T) {
+	msg := NewMessage()
+	msg.unixFds = 2
+
+	r, w, _ := os.Pipe()
+	defer r.Close()
+	defer w.Close()
+
+	// too few: rejected and the received fds are closed
+	if _, e := checkUnixFds(msg, []int{dupFd(r)}); e == nil {
+		t.Error("#1 Failed")
+	}
+
+	// too many: the surplus is closed
+	extra := dupFd(r)
+	fds, e := checkUnixFds(msg, []int{dupFd(r), dupFd(w), extra})
+	if e != nil || len(fds) != 2 {
+		t.Error("#2-1 Failed", e)
+	}
+	if _, errno := syscall.Dup(extra); errno == 0 {
+		t.Error("#2-2 Failed: surplus fd left open")
+	}
+	closeFds(fds)
+
+	msg.unixFds = 0
+	if fds, e = checkUnixFds(msg, nil); e != nil || len(fds) != 0 {
+		t.Error("#3 Failed")
+	}
+}
+
+func dupFd(f *os.File) int {
+	fd, _ := syscall.Dup(f.Fd())
+	return fd
+}
+
+func TestIsValid(t *testing.T) {
+	msg := NewMessage()
+	if msg.IsValid() == nil {
+		t.Error("#1 Failed") // INVALID type
+	}
+
+	msg.Type = METHOD_CALL
+	msg.Path = "/org/freedesktop/DBus"
+	if msg.IsValid() == nil {
+		t.Error("#2-1 Failed")
+	}
+	msg.Member = "Hello"
+	if e := msg.IsValid(); e != nil {
+		t.Error("#2-2 Failed", e)
+	}
+
+	msg.Type = SIGNAL
+	if msg.IsValid() == nil {
+		t.Error("#3-1 Failed")
+	}
+	msg.Iface = "org.freedesktop.DBus"
+	if e := msg.IsValid(); e != nil {
+		t.Error("#3-2 Failed", e)
+	}
+
+	msg.Type = METHOD_RETURN
+	if msg.IsValid() == nil {
+		t.Error("#4-1 Failed")
+	}
+	msg.replySerial = 1
+	if e := msg.IsValid(); e != nil {
+		t.Error("#4-2 Failed", e)
+	}
+
+	msg.Type = ERROR
+	if msg.IsValid() == nil {
+		t.Error("#5-1 Failed")
+	}
+	msg.ErrorName = "org.freedesktop.DBus.Error.Failed"
+	if e := msg.IsValid(); e != nil {
+		t.Error("#5-2 Failed", e)
+	}
+
+	msg.serial = 0
+	if msg.IsValid() == nil {
+		t.Error("#6 Failed")
+	}
+	// serials use all 32 bits
+	for _, serial := range []uint32{1 << 31, math.MaxUint32} {
+		msg.serial = serial
+		if e := msg.IsValid(); e != nil {
+			t.Error("#7 Failed", serial, e)
+		}
+	}
+}
+
+func TestHeaderFieldsRoundTrip(t *testing.T) {
+	msg := NewMessage()
+	msg.Type = ERROR
+	msg.Path = "/org/example"
+	msg.Iface = "org.example.Foo"
+	msg.Member = "Bar"
+	msg.ErrorName = "org.example.Error"
+	msg.replySerial = 7
+	msg.Dest = ":1.2"
+	msg.Sender = ":1.3"
+	msg.Sig = "s"
+	msg.unixFds = 0
+	msg.Params.Push("text")
+
+	buff, e := EncodeMessage(msg)
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	got, _, e := DecodeMessage(buff)
+	if e != nil {
+		t.Fatal("#2 Failed", e)
+	}
+	if got.Path != msg.Path || got.Iface != msg.Iface || got.Member != msg.Member ||
+		got.ErrorName != msg.ErrorName || got.replySerial != 7 || got.Dest != msg.Dest ||
+		got.Sender != msg.Sender || got.Sig != "s" || got.Params.At(0).(string) != "text" {
+		t.Error("#3 Failed", got)
+	}
+
+	msg.Type = SIGNAL
+	msg.Iface = ""
+	e = msg.IsValid()
+	if e == nil || !strings.HasSuffix(e.String(), "signal needs INTERFACE") {
+		t.Error("#4 Failed", e)
+	}
+	if joinNames([]string{"A", "B", "C"}) != "A, B and C" {
+		t.Error("#5 Failed")
+	}
+}
@@ -0,0 +1,289 @@
+package dbus
+
+import (
+	"bytes"
+	"container/vector"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// MatchFunc selects the method calls a MockServer expectation answers.
+type MatchFunc func(call *Message) bool
+
+// MatchMethod returns a MatchFunc selecting the calls of member of iface.
+func MatchMethod(iface string, member string) MatchFunc {
+	return func(call *Message) bool { return call.Iface == iface && call.Member == member }
+}
+
+// MockServer plays the bus for a Connection in the same process, for
+// tests. It answers the handshake and the bus methods listed by
+// Capabilities itself, and other method calls as programmed with Expect,
+// which takes precedence. Other bus methods get
+// org.freedesktop.DBus.Error.NotSupported, saying the test bus does not
+// support them, and other unexpected calls get
+// org.freedesktop.DBus.Error.UnknownMethod.
+type MockServer struct {
+	conn       net.Conn
+	writeMutex sync.Mutex
+	mutex      sync.Mutex
+	expects    *vector.Vector // of *mockExpectation, in the order added
+	received   *vector.Vector // of *Message
+	bus        map[string]*exportedMethod
+	matchRules map[string]int // rules added and not removed, with their count
+	matchLimit int
+}
+
+// MockCapabilities describes which behaviours of the real daemon a
+// MockServer implements, so that a test can check for what it relies on.
+type MockCapabilities struct {
+	// Methods are the org.freedesktop.DBus methods answered without an
+	// expectation, in sorted order.
+	Methods []string
+	// MatchRuleLimit is the number of match rules a client may have;
+	// AddMatch beyond it gets org.freedesktop.DBus.Error.LimitsExceeded.
+	// 0 means no limit is enforced.
+	MatchRuleLimit int
+	// Activation is whether calls to names without an owner start a
+	// service. A MockServer never does.
+	Activation bool
+}
+
+type mockExpectation struct {
+	match MatchFunc
+	resp  *Message
+}
+
+// MOCK_UNIQUE_NAME is the unique name a MockServer gives its client.
+const MOCK_UNIQUE_NAME = ":1.1"
+
+// NewMockServer returns a MockServer and a Connection to it, connected
+// through an in-memory pipe and already initialized.
+func NewMockServer() (*MockServer, *Connection, os.Error) {
+	client, server := net.Pipe()
+	p := &MockServer{conn: server, expects: new(vector.Vector), received: new(vector.Vector),
+		matchRules: make(map[string]int)}
+	if err := p.exportBus(); err != nil {
+		client.Close()
+		server.Close()
+		return nil, nil, err
+	}
+	go p.serve()
+	conn := newConnection("mock:", client)
+	if err := conn.Initialize(); err != nil {
+		conn.Close()
+		server.Close()
+		return nil, nil, err
+	}
+	return p, conn, nil
+}
+
+// Expect makes the server answer the calls matching req with resp: a
+// METHOD_RETURN or an ERROR, whose reply serial and destination are filled
+// in. A nil resp is an empty METHOD_RETURN. The first expectation added
+// that matches a call answers it, as many times as calls come.
+func (p *MockServer) Expect(req MatchFunc, resp *Message) {
+	p.mutex.Lock()
+	p.expects.Push(&mockExpectation{req, resp})
+	p.mutex.Unlock()
+}
+
+// Capabilities returns what the server implements of the daemon.
+func (p *MockServer) Capabilities() MockCapabilities {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	methods := new(vector.StringVector)
+	for name, _ := range p.bus {
+		methods.Push(name)
+	}
+	return MockCapabilities{Methods: sortedStrings(methods), MatchRuleLimit: p.matchLimit}
+}
+
+// SetMatchRuleLimit makes the server refuse AddMatch once the client has n
+// match rules, as the daemon does; 0 removes the limit.
+func (p *MockServer) SetMatchRuleLimit(n int) {
+	p.mutex.Lock()
+	p.matchLimit = n
+	p.mutex.Unlock()
+}
+
+// exportBus sets up the bus methods the server answers itself, as
+// exported methods.
+func (p *MockServer) exportBus() os.Error {
+	p.bus = make(map[string]*exportedMethod)
+	for _, spec := range []MethodSpec{
+		MethodSpec{"Hello", "", "s", func() (string, os.Error) { return MOCK_UNIQUE_NAME, nil }},
+		MethodSpec{"AddMatch", "s", "", p.addMatch},
+		MethodSpec{"RemoveMatch", "s", "", p.removeMatch},
+	} {
+		m, err := newExportedMethod(spec)
+		if err != nil {
+			return err
+		}
+		p.bus[spec.Name] = m
+	}
+	return nil
+}
+
+func (p *MockServer) addMatch(rule string) os.Error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	n := 0
+	for _, count := range p.matchRules {
+		n += count
+	}
+	if 0 < p.matchLimit && p.matchLimit <= n {
+		return &Error{"org.freedesktop.DBus.Error.LimitsExceeded", "too many match rules"}
+	}
+	p.matchRules[rule]++
+	return nil
+}
+
+func (p *MockServer) removeMatch(rule string) os.Error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	switch n := p.matchRules[rule]; n {
+	case 0:
+		return &Error{"org.freedesktop.DBus.Error.MatchRuleNotFound", "no match rule " + rule}
+	case 1:
+		p.matchRules[rule] = 0, false
+	default:
+		p.matchRules[rule] = n - 1
+	}
+	return nil
+}
+
+// Received returns the messages the client sent, oldest first.
+func (p *MockServer) Received() []*Message {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	msgs := make([]*Message, p.received.Len())
+	for i := range msgs {
+		msgs[i] = p.received.At(i).(*Message)
+	}
+	return msgs
+}
+
+// Emit sends msg, typically a signal, to the client.
+func (p *MockServer) Emit(msg *Message) os.Error {
+	if msg.Sender == "" {
+		msg.Sender = "org.freedesktop.DBus"
+	}
+	return p.write(msg)
+}
+
+// Close disconnects the client.
+func (p *MockServer) Close() os.Error { return p.conn.Close() }
+
+func (p *MockServer) write(msg *Message) os.Error {
+	buff, err := EncodeMessage(msg)
+	if err != nil {
+		return err
+	}
+	p.writeMutex.Lock()
+	defer p.writeMutex.Unlock()
+	_, err = p.conn.Write(buff)
+	return err
+}
+
+func (p *MockServer) serve() {
+	defer p.conn.Close()
+	buff := bytes.NewBuffer([]byte{})
+	if !p.handshake(buff) {
+		return
+	}
+	chunk := make([]byte, 4096)
+	for {
+		msg, n, err := DecodeMessage(buff.Bytes())
+		if err != nil {
+			m, err := p.conn.Read(chunk)
+			if err != nil {
+				return
+			}
+			buff.Write(chunk[0:m])
+			continue
+		}
+		buff.Next(n)
+		p.mutex.Lock()
+		p.received.Push(msg)
+		p.mutex.Unlock()
+		if msg.Type == METHOD_CALL && msg.Flags&NO_REPLY_EXPECTED == 0 {
+			if p.write(p.reply(msg)) != nil {
+				return
+			}
+		}
+	}
+}
+
+// handshake answers the authentication lines up to BEGIN; what follows
+// stays in buff.
+func (p *MockServer) handshake(buff *bytes.Buffer) bool {
+	chunk := make([]byte, 4096)
+	for {
+		data := buff.Bytes()
+		i := bytes.Index(data, strings.Bytes("\r\n"))
+		if i < 0 {
+			n, err := p.conn.Read(chunk)
+			if err != nil {
+				return false
+			}
+			buff.Write(chunk[0:n])
+			continue
+		}
+		line := strings.TrimLeft(string(data[0:i]), "\x00")
+		buff.Next(i + 2)
+		reply := "ERROR"
+		switch {
+		case line == "BEGIN":
+			return true
+		case strings.HasPrefix(line, "AUTH "):
+			reply = "OK 0123456789abcdef0123456789abcdef"
+		}
+		if _, err := p.conn.Write(strings.Bytes(reply + "\r\n")); err != nil {
+			return false
+		}
+	}
+	return false
+}
+
+// reply returns the answer to call.
+func (p *MockServer) reply(call *Message) *Message {
+	p.mutex.Lock()
+	var resp *Message
+	found := false
+	for e := range p.expects.Iter() {
+		if x := e.(*mockExpectation); x.match(call) {
+			resp, found = x.resp, true
+			break
+		}
+	}
+	bus, isBus := p.bus[call.Member]
+	p.mutex.Unlock()
+
+	var reply *Message
+	switch {
+	case found && resp == nil:
+		reply = newMethodReturn(call)
+	case found:
+		reply = copyMessage(resp)
+		if reply.Type != ERROR {
+			reply.Type = METHOD_RETURN
+		}
+		reply.replySerial = call.serial
+		reply.Dest = call.Sender
+	case call.Iface == "org.freedesktop.DBus" && isBus:
+		reply = bus.call(call)
+	case call.Dest == "org.freedesktop.DBus":
+		reply = newErrorReply(call, "org.freedesktop.DBus.Error.NotSupported",
+			call.Iface+"."+call.Member+" is not supported by this test bus")
+	default:
+		reply = newErrorReply(call, "org.freedesktop.DBus.Error.UnknownMethod",
+			"no expectation for "+call.Iface+"."+call.Member)
+	}
+	reply.serial = getNewSerial()
+	if reply.Sender == "" {
+		reply.Sender = call.Dest
+	}
+	return reply
+}
@@ -0,0 +1,108 @@
+package dbus
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMockServer(t *testing.T) {
+	server, p, e := NewMockServer()
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	defer p.Close()
+	defer server.Close()
+	if p.UniqueName() != MOCK_UNIQUE_NAME || p.GUID() != "0123456789abcdef0123456789abcdef" {
+		t.Error("#2 Failed", p.UniqueName(), p.GUID())
+	}
+
+	resp := NewMessage()
+	resp.Sig = "s"
+	resp.Params.Push("pong")
+	server.Expect(MatchMethod("org.example.Foo", "Ping"), resp)
+	failure := NewMessage()
+	failure.Type = ERROR
+	failure.ErrorName = "org.example.Error.Busy"
+	failure.Sig = "s"
+	failure.Params.Push("busy")
+	server.Expect(MatchMethod("org.example.Foo", "Work"), failure)
+
+	values, e := p.CallTimeout(int64(1e9), "org.example", "/org/example", "org.example.Foo", "Ping")
+	if e != nil || len(values) != 1 || values[0].(string) != "pong" {
+		t.Error("#3 Failed", values, e)
+	}
+	_, e = p.CallTimeout(int64(1e9), "org.example", "/org/example", "org.example.Foo", "Work")
+	if err, ok := e.(*Error); !ok || err.Name != "org.example.Error.Busy" {
+		t.Error("#4 Failed", e)
+	}
+	_, e = p.CallTimeout(int64(1e9), "org.example", "/org/example", "org.example.Foo", "Other")
+	if err, ok := e.(*Error); !ok || err.Name != "org.freedesktop.DBus.Error.UnknownMethod" {
+		t.Error("#5 Failed", e)
+	}
+
+	received := server.Received()
+	if len(received) != 4 || received[0].Member != "Hello" || received[1].Member != "Ping" || received[3].Member != "Other" {
+		t.Error("#6 Failed", received)
+	}
+
+	sub := p.Subscribe(&MatchRule{Type: "signal", Interface: "org.example.Foo"}, 1)
+	defer sub.Remove(0)
+	signal := NewMessage()
+	signal.Type = SIGNAL
+	signal.Path = "/org/example"
+	signal.Iface = "org.example.Foo"
+	signal.Member = "Changed"
+	if e := server.Emit(signal); e != nil {
+		t.Error("#7 Failed", e)
+	}
+	if msg := <-sub.C; msg.Member != "Changed" {
+		t.Error("#8 Failed", msg)
+	}
+}
+
+func TestMockCapabilities(t *testing.T) {
+	server, p, e := NewMockServer()
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	defer p.Close()
+	defer server.Close()
+	caps := server.Capabilities()
+	if len(caps.Methods) != 3 || caps.Methods[0] != "AddMatch" || caps.Methods[1] != "Hello" ||
+		caps.Methods[2] != "RemoveMatch" || caps.MatchRuleLimit != 0 || caps.Activation {
+		t.Error("#2 Failed", caps)
+	}
+
+	// the limit on match rules is enforced as the daemon does
+	server.SetMatchRuleLimit(1)
+	if server.Capabilities().MatchRuleLimit != 1 {
+		t.Error("#3 Failed")
+	}
+	addMatch := func(member string, rule string) os.Error {
+		_, e := p.CallTimeout(int64(1e9), "org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", member, rule)
+		return e
+	}
+	if e = addMatch("AddMatch", "type='signal',member='A'"); e != nil {
+		t.Error("#4 Failed", e)
+	}
+	if err, ok := addMatch("AddMatch", "type='signal',member='B'").(*Error); !ok || err.Name != "org.freedesktop.DBus.Error.LimitsExceeded" {
+		t.Error("#5 Failed", err)
+	}
+	if err, ok := addMatch("RemoveMatch", "type='signal',member='B'").(*Error); !ok || err.Name != "org.freedesktop.DBus.Error.MatchRuleNotFound" {
+		t.Error("#6 Failed", err)
+	}
+	if e = addMatch("RemoveMatch", "type='signal',member='A'"); e != nil {
+		t.Error("#7 Failed", e)
+	}
+
+	// other bus methods fail clearly rather than hang
+	_, e = p.CallTimeout(int64(1e9), "org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "StartServiceByName", "org.example", uint32(0))
+	if err, ok := e.(*Error); !ok || err.Name != "org.freedesktop.DBus.Error.NotSupported" ||
+		strings.Index(err.Message, "not supported by this test bus") < 0 {
+		t.Error("#8 Failed", e)
+	}
+	if _, e = p.GetAllDaemonMatchRules(); e != ErrDaemonUnsupported {
+		t.Error("#9 Failed", e)
+	}
+}
@@ -0,0 +1,139 @@
+package dbus
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+)
+
+// NameMapper translates D-Bus member, property and argument names to Go
+// identifiers. The zero value uses names as they are, which requires them
+// to start with a capital letter, as D-Bus members usually do.
+type NameMapper struct {
+	// SnakeCase converts names like "power_state", "power-state" or
+	// "org.example.Power" to CamelCase ("PowerState", "OrgExamplePower").
+	SnakeCase bool
+	// Overrides maps D-Bus names to Go names, taking precedence over the
+	// rules above. For struct fields, the tag of a field names the D-Bus
+	// name the field stands for.
+	Overrides map[string]string
+}
+
+// DefaultNameMapper is the mapper used when none is given.
+var DefaultNameMapper = &NameMapper{}
+
+// GoName returns the Go identifier for the D-Bus name name.
+func (p *NameMapper) GoName(name string) (string, os.Error) {
+	if goName, ok := p.Overrides[name]; ok {
+		return goName, nil
+	}
+	goName := name
+	if p.SnakeCase {
+		goName = camelCase(name)
+	}
+	if !isExportedName(goName) {
+		return "", os.NewError(fmt.Sprintf("%q is not an exported Go name; use SnakeCase or an override", name))
+	}
+	return goName, nil
+}
+
+// MapNames returns the Go names of names, keyed by D-Bus name. Two names
+// mapping to the same Go name are an error naming both.
+func (p *NameMapper) MapNames(names []string) (map[string]string, os.Error) {
+	goNames := make(map[string]string)
+	owners := make(map[string]string)
+	for _, name := range names {
+		goName, err := p.GoName(name)
+		if err != nil {
+			return nil, err
+		}
+		if other, ok := owners[goName]; ok && other != name {
+			return nil, os.NewError(fmt.Sprintf("%q and %q both map to %s", other, name, goName))
+		}
+		owners[goName] = name
+		goNames[name] = goName
+	}
+	return goNames, nil
+}
+
+// FieldNames returns the index of the field of t standing for each D-Bus
+// name: the tag of a field if it has one, otherwise the field name, which
+// the D-Bus name must map to. Unexported fields are skipped.
+func (p *NameMapper) FieldNames(t *reflect.StructType) (map[string]int, os.Error) {
+	fields := make(map[string]int)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !isExportedName(f.Name) {
+			continue
+		}
+		name := f.Tag
+		if name == "" {
+			name = p.dbusName(f.Name)
+		}
+		if j, ok := fields[name]; ok {
+			return nil, os.NewError(fmt.Sprintf("fields %s and %s both stand for %q", t.Field(j).Name, f.Name, name))
+		}
+		fields[name] = i
+	}
+	return fields, nil
+}
+
+// dbusName is the inverse of GoName for a field without tag.
+func (p *NameMapper) dbusName(goName string) string {
+	for name, g := range p.Overrides {
+		if g == goName {
+			return name
+		}
+	}
+	if p.SnakeCase {
+		return snakeCase(goName)
+	}
+	return goName
+}
+
+func camelCase(name string) string {
+	buff := bytes.NewBuffer([]byte{})
+	upper := true
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c == '_' || c == '-' || c == '.':
+			upper = true
+			continue
+		case upper && 'a' <= c && c <= 'z':
+			c -= 'a' - 'A'
+		}
+		upper = false
+		buff.WriteByte(c)
+	}
+	return buff.String()
+}
+
+func snakeCase(name string) string {
+	buff := bytes.NewBuffer([]byte{})
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if 'A' <= c && c <= 'Z' {
+			if i > 0 {
+				buff.WriteByte('_')
+			}
+			c += 'a' - 'A'
+		}
+		buff.WriteByte(c)
+	}
+	return buff.String()
+}
+
+func isExportedName(name string) bool {
+	if name == "" || name[0] < 'A' || 'Z' < name[0] {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,54 @@
+package dbus
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNameMapper(t *testing.T) {
+	exact := DefaultNameMapper
+	if name, e := exact.GoName("GetAll"); e != nil || name != "GetAll" {
+		t.Error("#1 Failed", name, e)
+	}
+	if _, e := exact.GoName("power_state"); e == nil {
+		t.Error("#2 Failed")
+	}
+
+	snake := &NameMapper{SnakeCase: true, Overrides: map[string]string{"type": "Kind"}}
+	if name, e := snake.GoName("power-state"); e != nil || name != "PowerState" {
+		t.Error("#3 Failed", name, e)
+	}
+	if name, e := snake.GoName("org.example.Power"); e != nil || name != "OrgExamplePower" {
+		t.Error("#4 Failed", name, e)
+	}
+	if name, e := snake.GoName("type"); e != nil || name != "Kind" {
+		t.Error("#5 Failed", name, e)
+	}
+
+	if _, e := snake.MapNames([]string{"power_state", "PowerState"}); e == nil {
+		t.Error("#6 Failed")
+	}
+	names, e := snake.MapNames([]string{"power_state", "level"})
+	if e != nil || names["level"] != "Level" {
+		t.Error("#7 Failed", names, e)
+	}
+}
+
+type namedFields struct {
+	PowerState uint32
+	Kind       string
+	Label      string "display-name"
+	hidden     int
+}
+
+func TestFieldNames(t *testing.T) {
+	snake := &NameMapper{SnakeCase: true, Overrides: map[string]string{"type": "Kind"}}
+	st := reflect.Typeof(namedFields{}).(*reflect.StructType)
+	fields, e := snake.FieldNames(st)
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	if len(fields) != 3 || fields["power_state"] != 0 || fields["type"] != 1 || fields["display-name"] != 2 {
+		t.Error("#2 Failed", fields)
+	}
+}
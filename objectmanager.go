@@ -0,0 +1,475 @@
+package dbus
+
+import (
+	"container/vector"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// InterfacesAdded builds the body of the
+// org.freedesktop.DBus.ObjectManager.InterfacesAdded signal (signature
+// "oa{sa{sv}}") for one object: its interfaces in the order added, each with
+// its properties and their values.
+type InterfacesAdded struct {
+	Path   string
+	ifaces *vector.Vector // of []interface{}{name, props}
+	props  map[string]*vector.Vector
+}
+
+// NewInterfacesAdded returns an empty payload for the object at path.
+func NewInterfacesAdded(path string) *InterfacesAdded {
+	return &InterfacesAdded{path, new(vector.Vector), make(map[string]*vector.Vector)}
+}
+
+// AddInterface adds iface with no properties. Adding it twice has no
+// effect.
+func (p *InterfacesAdded) AddInterface(iface string) *InterfacesAdded {
+	if _, ok := p.props[iface]; !ok {
+		props := new(vector.Vector)
+		p.props[iface] = props
+		p.ifaces.Push([]interface{}{iface, props})
+	}
+	return p
+}
+
+// AddProperty adds property name of iface, adding iface if needed.
+func (p *InterfacesAdded) AddProperty(iface string, name string, value Variant) *InterfacesAdded {
+	p.AddInterface(iface)
+	p.props[iface].Push([]interface{}{name, value})
+	return p
+}
+
+// Body returns the signal arguments, in the form accepted by
+// Message.Params.
+func (p *InterfacesAdded) Body() *vector.Vector {
+	body := new(vector.Vector)
+	body.Push(p.Path)
+	body.Push(p.ifaces)
+	return body
+}
+
+// Signal returns the InterfacesAdded signal emitted by the object manager
+// at managerPath.
+func (p *InterfacesAdded) Signal(managerPath string) *Message {
+	msg := NewMessage()
+	msg.Type = SIGNAL
+	msg.Path = managerPath
+	msg.Iface = "org.freedesktop.DBus.ObjectManager"
+	msg.Member = "InterfacesAdded"
+	msg.Sig = "oa{sa{sv}}"
+	msg.Params.AppendVector(p.Body())
+	return msg
+}
+
+// InterfacesAddedFor builds the InterfacesAdded payload of the object at
+// path from what is exported there: its interfaces in sorted order, each
+// with the published values of its property struct, if any.
+func (p *Connection) InterfacesAddedFor(path string) *InterfacesAdded {
+	p.exportMutex.Lock()
+	defer p.exportMutex.Unlock()
+	return p.interfacesAdded(path, p.exportedInterfaces(path))
+}
+
+// interfacesAdded is InterfacesAddedFor for the interfaces ifaces of path.
+// exportMutex must be held.
+func (p *Connection) interfacesAdded(path string, ifaces []string) *InterfacesAdded {
+	payload := NewInterfacesAdded(path)
+	for _, iface := range ifaces {
+		payload.AddInterface(iface)
+		ps, ok := p.propertyStructs[path+" "+iface]
+		if !ok {
+			continue
+		}
+		values := ps.getAll()
+		names := new(vector.StringVector)
+		for name, _ := range values {
+			names.Push(name)
+		}
+		for _, name := range sortedStrings(names) {
+			payload.AddProperty(iface, name, values[name])
+		}
+	}
+	return payload
+}
+
+// exportedInterfaces returns the interfaces exported at path, with methods
+// or a property struct, in sorted order. exportMutex must be held.
+func (p *Connection) exportedInterfaces(path string) []string {
+	seen := make(map[string]bool)
+	for key, _ := range p.exports {
+		if strings.HasPrefix(key, path+" ") {
+			seen[key[len(path)+1:]] = true
+		}
+	}
+	for key, _ := range p.propertyStructs {
+		if strings.HasPrefix(key, path+" ") {
+			seen[key[len(path)+1:]] = true
+		}
+	}
+	names := new(vector.StringVector)
+	for iface, _ := range seen {
+		names.Push(iface)
+	}
+	return sortedStrings(names)
+}
+
+// interfacesAt is exportedInterfaces taking exportMutex.
+func (p *Connection) interfacesAt(path string) []string {
+	p.exportMutex.Lock()
+	defer p.exportMutex.Unlock()
+	return p.exportedInterfaces(path)
+}
+
+// ExportObjectManager makes the object at path an
+// org.freedesktop.DBus.ObjectManager for the objects exported below it.
+// GetManagedObjects describes them from what is exported, and exporting or
+// unexporting interfaces and property structs below path emits
+// InterfacesAdded, built as by InterfacesAddedFor, and InterfacesRemoved.
+func (p *Connection) ExportObjectManager(path string) os.Error {
+	err := p.RegisterMethodHandler(path, "org.freedesktop.DBus.ObjectManager", "GetManagedObjects",
+		func(msg *Message) (*Message, os.Error) {
+			reply := NewMessage()
+			reply.Sig = "a{oa{sa{sv}}}"
+			reply.Params.Push(p.managedObjects(path))
+			return reply, nil
+		})
+	if err != nil {
+		return err
+	}
+	p.exportMutex.Lock()
+	if p.objectManagers == nil {
+		p.objectManagers = make(map[string]bool)
+	}
+	p.objectManagers[path] = true
+	p.exportMutex.Unlock()
+	return p.ExportStandardInterfaces(path)
+}
+
+// managedObjects returns the GetManagedObjects reply of the object manager
+// at root, objects in sorted order.
+func (p *Connection) managedObjects(root string) *vector.Vector {
+	p.exportMutex.Lock()
+	defer p.exportMutex.Unlock()
+	prefix := childPath(root, "")
+	seen := make(map[string]bool)
+	for key, _ := range p.exports {
+		seen[key[0:strings.Index(key, " ")]] = true
+	}
+	for key, _ := range p.propertyStructs {
+		seen[key[0:strings.Index(key, " ")]] = true
+	}
+	paths := new(vector.StringVector)
+	for path, _ := range seen {
+		if path != root && strings.HasPrefix(path, prefix) {
+			paths.Push(path)
+		}
+	}
+	objects := new(vector.Vector)
+	for _, path := range sortedStrings(paths) {
+		payload := p.interfacesAdded(path, p.exportedInterfaces(path))
+		objects.Push([]interface{}{path, payload.ifaces})
+	}
+	return objects
+}
+
+// managerOf returns the path of the object manager of the object at path.
+// exportMutex must be held.
+func (p *Connection) managerOf(path string) (string, bool) {
+	for manager, _ := range p.objectManagers {
+		if path != manager && strings.HasPrefix(path, childPath(manager, "")) {
+			return manager, true
+		}
+	}
+	return "", false
+}
+
+// announceInterfaces emits, when an object manager exports path,
+// InterfacesAdded for the interfaces exported at path that are not in
+// before and InterfacesRemoved for those of before no longer exported.
+func (p *Connection) announceInterfaces(path string, before []string) {
+	p.exportMutex.Lock()
+	manager, ok := p.managerOf(path)
+	if !ok {
+		p.exportMutex.Unlock()
+		return
+	}
+	after := p.exportedInterfaces(path)
+	added, removed := missingFrom(after, before), missingFrom(before, after)
+	var payload *InterfacesAdded
+	if len(added) != 0 {
+		payload = p.interfacesAdded(path, added)
+	}
+	p.exportMutex.Unlock()
+
+	if payload != nil {
+		if err := p.send(payload.Signal(manager)); err != nil {
+			p.logf("InterfacesAdded %s: %s", path, err)
+		}
+	}
+	if len(removed) != 0 {
+		msg := NewMessage()
+		msg.Type = SIGNAL
+		msg.Path = manager
+		msg.Iface = "org.freedesktop.DBus.ObjectManager"
+		msg.Member = "InterfacesRemoved"
+		msg.Sig = "oas"
+		names := new(vector.Vector)
+		for _, iface := range removed {
+			names.Push(iface)
+		}
+		msg.Params.Push(path)
+		msg.Params.Push(names)
+		if err := p.send(msg); err != nil {
+			p.logf("InterfacesRemoved %s: %s", path, err)
+		}
+	}
+}
+
+// missingFrom returns the strings of strs that are not in other.
+func missingFrom(strs []string, other []string) []string {
+	missing := new(vector.StringVector)
+	for _, s := range strs {
+		if !contains(other, s) {
+			missing.Push(s)
+		}
+	}
+	return missing.Data()
+}
+
+// OBJECT_MANAGER_QUEUE is the number of signals an ObjectManager queues
+// before it drops them; a dropped signal leaves the snapshot out of date.
+const OBJECT_MANAGER_QUEUE = 1024
+
+// ObjectManager mirrors the objects of a remote
+// org.freedesktop.DBus.ObjectManager: their interfaces and property values,
+// kept up to date from InterfacesAdded, InterfacesRemoved and
+// PropertiesChanged. Hold RLock while reading it for a consistent snapshot.
+// Only signals sent by the owner of dest when the manager was created are
+// applied; if the name changes owner, get a new manager.
+type ObjectManager struct {
+	conn    *Connection
+	dest    string
+	path    string
+	sender  string // the unique name of the owner of dest
+	mutex   sync.RWMutex
+	objects map[string]map[string]map[string]interface{}
+	sub     *Subscription
+	// the handlers are only changed by GetObjectManager, run and Close,
+	// one after the other
+	managerHandlers []*signalHandler
+	propHandlers    map[string]*signalHandler // by object path
+	done            chan bool
+	closeMutex      sync.Mutex
+	closed          bool
+}
+
+// GetObjectManager returns a live view of the objects managed by the object
+// manager at path of dest. Call Close when done with it.
+func (p *Connection) GetObjectManager(dest string, path string) (*ObjectManager, os.Error) {
+	return p.GetObjectManagerCancel(nil, dest, path)
+}
+
+// GetObjectManagerCancel is GetObjectManager giving up with ErrCanceled,
+// and removing the match rules it added, when cancel is closed.
+func (p *Connection) GetObjectManagerCancel(cancel <-chan bool, dest string, path string) (*ObjectManager, os.Error) {
+	sender, err := p.senderOf(cancel, dest)
+	if err != nil {
+		return nil, err
+	}
+	om := &ObjectManager{conn: p, dest: dest, path: path, sender: sender, sub: newSubscription(OBJECT_MANAGER_QUEUE),
+		propHandlers: make(map[string]*signalHandler), done: make(chan bool)}
+	// subscribe before taking the snapshot so no change is missed; replaying
+	// a change the snapshot already has is harmless
+	members := []string{"InterfacesAdded", "InterfacesRemoved"}
+	om.managerHandlers = make([]*signalHandler, len(members))
+	for i, member := range members {
+		mr := &MatchRule{Type: "signal", Sender: sender, Interface: "org.freedesktop.DBus.ObjectManager",
+			Member: member, Path: path}
+		om.managerHandlers[i] = p.addSignalHandler(mr, om.deliver)
+	}
+	managed, err := p.getManagedObjects(cancel, dest, path)
+	if err != nil {
+		close(om.done)
+		om.Close()
+		return nil, err
+	}
+	om.objects = managed
+	for objPath, _ := range managed {
+		om.watchProperties(objPath)
+	}
+	go om.run()
+	return om, nil
+}
+
+func (p *ObjectManager) deliver(msg *Message) { p.sub.deliver(msg) }
+
+// watchProperties follows the property changes of the object at path.
+func (p *ObjectManager) watchProperties(path string) {
+	if _, ok := p.propHandlers[path]; ok {
+		return
+	}
+	mr := &MatchRule{Type: "signal", Sender: p.sender, Interface: PROPERTIES_INTERFACE, Member: "PropertiesChanged",
+		Path: path}
+	p.propHandlers[path] = p.conn.addSignalHandler(mr, p.deliver)
+}
+
+func (p *ObjectManager) unwatchProperties(path string) {
+	if handler, ok := p.propHandlers[path]; ok {
+		p.propHandlers[path] = nil, false
+		p.conn.removeSignalHandler(handler)
+	}
+}
+
+// run applies the queued signals. Match rules are added and removed here
+// rather than in the signal handlers, which run on the message loop.
+func (p *ObjectManager) run() {
+	for msg := range p.sub.C {
+		if err := p.apply(msg); err != nil {
+			p.conn.logf("ObjectManager %s %s: bad %s signal: %s", p.dest, p.path, msg.Member, err)
+		}
+	}
+	close(p.done)
+}
+
+func (p *ObjectManager) apply(msg *Message) os.Error {
+	args := msg.Params.Data()
+	switch msg.Member {
+	case "InterfacesAdded":
+		var path string
+		var ifaces map[string]map[string]interface{}
+		if err := p.conn.UnmarshalReply(args, &path, &ifaces); err != nil {
+			return err
+		}
+		p.mutex.Lock()
+		obj, ok := p.objects[path]
+		if !ok {
+			obj = make(map[string]map[string]interface{})
+			p.objects[path] = obj
+		}
+		for iface, props := range ifaces {
+			obj[iface] = props
+		}
+		p.mutex.Unlock()
+		p.watchProperties(path)
+
+	case "InterfacesRemoved":
+		var path string
+		var ifaces []string
+		if err := p.conn.UnmarshalReply(args, &path, &ifaces); err != nil {
+			return err
+		}
+		p.mutex.Lock()
+		obj, ok := p.objects[path]
+		for _, iface := range ifaces {
+			if ok {
+				obj[iface] = nil, false
+			}
+		}
+		gone := ok && len(obj) == 0
+		if gone {
+			p.objects[path] = nil, false
+		}
+		p.mutex.Unlock()
+		if gone {
+			p.unwatchProperties(path)
+		}
+
+	case "PropertiesChanged":
+		var iface string
+		var changed map[string]interface{}
+		var invalidated []string
+		if err := p.conn.UnmarshalReply(args, &iface, &changed, &invalidated); err != nil {
+			return err
+		}
+		p.mutex.Lock()
+		if props, ok := p.interfaceProps(msg.Path, iface); ok {
+			for name, value := range changed {
+				props[name] = value
+			}
+			// the new value of an invalidated property is unknown
+			for _, name := range invalidated {
+				props[name] = nil, false
+			}
+		}
+		p.mutex.Unlock()
+	}
+	return nil
+}
+
+// RLock locks the snapshot against updates, for reading. Updates wait until
+// RUnlock.
+func (p *ObjectManager) RLock() { p.mutex.RLock() }
+
+// RUnlock undoes RLock.
+func (p *ObjectManager) RUnlock() { p.mutex.RUnlock() }
+
+// Objects returns the managed objects: the property values of each
+// interface of each object path. The caller must hold RLock while using
+// the map and must not change it.
+func (p *ObjectManager) Objects() map[string]map[string]map[string]interface{} {
+	return p.objects
+}
+
+// Paths returns the paths of the managed objects in sorted order.
+func (p *ObjectManager) Paths() []string {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	paths := make([]string, len(p.objects))
+	i := 0
+	for path, _ := range p.objects {
+		paths[i] = path
+		i++
+	}
+	sort.SortStrings(paths)
+	return paths
+}
+
+// Property returns the value of property name of iface of the object at
+// path, and whether it is known.
+func (p *ObjectManager) Property(path string, iface string, name string) (interface{}, bool) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	props, ok := p.interfaceProps(path, iface)
+	if !ok {
+		return nil, false
+	}
+	value, ok := props[name]
+	return value, ok
+}
+
+func (p *ObjectManager) interfaceProps(path string, iface string) (map[string]interface{}, bool) {
+	obj, ok := p.objects[path]
+	if !ok {
+		return nil, false
+	}
+	props, ok := obj[iface]
+	return props, ok
+}
+
+// Close stops following changes and removes the match rules. The snapshot
+// stays readable. It returns the first error removing a rule.
+func (p *ObjectManager) Close() os.Error {
+	p.closeMutex.Lock()
+	defer p.closeMutex.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	p.sub.Remove(0)
+	<-p.done
+	var first os.Error
+	for _, handler := range p.managerHandlers {
+		if err := p.conn.removeSignalHandler(handler); err != nil && first == nil {
+			first = err
+		}
+	}
+	for path, handler := range p.propHandlers {
+		p.propHandlers[path] = nil, false
+		if err := p.conn.removeSignalHandler(handler); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
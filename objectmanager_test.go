@@ -0,0 +1,224 @@
+package dbus
+
+import (
+	"container/vector"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInterfacesAdded(t *testing.T) {
+	payload := NewInterfacesAdded("/org/example/Device0")
+	payload.AddProperty("org.example.Device", "Name", Variant{"s", "dev0"})
+	payload.AddProperty("org.example.Device", "Powered", Variant{"b", true})
+	payload.AddInterface("org.freedesktop.DBus.Properties")
+
+	buff, e := EncodeMessage(payload.Signal("/org/example"))
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	msg, _, e := DecodeMessage(buff)
+	if e != nil {
+		t.Fatal("#2 Failed", e)
+	}
+	if msg.Member != "InterfacesAdded" || msg.Sig != "oa{sa{sv}}" {
+		t.Error("#3 Failed", msg.Member, msg.Sig)
+	}
+
+	if "/org/example/Device0" != msg.Params.At(0).(string) {
+		t.Error("#4 Failed")
+	}
+	ifaces := msg.Params.At(1).(*vector.Vector)
+	if ifaces.Len() != 2 {
+		t.Fatal("#5 Failed", ifaces.Len())
+	}
+	if "org.example.Device" != vecRef(ifaces, 0, 0).(string) {
+		t.Error("#6-1 Failed")
+	}
+	if "Name" != vecRef(ifaces, 0, 1, 0, 0).(string) || "dev0" != vecRef(ifaces, 0, 1, 0, 1).(Variant).Value.(string) {
+		t.Error("#6-2 Failed")
+	}
+	if "Powered" != vecRef(ifaces, 0, 1, 1, 0).(string) || true != vecRef(ifaces, 0, 1, 1, 1).(Variant).Value.(bool) {
+		t.Error("#6-3 Failed")
+	}
+	if "org.freedesktop.DBus.Properties" != vecRef(ifaces, 1, 0).(string) || 0 != vecRef(ifaces, 1, 1).(*vector.Vector).Len() {
+		t.Error("#6-4 Failed")
+	}
+}
+
+func TestGetObjectManager(t *testing.T) {
+	var mutex sync.Mutex
+	matches := 0
+	p := fakeService(t, func(call *Message) *Message {
+		msg := NewMessage()
+		msg.Type = METHOD_RETURN
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch call.Member {
+		case "AddMatch":
+			matches++
+		case "RemoveMatch":
+			matches--
+		case "GetNameOwner":
+			msg.Sig = "s"
+			msg.Params.Push(":1.42")
+		case "GetManagedObjects":
+			props := new(vector.Vector)
+			props.Push([]interface{}{"Name", Variant{"s", "dev0"}})
+			ifaces := new(vector.Vector)
+			ifaces.Push([]interface{}{"org.example.Device", props})
+			objects := new(vector.Vector)
+			objects.Push([]interface{}{"/org/example/dev0", ifaces})
+			msg.Sig = "a{oa{sa{sv}}}"
+			msg.Params.Push(objects)
+		}
+		return msg
+	})
+	defer p.Close()
+	matchCount := func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return matches
+	}
+
+	om, e := p.GetObjectManager("org.example", "/org/example")
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	if v, ok := om.Property("/org/example/dev0", "org.example.Device", "Name"); !ok || v.(string) != "dev0" {
+		t.Error("#2 Failed", v)
+	}
+	if matchCount() != 3 {
+		t.Error("#3 Failed", matchCount())
+	}
+
+	added := func(path string, name string, sender string) *Message {
+		msg := NewInterfacesAdded(path).AddProperty("org.example.Device", "Name", Variant{"s", name}).Signal("/org/example")
+		msg.Sender = sender
+		return msg
+	}
+	changed := func(name string, sender string) *Message {
+		msg := NewMessage()
+		msg.Type = SIGNAL
+		msg.Sender = sender
+		msg.Path = "/org/example/dev0"
+		msg.Iface = PROPERTIES_INTERFACE
+		msg.Member = "PropertiesChanged"
+		msg.Sig = "sa{sv}as"
+		values := new(vector.Vector)
+		values.Push([]interface{}{"Name", Variant{"s", name}})
+		msg.Params.Push("org.example.Device")
+		msg.Params.Push(values)
+		msg.Params.Push(new(vector.Vector))
+		return msg
+	}
+	// another peer cannot add objects
+	p.InjectMessage(added("/org/example/dev2", "spoofed", ":1.99"))
+	p.InjectMessage(added("/org/example/dev1", "dev1", ":1.42"))
+	p.InjectMessage(changed("renamed", ":1.42"))
+	removed := NewMessage()
+	removed.Type = SIGNAL
+	removed.Sender = ":1.42"
+	removed.Path = "/org/example"
+	removed.Iface = "org.freedesktop.DBus.ObjectManager"
+	removed.Member = "InterfacesRemoved"
+	removed.Sig = "oas"
+	names := new(vector.Vector)
+	names.Push("org.example.Device")
+	removed.Params.Push("/org/example/dev1")
+	removed.Params.Push(names)
+
+	for i := 0; len(om.Paths()) != 2 && i < 1000; i++ {
+		time.Sleep(1e6)
+	}
+	om.RLock()
+	objects := om.Objects()
+	if len(objects) != 2 || objects["/org/example/dev1"]["org.example.Device"]["Name"].(string) != "dev1" {
+		t.Error("#4 Failed", objects)
+	}
+	om.RUnlock()
+	for i := 0; matchCount() != 4 && i < 1000; i++ {
+		time.Sleep(1e6)
+	}
+	for i := 0; i < 1000; i++ {
+		if v, _ := om.Property("/org/example/dev0", "org.example.Device", "Name"); v == "renamed" {
+			break
+		}
+		time.Sleep(1e6)
+	}
+	if v, _ := om.Property("/org/example/dev0", "org.example.Device", "Name"); v != "renamed" {
+		t.Error("#5 Failed", v)
+	}
+
+	// nor change their properties
+	p.InjectMessage(changed("spoofed", ":1.99"))
+	p.InjectMessage(removed)
+	for i := 0; len(om.Paths()) != 1 && i < 1000; i++ {
+		time.Sleep(1e6)
+	}
+	if paths := om.Paths(); len(paths) != 1 || paths[0] != "/org/example/dev0" {
+		t.Error("#6 Failed", paths)
+	}
+	if v, _ := om.Property("/org/example/dev0", "org.example.Device", "Name"); v != "renamed" {
+		t.Error("#7 Failed", v)
+	}
+
+	if e = om.Close(); e != nil || matchCount() != 0 {
+		t.Error("#8 Failed", e, matchCount())
+	}
+	if e = om.Close(); e != nil {
+		t.Error("#9 Failed", e)
+	}
+}
+
+func TestExportObjectManager(t *testing.T) {
+	p, server := dialTest(t)
+	// a bus delivering the signals of p back to it
+	go serveMessages(server, newMethodReturn, func(msg *Message) *Message {
+		msg.Sender = ":1.5"
+		return msg
+	})
+	p.ready = true
+	go p.runLoop()
+	defer p.Close()
+	p.uniqName = ":1.5"
+	p.SetLocalCalls(true)
+
+	if e := p.ExportObjectManager("/org/example"); e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	if _, e := p.ExportPropertyStruct("/org/example/bat0", "org.example.Battery", &batteryProps{50, "charging"}); e != nil {
+		t.Fatal("#2 Failed", e)
+	}
+	payload := p.InterfacesAddedFor("/org/example/bat0")
+	ifaces := payload.Body().At(1).(*vector.Vector)
+	if ifaces.Len() != 4 || vecRef(ifaces, 0, 0).(string) != "org.example.Battery" ||
+		vecRef(ifaces, 0, 1, 0, 0).(string) != "Percentage" || vecRef(ifaces, 0, 1, 0, 1).(Variant).Value.(uint32) != 50 {
+		t.Error("#3 Failed", ifaces)
+	}
+
+	om, e := p.GetObjectManager(":1.5", "/org/example")
+	if e != nil {
+		t.Fatal("#4 Failed", e)
+	}
+	defer om.Close()
+	if v, ok := om.Property("/org/example/bat0", "org.example.Battery", "charge-state"); !ok || v.(string) != "charging" {
+		t.Error("#5 Failed", v)
+	}
+
+	// InterfacesAdded and InterfacesRemoved reach the client
+	p.ExportPropertyStruct("/org/example/bat1", "org.example.Battery", &batteryProps{20, "discharging"})
+	for i := 0; len(om.Paths()) != 2 && i < 1000; i++ {
+		time.Sleep(1e6)
+	}
+	if v, ok := om.Property("/org/example/bat1", "org.example.Battery", "Percentage"); !ok || v.(uint32) != 20 {
+		t.Error("#6 Failed", v)
+	}
+	p.UnexportObjectTree("/org/example/bat0")
+	for i := 0; len(om.Paths()) != 1 && i < 1000; i++ {
+		time.Sleep(1e6)
+	}
+	if paths := om.Paths(); len(paths) != 1 || paths[0] != "/org/example/bat1" {
+		t.Error("#7 Failed", paths)
+	}
+}
@@ -0,0 +1,46 @@
+package dbus
+
+import (
+	"os"
+)
+
+func newPeerCall(dest string, member string) *Message {
+	msg := NewMessage()
+	msg.Type = METHOD_CALL
+	msg.Path = "/"
+	msg.Iface = "org.freedesktop.DBus.Peer"
+	msg.Dest = dest
+	msg.Member = member
+	return msg
+}
+
+// Ping calls org.freedesktop.DBus.Peer.Ping on dest.
+func (p *Connection) Ping(dest string) os.Error {
+	_, err := p.PingTimed(nil, dest)
+	return err
+}
+
+// PingTimed pings dest and returns the round-trip time in nanoseconds.
+// ErrCanceled is returned if cancel is closed before the reply arrives.
+func (p *Connection) PingTimed(cancel <-chan bool, dest string) (int64, os.Error) {
+	msg := newPeerCall(dest, "Ping")
+	start := p.now()
+	if _, err := p.call(msg, 0, cancel); err != nil {
+		return 0, err
+	}
+	return p.now() - start, nil
+}
+
+// PingMany pings dest n times in a row and returns every round-trip time
+// in nanoseconds. It stops at the first failure.
+func (p *Connection) PingMany(cancel <-chan bool, dest string, n int) ([]int64, os.Error) {
+	rtts := make([]int64, n)
+	for i := 0; i < n; i++ {
+		rtt, err := p.PingTimed(cancel, dest)
+		if err != nil {
+			return rtts[0:i], err
+		}
+		rtts[i] = rtt
+	}
+	return rtts, nil
+}
@@ -0,0 +1,102 @@
+package dbus
+
+import (
+	"os"
+)
+
+// pendingCall is a method call waiting for its reply. A reply, a timeout, a
+// cancellation and a connection failure may all race to complete it; the
+// one that removes it from Connection.methodCallReplies (under replyMutex)
+// wins, so completion happens exactly once and the losers are no-ops.
+type pendingCall struct {
+	serial uint32
+	done   chan bool // closed on completion
+	reply  *Message
+	err    os.Error
+}
+
+func (p *Connection) addPending(serial uint32) *pendingCall {
+	call := &pendingCall{serial: serial, done: make(chan bool)}
+	p.replyMutex.Lock()
+	p.methodCallReplies[serial] = call
+	p.replyMutex.Unlock()
+	return call
+}
+
+// complete completes the pending call for serial with reply or err. It
+// returns false if the call had already been completed (or never existed).
+func (p *Connection) complete(serial uint32, reply *Message, err os.Error) bool {
+	p.replyMutex.Lock()
+	call, ok := p.methodCallReplies[serial]
+	if ok {
+		p.methodCallReplies[serial] = nil, false
+	}
+	p.replyMutex.Unlock()
+	if !ok {
+		return false
+	}
+	call.reply = reply
+	call.err = err
+	close(call.done)
+	return true
+}
+
+// completeAll fails every pending call with err.
+func (p *Connection) completeAll(err os.Error) {
+	p.replyMutex.Lock()
+	serials := make([]uint32, len(p.methodCallReplies))
+	i := 0
+	for serial, _ := range p.methodCallReplies {
+		serials[i] = serial
+		i++
+	}
+	p.replyMutex.Unlock()
+	for _, serial := range serials {
+		p.complete(serial, nil, err)
+	}
+}
+
+// sendAsync writes msg and returns its pending call.
+func (p *Connection) sendAsync(msg *Message) (*pendingCall, os.Error) {
+	if err := p.checkReady(); err != nil {
+		return nil, err
+	}
+	p.assignSerial(msg)
+	if err := msg.IsValid(); err != nil {
+		return nil, err
+	}
+	buff, err := p.encode(msg)
+	if err != nil {
+		return nil, err
+	}
+	call := p.addPending(msg.serial)
+	if p.isLocalCall(msg) {
+		p.dispatchLocal(buff)
+		return call, nil
+	}
+	if err := p.writePriority(buff, priorityOf(msg)); err != nil {
+		p.complete(call.serial, nil, err)
+		return nil, err
+	}
+	return call, nil
+}
+
+// cancelCall completes call with err unless it has been completed already.
+func (p *Connection) cancelCall(call *pendingCall, err os.Error) {
+	p.complete(call.serial, nil, err)
+}
+
+// waitReply waits until call is completed, timeout nanoseconds pass
+// (0 means forever) or cancel is closed. When the reply wins the race
+// against a timeout or cancel, the reply is returned.
+func (p *Connection) waitReply(call *pendingCall, timeout int64, cancel <-chan bool) (*Message, os.Error) {
+	select {
+	case <-call.done:
+	case <-p.after(timeout):
+		p.cancelCall(call, ErrTimeout)
+	case <-cancel:
+		p.cancelCall(call, ErrCanceled)
+	}
+	<-call.done
+	return call.reply, call.err
+}
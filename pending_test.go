@@ -0,0 +1,102 @@
+package dbus
+
+import (
+	"testing"
+)
+
+func TestPendingCallOnce(t *testing.T) {
+	p := new(Connection)
+	p.methodCallReplies = make(map[uint32]*pendingCall)
+
+	const n = 5000
+	calls := make([]*pendingCall, n)
+	results := make(chan bool, 3*n)
+	for i := 0; i < n; i++ {
+		calls[i] = p.addPending(uint32(i + 1))
+	}
+	for i := 0; i < n; i++ {
+		serial := uint32(i + 1)
+		go func(serial uint32) { results <- p.complete(serial, NewMessage(), nil) }(serial)
+		go func(serial uint32) { results <- p.complete(serial, nil, ErrTimeout) }(serial)
+		go func(serial uint32) { results <- p.complete(serial, nil, ErrCanceled) }(serial)
+	}
+
+	completed := 0
+	for i := 0; i < 3*n; i++ {
+		if <-results {
+			completed++
+		}
+	}
+	if completed != n {
+		t.Error("#1 Failed: completed", completed, "times")
+	}
+
+	for i, call := range calls {
+		<-call.done
+		if (call.reply == nil) == (call.err == nil) {
+			t.Error("#2 Failed: serial", i+1)
+		}
+	}
+	if len(p.methodCallReplies) != 0 {
+		t.Error("#3 Failed")
+	}
+}
+
+func TestPendingCallTimeout(t *testing.T) {
+	p := new(Connection)
+	p.methodCallReplies = make(map[uint32]*pendingCall)
+
+	call := p.addPending(1)
+	if _, e := p.waitReply(call, 1000000, nil); e != ErrTimeout {
+		t.Error("#1 Failed")
+	}
+	// a late reply is dropped
+	if p.complete(1, NewMessage(), nil) {
+		t.Error("#2 Failed")
+	}
+
+	call = p.addPending(2)
+	cancel := make(chan bool)
+	close(cancel)
+	if _, e := p.waitReply(call, 0, cancel); e != ErrCanceled {
+		t.Error("#3 Failed")
+	}
+
+	call = p.addPending(3)
+	p.completeAll(ErrClosed)
+	if _, e := p.waitReply(call, 0, nil); e != ErrClosed {
+		t.Error("#4 Failed")
+	}
+}
+
+func TestTestClock(t *testing.T) {
+	p := new(Connection)
+	p.methodCallReplies = make(map[uint32]*pendingCall)
+
+	fire := make(chan bool, 1)
+	var asked int64
+	p.SetTestClock(func() int64 { return 42 }, func(ns int64) <-chan bool {
+		asked = ns
+		return fire
+	})
+	if p.now() != 42 {
+		t.Error("#1 Failed")
+	}
+
+	call := p.addPending(1)
+	fire <- true
+	if _, e := p.waitReply(call, 5000, nil); e != ErrTimeout || asked != 5000 {
+		t.Error("#2 Failed", e, asked)
+	}
+
+	serial := uint32(100)
+	p.SetTestSerials(func() uint32 {
+		serial++
+		return serial
+	})
+	msg := NewMessage()
+	p.assignSerial(msg)
+	if msg.Serial() != 101 {
+		t.Error("#3 Failed", msg.Serial())
+	}
+}
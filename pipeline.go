@@ -0,0 +1,41 @@
+package dbus
+
+import (
+	"os"
+)
+
+// Pipelined runs fns concurrently on p, so that their calls are all in
+// flight at once instead of waiting for each other's replies. The error of
+// fns[i] is returned in errs[i]. If cancel is closed before all fns have
+// returned, the unfinished ones are reported as ErrCanceled; they keep
+// running but their results are discarded.
+func (p *Connection) Pipelined(cancel <-chan bool, fns []func(*Connection) os.Error) []os.Error {
+	type result struct {
+		index int
+		err   os.Error
+	}
+	errs := make([]os.Error, len(fns))
+	done := make([]bool, len(fns))
+	results := make(chan result, len(fns))
+	for i, fn := range fns {
+		go func(i int, fn func(*Connection) os.Error) {
+			results <- result{i, fn(p)}
+		}(i, fn)
+	}
+
+	for n := 0; n < len(fns); n++ {
+		select {
+		case r := <-results:
+			errs[r.index] = r.err
+			done[r.index] = true
+		case <-cancel:
+			for i, _ := range errs {
+				if !done[i] {
+					errs[i] = ErrCanceled
+				}
+			}
+			return errs
+		}
+	}
+	return errs
+}
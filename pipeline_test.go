@@ -0,0 +1,31 @@
+package dbus
+
+import (
+	"os"
+	"testing"
+)
+
+func TestPipelined(t *testing.T) {
+	p := new(Connection)
+	errFoo := os.NewError("foo")
+	release := make(chan bool)
+	fns := []func(*Connection) os.Error{
+		func(*Connection) os.Error { <-release; return nil },
+		func(*Connection) os.Error { release <- true; return errFoo },
+	}
+	errs := p.Pipelined(nil, fns)
+	if len(errs) != 2 || errs[0] != nil || errs[1] != errFoo {
+		t.Error("#1 Failed", errs)
+	}
+
+	cancel := make(chan bool)
+	fns = []func(*Connection) os.Error{
+		func(*Connection) os.Error { return nil },
+		func(*Connection) os.Error { close(cancel); <-release; return nil },
+	}
+	errs = p.Pipelined(cancel, fns)
+	if errs[1] != ErrCanceled {
+		t.Error("#2 Failed", errs)
+	}
+	close(release)
+}
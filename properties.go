@@ -0,0 +1,194 @@
+package dbus
+
+import (
+	"container/vector"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+)
+
+const PROPERTIES_INTERFACE = "org.freedesktop.DBus.Properties"
+
+// ExportedPropertyStruct publishes the fields of a struct as the
+// properties of an interface; see ExportPropertyStruct.
+type ExportedPropertyStruct struct {
+	conn   *Connection
+	path   string
+	iface  string
+	value  *reflect.StructValue
+	fields map[string]int // field index by property name
+	mutex  sync.Mutex
+	values map[string]Variant // what D-Bus callers see
+}
+
+// ExportPropertyStruct publishes the exported fields of the struct s points
+// to as the read-only properties of iface at path, through
+// org.freedesktop.DBus.Properties. Property names follow the FieldNames of
+// the NameMapper of the connection (see SetNameMapper), so a field tag
+// names its property.
+//
+// Callers see the values the fields had at export time; after changing a
+// field, call Notify (or NotifyAll) to publish the new value and emit
+// PropertiesChanged.
+func (p *Connection) ExportPropertyStruct(path string, iface string, s interface{}) (*ExportedPropertyStruct, os.Error) {
+	ptr, ok := reflect.NewValue(s).(*reflect.PtrValue)
+	if !ok || ptr.IsNil() {
+		return nil, os.NewError("ExportPropertyStruct: not a pointer to a struct")
+	}
+	value, ok := ptr.Elem().(*reflect.StructValue)
+	if !ok {
+		return nil, os.NewError("ExportPropertyStruct: not a pointer to a struct")
+	}
+	fields, err := p.getNameMapper().FieldNames(value.Type().(*reflect.StructType))
+	if err != nil {
+		return nil, os.NewError("ExportPropertyStruct: " + err.String())
+	}
+
+	before := p.interfacesAt(path)
+	ps := &ExportedPropertyStruct{conn: p, path: path, iface: iface, value: value, fields: fields,
+		values: make(map[string]Variant)}
+	for name, _ := range fields {
+		if err = ps.update(name); err != nil {
+			return nil, os.NewError("ExportPropertyStruct: " + err.String())
+		}
+	}
+
+	p.exportMutex.Lock()
+	if p.propertyStructs == nil {
+		p.propertyStructs = make(map[string]*ExportedPropertyStruct)
+	}
+	p.propertyStructs[path+" "+iface] = ps
+	p.exportMutex.Unlock()
+
+	if err = p.ExportStandardInterfaces(path); err != nil {
+		return nil, err
+	}
+	p.announceInterfaces(path, before)
+	return ps, nil
+}
+
+// propertiesMethods implements org.freedesktop.DBus.Properties at path over
+// the property structs exported there.
+func (p *Connection) propertiesMethods(path string) []MethodSpec {
+	return []MethodSpec{
+		MethodSpec{"Get", "ss", "v", func(iface string, name string) (Variant, os.Error) {
+			ps, err := p.propertyStruct(path, iface)
+			if err != nil {
+				return Variant{}, err
+			}
+			return ps.get(name)
+		}},
+		MethodSpec{"GetAll", "s", "a{sv}", func(iface string) (map[string]Variant, os.Error) {
+			ps, err := p.propertyStruct(path, iface)
+			if err != nil {
+				return nil, err
+			}
+			return ps.getAll(), nil
+		}},
+		MethodSpec{"Set", "ssv", "", func(iface string, name string, value Variant) os.Error {
+			if _, err := p.propertyStruct(path, iface); err != nil {
+				return err
+			}
+			return &Error{"org.freedesktop.DBus.Error.PropertyReadOnly", name + " is read-only"}
+		}},
+	}
+}
+
+func (p *Connection) propertyStruct(path string, iface string) (*ExportedPropertyStruct, os.Error) {
+	p.exportMutex.Lock()
+	defer p.exportMutex.Unlock()
+	if p.propertyStructs != nil {
+		if ps, ok := p.propertyStructs[path+" "+iface]; ok {
+			return ps, nil
+		}
+	}
+	return nil, &Error{"org.freedesktop.DBus.Error.UnknownInterface", "no properties for " + iface}
+}
+
+// update publishes the current value of the field of property name.
+func (p *ExportedPropertyStruct) update(name string) os.Error {
+	field := p.value.Field(p.fields[name])
+	sig, err := SignatureFromType(field.Type())
+	if err != nil {
+		return err
+	}
+	p.mutex.Lock()
+	p.values[name] = Variant{sig, wireValue(field)}
+	p.mutex.Unlock()
+	return nil
+}
+
+func (p *ExportedPropertyStruct) get(name string) (Variant, os.Error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if v, ok := p.values[name]; ok {
+		return v, nil
+	}
+	return Variant{}, &Error{"org.freedesktop.DBus.Error.UnknownProperty", "no property " + name}
+}
+
+func (p *ExportedPropertyStruct) getAll() map[string]Variant {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	values := make(map[string]Variant)
+	for name, v := range p.values {
+		values[name] = v
+	}
+	return values
+}
+
+// propertyName returns the property of the Go field fieldName.
+func (p *ExportedPropertyStruct) propertyName(fieldName string) (string, os.Error) {
+	st := p.value.Type().(*reflect.StructType)
+	for name, i := range p.fields {
+		if st.Field(i).Name == fieldName {
+			return name, nil
+		}
+	}
+	return "", os.NewError(fmt.Sprintf("no exported field %s", fieldName))
+}
+
+// Notify publishes the current value of the field fieldName and emits
+// PropertiesChanged for it.
+func (p *ExportedPropertyStruct) Notify(fieldName string) os.Error {
+	name, err := p.propertyName(fieldName)
+	if err != nil {
+		return err
+	}
+	return p.notify([]string{name})
+}
+
+// NotifyAll publishes the current values of all fields and emits a single
+// PropertiesChanged for them.
+func (p *ExportedPropertyStruct) NotifyAll() os.Error {
+	names := make([]string, len(p.fields))
+	i := 0
+	for name, _ := range p.fields {
+		names[i] = name
+		i++
+	}
+	return p.notify(names)
+}
+
+func (p *ExportedPropertyStruct) notify(names []string) os.Error {
+	changed := new(vector.Vector)
+	for _, name := range names {
+		if err := p.update(name); err != nil {
+			return err
+		}
+		v, _ := p.get(name)
+		changed.Push([]interface{}{name, v})
+	}
+
+	msg := NewMessage()
+	msg.Type = SIGNAL
+	msg.Path = p.path
+	msg.Iface = PROPERTIES_INTERFACE
+	msg.Member = "PropertiesChanged"
+	msg.Sig = "sa{sv}as"
+	msg.Params.Push(p.iface)
+	msg.Params.Push(changed)
+	msg.Params.Push(new(vector.Vector))
+	return p.conn.send(msg)
+}
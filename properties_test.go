@@ -0,0 +1,88 @@
+package dbus
+
+import (
+	"container/vector"
+	"testing"
+)
+
+type batteryProps struct {
+	Percentage uint32
+	State      string "charge-state"
+}
+
+func TestExportPropertyStruct(t *testing.T) {
+	p := new(Connection)
+	props := &batteryProps{50, "charging"}
+	ps, e := p.ExportPropertyStruct("/battery", "org.example.Battery", props)
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+
+	get := func(name string) interface{} {
+		msg := NewMessage()
+		msg.Type = METHOD_CALL
+		msg.Path = "/battery"
+		msg.Iface = PROPERTIES_INTERFACE
+		msg.Member = "Get"
+		msg.Sig = "ss"
+		msg.Params.Push("org.example.Battery")
+		msg.Params.Push(name)
+		reply := p.handleCall(msg)
+		if reply.Type != METHOD_RETURN {
+			return reply.ErrorName
+		}
+		return reply.Params.At(0).(Variant).Value
+	}
+
+	if v := get("Percentage"); v != uint32(50) {
+		t.Error("#2 Failed", v)
+	}
+	if v := get("charge-state"); v != "charging" {
+		t.Error("#3 Failed", v)
+	}
+	if v := get("Missing"); v != "org.freedesktop.DBus.Error.UnknownProperty" {
+		t.Error("#4 Failed", v)
+	}
+
+	// changes are published by Notify only; sending fails without a bus
+	props.Percentage = 60
+	if v := get("Percentage"); v != uint32(50) {
+		t.Error("#5 Failed", v)
+	}
+	if e = ps.Notify("Percentage"); e != ErrNotInitialized {
+		t.Error("#6 Failed", e)
+	}
+	if v := get("Percentage"); v != uint32(60) {
+		t.Error("#7 Failed", v)
+	}
+	if e = ps.Notify("Missing"); e == nil || e == ErrNotInitialized {
+		t.Error("#8 Failed", e)
+	}
+}
+
+func TestExportPropertyStructNameMapper(t *testing.T) {
+	p := new(Connection)
+	p.SetNameMapper(&NameMapper{SnakeCase: true})
+	if _, e := p.ExportPropertyStruct("/battery", "org.example.Battery", &batteryProps{50, "charging"}); e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	msg := NewMessage()
+	msg.Type = METHOD_CALL
+	msg.Path = "/battery"
+	msg.Iface = PROPERTIES_INTERFACE
+	msg.Member = "GetAll"
+	msg.Sig = "s"
+	msg.Params.Push("org.example.Battery")
+	reply := p.handleCall(msg)
+	if reply.Type != METHOD_RETURN {
+		t.Fatal("#2 Failed", reply)
+	}
+	names := make(map[string]bool)
+	for _, entry := range reply.Params.At(0).(*vector.Vector).Data() {
+		names[entry.([]interface{})[0].(string)] = true
+	}
+	// tags still name their property
+	if len(names) != 2 || !names["percentage"] || !names["charge-state"] {
+		t.Error("#3 Failed", names)
+	}
+}
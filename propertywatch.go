@@ -0,0 +1,208 @@
+package dbus
+
+import (
+	"os"
+	"reflect"
+	"sync"
+)
+
+// PROPERTY_WATCH_QUEUE is the number of PropertiesChanged signals a
+// PropertyWatch queues while its channel is not read.
+const PROPERTY_WATCH_QUEUE = 64
+
+// GetProperty returns the value of property name of iface of obj, read
+// through org.freedesktop.DBus.Properties.Get.
+func (p *Connection) GetProperty(obj *Object, iface string, name string) (interface{}, os.Error) {
+	return p.getProperty(nil, obj, iface, name)
+}
+
+// getProperty is GetProperty giving up with ErrCanceled when cancel is
+// closed.
+func (p *Connection) getProperty(cancel <-chan bool, obj *Object, iface string, name string) (interface{}, os.Error) {
+	msg, err := NewMethodCall(obj.dest, obj.path, PROPERTIES_INTERFACE, "Get").WithArg(iface).WithArg(name).Build()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := p.call(msg, 0, cancel)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Params.Len() != 1 {
+		return nil, os.NewError("GetProperty: reply does not hold a single value")
+	}
+	return unwrapVariant(reply.Params.At(0)), nil
+}
+
+// GetPropertyWithDefault is GetProperty for optional properties: it returns
+// defaultVal instead of an error when obj has no property name, or when its
+// interfaces cannot be known. Other errors are returned.
+func (p *Connection) GetPropertyWithDefault(obj *Object, iface string, name string, defaultVal interface{}) (interface{}, os.Error) {
+	value, err := p.GetProperty(obj, iface, name)
+	if err != nil && isMissingProperty(err) {
+		return defaultVal, nil
+	}
+	return value, err
+}
+
+func isMissingProperty(err os.Error) bool {
+	switch e := err.(type) {
+	case *Error:
+		return e.Name == "org.freedesktop.DBus.Error.UnknownProperty"
+	case *InterfaceError:
+		return e.Reason == ErrNoIntrospection
+	}
+	return err == ErrNoIntrospection
+}
+
+// PropertyEvent is a value of a watched property, or the error that ended
+// the watch.
+type PropertyEvent struct {
+	Value interface{}
+	Err   os.Error
+}
+
+// PropertyWatch delivers the values of a property on the channel C.
+type PropertyWatch struct {
+	C <-chan PropertyEvent
+
+	conn    *Connection
+	obj     *Object
+	iface   string
+	name    string
+	sub     *Subscription
+	mutex   sync.Mutex
+	removed bool
+	stop    chan bool
+}
+
+// WatchProperty watches property name of iface of obj. The first event on
+// C is the value when the watch starts, followed by each new value
+// announced by PropertiesChanged; no change in between is missed. Only the
+// signals of the owner of obj's destination when the watch starts are
+// followed. If the first value cannot be read, C gets an event with Err set
+// and is closed.
+func (p *Connection) WatchProperty(obj *Object, iface string, name string) *PropertyWatch {
+	out := make(chan PropertyEvent)
+	w := &PropertyWatch{C: out, conn: p, obj: obj, iface: iface, name: name, stop: make(chan bool)}
+	sender, err := p.senderOf(nil, obj.dest)
+	if err != nil {
+		w.sub = newSubscription(0)
+		go w.run(out, PropertyEvent{nil, err})
+		return w
+	}
+	// subscribe before reading the value so the changes after it are queued
+	w.sub = p.Subscribe(&MatchRule{Type: "signal", Sender: sender, Interface: PROPERTIES_INTERFACE,
+		Member: "PropertiesChanged", Path: obj.path}, PROPERTY_WATCH_QUEUE)
+	value, err := p.GetProperty(obj, iface, name)
+	go w.run(out, PropertyEvent{value, err})
+	return w
+}
+
+func (p *PropertyWatch) run(out chan PropertyEvent, first PropertyEvent) {
+	defer close(out)
+	if !p.send(out, first) || first.Err != nil {
+		p.Remove()
+		return
+	}
+	for msg := range p.sub.C {
+		ev, ok := p.change(msg)
+		if ok && !p.send(out, ev) {
+			return
+		}
+	}
+}
+
+// change returns the new value of the property announced by msg, if any.
+func (p *PropertyWatch) change(msg *Message) (PropertyEvent, bool) {
+	var iface string
+	var changed map[string]interface{}
+	var invalidated []string
+	if err := p.conn.UnmarshalMessage(msg, &iface, &changed, &invalidated); err != nil || iface != p.iface {
+		return PropertyEvent{}, false
+	}
+	if value, ok := changed[p.name]; ok {
+		return PropertyEvent{value, nil}, true
+	}
+	if contains(invalidated, p.name) {
+		// the new value was not sent along
+		value, err := p.conn.GetProperty(p.obj, p.iface, p.name)
+		if err != nil {
+			p.conn.logf("WatchProperty %s.%s: %s", p.iface, p.name, err)
+			return PropertyEvent{}, false
+		}
+		return PropertyEvent{value, nil}, true
+	}
+	return PropertyEvent{}, false
+}
+
+func (p *PropertyWatch) send(out chan PropertyEvent, ev PropertyEvent) bool {
+	select {
+	case out <- ev:
+		return true
+	case <-p.stop:
+	}
+	return false
+}
+
+// Remove stops the watch and closes C.
+func (p *PropertyWatch) Remove() {
+	p.mutex.Lock()
+	if p.removed {
+		p.mutex.Unlock()
+		return
+	}
+	p.removed = true
+	p.mutex.Unlock()
+	close(p.stop)
+	p.sub.Remove(0)
+}
+
+// PollProperty is WatchProperty for services that do not emit
+// PropertiesChanged: it reads property name of iface of obj every interval
+// nanoseconds and sends an event when the value differs from the previous
+// one, comparing them deeply. The first event is the current value; an
+// error reading it is returned instead. A later error is sent as an event
+// with Err set, ending the poll. The channel is closed when the poll ends
+// or cancel is closed, including while a read is pending.
+func (p *Connection) PollProperty(cancel <-chan bool, obj *Object, iface string, name string, interval int64) (<-chan PropertyEvent, os.Error) {
+	value, err := p.GetProperty(obj, iface, name)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan PropertyEvent)
+	go func() {
+		defer close(out)
+		ev := PropertyEvent{value, nil}
+		for {
+			select {
+			case out <- ev:
+			case <-cancel:
+				return
+			}
+			if ev.Err != nil {
+				return
+			}
+			for {
+				select {
+				case <-p.after(interval):
+				case <-cancel:
+					return
+				}
+				next, err := p.getProperty(cancel, obj, iface, name)
+				if err == ErrCanceled {
+					return
+				}
+				if err != nil {
+					ev = PropertyEvent{nil, err}
+					break
+				}
+				if !reflect.DeepEqual(next, value) {
+					value = next
+					ev = PropertyEvent{value, nil}
+					break
+				}
+			}
+		}
+	}()
+	return out, nil
+}
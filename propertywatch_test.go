@@ -0,0 +1,196 @@
+package dbus
+
+import (
+	"container/vector"
+	"sync"
+	"testing"
+)
+
+func TestWatchProperty(t *testing.T) {
+	var mutex sync.Mutex
+	value := uint32(50)
+	p := fakeService(t, func(call *Message) *Message {
+		msg := NewMessage()
+		msg.Type = METHOD_RETURN
+		if call.Member == "GetNameOwner" {
+			msg.Sig = "s"
+			msg.Params.Push(":1.42")
+		}
+		if call.Member == "Get" {
+			if call.Params.At(1).(string) != "Percentage" {
+				return newErrorReply(call, "org.freedesktop.DBus.Error.UnknownProperty", "")
+			}
+			mutex.Lock()
+			msg.Sig = "v"
+			msg.Params.Push(Variant{"u", value})
+			mutex.Unlock()
+		}
+		return msg
+	})
+	defer p.Close()
+	obj := &Object{dest: "org.example", path: "/battery"}
+	changed := func(props map[string]uint32, invalidated string) *Message {
+		msg := NewMessage()
+		msg.Type = SIGNAL
+		msg.Sender = ":1.42"
+		msg.Path = "/battery"
+		msg.Iface = PROPERTIES_INTERFACE
+		msg.Member = "PropertiesChanged"
+		msg.Sig = "sa{sv}as"
+		msg.Params.Push("org.example.Battery")
+		values := new(vector.Vector)
+		for k, v := range props {
+			values.Push([]interface{}{k, Variant{"u", v}})
+		}
+		msg.Params.Push(values)
+		names := new(vector.Vector)
+		if invalidated != "" {
+			names.Push(invalidated)
+		}
+		msg.Params.Push(names)
+		return msg
+	}
+
+	w := p.WatchProperty(obj, "org.example.Battery", "Percentage")
+	// changes follow the first value; another peer's signal is ignored
+	spoofed := changed(map[string]uint32{"Percentage": 99}, "")
+	spoofed.Sender = ":1.99"
+	p.InjectMessage(spoofed)
+	p.InjectMessage(changed(map[string]uint32{"Percentage": 60}, ""))
+	if ev := <-w.C; ev.Err != nil || ev.Value != uint32(50) {
+		t.Error("#1 Failed", ev)
+	}
+	if ev := <-w.C; ev.Err != nil || ev.Value != uint32(60) {
+		t.Error("#2 Failed", ev)
+	}
+	p.InjectMessage(changed(map[string]uint32{"Other": 1}, ""))
+	mutex.Lock()
+	value = 70
+	mutex.Unlock()
+	p.InjectMessage(changed(nil, "Percentage"))
+	if ev := <-w.C; ev.Err != nil || ev.Value != uint32(70) {
+		t.Error("#3 Failed", ev)
+	}
+	w.Remove()
+	for _ = range w.C {
+	}
+
+	w = p.WatchProperty(obj, "org.example.Battery", "Missing")
+	ev := <-w.C
+	if err, ok := ev.Err.(*Error); !ok || err.Name != "org.freedesktop.DBus.Error.UnknownProperty" {
+		t.Error("#4 Failed", ev)
+	}
+	// C is closed and the match rule removed
+	for _ = range w.C {
+		t.Error("#5 Failed")
+	}
+	if handlerCount(p) != 0 {
+		t.Error("#6 Failed", handlerCount(p))
+	}
+}
+
+func TestGetPropertyWithDefault(t *testing.T) {
+	server, p, e := NewMockServer()
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	defer p.Close()
+	server.Expect(func(call *Message) bool {
+		return call.Member == "Get" && call.Params.At(1).(string) == "Missing"
+	}, newErrorReply(NewMessage(), "org.freedesktop.DBus.Error.UnknownProperty", "no property Missing"))
+	server.Expect(func(call *Message) bool {
+		return call.Member == "Get" && call.Params.At(1).(string) == "Broken"
+	}, newErrorReply(NewMessage(), "org.freedesktop.DBus.Error.AccessDenied", "denied"))
+	reply := NewMessage()
+	reply.Sig = "v"
+	reply.Params.Push(Variant{"u", uint32(7)})
+	server.Expect(MatchMethod(PROPERTIES_INTERFACE, "Get"), reply)
+	obj := &Object{dest: "org.example", path: "/org/example"}
+
+	if v, e := p.GetPropertyWithDefault(obj, "org.example.Foo", "Missing", "none"); e != nil || v.(string) != "none" {
+		t.Error("#2 Failed", v, e)
+	}
+	if v, e := p.GetPropertyWithDefault(obj, "org.example.Foo", "Broken", "none"); e == nil {
+		t.Error("#3 Failed", v)
+	}
+	if v, e := p.GetPropertyWithDefault(obj, "org.example.Foo", "Count", uint32(0)); e != nil || v == "none" {
+		t.Error("#4 Failed", v, e)
+	}
+}
+
+func TestPollProperty(t *testing.T) {
+	server, p, e := NewMockServer()
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	defer p.Close()
+	value := func(v uint32) *Message {
+		reply := NewMessage()
+		reply.Sig = "v"
+		reply.Params.Push(Variant{"u", v})
+		return reply
+	}
+	// the value changes at the third read
+	gets := 0
+	server.Expect(func(call *Message) bool {
+		if call.Member != "Get" {
+			return false
+		}
+		gets++
+		return gets <= 2
+	}, value(1))
+	server.Expect(MatchMethod(PROPERTIES_INTERFACE, "Get"), value(2))
+
+	cancel := make(chan bool)
+	obj := &Object{dest: "org.example", path: "/battery"}
+	events, e := p.PollProperty(cancel, obj, "org.example.Battery", "Level", 1e6)
+	if e != nil {
+		t.Fatal("#2 Failed", e)
+	}
+	if ev := <-events; ev.Err != nil || ev.Value.(uint32) != 1 {
+		t.Error("#3 Failed", ev)
+	}
+	if ev := <-events; ev.Err != nil || ev.Value.(uint32) != 2 {
+		t.Error("#4 Failed", ev)
+	}
+	close(cancel)
+	for ev := range events {
+		t.Error("#5 Failed", ev)
+	}
+}
+
+func TestPollPropertyCancelPending(t *testing.T) {
+	pending := make(chan bool, 1)
+	release := make(chan bool)
+	defer close(release)
+	gets := 0
+	p := fakeService(t, func(call *Message) *Message {
+		gets++
+		if gets > 1 {
+			// the second read never gets an answer
+			pending <- true
+			<-release
+		}
+		reply := NewMessage()
+		reply.Type = METHOD_RETURN
+		reply.Sig = "v"
+		reply.Params.Push(Variant{"u", uint32(1)})
+		return reply
+	})
+	defer p.Close()
+
+	cancel := make(chan bool)
+	obj := &Object{dest: "org.example", path: "/battery"}
+	events, e := p.PollProperty(cancel, obj, "org.example.Battery", "Level", 1e6)
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	if ev := <-events; ev.Err != nil || ev.Value.(uint32) != 1 {
+		t.Error("#2 Failed", ev)
+	}
+	<-pending
+	close(cancel)
+	for ev := range events {
+		t.Error("#3 Failed", ev)
+	}
+}
@@ -0,0 +1,93 @@
+package dbus
+
+import (
+	"os"
+)
+
+// TransientActivationErrors are the errors the bus answers with when an
+// activatable service fails to start in time; a later call may succeed.
+var TransientActivationErrors = []string{
+	"org.freedesktop.DBus.Error.Spawn.ChildExited",
+	"org.freedesktop.DBus.Error.Spawn.ChildSignaled",
+	"org.freedesktop.DBus.Error.TimedOut",
+}
+
+// RetryPolicy makes a method call that failed with one of a few error
+// replies be sent again. Only calls that received such an error are
+// retried: a call that timed out or was canceled may have been carried out
+// and is not. Each attempt is a new message, so a late reply to an earlier
+// attempt is dropped.
+type RetryPolicy struct {
+	// Attempts is the number of attempts in all; 0 or 1 means no retries.
+	Attempts int
+	// Backoff is the time in nanoseconds before the first retry; it
+	// doubles before each further one.
+	Backoff int64
+	// Errors are the error names to retry; nil means
+	// TransientActivationErrors.
+	Errors []string
+}
+
+func (p *RetryPolicy) retries(err os.Error) bool {
+	e, ok := err.(*Error)
+	if !ok {
+		return false
+	}
+	names := p.Errors
+	if names == nil {
+		names = TransientActivationErrors
+	}
+	return contains(names, e.Name)
+}
+
+// closedChan is always ready to receive from.
+var closedChan = make(chan bool)
+
+func init() { close(closedChan) }
+
+// SetRetryPolicy sets the retry policy for the method calls of the
+// connection; nil, the default, never retries. MessageBuilder.WithRetry
+// overrides it for one call.
+func (p *Connection) SetRetryPolicy(policy *RetryPolicy) {
+	p.stateMutex.Lock()
+	p.retryPolicy = policy
+	p.stateMutex.Unlock()
+}
+
+// callRetry is call retrying as policy says, or as the connection's policy
+// says if policy is nil. timeout applies to each attempt.
+func (p *Connection) callRetry(msg *Message, timeout int64, cancel <-chan bool, policy *RetryPolicy) (*Message, os.Error) {
+	if policy == nil {
+		p.stateMutex.Lock()
+		policy = p.retryPolicy
+		p.stateMutex.Unlock()
+	}
+	backoff := int64(0)
+	for attempt := 1; ; attempt++ {
+		reply, err := p.callOnce(msg, timeout, cancel)
+		if policy == nil || policy.Attempts <= attempt || !policy.retries(err) {
+			return reply, err
+		}
+		if p.limiter != nil {
+			p.limiter.retried()
+		}
+		p.logf("retrying %s.%s on %s after %s", msg.Iface, msg.Member, msg.Dest, err)
+
+		if backoff == 0 {
+			backoff = policy.Backoff
+		} else {
+			backoff *= 2
+		}
+		wait := p.after(backoff)
+		if wait == nil {
+			wait = closedChan
+		}
+		select {
+		case <-cancel:
+			return nil, ErrCanceled
+		case <-wait:
+		}
+		msg.serial = getNewSerial()
+	}
+	return nil, nil
+}
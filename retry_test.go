@@ -0,0 +1,78 @@
+package dbus
+
+import (
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	var mutex sync.Mutex
+	failures := 0
+	p := fakeService(t, func(call *Message) *Message {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if call.Member == "Other" {
+			return newErrorReply(call, "org.example.Error.Other", "")
+		}
+		if 0 < failures {
+			failures--
+			return newErrorReply(call, "org.freedesktop.DBus.Error.Spawn.ChildExited", "")
+		}
+		msg := newMethodReturn(call)
+		msg.Sig = "u"
+		msg.Params.Push(uint32(42))
+		return msg
+	})
+	defer p.Close()
+	fail := func(n int) {
+		mutex.Lock()
+		failures = n
+		mutex.Unlock()
+	}
+	call := func(member string) (*Message, os.Error) {
+		return NewMethodCall("org.example", "/", "org.example.Foo", member).Call(p)
+	}
+
+	// no retries by default
+	fail(1)
+	if _, e := call("Start"); e == nil {
+		t.Error("#1 Failed")
+	}
+
+	p.SetRetryPolicy(&RetryPolicy{Attempts: 3, Backoff: 1e6})
+	fail(2)
+	reply, e := call("Start")
+	if e != nil || reply.Params.At(0).(uint32) != 42 {
+		t.Error("#2 Failed", e)
+	}
+	if p.Stats().CallsRetried != 2 {
+		t.Error("#3 Failed", p.Stats())
+	}
+
+	fail(3)
+	if _, e = call("Start"); e == nil {
+		t.Error("#4 Failed: more attempts than allowed")
+	}
+	fail(0)
+
+	// other errors are not retried
+	if _, e = call("Other"); e == nil || p.Stats().CallsRetried != 4 {
+		t.Error("#5 Failed", p.Stats())
+	}
+
+	// the policy of the call wins
+	fail(1)
+	_, e = NewMethodCall("org.example", "/", "org.example.Foo", "Start").WithRetry(&RetryPolicy{}).Call(p)
+	if e == nil || p.Stats().CallsRetried != 4 {
+		t.Error("#6 Failed", e)
+	}
+
+	cancel := make(chan bool)
+	close(cancel)
+	fail(1)
+	_, e = NewMethodCall("org.example", "/", "org.example.Foo", "Start").WithCancel(cancel).Call(p)
+	if e != ErrCanceled {
+		t.Error("#7 Failed", e)
+	}
+}
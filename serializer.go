@@ -0,0 +1,151 @@
+package dbus
+
+import (
+	"container/vector"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// Serializer maps values of custom Go types, such as net.IP or time.Time,
+// to a basic D-Bus type. Marshal returns the type code and the content of
+// the value in little-endian order, without alignment or length prefix:
+// the bytes of a string, object path or signature, or the 1 to 8 bytes of
+// a number (4 for a boolean). Unmarshal gets the same form back.
+type Serializer interface {
+	CanHandle(t reflect.Type) bool
+	Marshal(v interface{}) (TypeCode, []byte, os.Error)
+	Unmarshal(code TypeCode, b []byte) (interface{}, os.Error)
+}
+
+// AddSerializer makes the connection use s for the types it handles, before
+// the built-in rules, when encoding the arguments of CallMethod and
+// EmitSignal (whose signatures come from introspection) and when storing
+// values with UnmarshalReply and UnmarshalMessage. Serializers are asked in
+// the order added. When storing, a value decoded from an object path is
+// passed with the code TypeString.
+func (p *Connection) AddSerializer(s Serializer) {
+	p.stateMutex.Lock()
+	defer p.stateMutex.Unlock()
+	serializers := make([]Serializer, len(p.serializers)+1)
+	for i, other := range p.serializers {
+		serializers[i] = other
+	}
+	serializers[len(p.serializers)] = s
+	p.serializers = serializers
+}
+
+func (p *Connection) getSerializers() []Serializer {
+	p.stateMutex.Lock()
+	defer p.stateMutex.Unlock()
+	return p.serializers
+}
+
+func findSerializer(serializers []Serializer, t reflect.Type) Serializer {
+	for _, s := range serializers {
+		if s.CanHandle(t) {
+			return s
+		}
+	}
+	return nil
+}
+
+// serializeParams returns params with the values serializers handle, at
+// any depth, replaced by basic values.
+func serializeParams(serializers []Serializer, params *vector.Vector) (*vector.Vector, os.Error) {
+	out := new(vector.Vector)
+	for v := range params.Iter() {
+		sv, err := serializeValue(serializers, v)
+		if err != nil {
+			return nil, err
+		}
+		out.Push(sv)
+	}
+	return out, nil
+}
+
+func serializeValue(serializers []Serializer, v interface{}) (interface{}, os.Error) {
+	if v == nil {
+		return nil, nil
+	}
+	if s := findSerializer(serializers, reflect.Typeof(v)); s != nil {
+		code, b, err := s.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return basicValue(code, b)
+	}
+	switch x := v.(type) {
+	case *vector.Vector:
+		return serializeParams(serializers, x)
+	case []interface{}:
+		out := make([]interface{}, len(x))
+		for i, elem := range x {
+			sv, err := serializeValue(serializers, elem)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = sv
+		}
+		return out, nil
+	case Variant:
+		sv, err := serializeValue(serializers, x.Value)
+		if err != nil {
+			return nil, err
+		}
+		return Variant{x.Sig, sv}, nil
+	}
+	return v, nil
+}
+
+// basicValue returns the Go value of the content b of a value of the basic
+// type code.
+func basicValue(code TypeCode, b []byte) (interface{}, os.Error) {
+	switch code {
+	case TypeString, TypeObjectPath, TypeSignature:
+		return string(b), nil
+	case TypeByte, TypeBoolean, TypeInt16, TypeUint16, TypeInt32, TypeUint32, TypeUnixFd,
+		TypeInt64, TypeUint64, TypeDouble:
+		// the size of a basic number is its alignment
+		if alignOf(string([]byte{byte(code)})) != len(b) {
+			return nil, os.NewError(fmt.Sprintf("serializer: %d bytes for type %c", len(b), code))
+		}
+		v, _, err := DecodeValue(b, 0, string([]byte{byte(code)}), binary.LittleEndian)
+		return v, err
+	}
+	return nil, os.NewError(fmt.Sprintf("serializer: %c is not a basic type", code))
+}
+
+// basicContent returns the type code and content of the decoded basic
+// value v, as Serializer.Unmarshal expects them.
+func basicContent(v interface{}) (TypeCode, []byte, os.Error) {
+	var code TypeCode
+	switch x := v.(type) {
+	case string:
+		return TypeString, strings.Bytes(x), nil
+	case byte:
+		code = TypeByte
+	case bool:
+		code = TypeBoolean
+	case int16:
+		code = TypeInt16
+	case uint16:
+		code = TypeUint16
+	case int32:
+		code = TypeInt32
+	case uint32:
+		code = TypeUint32
+	case int64:
+		code = TypeInt64
+	case uint64:
+		code = TypeUint64
+	case float64:
+		code = TypeDouble
+	default:
+		return 0, nil, os.NewError(fmt.Sprintf("serializer: %T is not a basic value", v))
+	}
+	b, err := EncodeValue([]byte{}, string([]byte{byte(code)}), v, binary.LittleEndian)
+	return code, b, err
+}
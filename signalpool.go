@@ -0,0 +1,160 @@
+package dbus
+
+import (
+	"sync"
+)
+
+// SignalOverflow selects what happens to a signal arriving while every
+// handler slot set by SetMaxConcurrentSignalHandlers is taken.
+type SignalOverflow int
+
+const (
+	// the message loop waits for a free slot
+	SIGNAL_OVERFLOW_BLOCK SignalOverflow = iota
+	// the handlers are not run for the signal
+	SIGNAL_OVERFLOW_DROP
+	// the signal waits in a queue; the message loop waits when it is full
+	SIGNAL_OVERFLOW_QUEUE
+)
+
+// signalPool runs signal handlers on worker goroutines.
+type signalPool struct {
+	jobs    chan func()
+	closing chan bool // closed by close before it takes mutex
+	once    sync.Once
+	mutex   sync.RWMutex // write-locked to close jobs
+	closed  bool
+	policy  SignalOverflow
+	stat    sync.Mutex
+	dropped uint64 // under stat
+}
+
+func newSignalPool(n int, policy SignalOverflow, queue int) *signalPool {
+	if policy != SIGNAL_OVERFLOW_QUEUE {
+		queue = 0
+	}
+	p := &signalPool{jobs: make(chan func(), queue), closing: make(chan bool), policy: policy}
+	for i := 0; i < n; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *signalPool) work() {
+	for job := range p.jobs {
+		job()
+	}
+}
+
+// run hands job to a worker, applying the overflow policy.
+func (p *signalPool) run(job func()) {
+	p.mutex.RLock()
+	if p.closed {
+		p.mutex.RUnlock()
+		// the pool was replaced after the handlers were looked up
+		job()
+		return
+	}
+	if p.policy != SIGNAL_OVERFLOW_DROP {
+		// a handler replacing the pool while every worker is busy would
+		// wait for the lock forever, so close interrupts the send
+		select {
+		case p.jobs <- job:
+			p.mutex.RUnlock()
+			return
+		case <-p.closing:
+		}
+		p.mutex.RUnlock()
+		job()
+		return
+	}
+	select {
+	case p.jobs <- job:
+	default:
+		p.stat.Lock()
+		p.dropped++
+		p.stat.Unlock()
+	}
+	p.mutex.RUnlock()
+}
+
+func (p *signalPool) getDropped() uint64 {
+	p.stat.Lock()
+	defer p.stat.Unlock()
+	return p.dropped
+}
+
+// close stops the workers once they have run the queued handlers.
+func (p *signalPool) close() {
+	p.once.Do(func() { close(p.closing) })
+	p.mutex.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.jobs)
+	}
+	p.mutex.Unlock()
+}
+
+// SetMaxConcurrentSignalHandlers runs the handlers added with
+// AddSignalHandler on at most n goroutines instead of the message loop, so
+// that handlers doing I/O do not hold up method replies. The handlers of a
+// signal still run in order, but different signals may be handled out of
+// order. n <= 0 runs the handlers on the message loop again, the default.
+func (p *Connection) SetMaxConcurrentSignalHandlers(n int) {
+	p.handlerMutex.Lock()
+	p.signalWorkers = n
+	p.handlerMutex.Unlock()
+	p.resetSignalPool()
+}
+
+// SetSignalOverflowPolicy says what happens to a signal arriving while all
+// the goroutines set by SetMaxConcurrentSignalHandlers are busy; with
+// SIGNAL_OVERFLOW_QUEUE, up to queue signals wait. The default is
+// SIGNAL_OVERFLOW_BLOCK. Dropped signals are counted in Stats.
+func (p *Connection) SetSignalOverflowPolicy(policy SignalOverflow, queue int) {
+	p.handlerMutex.Lock()
+	p.signalOverflow = policy
+	p.signalQueue = queue
+	p.handlerMutex.Unlock()
+	p.resetSignalPool()
+}
+
+// resetSignalPool replaces the pool after a change of its settings. The
+// old pool finishes the handlers it was given.
+func (p *Connection) resetSignalPool() {
+	p.handlerMutex.Lock()
+	old := p.signalPool
+	p.signalPool = nil
+	if 0 < p.signalWorkers {
+		p.signalPool = newSignalPool(p.signalWorkers, p.signalOverflow, p.signalQueue)
+	}
+	if old != nil {
+		p.signalsDropped += old.getDropped()
+	}
+	p.handlerMutex.Unlock()
+	if old != nil {
+		old.close()
+	}
+}
+
+// droppedSignals returns the number of signals dropped by
+// SIGNAL_OVERFLOW_DROP.
+func (p *Connection) droppedSignals() uint64 {
+	p.handlerMutex.Lock()
+	defer p.handlerMutex.Unlock()
+	n := p.signalsDropped
+	if p.signalPool != nil {
+		n += p.signalPool.getDropped()
+	}
+	return n
+}
+
+// stopSignalPool lets the handler goroutines exit once idle.
+func (p *Connection) stopSignalPool() {
+	p.handlerMutex.Lock()
+	pool := p.signalPool
+	p.handlerMutex.Unlock()
+	if pool != nil {
+		pool.close()
+	}
+}
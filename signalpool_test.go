@@ -0,0 +1,99 @@
+package dbus
+
+import (
+	"testing"
+	"time"
+)
+
+func emitFooSignal(server *MockServer, member string) {
+	signal := NewMessage()
+	signal.Type = SIGNAL
+	signal.Path = "/org/example"
+	signal.Iface = "org.example.Foo"
+	signal.Member = member
+	server.Emit(signal)
+}
+
+func TestMaxConcurrentSignalHandlers(t *testing.T) {
+	server, p, e := NewMockServer()
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	defer p.Close()
+	server.Expect(MatchMethod("org.example.Foo", "Ping"), nil)
+
+	started := make(chan bool, 10)
+	release := make(chan bool)
+	p.AddSignalHandler(&MatchRule{Type: "signal", Interface: "org.example.Foo"}, func(msg *Message) {
+		started <- true
+		<-release
+	})
+	emit := func() { emitFooSignal(server, "Changed") }
+	ping := func() {
+		if _, e := p.CallTimeout(int64(1e9), "org.example", "/org/example", "org.example.Foo", "Ping"); e != nil {
+			t.Error("ping Failed", e)
+		}
+	}
+
+	// both handlers run at once, and replies still get through
+	p.SetMaxConcurrentSignalHandlers(2)
+	emit()
+	emit()
+	<-started
+	<-started
+	ping()
+	release <- true
+	release <- true
+
+	p.SetSignalOverflowPolicy(SIGNAL_OVERFLOW_DROP, 0)
+	p.SetMaxConcurrentSignalHandlers(1)
+	emit()
+	<-started
+	emit()
+	emit()
+	// the reply comes after the signals, so they have been dispatched
+	ping()
+	if n := p.Stats().SignalsDropped; n != 2 {
+		t.Error("#2 Failed", n)
+	}
+	release <- true
+	if len(started) != 0 {
+		t.Error("#3 Failed", len(started))
+	}
+}
+
+func TestResizeSignalPoolFromHandler(t *testing.T) {
+	server, p, e := NewMockServer()
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	defer p.Close()
+	server.Expect(MatchMethod("org.example.Foo", "Ping"), nil)
+
+	release := make(chan bool)
+	handled := make(chan string, 10)
+	p.AddSignalHandler(&MatchRule{Type: "signal", Interface: "org.example.Foo"}, func(msg *Message) {
+		if msg.Member == "Resize" {
+			<-release
+			p.SetMaxConcurrentSignalHandlers(2)
+		}
+		handled <- msg.Member
+	})
+
+	// the only worker is busy, so the loop waits to hand it Changed
+	p.SetMaxConcurrentSignalHandlers(1)
+	emitFooSignal(server, "Resize")
+	emitFooSignal(server, "Changed")
+	time.Sleep(10e6)
+	release <- true
+	for i := 0; i < 2; i++ {
+		select {
+		case <-handled:
+		case <-after(int64(1e9)):
+			t.Fatal("#2 Failed: deadlock")
+		}
+	}
+	if _, e := p.CallTimeout(int64(1e9), "org.example", "/org/example", "org.example.Foo", "Ping"); e != nil {
+		t.Error("#3 Failed", e)
+	}
+}
@@ -0,0 +1,166 @@
+package dbus
+
+import (
+	"container/vector"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+var (
+	variantType   = reflect.Typeof(Variant{})
+	objectRefType = reflect.Typeof(ObjectRef{})
+	errorType     = reflect.Typeof((*os.Error)(nil)).(*reflect.PtrType).Elem()
+)
+
+// SignatureFromType returns the D-Bus signature of values of type t, such
+// as "a{sv}" for map[string]Variant, or an error for types D-Bus cannot
+// carry, such as channels and functions. Structs map to D-Bus structs of
+// their fields, Variant and interface{} to "v" and ObjectRef to "o".
+func SignatureFromType(t reflect.Type) (string, os.Error) {
+	switch t {
+	case variantType:
+		return "v", nil
+	case objectRefType:
+		return "o", nil
+	}
+	switch x := t.(type) {
+	case *reflect.Uint8Type:
+		return "y", nil
+	case *reflect.BoolType:
+		return "b", nil
+	case *reflect.Int16Type:
+		return "n", nil
+	case *reflect.Uint16Type:
+		return "q", nil
+	case *reflect.Int32Type:
+		return "i", nil
+	case *reflect.Uint32Type:
+		return "u", nil
+	case *reflect.Int64Type:
+		return "x", nil
+	case *reflect.Uint64Type:
+		return "t", nil
+	case *reflect.Float64Type:
+		return "d", nil
+	case *reflect.StringType:
+		return "s", nil
+	case *reflect.InterfaceType:
+		if x.NumMethod() == 0 {
+			return "v", nil
+		}
+	case *reflect.SliceType:
+		elem, err := SignatureFromType(x.Elem())
+		if err != nil {
+			return "", err
+		}
+		return "a" + elem, nil
+	case *reflect.MapType:
+		key, err := SignatureFromType(x.Key())
+		if err != nil {
+			return "", err
+		}
+		if len(key) != 1 || key == "v" {
+			return "", os.NewError(fmt.Sprintf("no D-Bus signature for %s: keys must be of a basic type", t))
+		}
+		elem, err := SignatureFromType(x.Elem())
+		if err != nil {
+			return "", err
+		}
+		return "a{" + key + elem + "}", nil
+	case *reflect.StructType:
+		sig := "("
+		for i := 0; i < x.NumField(); i++ {
+			field, err := SignatureFromType(x.Field(i).Type)
+			if err != nil {
+				return "", err
+			}
+			sig += field
+		}
+		if x.NumField() == 0 {
+			return "", os.NewError(fmt.Sprintf("no D-Bus signature for empty struct %s", t))
+		}
+		return sig + ")", nil
+	}
+	return "", os.NewError(fmt.Sprintf("no D-Bus signature for %s", t))
+}
+
+// wireValue converts v to the form appendValue encodes: slices and maps
+// become *vector.Vector, structs and dict entries []interface{}.
+func wireValue(v reflect.Value) interface{} {
+	switch x := v.(type) {
+	case *reflect.SliceValue:
+		vec := new(vector.Vector)
+		for i := 0; i < x.Len(); i++ {
+			vec.Push(wireValue(x.Elem(i)))
+		}
+		return vec
+	case *reflect.MapValue:
+		vec := new(vector.Vector)
+		for _, key := range x.Keys() {
+			vec.Push([]interface{}{wireValue(key), wireValue(x.Elem(key))})
+		}
+		return vec
+	case *reflect.StructValue:
+		if t := x.Type(); t == variantType || t == objectRefType {
+			break
+		}
+		fields := make([]interface{}, x.NumField())
+		for i := 0; i < x.NumField(); i++ {
+			fields[i] = wireValue(x.Field(i))
+		}
+		return fields
+	case *reflect.InterfaceValue:
+		if x.IsNil() {
+			return nil
+		}
+		return wireValue(x.Elem())
+	}
+	return v.Interface()
+}
+
+// Results of compareReplySignature.
+const (
+	REPLY_SIGNATURE_MATCHES = iota
+	// the declared types, followed by types the method does not declare
+	REPLY_SIGNATURE_EXTRA
+	REPLY_SIGNATURE_INCOMPATIBLE
+)
+
+// compareReplySignature compares the signature of a reply with the out
+// signature declared for the method. Services newer than their
+// introspection data often append out arguments, which callers that only
+// read the leading ones can ignore.
+func compareReplySignature(declared string, got string) int {
+	if got == declared {
+		return REPLY_SIGNATURE_MATCHES
+	}
+	if !strings.HasPrefix(got, declared) {
+		return REPLY_SIGNATURE_INCOMPATIBLE
+	}
+	// declared is a sequence of complete types, so the rest starts at a
+	// type boundary; it must be complete types as well
+	for i := len(declared); i < len(got); {
+		block, e := getSigBlock(got, i)
+		if e != nil {
+			return REPLY_SIGNATURE_INCOMPATIBLE
+		}
+		i += len(block)
+	}
+	return REPLY_SIGNATURE_EXTRA
+}
+
+// splitSignature returns the complete types sig is a sequence of.
+func splitSignature(sig string) ([]string, os.Error) {
+	types := new(vector.StringVector)
+	for i := 0; i < len(sig); {
+		block, err := getSigBlock(sig, i)
+		if err != nil {
+			return nil, err
+		}
+		types.Push(block)
+		i += len(block)
+	}
+	return types.Data(), nil
+}
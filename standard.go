@@ -0,0 +1,145 @@
+package dbus
+
+import (
+	"bytes"
+	"container/vector"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	PEER_INTERFACE           = "org.freedesktop.DBus.Peer"
+	INTROSPECTABLE_INTERFACE = "org.freedesktop.DBus.Introspectable"
+)
+
+// standardInterfaces are the interfaces every exported object implements.
+var standardInterfaces = []string{PEER_INTERFACE, INTROSPECTABLE_INTERFACE, PROPERTIES_INTERFACE}
+
+func isStandardInterface(iface string) bool { return contains(standardInterfaces, iface) }
+
+// ExportStandardInterfaces makes the object at path answer
+// org.freedesktop.DBus.Peer, org.freedesktop.DBus.Introspectable, with the
+// interfaces exported at path, and org.freedesktop.DBus.Properties, with
+// the property structs exported there. Export and ExportPropertyStruct call
+// it; interfaces already exported at path are kept.
+func (p *Connection) ExportStandardInterfaces(path string) os.Error {
+	err := p.exportMethods(path, PEER_INTERFACE, []MethodSpec{
+		MethodSpec{"Ping", "", "", func() os.Error { return nil }},
+		MethodSpec{"GetMachineId", "", "s", func() (string, os.Error) {
+			id, err := MachineID()
+			if err != nil {
+				return "", &Error{"org.freedesktop.DBus.Error.FileNotFound", err.String()}
+			}
+			return id, nil
+		}},
+	}, false)
+	if err == nil {
+		err = p.exportMethods(path, INTROSPECTABLE_INTERFACE, []MethodSpec{
+			MethodSpec{"Introspect", "", "s", func() (string, os.Error) { return p.introspectExported(path), nil }},
+		}, false)
+	}
+	if err == nil {
+		err = p.exportMethods(path, PROPERTIES_INTERFACE, p.propertiesMethods(path), false)
+	}
+	return err
+}
+
+// introspectExported returns the introspection data of the object at path:
+// its exported interfaces and methods, the properties of its property
+// structs and the nodes below it.
+func (p *Connection) introspectExported(path string) string {
+	p.exportMutex.Lock()
+	defer p.exportMutex.Unlock()
+	ifaces := make(map[string]map[string]*exportedMethod)
+	children := make(map[string]bool)
+	prefix := childPath(path, "")
+	add := func(key string, table map[string]*exportedMethod) {
+		i := strings.Index(key, " ")
+		objPath, iface := key[0:i], key[i+1:]
+		if objPath == path {
+			if table != nil || ifaces[iface] == nil {
+				ifaces[iface] = table
+			}
+		} else if strings.HasPrefix(objPath, prefix) {
+			children[strings.Split(objPath[len(prefix):], "/", 2)[0]] = true
+		}
+	}
+	for key, table := range p.exports {
+		add(key, table)
+	}
+	// an interface may only have properties
+	for key, _ := range p.propertyStructs {
+		add(key, nil)
+	}
+
+	buff := bytes.NewBufferString(dbusIntrospectHeader)
+	buff.WriteString("<node>\n")
+	names := new(vector.StringVector)
+	for iface, _ := range ifaces {
+		names.Push(iface)
+	}
+	for _, iface := range sortedStrings(names) {
+		fmt.Fprintf(buff, "  <interface name=\"%s\">\n", iface)
+		methods := new(vector.StringVector)
+		for name, _ := range ifaces[iface] {
+			methods.Push(name)
+		}
+		for _, name := range sortedStrings(methods) {
+			writeMethodXML(buff, ifaces[iface][name])
+		}
+		if ps, ok := p.propertyStructs[path+" "+iface]; ok {
+			writePropertiesXML(buff, ps)
+		}
+		buff.WriteString("  </interface>\n")
+	}
+	names = new(vector.StringVector)
+	for child, _ := range children {
+		names.Push(child)
+	}
+	for _, child := range sortedStrings(names) {
+		fmt.Fprintf(buff, "  <node name=\"%s\"/>\n", child)
+	}
+	buff.WriteString("</node>\n")
+	return buff.String()
+}
+
+const dbusIntrospectHeader = `<!DOCTYPE node PUBLIC "-//freedesktop//DTD D-BUS Object Introspection 1.0//EN"
+"http://www.freedesktop.org/standards/dbus/1.0/introspect.dtd">
+`
+
+func writeMethodXML(buff *bytes.Buffer, m *exportedMethod) {
+	in, _ := splitSignature(m.inSig)
+	out, _ := splitSignature(m.outSig)
+	if len(in) == 0 && len(out) == 0 {
+		fmt.Fprintf(buff, "    <method name=\"%s\"/>\n", m.name)
+		return
+	}
+	fmt.Fprintf(buff, "    <method name=\"%s\">\n", m.name)
+	for _, sig := range in {
+		fmt.Fprintf(buff, "      <arg direction=\"in\" type=\"%s\"/>\n", sig)
+	}
+	for _, sig := range out {
+		fmt.Fprintf(buff, "      <arg direction=\"out\" type=\"%s\"/>\n", sig)
+	}
+	buff.WriteString("    </method>\n")
+}
+
+func writePropertiesXML(buff *bytes.Buffer, ps *ExportedPropertyStruct) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	names := new(vector.StringVector)
+	for name, _ := range ps.values {
+		names.Push(name)
+	}
+	for _, name := range sortedStrings(names) {
+		fmt.Fprintf(buff, "    <property name=\"%s\" type=\"%s\" access=\"read\"/>\n", name, ps.values[name].Sig)
+	}
+}
+
+func sortedStrings(v *vector.StringVector) []string {
+	strs := v.Data()
+	sort.SortStrings(strs)
+	return strs
+}
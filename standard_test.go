@@ -0,0 +1,167 @@
+package dbus
+
+import (
+	"os"
+	"testing"
+)
+
+func standardCall(p *Connection, path string, iface string, member string, sig string, args ...) *Message {
+	msg := NewMessage()
+	msg.Type = METHOD_CALL
+	msg.Path = path
+	msg.Iface = iface
+	msg.Member = member
+	msg.Sig = sig
+	msg.Params.AppendVector(argToVector(args))
+	return p.handleCall(msg)
+}
+
+func TestExportStandardInterfaces(t *testing.T) {
+	p := new(Connection)
+	p.Export("/org/example/dev0", "org.example.Foo", []MethodSpec{
+		MethodSpec{Name: "Info", Handler: func(name string, flags uint32) (string, []string, os.Error) { return name, nil, nil }},
+	})
+	p.ExportPropertyStruct("/org/example/dev0", "org.example.Foo", &batteryProps{50, "charging"})
+	p.Export("/org/example/dev0/sub", "org.example.Bar", []MethodSpec{})
+
+	if reply := standardCall(p, "/org/example/dev0", PEER_INTERFACE, "Ping", ""); reply.Type != METHOD_RETURN {
+		t.Error("#1 Failed", reply)
+	}
+	reply := standardCall(p, "/org/example/dev0", INTROSPECTABLE_INTERFACE, "Introspect", "")
+	if reply.Type != METHOD_RETURN {
+		t.Fatal("#2 Failed", reply)
+	}
+	intro, e := NewIntrospect(reply.Params.At(0).(string))
+	if e != nil {
+		t.Fatal("#3 Failed", e)
+	}
+	names := interfaceNames(intro)
+	if len(names) != 4 || names[0] != "org.example.Foo" {
+		t.Error("#4 Failed", names)
+	}
+	info := intro.GetInterfaceData("org.example.Foo").GetMethodData("Info")
+	if info == nil || info.GetInSignature() != "su" || info.GetOutSignature() != "sas" {
+		t.Error("#5 Failed", info)
+	}
+	if children := childNames(intro); len(children) != 1 || children[0] != "sub" {
+		t.Error("#6 Failed", children)
+	}
+
+	// properties of objects without property structs are unknown
+	reply = standardCall(p, "/org/example/dev0/sub", PROPERTIES_INTERFACE, "GetAll", "s", "org.example.Bar")
+	if reply.ErrorName != "org.freedesktop.DBus.Error.UnknownInterface" {
+		t.Error("#7 Failed", reply)
+	}
+
+	// the standard interfaces go with the last other one
+	p.Unexport("/org/example/dev0/sub", "org.example.Bar")
+	if reply = standardCall(p, "/org/example/dev0/sub", PEER_INTERFACE, "Ping", ""); reply.Type != ERROR {
+		t.Error("#8 Failed", reply)
+	}
+	if reply = standardCall(p, "/org/example/dev0", PEER_INTERFACE, "Ping", ""); reply.Type != METHOD_RETURN {
+		t.Error("#9 Failed", reply)
+	}
+}
+
+func TestExportObjectTree(t *testing.T) {
+	p := new(Connection)
+	ping := []MethodSpec{MethodSpec{Name: "Ping", Handler: func() os.Error { return nil }}}
+	e := p.ExportObjectTree("/org/example", map[string]ObjectSpec{
+		"dev0":     ObjectSpec{"org.example.Dev": ping},
+		"dev0/sub": ObjectSpec{"org.example.Sub": ping},
+		"/dev1":    ObjectSpec{"org.example.Dev": ping},
+	})
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	if reply := standardCall(p, "/org/example/dev0/sub", "org.example.Sub", "Ping", ""); reply.Type != METHOD_RETURN {
+		t.Error("#2 Failed", reply)
+	}
+	reply := standardCall(p, "/org/example", INTROSPECTABLE_INTERFACE, "Introspect", "")
+	if reply.Type != METHOD_RETURN {
+		t.Fatal("#3 Failed", reply)
+	}
+	intro, e := NewIntrospect(reply.Params.At(0).(string))
+	if e != nil {
+		t.Fatal("#4 Failed", e)
+	}
+	if children := childNames(intro); len(children) != 2 || children[0] != "dev0" || children[1] != "dev1" {
+		t.Error("#5 Failed", children)
+	}
+
+	// a bad spec exports nothing
+	e = p.ExportObjectTree("/org/other", map[string]ObjectSpec{
+		"/":   ObjectSpec{"org.example.Dev": ping},
+		"bad": ObjectSpec{"org.example.Dev": []MethodSpec{MethodSpec{Name: "Bad", Handler: 1}}},
+	})
+	if e == nil {
+		t.Error("#6 Failed")
+	}
+	if reply = standardCall(p, "/org/other", "org.example.Dev", "Ping", ""); reply.Type != ERROR {
+		t.Error("#7 Failed", reply)
+	}
+	// nor when a valid object comes first: a bad interface name, a bad
+	// path, members colliding once mapped
+	dev := ObjectSpec{"org.example.Dev": ping}
+	colliding := []MethodSpec{ping[0], MethodSpec{Name: "ping", Handler: func() os.Error { return nil }}}
+	p.SetNameMapper(&NameMapper{SnakeCase: true})
+	for i, bad := range []map[string]ObjectSpec{
+		map[string]ObjectSpec{"a": dev, "b": ObjectSpec{"bad name": ping}},
+		map[string]ObjectSpec{"a": dev, "b-c": dev},
+		map[string]ObjectSpec{"a": dev, "b": ObjectSpec{"org.example.Dev": colliding}},
+	} {
+		if e = p.ExportObjectTree("/org/other", bad); e == nil {
+			t.Error("#7-1 Failed", i)
+		}
+		if reply = standardCall(p, "/org/other/a", "org.example.Dev", "Ping", ""); reply.Type != ERROR {
+			t.Error("#7-2 Failed", i, reply)
+		}
+	}
+	p.SetNameMapper(nil)
+
+	p.Export("/org/examples", "org.example.Dev", ping)
+	p.UnexportObjectTree("/org/example")
+	for _, path := range []string{"/org/example", "/org/example/dev0", "/org/example/dev0/sub"} {
+		if reply = standardCall(p, path, PEER_INTERFACE, "Ping", ""); reply.Type != ERROR {
+			t.Error("#8 Failed", path, reply)
+		}
+	}
+	if reply = standardCall(p, "/org/examples", "org.example.Dev", "Ping", ""); reply.Type != METHOD_RETURN {
+		t.Error("#9 Failed", reply)
+	}
+}
+
+func TestExportPropertyStructOnly(t *testing.T) {
+	p := new(Connection)
+	if _, e := p.ExportPropertyStruct("/org/example/bat0", "org.example.Battery", &batteryProps{50, "charging"}); e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	introspect := func() Introspect {
+		reply := standardCall(p, "/org/example/bat0", INTROSPECTABLE_INTERFACE, "Introspect", "")
+		if reply.Type != METHOD_RETURN {
+			t.Fatal("#2 Failed", reply)
+		}
+		intro, e := NewIntrospect(reply.Params.At(0).(string))
+		if e != nil {
+			t.Fatal("#3 Failed", e)
+		}
+		return intro
+	}
+	iface := introspect().GetInterfaceData("org.example.Battery")
+	if iface == nil {
+		t.Fatal("#4 Failed")
+	}
+	if prop := iface.GetPropertyData("Percentage"); prop == nil || prop.GetType() != "u" {
+		t.Error("#5 Failed", prop)
+	}
+
+	// the property struct keeps the standard interfaces
+	p.Export("/org/example/bat0", "org.example.Foo", []MethodSpec{})
+	p.Unexport("/org/example/bat0", "org.example.Foo")
+	if reply := standardCall(p, "/org/example/bat0", PEER_INTERFACE, "Ping", ""); reply.Type != METHOD_RETURN {
+		t.Error("#6 Failed", reply)
+	}
+	if introspect().GetInterfaceData("org.example.Battery") == nil {
+		t.Error("#7 Failed")
+	}
+}
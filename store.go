@@ -0,0 +1,272 @@
+package dbus
+
+import (
+	"container/vector"
+	"fmt"
+	"os"
+	"reflect"
+)
+
+// UnmarshalReply stores the values of reply, as returned by CallMethod, into
+// dests, which must be pointers. Numbers are converted between integer
+// types when the value fits; arrays, dicts and structs (decoded as
+// *vector.Vector) are stored into slices, maps and structs. Values beyond
+// the last destination are ignored unless the connection is strict (see
+// SetStrictReplies).
+func (p *Connection) UnmarshalReply(reply []interface{}, dests ...) os.Error {
+	v := reflect.NewValue(dests).(*reflect.StructValue)
+	if !p.countFits(len(reply), v.NumField()) {
+		return os.NewError(fmt.Sprintf("UnmarshalReply: %d values for %d destinations", len(reply), v.NumField()))
+	}
+	for i := 0; i < v.NumField(); i++ {
+		if err := storeArg(v.Field(i), reply[i], "", p.getSerializers()); err != nil {
+			return os.NewError(fmt.Sprintf("UnmarshalReply: argument %d: %s", i, err))
+		}
+	}
+	return nil
+}
+
+// UnmarshalMessage is UnmarshalReply for the body of msg. Object paths
+// stored into an ObjectRef get the sender of msg as their destination.
+func (p *Connection) UnmarshalMessage(msg *Message, dests ...) os.Error {
+	v := reflect.NewValue(dests).(*reflect.StructValue)
+	if !p.countFits(msg.Params.Len(), v.NumField()) {
+		return os.NewError(fmt.Sprintf("UnmarshalMessage: %d values for %d destinations", msg.Params.Len(), v.NumField()))
+	}
+	for i := 0; i < v.NumField(); i++ {
+		if err := storeArg(v.Field(i), msg.Params.At(i), msg.Sender, p.getSerializers()); err != nil {
+			return os.NewError(fmt.Sprintf("UnmarshalMessage: argument %d: %s", i, err))
+		}
+	}
+	return nil
+}
+
+// SetStrictReplies makes UnmarshalReply and UnmarshalMessage fail when
+// there are more values than destinations, and CallMethod fail when a
+// reply has more values than the method declares. By default the extra
+// values are ignored.
+func (p *Connection) SetStrictReplies(strict bool) {
+	p.stateMutex.Lock()
+	p.strictReplies = strict
+	p.stateMutex.Unlock()
+}
+
+func (p *Connection) isStrict() bool {
+	p.stateMutex.Lock()
+	defer p.stateMutex.Unlock()
+	return p.strictReplies
+}
+
+// countFits reports whether values values can be stored into dests
+// destinations.
+func (p *Connection) countFits(values int, dests int) bool {
+	if p.isStrict() {
+		return values == dests
+	}
+	return dests <= values
+}
+
+// storeArg stores src through the pointer dest. Object paths stored into
+// an ObjectRef get sender as their destination; values of the types
+// serializers handle are made by them.
+func storeArg(dest reflect.Value, src interface{}, sender string, serializers []Serializer) os.Error {
+	if iv, ok := dest.(*reflect.InterfaceValue); ok {
+		dest = iv.Elem()
+	}
+	ptr, ok := dest.(*reflect.PtrValue)
+	if !ok || ptr.IsNil() {
+		return os.NewError("destination is not a pointer")
+	}
+	return storeValueWith(ptr.Elem(), src, sender, serializers)
+}
+
+func storeValue(dest reflect.Value, src interface{}, sender string) os.Error {
+	return storeValueWith(dest, src, sender, nil)
+}
+
+func storeValueWith(dest reflect.Value, src interface{}, sender string, serializers []Serializer) os.Error {
+	if src == nil {
+		return os.NewError("no value")
+	}
+	if dest.Type() != variantType {
+		src = unwrapVariant(src)
+	}
+	if s := findSerializer(serializers, dest.Type()); s != nil {
+		code, b, err := basicContent(src)
+		if err != nil {
+			return err
+		}
+		v, err := s.Unmarshal(code, b)
+		if err != nil {
+			return err
+		}
+		dest.SetValue(reflect.NewValue(v))
+		return nil
+	}
+	sv := reflect.NewValue(src)
+
+	switch d := dest.(type) {
+	case *reflect.InterfaceValue:
+		d.Set(sv)
+		return nil
+
+	case *reflect.SliceValue:
+		vec, ok := src.(*vector.Vector)
+		if !ok {
+			break
+		}
+		slice := reflect.MakeSlice(d.Type().(*reflect.SliceType), vec.Len(), vec.Len())
+		for i := 0; i < vec.Len(); i++ {
+			if err := storeValueWith(slice.Elem(i), vec.At(i), sender, serializers); err != nil {
+				return err
+			}
+		}
+		d.Set(slice)
+		return nil
+
+	case *reflect.MapValue:
+		vec, ok := src.(*vector.Vector)
+		if !ok {
+			break
+		}
+		mt := d.Type().(*reflect.MapType)
+		m := reflect.MakeMap(mt)
+		for entry := range vec.Iter() {
+			kv, ok := entry.(*vector.Vector)
+			if !ok || kv.Len() != 2 {
+				return os.NewError("malformed dict entry")
+			}
+			key := reflect.MakeZero(mt.Key())
+			if err := storeValueWith(key, kv.At(0), sender, serializers); err != nil {
+				return err
+			}
+			val := reflect.MakeZero(mt.Elem())
+			if err := storeValueWith(val, kv.At(1), sender, serializers); err != nil {
+				return err
+			}
+			m.SetElem(key, val)
+		}
+		d.Set(m)
+		return nil
+
+	case *reflect.StructValue:
+		if path, ok := src.(string); ok && d.Type() == objectRefType {
+			d.SetValue(reflect.NewValue(ObjectRef{sender, path}))
+			return nil
+		}
+		if _, ok := src.(Variant); !ok && d.Type() == variantType {
+			sig, err := variantSignature(src)
+			if err != nil {
+				return err
+			}
+			d.SetValue(reflect.NewValue(Variant{sig, src}))
+			return nil
+		}
+		vec, ok := src.(*vector.Vector)
+		if !ok {
+			break
+		}
+		if vec.Len() != d.NumField() {
+			return os.NewError(fmt.Sprintf("struct has %d fields, value has %d", d.NumField(), vec.Len()))
+		}
+		for i := 0; i < d.NumField(); i++ {
+			if err := storeValueWith(d.Field(i), vec.At(i), sender, serializers); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if sv.Type() == dest.Type() {
+		dest.SetValue(sv)
+		return nil
+	}
+	if storeNumber(dest, src) {
+		return nil
+	}
+	return os.NewError(fmt.Sprintf("cannot store %T into %s", src, dest.Type()))
+}
+
+// storeNumber converts the integer src to the integer type of dest. It
+// returns false when either is not an integer or the value does not fit.
+func storeNumber(dest reflect.Value, src interface{}) bool {
+	var n int64
+	var u uint64
+	signed := true
+	switch x := src.(type) {
+	case byte:
+		u, signed = uint64(x), false
+	case uint16:
+		u, signed = uint64(x), false
+	case uint32:
+		u, signed = uint64(x), false
+	case uint64:
+		u, signed = x, false
+	case int16:
+		n = int64(x)
+	case int32:
+		n = int64(x)
+	case int64:
+		n = x
+	default:
+		return false
+	}
+	if !signed {
+		if u > 1<<63-1 {
+			// only a uint64 destination can hold it
+			if d, ok := dest.(*reflect.Uint64Value); ok {
+				d.Set(u)
+				return true
+			}
+			return false
+		}
+		n = int64(u)
+	}
+
+	switch d := dest.(type) {
+	case *reflect.Uint8Value:
+		if 0 <= n && n <= 1<<8-1 {
+			d.Set(uint8(n))
+			return true
+		}
+	case *reflect.Uint16Value:
+		if 0 <= n && n <= 1<<16-1 {
+			d.Set(uint16(n))
+			return true
+		}
+	case *reflect.Uint32Value:
+		if 0 <= n && n <= 1<<32-1 {
+			d.Set(uint32(n))
+			return true
+		}
+	case *reflect.Uint64Value:
+		if 0 <= n {
+			d.Set(uint64(n))
+			return true
+		}
+	case *reflect.UintValue:
+		if 0 <= n && n <= 1<<32-1 {
+			d.Set(uint(n))
+			return true
+		}
+	case *reflect.Int16Value:
+		if -1<<15 <= n && n <= 1<<15-1 {
+			d.Set(int16(n))
+			return true
+		}
+	case *reflect.Int32Value:
+		if -1<<31 <= n && n <= 1<<31-1 {
+			d.Set(int32(n))
+			return true
+		}
+	case *reflect.Int64Value:
+		d.Set(n)
+		return true
+	case *reflect.IntValue:
+		if -1<<31 <= n && n <= 1<<31-1 {
+			d.Set(int(n))
+			return true
+		}
+	}
+	return false
+}
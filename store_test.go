@@ -0,0 +1,305 @@
+package dbus
+
+import (
+	"bytes"
+	"container/vector"
+	"encoding/binary"
+	"net"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestUnmarshalReply(t *testing.T) {
+	p := new(Connection)
+
+	var s string
+	var u uint32
+	var i int
+	if e := p.UnmarshalReply([]interface{}{"test", uint32(4), int32(-2)}, &s, &u, &i); e != nil {
+		t.Error("#1-1 Failed", e)
+	}
+	if s != "test" || u != 4 || i != -2 {
+		t.Error("#1-2 Failed", s, u, i)
+	}
+
+	if e := p.UnmarshalReply([]interface{}{"test"}, &s, &u); e == nil {
+		t.Error("#2 Failed")
+	}
+	if e := p.UnmarshalReply([]interface{}{int32(-1)}, &u); e == nil {
+		t.Error("#3 Failed")
+	}
+	if e := p.UnmarshalReply([]interface{}{"test"}, s); e == nil {
+		t.Error("#4 Failed")
+	}
+
+	ary := new(vector.Vector)
+	ary.Push("a")
+	ary.Push("b")
+	var strs []string
+	if e := p.UnmarshalReply([]interface{}{ary}, &strs); e != nil || len(strs) != 2 || strs[1] != "b" {
+		t.Error("#5 Failed", strs)
+	}
+
+	dict := new(vector.Vector)
+	entry := new(vector.Vector)
+	entry.Push(uint32(1))
+	entry.Push("one")
+	dict.Push(entry)
+	var m map[uint32]string
+	if e := p.UnmarshalReply([]interface{}{dict}, &m); e != nil || m[1] != "one" {
+		t.Error("#6 Failed", m)
+	}
+
+	var any interface{}
+	if e := p.UnmarshalReply([]interface{}{byte(3)}, &any); e != nil || any.(byte) != 3 {
+		t.Error("#7 Failed")
+	}
+}
+
+func TestUnmarshalObjectRef(t *testing.T) {
+	p := new(Connection)
+	msg := NewMessage()
+	msg.Sender = ":1.42"
+	paths := new(vector.Vector)
+	paths.Push("/org/bluez/hci0/dev_00")
+	msg.Params.Push("/org/bluez/hci0")
+	msg.Params.Push(paths)
+
+	var ref ObjectRef
+	var refs []ObjectRef
+	if e := p.UnmarshalMessage(msg, &ref, &refs); e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	if ref.Dest != ":1.42" || ref.Path != "/org/bluez/hci0" {
+		t.Error("#2 Failed", ref)
+	}
+	if len(refs) != 1 || refs[0].Dest != ":1.42" || refs[0].Path != "/org/bluez/hci0/dev_00" {
+		t.Error("#3 Failed", refs)
+	}
+
+	msg.Sig = "o"
+	msg.Params = new(vector.Vector)
+	msg.Params.Push(ref)
+	msg.Type = SIGNAL
+	msg.Path = "/"
+	msg.Iface = "org.example"
+	msg.Member = "Ref"
+	buff, e := EncodeMessage(msg)
+	if e != nil {
+		t.Fatal("#4 Failed", e)
+	}
+	decoded, _, e := DecodeMessage(buff)
+	if e != nil || decoded.Params.At(0).(string) != "/org/bluez/hci0" {
+		t.Error("#5 Failed", e)
+	}
+}
+
+// fakeService returns a ready connection to a peer that answers every
+// method call with reply(call).
+func fakeService(t *testing.T, reply func(call *Message) *Message) *Connection {
+	p, server := dialTest(t)
+	go serveCalls(server, reply)
+	p.ready = true
+	go p.runLoop()
+	return p
+}
+
+func serveCalls(conn net.Conn, reply func(call *Message) *Message) { serveMessages(conn, reply, nil) }
+
+// serveMessages is serveCalls also passing the signals received to signal,
+// if not nil, and sending back what it returns.
+func serveMessages(conn net.Conn, reply func(call *Message) *Message, signal func(msg *Message) *Message) {
+	defer conn.Close()
+	buff := bytes.NewBuffer([]byte{})
+	chunk := make([]byte, 4096)
+	for {
+		call, n, e := DecodeMessage(buff.Bytes())
+		if e != nil {
+			m, e := conn.Read(chunk)
+			if e != nil {
+				return
+			}
+			buff.Write(chunk[0:m])
+			continue
+		}
+		buff.Next(n)
+		var msg *Message
+		switch {
+		case call.Type == METHOD_CALL:
+			msg = reply(call)
+			msg.replySerial = call.Serial()
+		case call.Type == SIGNAL && signal != nil:
+			msg = signal(call)
+		}
+		if msg == nil {
+			continue
+		}
+		data, e := EncodeMessage(msg)
+		if e != nil {
+			return
+		}
+		conn.Write(data)
+	}
+}
+
+func TestExtraReplyValues(t *testing.T) {
+	p := fakeService(t, func(call *Message) *Message {
+		msg := NewMessage()
+		msg.Type = METHOD_RETURN
+		if call.Member == "Frobate" {
+			// one more value than introspection declares
+			msg.Sig = "sa{us}u"
+			msg.Params.Push("bar")
+			msg.Params.Push(new(vector.Vector))
+			msg.Params.Push(uint32(7))
+		} else {
+			msg.Sig = "i"
+			msg.Params.Push(int32(1))
+		}
+		return msg
+	})
+	defer p.Close()
+	intro, _ := NewIntrospect(introStr)
+	obj := &Object{dest: "org.freedesktop.Sample", path: "/org/freedesktop/sample_object", intro: intro}
+	iface, e := p.Interface(obj, "org.freedesktop.SampleInterface")
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+
+	ret, e := p.CallMethod(iface, "Frobate", int32(1))
+	if e != nil || len(ret) != 3 {
+		t.Fatal("#2 Failed", e, ret)
+	}
+	var s string
+	var m map[uint32]string
+	if e = p.UnmarshalReply(ret, &s, &m); e != nil || s != "bar" {
+		t.Error("#3 Failed", e)
+	}
+	if _, e = p.CallMethod(iface, "Bazify", []interface{}{int32(1), int32(2), uint32(3)}); e == nil {
+		t.Error("#4 Failed")
+	}
+
+	p.SetStrictReplies(true)
+	_, e = p.CallMethod(iface, "Frobate", int32(1))
+	if se, ok := e.(*ReplySignatureError); !ok || se.Declared != "sa{us}" || se.Got != "sa{us}u" {
+		t.Error("#5 Failed", e)
+	}
+	if e = p.UnmarshalReply(ret, &s, &m); e == nil {
+		t.Error("#6 Failed")
+	}
+}
+
+func TestCompareReplySignature(t *testing.T) {
+	if compareReplySignature("s", "s") != REPLY_SIGNATURE_MATCHES {
+		t.Error("#1 Failed")
+	}
+	if compareReplySignature("", "s") != REPLY_SIGNATURE_EXTRA ||
+		compareReplySignature("ai", "aia{sv}") != REPLY_SIGNATURE_EXTRA {
+		t.Error("#2 Failed")
+	}
+	if compareReplySignature("s", "i") != REPLY_SIGNATURE_INCOMPATIBLE ||
+		compareReplySignature("s", "sa") != REPLY_SIGNATURE_INCOMPATIBLE ||
+		compareReplySignature("su", "s") != REPLY_SIGNATURE_INCOMPATIBLE {
+		t.Error("#3 Failed")
+	}
+}
+
+type sigTestStruct struct {
+	Name  string
+	Flags []uint32
+	Props map[string]Variant
+}
+
+func TestSignatureFromType(t *testing.T) {
+	good := map[string]interface{}{
+		"y":           byte(0),
+		"b":           false,
+		"x":           int64(0),
+		"d":           float64(0),
+		"as":          []string{},
+		"a{sv}":       map[string]Variant{},
+		"o":           ObjectRef{},
+		"(saua{sv})":  sigTestStruct{},
+		"aa{ov}":      []map[ObjectRef]interface{}{},
+		"a(saua{sv})": []sigTestStruct{},
+	}
+	for want, v := range good {
+		if sig, e := SignatureFromType(reflect.Typeof(v)); e != nil || sig != want {
+			t.Error("#1 Failed", want, sig, e)
+		}
+	}
+
+	bad := []interface{}{make(chan int), func() {}, map[Variant]string{}, map[sigTestStruct]bool{}, struct{}{}}
+	for i, v := range bad {
+		if sig, e := SignatureFromType(reflect.Typeof(v)); e == nil {
+			t.Error("#2 Failed", i, sig)
+		}
+	}
+}
+
+// stamp is a custom type sent as seconds, type 'x'.
+type stamp struct {
+	secs int64
+}
+
+type stampSerializer struct{}
+
+func (p stampSerializer) CanHandle(t reflect.Type) bool { return t == reflect.Typeof(stamp{}) }
+
+func (p stampSerializer) Marshal(v interface{}) (TypeCode, []byte, os.Error) {
+	b, e := EncodeValue([]byte{}, "x", v.(stamp).secs, binary.LittleEndian)
+	return TypeInt64, b, e
+}
+
+func (p stampSerializer) Unmarshal(code TypeCode, b []byte) (interface{}, os.Error) {
+	if code != TypeInt64 {
+		return nil, os.NewError("not seconds")
+	}
+	v, _, e := DecodeValue(b, 0, "x", binary.LittleEndian)
+	if e != nil {
+		return nil, e
+	}
+	return stamp{v.(int64)}, nil
+}
+
+func TestSerializer(t *testing.T) {
+	p := new(Connection)
+	p.AddSerializer(stampSerializer{})
+
+	var one stamp
+	var many []stamp
+	if e := p.UnmarshalReply([]interface{}{int64(5), vectorOf(int64(6), int64(7))}, &one, &many); e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	if one.secs != 5 || len(many) != 2 || many[1].secs != 7 {
+		t.Error("#2 Failed", one, many)
+	}
+	var s string
+	if e := p.UnmarshalReply([]interface{}{"now"}, &one); e == nil {
+		t.Error("#3 Failed")
+	}
+	if e := p.UnmarshalReply([]interface{}{"plain"}, &s); e != nil || s != "plain" {
+		t.Error("#4 Failed", e, s)
+	}
+
+	msg := NewMessage()
+	msg.Type = SIGNAL
+	msg.Path = "/org/example"
+	msg.Iface = "org.example.Foo"
+	msg.Member = "Tick"
+	msg.Sig = "xax"
+	msg.Params.Push(stamp{8})
+	msg.Params.Push(vectorOf(stamp{9}))
+	buff, e := p.encode(msg)
+	if e != nil {
+		t.Fatal("#5 Failed", e)
+	}
+	if _, ok := msg.Params.At(0).(stamp); !ok {
+		t.Error("#6 Failed: the message was modified")
+	}
+	decoded, _, e := DecodeMessage(buff)
+	if e != nil || decoded.Params.At(0).(int64) != 8 || decoded.Params.At(1).(*vector.Vector).At(0).(int64) != 9 {
+		t.Error("#7 Failed", e, decoded)
+	}
+}
@@ -0,0 +1,247 @@
+package dbus
+
+import (
+	"container/vector"
+	"os"
+	"sync"
+	"time"
+)
+
+// Subscription delivers the signals matching a rule on the channel C.
+// Signals are queued (up to a limit) so a slow reader never blocks the
+// connection; signals arriving while the queue is full are counted in
+// Dropped and discarded.
+type Subscription struct {
+	C <-chan *Message
+
+	out      chan *Message
+	conn     *Connection
+	handlers []*signalHandler
+	mutex    sync.Mutex
+	queue    *vector.Vector
+	limit    int
+	wake     chan bool
+	removed  bool
+	deadline int64
+	dropped  int
+}
+
+func newSubscription(limit int) *Subscription {
+	p := new(Subscription)
+	p.out = make(chan *Message)
+	p.C = p.out
+	p.queue = new(vector.Vector)
+	p.limit = limit
+	p.wake = make(chan bool, 1)
+	go p.forward()
+	return p
+}
+
+// Subscribe returns a subscription to the signals matching mr, queueing at
+// most limit undelivered signals.
+func (p *Connection) Subscribe(mr *MatchRule, limit int) *Subscription {
+	sub := newSubscription(limit)
+	sub.conn = p
+	sub.handlers = []*signalHandler{p.addSignalHandler(mr, func(msg *Message) { sub.deliver(msg) })}
+	return sub
+}
+
+// Remove cancels the subscription. Signals already queued are still
+// delivered for up to drain nanoseconds; then the rest are discarded and C
+// is closed. Nothing is sent on C after it is closed.
+func (p *Subscription) Remove(drain int64) {
+	if !p.stop(drain) {
+		return
+	}
+	if p.conn != nil {
+		for _, handler := range p.handlers {
+			p.conn.removeSignalHandler(handler)
+		}
+	}
+}
+
+// AddFilter makes the subscription skip signals for which fn returns
+// false, as AddSignalFilter does for a handler; see Deduper.Filter.
+func (p *Subscription) AddFilter(fn func(*Message) bool) {
+	if p.conn == nil {
+		return
+	}
+	for _, handler := range p.handlers {
+		p.conn.AddSignalFilter(Handle{handler}, fn)
+	}
+}
+
+// Dropped returns the number of signals discarded because the queue was
+// full.
+func (p *Subscription) Dropped() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.dropped
+}
+
+func (p *Subscription) notify() {
+	select {
+	case p.wake <- true:
+	default:
+	}
+}
+
+func (p *Subscription) deliver(msg *Message) {
+	p.mutex.Lock()
+	if p.removed {
+		p.mutex.Unlock()
+		return
+	}
+	if p.limit <= p.queue.Len() {
+		p.dropped++
+	} else {
+		p.queue.Push(msg)
+	}
+	p.mutex.Unlock()
+	p.notify()
+}
+
+// stop stops accepting signals; it returns false if it was already stopped.
+func (p *Subscription) stop(drain int64) bool {
+	p.mutex.Lock()
+	if p.removed {
+		p.mutex.Unlock()
+		return false
+	}
+	p.removed = true
+	p.deadline = time.Nanoseconds() + drain
+	p.mutex.Unlock()
+	p.notify()
+	return true
+}
+
+// forward is the only goroutine sending on and closing p.out.
+func (p *Subscription) forward() {
+	for {
+		p.mutex.Lock()
+		if p.queue.Len() == 0 {
+			removed := p.removed
+			p.mutex.Unlock()
+			if removed {
+				close(p.out)
+				return
+			}
+			<-p.wake
+			continue
+		}
+		msg := p.queue.At(0).(*Message)
+		removed := p.removed
+		deadline := p.deadline
+		p.mutex.Unlock()
+
+		var timeout <-chan bool
+		if removed {
+			left := deadline - time.Nanoseconds()
+			if left <= 0 {
+				close(p.out)
+				return
+			}
+			timeout = after(left)
+		}
+
+		select {
+		case p.out <- msg:
+			p.mutex.Lock()
+			p.queue.Delete(0)
+			p.mutex.Unlock()
+		case <-p.wake:
+			// removed meanwhile; look again
+		case <-timeout:
+			close(p.out)
+			return
+		}
+	}
+}
+
+// SignalEvent is a signal delivered by a SignalWatch together with the
+// introspection data of the signal, for decoding its arguments.
+type SignalEvent struct {
+	Msg  *Message
+	Data SignalData
+}
+
+// SignalWatch delivers the signals of an interface on the channel C, which
+// is closed once the watch is removed and drained.
+type SignalWatch struct {
+	C <-chan *SignalEvent
+
+	sub     *Subscription
+	mutex   sync.Mutex
+	removed bool
+	expired chan bool // closed when the drain time is over
+}
+
+// WatchAllSignals subscribes to the signals that iface declares, queueing
+// at most limit undelivered signals. With perSignal one match rule per
+// signal is added, otherwise a single rule for the whole interface;
+// signals iface does not declare are not delivered either way.
+func (p *Connection) WatchAllSignals(iface *Interface, perSignal bool, limit int) (*SignalWatch, os.Error) {
+	if iface == nil {
+		return nil, os.NewError("nil Interface (see GetInterface for the reason)")
+	}
+	if err := p.checkReady(); err != nil {
+		return nil, err
+	}
+	rules := []*MatchRule{&MatchRule{Type: "signal", Interface: iface.name, Path: iface.obj.path}}
+	if perSignal {
+		names := signalNames(iface.intro)
+		rules = make([]*MatchRule, len(names))
+		for i, name := range names {
+			rules[i] = &MatchRule{Type: "signal", Interface: iface.name, Member: name, Path: iface.obj.path}
+		}
+	}
+
+	sub := newSubscription(limit)
+	sub.conn = p
+	sub.handlers = make([]*signalHandler, len(rules))
+	for i, mr := range rules {
+		sub.handlers[i] = p.addSignalHandler(mr, func(msg *Message) {
+			if iface.intro.GetSignalData(msg.Member) != nil {
+				sub.deliver(msg)
+			}
+		})
+	}
+
+	out := make(chan *SignalEvent)
+	watch := &SignalWatch{C: out, sub: sub, expired: make(chan bool)}
+	go func() {
+		defer close(out)
+		for msg := range sub.C {
+			select {
+			case out <- &SignalEvent{msg, iface.intro.GetSignalData(msg.Member)}:
+			case <-watch.expired:
+				return
+			}
+		}
+	}()
+	return watch, nil
+}
+
+// Remove removes every match rule of the watch. Signals already queued
+// are still delivered for up to drain nanoseconds, as with
+// Subscription.Remove.
+func (p *SignalWatch) Remove(drain int64) {
+	p.mutex.Lock()
+	if p.removed {
+		p.mutex.Unlock()
+		return
+	}
+	p.removed = true
+	p.mutex.Unlock()
+	p.sub.Remove(drain)
+	go func() {
+		if 0 < drain {
+			<-after(drain)
+		}
+		close(p.expired)
+	}()
+}
+
+// Dropped returns the number of signals discarded because the queue was
+// full.
+func (p *SignalWatch) Dropped() int { return p.sub.Dropped() }
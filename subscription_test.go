@@ -0,0 +1,128 @@
+package dbus
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSubscriptionDrain(t *testing.T) {
+	sub := newSubscription(100)
+	for i := 0; i < 10; i++ {
+		sub.deliver(NewMessage())
+	}
+	sub.stop(int64(1e9))
+	sub.deliver(NewMessage()) // after removal: never delivered
+
+	n := 0
+	for msg := range sub.C {
+		if msg == nil {
+			t.Fatal("#1 Failed: channel closed early")
+		}
+		n++
+	}
+	if n != 10 {
+		t.Error("#2 Failed", n)
+	}
+}
+
+func TestSubscriptionDrainTimeout(t *testing.T) {
+	sub := newSubscription(100)
+	for i := 0; i < 10; i++ {
+		sub.deliver(NewMessage())
+	}
+	<-sub.C
+	sub.stop(int64(1e6))
+	time.Sleep(int64(1e7))
+
+	n := 0
+	for _ = range sub.C {
+		n++
+	}
+	if n != 0 {
+		t.Error("#1 Failed: delivered after drain timeout", n)
+	}
+}
+
+func TestSubscriptionRemoveRace(t *testing.T) {
+	for round := 0; round < 100; round++ {
+		sub := newSubscription(8)
+		done := make(chan bool)
+		go func() {
+			for i := 0; i < 100; i++ {
+				sub.deliver(NewMessage())
+			}
+			done <- true
+		}()
+		go sub.stop(int64(1e6))
+		for _ = range sub.C {
+		}
+		<-done
+		if sub.Dropped() < 0 {
+			t.Fatal("#1 Failed")
+		}
+	}
+}
+
+func TestWatchAllSignals(t *testing.T) {
+	var mutex sync.Mutex
+	added := make(map[string]int)
+	removed := make(map[string]int)
+	p := fakeService(t, func(call *Message) *Message {
+		mutex.Lock()
+		switch call.Member {
+		case "AddMatch":
+			added[call.Params.At(0).(string)]++
+		case "RemoveMatch":
+			removed[call.Params.At(0).(string)]++
+		}
+		mutex.Unlock()
+		msg := NewMessage()
+		msg.Type = METHOD_RETURN
+		return msg
+	})
+	defer p.Close()
+	intro, _ := NewIntrospect(introStr)
+	obj := &Object{dest: "org.freedesktop.Sample", path: "/org/freedesktop/sample_object", intro: intro}
+	iface, _ := p.Interface(obj, "org.freedesktop.SampleInterface")
+
+	w1, e := p.WatchAllSignals(iface, true, 10)
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	w2, _ := p.WatchAllSignals(iface, true, 10)
+	w3, _ := p.WatchAllSignals(iface, false, 10)
+	perSignal := "type='signal',interface='org.freedesktop.SampleInterface',member='Changed',path='/org/freedesktop/sample_object'"
+	wide := "type='signal',interface='org.freedesktop.SampleInterface',path='/org/freedesktop/sample_object'"
+	if len(added) != 2 || added[perSignal] != 1 || added[wide] != 1 {
+		t.Error("#2 Failed", added)
+	}
+
+	signal := NewMessage()
+	signal.Type = SIGNAL
+	signal.Path = "/org/freedesktop/sample_object"
+	signal.Iface = "org.freedesktop.SampleInterface"
+	signal.Member = "Changed"
+	signal.Params.Push(true)
+	p.InjectMessage(signal)
+	for _, w := range []*SignalWatch{w1, w3} {
+		ev := <-w.C
+		if ev.Msg != signal || ev.Data.GetSignature() != "b" {
+			t.Error("#3 Failed", ev)
+		}
+	}
+
+	w1.Remove(0)
+	if len(removed) != 0 {
+		t.Error("#4 Failed", removed)
+	}
+	w2.Remove(0)
+	w3.Remove(0)
+	w3.Remove(0)
+	if len(removed) != 2 || removed[perSignal] != 1 || removed[wide] != 1 {
+		t.Error("#5 Failed", removed)
+	}
+	// C is closed once the watch is drained
+	for _ = range w2.C {
+	}
+}
@@ -0,0 +1,57 @@
+package dbus
+
+import (
+	"time"
+)
+
+// SetTestSerials makes the connection number outgoing messages with next
+// instead of its serial counter, so that encoded messages are
+// reproducible. nil restores the default. Meant for tests.
+func (p *Connection) SetTestSerials(next func() uint32) {
+	p.stateMutex.Lock()
+	p.serialSource = next
+	p.stateMutex.Unlock()
+}
+
+// SetTestClock replaces the clock used for round-trip times (now) and for
+// call timeouts (after, which returns a channel that receives once ns
+// nanoseconds have passed). nil restores the real clock. Meant for tests.
+func (p *Connection) SetTestClock(now func() int64, after func(ns int64) <-chan bool) {
+	p.stateMutex.Lock()
+	p.clock = now
+	p.timer = after
+	p.stateMutex.Unlock()
+}
+
+// assignSerial numbers msg with the next serial of the connection, or of
+// the test serial source if one is installed.
+func (p *Connection) assignSerial(msg *Message) {
+	p.stateMutex.Lock()
+	next := p.serialSource
+	p.stateMutex.Unlock()
+	if next != nil {
+		msg.serial = next()
+	} else {
+		msg.serial = p.nextSerial()
+	}
+}
+
+func (p *Connection) now() int64 {
+	p.stateMutex.Lock()
+	clock := p.clock
+	p.stateMutex.Unlock()
+	if clock != nil {
+		return clock()
+	}
+	return time.Nanoseconds()
+}
+
+func (p *Connection) after(ns int64) <-chan bool {
+	p.stateMutex.Lock()
+	timer := p.timer
+	p.stateMutex.Unlock()
+	if timer != nil && 0 < ns {
+		return timer(ns)
+	}
+	return after(ns)
+}
@@ -0,0 +1,46 @@
+package dbus
+
+import (
+	"os"
+)
+
+// RoundTripTrace describes a method call made by the connection, for
+// latency profiling.
+type RoundTripTrace struct {
+	Dest      string
+	Path      string
+	Iface     string
+	Member    string
+	Args      []interface{}
+	ReplyType MessageType // INVALID if no reply arrived
+	Latency   int64       // nanoseconds from sending the call to its outcome
+	Err       os.Error
+}
+
+// SetRoundTripTracer makes the connection call fn at the end of every
+// method call it makes and waits for, whether it succeeds or not; a call
+// retried under a RetryPolicy is traced once per attempt. fn runs on the
+// calling goroutine and should be quick. nil removes it.
+func (p *Connection) SetRoundTripTracer(fn func(RoundTripTrace)) {
+	p.stateMutex.Lock()
+	p.tracer = fn
+	p.stateMutex.Unlock()
+}
+
+// traceCall runs roundTrip for msg, tracing it if a tracer is set.
+func (p *Connection) traceCall(msg *Message, roundTrip func() (*Message, os.Error)) (*Message, os.Error) {
+	p.stateMutex.Lock()
+	tracer := p.tracer
+	p.stateMutex.Unlock()
+	if tracer == nil {
+		return roundTrip()
+	}
+	start := p.now()
+	reply, err := roundTrip()
+	trace := RoundTripTrace{msg.Dest, msg.Path, msg.Iface, msg.Member, msg.Params.Data(), INVALID, p.now() - start, err}
+	if reply != nil {
+		trace.ReplyType = reply.Type
+	}
+	tracer(trace)
+	return reply, err
+}
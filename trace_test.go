@@ -0,0 +1,36 @@
+package dbus
+
+import (
+	"testing"
+)
+
+func TestRoundTripTracer(t *testing.T) {
+	server, p, e := NewMockServer()
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	defer p.Close()
+	server.Expect(MatchMethod("org.example.Foo", "Ping"), nil)
+
+	traces := make([]RoundTripTrace, 0, 4)
+	p.SetRoundTripTracer(func(trace RoundTripTrace) {
+		traces = traces[0 : len(traces)+1]
+		traces[len(traces)-1] = trace
+	})
+	p.CallTimeout(int64(1e9), "org.example", "/org/example", "org.example.Foo", "Ping", "hello")
+	p.CallTimeout(int64(1e9), "org.example", "/org/example", "org.example.Foo", "Other")
+	p.SetRoundTripTracer(nil)
+	p.CallTimeout(int64(1e9), "org.example", "/org/example", "org.example.Foo", "Ping")
+
+	if len(traces) != 2 {
+		t.Fatal("#2 Failed", traces)
+	}
+	ping := traces[0]
+	if ping.Dest != "org.example" || ping.Member != "Ping" || len(ping.Args) != 1 || ping.Args[0].(string) != "hello" ||
+		ping.ReplyType != METHOD_RETURN || ping.Err != nil || ping.Latency < 0 {
+		t.Error("#3 Failed", ping)
+	}
+	if other := traces[1]; other.ReplyType != ERROR || other.Err == nil {
+		t.Error("#4 Failed", other)
+	}
+}
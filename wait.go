@@ -0,0 +1,121 @@
+package dbus
+
+import (
+	"container/vector"
+	"os"
+	"sync"
+)
+
+// CallAndWaitForSignal calls method on iface and waits for the completion
+// signal signalIface.signalMember emitted on the object path that
+// pathFromReply extracts from the method reply (the "request token" pattern
+// used by portals, PackageKit transactions and agents). The match is
+// installed before the call is sent so that an early signal is not lost, and
+// it is removed before returning. It returns the signal body, ErrTimeout
+// once timeout nanoseconds have passed (0 means no timeout) or ErrCanceled
+// when cancel is closed.
+func (p *Connection) CallAndWaitForSignal(cancel <-chan bool, timeout int64, iface *Interface, method string, signalIface string, signalMember string, pathFromReply func(*Message) string, args ...) ([]interface{}, os.Error) {
+	msg, e := newMethodCall(iface, method, args)
+	if e != nil {
+		return nil, e
+	}
+
+	var mutex sync.Mutex
+	path := ""
+	early := new(vector.Vector)
+	found := make(chan *Message, 1)
+	deliver := func(sig *Message) {
+		select {
+		case found <- sig:
+		default:
+		}
+	}
+
+	mr := &MatchRule{Type: "signal", Interface: signalIface, Member: signalMember}
+	handler := p.addSignalHandler(mr, func(sig *Message) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if path == "" {
+			// the reply has not been seen yet; keep the signal for later
+			early.Push(sig)
+		} else if sig.Path == path {
+			deliver(sig)
+		}
+	})
+	defer p.removeSignalHandler(handler)
+
+	deadline := p.after(timeout)
+
+	call, e := p.sendAsync(msg)
+	if e != nil {
+		return nil, e
+	}
+
+	select {
+	case <-call.done:
+	case <-deadline:
+		p.cancelCall(call, ErrTimeout)
+	case <-cancel:
+		p.cancelCall(call, ErrCanceled)
+	}
+	<-call.done
+	reply := call.reply
+	if call.err != nil {
+		return nil, call.err
+	}
+
+	if reply.Type == ERROR {
+		return nil, errorFromMessage(reply)
+	}
+
+	mutex.Lock()
+	path = pathFromReply(reply)
+	if path == "" {
+		mutex.Unlock()
+		return nil, os.NewError("No object path in reply")
+	}
+	for v := range early.Iter() {
+		if sig := v.(*Message); sig.Path == path {
+			deliver(sig)
+			break
+		}
+	}
+	early = nil
+	mutex.Unlock()
+
+	select {
+	case sig := <-found:
+		return sig.Params.Data(), nil
+	case <-deadline:
+		return nil, ErrTimeout
+	case <-cancel:
+		return nil, ErrCanceled
+	}
+	return nil, nil
+}
+
+// SubscribeOnce waits for the first signal matching mr and returns it,
+// e.g. to wait for a service to reach a state before going on. The match
+// is added before waiting and removed before returning. It returns
+// ErrCanceled when cancel is closed.
+func (p *Connection) SubscribeOnce(cancel <-chan bool, mr *MatchRule) (*Message, os.Error) {
+	if err := p.checkReady(); err != nil {
+		return nil, err
+	}
+	found := make(chan *Message, 1)
+	handler := p.addSignalHandler(mr, func(sig *Message) {
+		select {
+		case found <- sig:
+		default:
+		}
+	})
+	defer p.removeSignalHandler(handler)
+
+	select {
+	case sig := <-found:
+		return sig, nil
+	case <-cancel:
+		return nil, ErrCanceled
+	}
+	return nil, nil
+}
@@ -0,0 +1,101 @@
+package dbus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSubscribeOnce(t *testing.T) {
+	p := fakeService(t, func(call *Message) *Message {
+		msg := NewMessage()
+		msg.Type = METHOD_RETURN
+		return msg
+	})
+	defer p.Close()
+
+	signal := func(member string) *Message {
+		msg := NewMessage()
+		msg.Type = SIGNAL
+		msg.Path = "/org/example"
+		msg.Iface = "org.example.Foo"
+		msg.Member = member
+		return msg
+	}
+	go func() {
+		// signals only reach handlers added before them
+		for handlerCount(p) == 0 {
+			time.Sleep(1e6)
+		}
+		p.InjectMessage(signal("Other"))
+		p.InjectMessage(signal("StateChanged"))
+	}()
+	msg, e := p.SubscribeOnce(nil, &MatchRule{Type: "signal", Member: "StateChanged"})
+	if e != nil || msg.Member != "StateChanged" {
+		t.Error("#1 Failed", e)
+	}
+	if handlerCount(p) != 0 {
+		t.Error("#2 Failed")
+	}
+
+	cancel := make(chan bool)
+	close(cancel)
+	if _, e = p.SubscribeOnce(cancel, &MatchRule{Type: "signal"}); e != ErrCanceled {
+		t.Error("#3 Failed", e)
+	}
+	if handlerCount(p) != 0 {
+		t.Error("#4 Failed")
+	}
+}
+
+func handlerCount(p *Connection) int {
+	p.handlerMutex.Lock()
+	defer p.handlerMutex.Unlock()
+	return p.signalMatchRules.Len()
+}
+
+func TestCallAndWaitForSignal(t *testing.T) {
+	var p *Connection
+	response := func(path string) *Message {
+		msg := NewMessage()
+		msg.Type = SIGNAL
+		msg.Path = path
+		msg.Iface = "org.example.Request"
+		msg.Member = "Response"
+		msg.Sig = "u"
+		msg.Params.Push(uint32(len(path)))
+		return msg
+	}
+	p = fakeService(t, func(call *Message) *Message {
+		// the signals may arrive before the reply naming the request
+		p.InjectMessage(response("/org/example/request/other"))
+		if call.Params.At(0).(int32) == 1 {
+			p.InjectMessage(response("/org/example/request/1"))
+		}
+		msg := newMethodReturn(call)
+		msg.Sig = "o"
+		msg.Params.Push("/org/example/request/1")
+		return msg
+	})
+	defer p.Close()
+	intro, _ := NewIntrospect(introStr)
+	obj := &Object{dest: "org.freedesktop.Sample", path: "/org/freedesktop/sample_object", intro: intro}
+	iface, _ := p.Interface(obj, "org.freedesktop.SampleInterface")
+	pathFromReply := func(reply *Message) string {
+		path, _ := reply.Params.At(0).(string)
+		return path
+	}
+
+	body, e := p.CallAndWaitForSignal(nil, int64(1e9), iface, "Frobate", "org.example.Request", "Response", pathFromReply, int32(1))
+	if e != nil || len(body) != 1 || body[0].(uint32) != uint32(len("/org/example/request/1")) {
+		t.Error("#1 Failed", body, e)
+	}
+	if handlerCount(p) != 0 {
+		t.Error("#2 Failed")
+	}
+
+	// the signal for another request does not count
+	_, e = p.CallAndWaitForSignal(nil, int64(1e7), iface, "Frobate", "org.example.Request", "Response", pathFromReply, int32(2))
+	if e != ErrTimeout || handlerCount(p) != 0 {
+		t.Error("#3 Failed", e)
+	}
+}
@@ -0,0 +1,355 @@
+package dbus
+
+import (
+	"container/vector"
+	"os"
+	"sort"
+	"strings"
+)
+
+// strategies used by WalkObjects
+const (
+	WALK_INTROSPECT     = "Introspect"
+	WALK_OBJECT_MANAGER = "ObjectManager"
+)
+
+// ObjectTree is the result of WalkObjects.
+type ObjectTree struct {
+	Dest string
+	Root string
+	// Interfaces maps every object path found to the interfaces it
+	// implements.
+	Interfaces map[string][]string
+	// Strategy tells how the tree was found: WALK_OBJECT_MANAGER when one
+	// GetManagedObjects call at Root described it, WALK_INTROSPECT when
+	// every node had to be introspected.
+	Strategy string
+}
+
+// Paths returns the object paths of the tree in sorted order.
+func (p *ObjectTree) Paths() []string {
+	paths := make([]string, len(p.Interfaces))
+	i := 0
+	for path, _ := range p.Interfaces {
+		paths[i] = path
+		i++
+	}
+	sort.SortStrings(paths)
+	return paths
+}
+
+// Refs returns references to the objects of the tree in sorted order.
+func (p *ObjectTree) Refs() []ObjectRef {
+	paths := p.Paths()
+	refs := make([]ObjectRef, len(paths))
+	for i, path := range paths {
+		refs[i] = ObjectRef{p.Dest, path}
+	}
+	return refs
+}
+
+// WalkObjects finds the objects of dest at and below root. If root
+// implements org.freedesktop.DBus.ObjectManager, a single GetManagedObjects
+// call replaces introspecting each object and only root itself is
+// introspected; otherwise the tree is introspected recursively.
+func (p *Connection) WalkObjects(dest string, root string) (*ObjectTree, os.Error) {
+	return p.WalkObjectsCancel(nil, dest, root)
+}
+
+// WalkObjectsCancel is WalkObjects giving up with ErrCanceled when cancel
+// is closed.
+func (p *Connection) WalkObjectsCancel(cancel <-chan bool, dest string, root string) (*ObjectTree, os.Error) {
+	tree := &ObjectTree{dest, root, make(map[string][]string), WALK_OBJECT_MANAGER}
+
+	managed, err := p.getManagedObjects(cancel, dest, root)
+	if err == ErrCanceled {
+		return nil, err
+	}
+	if err == nil {
+		for path, ifaces := range interfacesOfManaged(managed) {
+			tree.Interfaces[path] = ifaces
+		}
+		// the manager itself is not part of its managed objects
+		if _, ok := tree.Interfaces[root]; !ok {
+			intro, err := p.introspect(cancel, dest, root)
+			if err == ErrCanceled {
+				return nil, err
+			}
+			if intro != nil {
+				tree.Interfaces[root] = interfaceNames(intro)
+			}
+		}
+		return tree, nil
+	}
+
+	tree.Strategy = WALK_INTROSPECT
+	if err = p.walkIntrospect(cancel, tree, root); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+func (p *Connection) walkIntrospect(cancel <-chan bool, tree *ObjectTree, path string) os.Error {
+	return p.introspectTree(cancel, tree.Dest, path, func(path string, intro Introspect) {
+		tree.Interfaces[path] = interfaceNames(intro)
+	})
+}
+
+// DumpIntrospection introspects every object of dest, starting at "/" and
+// following the child nodes, and returns the introspection data by path.
+// It gives up with ErrCanceled when cancel is closed.
+func (p *Connection) DumpIntrospection(cancel <-chan bool, dest string) (map[string]Introspect, os.Error) {
+	intros := make(map[string]Introspect)
+	err := p.introspectTree(cancel, dest, "/", func(path string, intro Introspect) {
+		intros[path] = intro
+	})
+	if err != nil {
+		return nil, err
+	}
+	return intros, nil
+}
+
+// introspectTree introspects path of dest and its descendants, calling
+// visit for each.
+func (p *Connection) introspectTree(cancel <-chan bool, dest string, path string, visit func(string, Introspect)) os.Error {
+	intro, err := p.introspect(cancel, dest, path)
+	if err == ErrCanceled {
+		return err
+	}
+	if intro == nil {
+		return os.NewError("cannot introspect " + dest + " " + path)
+	}
+	visit(path, intro)
+	for _, child := range childNames(intro) {
+		if err := p.introspectTree(cancel, dest, childPath(path, child), visit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Connection) getManagedObjects(cancel <-chan bool, dest string, path string) (map[string]map[string]map[string]interface{}, os.Error) {
+	msg := NewMessage()
+	msg.Type = METHOD_CALL
+	msg.Dest = dest
+	msg.Path = path
+	msg.Iface = "org.freedesktop.DBus.ObjectManager"
+	msg.Member = "GetManagedObjects"
+
+	reply, err := p.call(msg, 0, cancel)
+	if err != nil {
+		return nil, err
+	}
+	var managed map[string]map[string]map[string]interface{}
+	if err = p.UnmarshalReply(reply.Params.Data(), &managed); err != nil {
+		return nil, err
+	}
+	return managed, nil
+}
+
+// ManagedObjectFlat is one interface of one object reported by
+// GetManagedObjects, with the values of its properties.
+type ManagedObjectFlat struct {
+	Path       string
+	Interface  string
+	Properties map[string]interface{}
+}
+
+// ManagedObjectList is the result of GetManagedObjectsFlat.
+type ManagedObjectList []ManagedObjectFlat
+
+// FilterBy returns the entries of interface iface.
+func (p ManagedObjectList) FilterBy(iface string) ManagedObjectList {
+	n := 0
+	for _, obj := range p {
+		if obj.Interface == iface {
+			n++
+		}
+	}
+	objs := make(ManagedObjectList, 0, n)
+	for _, obj := range p {
+		if obj.Interface == iface {
+			objs = objs[0 : len(objs)+1]
+			objs[len(objs)-1] = obj
+		}
+	}
+	return objs
+}
+
+// GetManagedObjectsFlat calls GetManagedObjects on the object manager at
+// path of dest and returns one entry per interface of each object, sorted
+// by path and then interface.
+func (p *Connection) GetManagedObjectsFlat(dest string, path string) (ManagedObjectList, os.Error) {
+	managed, err := p.getManagedObjects(nil, dest, path)
+	if err != nil {
+		return nil, err
+	}
+	n := 0
+	for _, ifaces := range managed {
+		n += len(ifaces)
+	}
+	objs := make(ManagedObjectList, 0, n)
+	objects := interfacesOfManaged(managed)
+	paths := new(vector.StringVector)
+	for objPath, _ := range objects {
+		paths.Push(objPath)
+	}
+	for _, objPath := range sortedStrings(paths) {
+		for _, iface := range objects[objPath] {
+			objs = objs[0 : len(objs)+1]
+			objs[len(objs)-1] = ManagedObjectFlat{objPath, iface, managed[objPath][iface]}
+		}
+	}
+	return objs, nil
+}
+
+// TreeNode is an object found by GetRootNode with the nodes below it.
+type TreeNode struct {
+	Path       string
+	Interfaces []string
+	Children   []*TreeNode
+}
+
+// ROOT_NODE_DEPTH is the number of levels below "/" GetRootNode follows.
+const ROOT_NODE_DEPTH = 10
+
+// GetRootNode introspects the objects of dest from "/" down, following the
+// child nodes for up to ROOT_NODE_DEPTH levels, and returns the tree. It
+// gives up with ErrCanceled when cancel is closed.
+func (p *Connection) GetRootNode(cancel <-chan bool, dest string) (*TreeNode, os.Error) {
+	return p.GetRootNodeDepth(cancel, dest, ROOT_NODE_DEPTH)
+}
+
+// GetRootNodeDepth is GetRootNode following at most depth levels below
+// "/". Only an error introspecting "/" is returned; a child that cannot be
+// introspected is left out of the tree with its descendants.
+func (p *Connection) GetRootNodeDepth(cancel <-chan bool, dest string, depth int) (*TreeNode, os.Error) {
+	return p.treeNode(cancel, dest, "/", depth)
+}
+
+func (p *Connection) treeNode(cancel <-chan bool, dest string, path string, depth int) (*TreeNode, os.Error) {
+	intro, err := p.introspect(cancel, dest, path)
+	if err != nil {
+		return nil, err
+	}
+	node := &TreeNode{Path: path, Interfaces: interfaceNames(intro)}
+	if depth <= 0 {
+		return node, nil
+	}
+	children := childNames(intro)
+	node.Children = make([]*TreeNode, 0, len(children))
+	for _, child := range children {
+		sub, err := p.treeNode(cancel, dest, childPath(path, child), depth-1)
+		if err == ErrCanceled {
+			return nil, err
+		}
+		if err != nil {
+			p.logf("cannot introspect %s %s: %s", dest, childPath(path, child), err)
+			continue
+		}
+		node.Children = node.Children[0 : len(node.Children)+1]
+		node.Children[len(node.Children)-1] = sub
+	}
+	return node, nil
+}
+
+// at most this many Introspect calls of GetChildObjects are in flight
+const CHILD_OBJECTS_CONCURRENCY = 8
+
+// GetChildObjects introspects rootPath of dest and, recursively, its child
+// nodes, and returns an introspected Object for every descendant of
+// rootPath, sorted by path. The nodes of each level are introspected
+// concurrently, with at most CHILD_OBJECTS_CONCURRENCY calls at a time. A
+// child that cannot be introspected is logged and left out with its
+// descendants; only an error introspecting rootPath is returned.
+func (p *Connection) GetChildObjects(dest string, rootPath string) ([]*Object, os.Error) {
+	root, err := p.introspect(nil, dest, rootPath)
+	if err != nil {
+		return nil, err
+	}
+	byPath := make(map[string]*Object)
+	paths := new(vector.StringVector)
+	parents := []*Object{&Object{dest: dest, path: rootPath, intro: root}}
+	for len(parents) != 0 {
+		level := new(vector.StringVector)
+		for _, parent := range parents {
+			for _, child := range childNames(parent.intro) {
+				level.Push(childPath(parent.path, child))
+			}
+		}
+		objs := p.introspectAll(dest, level.Data())
+		parents = make([]*Object, 0, len(objs))
+		for _, obj := range objs {
+			if obj != nil {
+				byPath[obj.path] = obj
+				paths.Push(obj.path)
+				parents = parents[0 : len(parents)+1]
+				parents[len(parents)-1] = obj
+			}
+		}
+	}
+	sorted := sortedStrings(paths)
+	objs := make([]*Object, len(sorted))
+	for i, path := range sorted {
+		objs[i] = byPath[path]
+	}
+	return objs, nil
+}
+
+// introspectAll introspects paths of dest concurrently. The object of a
+// path that cannot be introspected is nil.
+func (p *Connection) introspectAll(dest string, paths []string) []*Object {
+	objs := make([]*Object, len(paths))
+	work := make(chan int, len(paths))
+	for i := range paths {
+		work <- i
+	}
+	close(work)
+	done := make(chan bool)
+	workers := CHILD_OBJECTS_CONCURRENCY
+	if len(paths) < workers {
+		workers = len(paths)
+	}
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range work {
+				intro, err := p.introspect(nil, dest, paths[i])
+				if err != nil {
+					p.logf("cannot introspect %s %s: %s", dest, paths[i], err)
+					continue
+				}
+				objs[i] = &Object{dest: dest, path: paths[i], intro: intro}
+			}
+			done <- true
+		}()
+	}
+	for w := 0; w < workers; w++ {
+		<-done
+	}
+	return objs
+}
+
+// interfacesOfManaged turns a GetManagedObjects reply into sorted interface
+// lists per path.
+func interfacesOfManaged(managed map[string]map[string]map[string]interface{}) map[string][]string {
+	objects := make(map[string][]string)
+	for path, ifaces := range managed {
+		names := make([]string, len(ifaces))
+		i := 0
+		for name, _ := range ifaces {
+			names[i] = name
+			i++
+		}
+		sort.SortStrings(names)
+		objects[path] = names
+	}
+	return objects
+}
+
+// childPath returns the path of node child of parent.
+func childPath(parent string, child string) string {
+	if strings.HasSuffix(parent, "/") {
+		return parent + child
+	}
+	return parent + "/" + child
+}
@@ -0,0 +1,212 @@
+package dbus
+
+import (
+	"container/vector"
+	"testing"
+)
+
+func TestChildPath(t *testing.T) {
+	if "/a" != childPath("/", "a") {
+		t.Error("#1 Failed")
+	}
+	if "/a/b" != childPath("/a", "b") {
+		t.Error("#2 Failed")
+	}
+}
+
+func TestInterfacesOfManaged(t *testing.T) {
+	managed := map[string]map[string]map[string]interface{}{
+		"/org/bluez/hci0": map[string]map[string]interface{}{
+			"org.bluez.Adapter1":              map[string]interface{}{"Powered": true},
+			"org.freedesktop.DBus.Properties": map[string]interface{}{},
+		},
+		"/org/bluez/hci0/dev_00": map[string]map[string]interface{}{
+			"org.bluez.Device1": map[string]interface{}{},
+		},
+	}
+	objects := interfacesOfManaged(managed)
+	if len(objects) != 2 {
+		t.Fatal("#1 Failed", objects)
+	}
+	ifaces := objects["/org/bluez/hci0"]
+	if len(ifaces) != 2 || ifaces[0] != "org.bluez.Adapter1" {
+		t.Error("#2 Failed", ifaces)
+	}
+
+	tree := &ObjectTree{"org.bluez", "/", objects, WALK_OBJECT_MANAGER}
+	paths := tree.Paths()
+	if len(paths) != 2 || paths[0] != "/org/bluez/hci0" || paths[1] != "/org/bluez/hci0/dev_00" {
+		t.Error("#3 Failed", paths)
+	}
+}
+
+func TestChildNames(t *testing.T) {
+	intro, _ := NewIntrospect(introStr)
+	names := childNames(intro)
+	if len(names) != 2 || names[0] != "child_of_sample_object" {
+		t.Error("#1 Failed", names)
+	}
+}
+
+func TestDumpIntrospection(t *testing.T) {
+	server, p, e := NewMockServer()
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	defer p.Close()
+	nodes := map[string]string{
+		"/":      `<node><node name="org"/></node>`,
+		"/org":   `<node><interface name="org.example.Root"/><node name="a"/><node name="b"/></node>`,
+		"/org/a": `<node><interface name="org.example.A"/></node>`,
+		"/org/b": `<node/>`,
+	}
+	for path, xml := range nodes {
+		reply := NewMessage()
+		reply.Sig = "s"
+		reply.Params.Push(xml)
+		path := path
+		server.Expect(func(call *Message) bool {
+			return call.Member == "Introspect" && call.Path == path
+		}, reply)
+	}
+
+	intros, e := p.DumpIntrospection(nil, "org.example")
+	if e != nil {
+		t.Fatal("#2 Failed", e)
+	}
+	if len(intros) != 4 {
+		t.Error("#3 Failed", intros)
+	}
+	if _, ok := intros["/org/a"].Interface("org.example.A"); !ok {
+		t.Error("#4 Failed")
+	}
+	if children := intros["/org"].Children(); len(children) != 2 {
+		t.Error("#5 Failed", children)
+	}
+
+	if _, e := p.DumpIntrospection(CancelAfter(0), "org.example"); e != ErrCanceled {
+		t.Error("#6 Failed", e)
+	}
+}
+
+func TestGetRootNode(t *testing.T) {
+	server, p, e := NewMockServer()
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	defer p.Close()
+	// /org/b is not answered
+	nodes := map[string]string{
+		"/":        `<node><node name="org"/></node>`,
+		"/org":     `<node><interface name="org.example.Root"/><node name="a"/><node name="b"/></node>`,
+		"/org/a":   `<node><interface name="org.example.A"/><node name="c"/></node>`,
+		"/org/a/c": `<node/>`,
+	}
+	for path, xml := range nodes {
+		reply := NewMessage()
+		reply.Sig = "s"
+		reply.Params.Push(xml)
+		path := path
+		server.Expect(func(call *Message) bool {
+			return call.Member == "Introspect" && call.Path == path
+		}, reply)
+	}
+
+	root, e := p.GetRootNode(nil, "org.example")
+	if e != nil {
+		t.Fatal("#2 Failed", e)
+	}
+	if root.Path != "/" || len(root.Children) != 1 {
+		t.Fatal("#3 Failed", root)
+	}
+	org := root.Children[0]
+	if org.Path != "/org" || len(org.Interfaces) != 1 || org.Interfaces[0] != "org.example.Root" || len(org.Children) != 1 {
+		t.Fatal("#4 Failed", org)
+	}
+	if a := org.Children[0]; a.Path != "/org/a" || len(a.Children) != 1 || a.Children[0].Path != "/org/a/c" {
+		t.Error("#5 Failed", a)
+	}
+
+	root, e = p.GetRootNodeDepth(nil, "org.example", 1)
+	if e != nil || len(root.Children) != 1 || len(root.Children[0].Children) != 0 {
+		t.Error("#6 Failed", root, e)
+	}
+	if _, e = p.GetRootNode(CancelAfter(0), "org.example"); e != ErrCanceled {
+		t.Error("#7 Failed", e)
+	}
+}
+
+func TestGetChildObjects(t *testing.T) {
+	server, p, e := NewMockServer()
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	defer p.Close()
+	// /org/b is not answered
+	nodes := map[string]string{
+		"/org":     `<node><node name="a"/><node name="b"/></node>`,
+		"/org/a":   `<node><interface name="org.example.A"/><node name="c"/></node>`,
+		"/org/a/c": `<node><interface name="org.example.C"/></node>`,
+	}
+	for path, xml := range nodes {
+		reply := NewMessage()
+		reply.Sig = "s"
+		reply.Params.Push(xml)
+		path := path
+		server.Expect(func(call *Message) bool {
+			return call.Member == "Introspect" && call.Path == path
+		}, reply)
+	}
+
+	objs, e := p.GetChildObjects("org.example", "/org")
+	if e != nil {
+		t.Fatal("#2 Failed", e)
+	}
+	if len(objs) != 2 || objs[0].path != "/org/a" || objs[1].path != "/org/a/c" {
+		t.Fatal("#3 Failed", objs)
+	}
+	if _, ok := objs[1].intro.Interface("org.example.C"); !ok || objs[1].dest != "org.example" {
+		t.Error("#4 Failed", objs[1])
+	}
+
+	if _, e := p.GetChildObjects("org.example", "/missing"); e == nil {
+		t.Error("#5 Failed")
+	}
+}
+
+func TestGetManagedObjectsFlat(t *testing.T) {
+	server, p, e := NewMockServer()
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	defer p.Close()
+	object := func(path string, iface string, name string, value interface{}) []interface{} {
+		props := new(vector.Vector)
+		props.Push([]interface{}{name, value})
+		ifaces := new(vector.Vector)
+		ifaces.Push([]interface{}{iface, props})
+		ifaces.Push([]interface{}{"org.freedesktop.DBus.Properties", new(vector.Vector)})
+		return []interface{}{path, ifaces}
+	}
+	objects := new(vector.Vector)
+	objects.Push(object("/org/bluez/hci0/dev_01", "org.bluez.Device1", "Connected", Variant{"b", false}))
+	objects.Push(object("/org/bluez/hci0/dev_00", "org.bluez.Device1", "Connected", Variant{"b", true}))
+	objects.Push(object("/org/bluez/hci0", "org.bluez.Adapter1", "Powered", Variant{"b", true}))
+	reply := NewMessage()
+	reply.Sig = "a{oa{sa{sv}}}"
+	reply.Params.Push(objects)
+	server.Expect(MatchMethod("org.freedesktop.DBus.ObjectManager", "GetManagedObjects"), reply)
+
+	objs, e := p.GetManagedObjectsFlat("org.bluez", "/")
+	if e != nil {
+		t.Fatal("#2 Failed", e)
+	}
+	if len(objs) != 6 || objs[0].Path != "/org/bluez/hci0" || objs[0].Interface != "org.bluez.Adapter1" ||
+		objs[1].Interface != "org.freedesktop.DBus.Properties" {
+		t.Error("#3 Failed", objs)
+	}
+	devices := objs.FilterBy("org.bluez.Device1")
+	if len(devices) != 2 || devices[0].Path != "/org/bluez/hci0/dev_00" || devices[0].Properties["Connected"] != true {
+		t.Error("#4 Failed", devices)
+	}
+}
@@ -0,0 +1,141 @@
+package dbus
+
+import (
+	"os"
+	"sync"
+)
+
+// loopState tracks the progress of the run loop. It has its own mutex so
+// that stamping the loop does not contend with callers and handlers.
+type loopState struct {
+	mutex    sync.Mutex
+	stamp    int64 // when the loop last took or finished a message
+	queued   int   // messages waiting for the loop
+	queuedAt int64 // when the oldest of them started waiting
+	busy     bool  // the loop is dispatching a message
+	stop     chan bool
+}
+
+// enqueue hands msg to the run loop, counting it as queued until the loop
+// takes it.
+func (p *Connection) enqueue(msg *Message) {
+	now := p.now()
+	p.loop.mutex.Lock()
+	if p.loop.queued == 0 {
+		p.loop.queuedAt = now
+	}
+	p.loop.queued++
+	p.loop.mutex.Unlock()
+	p.msgChan <- msg
+}
+
+// progress records that the loop took a message (taken) or finished
+// dispatching one.
+func (p *Connection) progress(taken bool) {
+	now := p.now()
+	p.loop.mutex.Lock()
+	p.loop.stamp = now
+	p.loop.busy = taken
+	if taken {
+		p.loop.queued--
+		p.loop.queuedAt = now
+	}
+	p.loop.mutex.Unlock()
+}
+
+// DISPATCH_IDLE_QUIET is how long, in nanoseconds, DispatchUntilIdle waits
+// for more messages once the run loop is idle.
+const DISPATCH_IDLE_QUIET = 10e6
+
+// DispatchUntilIdle waits until the run loop has dispatched the messages
+// received so far and no other message has arrived for
+// DISPATCH_IDLE_QUIET nanoseconds, for tests that check the state left by
+// signal handlers. Handlers running on their own goroutines, such as
+// method handlers, are not waited for. It gives up with ErrCanceled when
+// cancel is closed, and returns the error of a failed connection.
+func (p *Connection) DispatchUntilIdle(cancel <-chan bool) os.Error {
+	return p.DispatchUntilIdleFor(cancel, DISPATCH_IDLE_QUIET)
+}
+
+// DispatchUntilIdleFor is DispatchUntilIdle waiting quiet nanoseconds for
+// more messages.
+func (p *Connection) DispatchUntilIdleFor(cancel <-chan bool, quiet int64) os.Error {
+	poll := int64(1e6)
+	if 0 < quiet && quiet < poll {
+		poll = quiet
+	}
+	for {
+		if err := p.Err(); err != nil {
+			return err
+		}
+		now := p.now()
+		p.loop.mutex.Lock()
+		idle := p.loop.queued == 0 && !p.loop.busy && quiet <= now-p.loop.stamp
+		p.loop.mutex.Unlock()
+		if idle {
+			return nil
+		}
+		select {
+		case <-cancel:
+			return ErrCanceled
+		case <-p.after(poll):
+		}
+	}
+	return nil
+}
+
+// stallAge returns for how long messages have been waiting without the
+// loop making progress, or 0 if none are waiting.
+func (p *Connection) stallAge() int64 {
+	now := p.now()
+	p.loop.mutex.Lock()
+	defer p.loop.mutex.Unlock()
+	if p.loop.queued == 0 {
+		return 0
+	}
+	since := p.loop.stamp
+	if since < p.loop.queuedAt {
+		since = p.loop.queuedAt
+	}
+	return now - since
+}
+
+// Healthy reports whether the run loop is keeping up: it is false when
+// messages have waited for more than maxAge nanoseconds without the loop
+// making progress, e.g. because a handler is blocked. An idle loop is
+// healthy.
+func (p *Connection) Healthy(maxAge int64) bool {
+	return p.stallAge() <= maxAge
+}
+
+// SetWatchdog checks the run loop every interval nanoseconds and calls
+// onStall, in its own goroutine, with the stall age whenever the loop is
+// not Healthy(interval). The watchdog stops when the connection fails.
+// A previous watchdog is replaced; an interval of 0 just stops it.
+func (p *Connection) SetWatchdog(interval int64, onStall func(age int64)) {
+	p.loop.mutex.Lock()
+	if p.loop.stop != nil {
+		close(p.loop.stop)
+		p.loop.stop = nil
+	}
+	if interval <= 0 || onStall == nil {
+		p.loop.mutex.Unlock()
+		return
+	}
+	stop := make(chan bool)
+	p.loop.stop = stop
+	p.loop.mutex.Unlock()
+
+	go func() {
+		for p.Err() == nil {
+			select {
+			case <-stop:
+				return
+			case <-p.after(interval):
+			}
+			if age := p.stallAge(); interval < age {
+				go onStall(age)
+			}
+		}
+	}()
+}
@@ -0,0 +1,83 @@
+package dbus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWatchdog(t *testing.T) {
+	p := fakeService(t, func(call *Message) *Message {
+		msg := NewMessage()
+		msg.Type = METHOD_RETURN
+		return msg
+	})
+	defer p.Close()
+	if !p.Healthy(0) {
+		t.Error("#1 Failed: an idle loop is healthy")
+	}
+
+	release := make(chan bool)
+	p.signalMatchRules.Push(&signalHandler{mr: MatchRule{Type: "signal"}, proc: func(*Message) { <-release }})
+	stalls := make(chan int64, 10)
+	p.SetWatchdog(5e6, func(age int64) { stalls <- age })
+
+	signal := NewMessage()
+	signal.Type = SIGNAL
+	p.InjectMessage(signal)    // blocks the handler
+	go p.InjectMessage(signal) // waits for the loop
+
+	select {
+	case age := <-stalls:
+		if age <= 5e6 {
+			t.Error("#2 Failed", age)
+		}
+	case <-after(1e9):
+		t.Fatal("#3 Failed: the watchdog did not fire")
+	}
+	if p.Healthy(5e6) {
+		t.Error("#4 Failed")
+	}
+
+	p.SetWatchdog(0, nil)
+	close(release)
+	for i := 0; !p.Healthy(0) && i < 100; i++ {
+		time.Sleep(1e6)
+	}
+	if !p.Healthy(0) {
+		t.Error("#5 Failed")
+	}
+}
+
+func TestDispatchUntilIdle(t *testing.T) {
+	server, p, e := NewMockServer()
+	if e != nil {
+		t.Fatal("#1 Failed", e)
+	}
+	defer p.Close()
+	count := 0
+	p.AddSignalHandler(&MatchRule{Type: "signal", Interface: "org.example.Foo"}, func(*Message) {
+		time.Sleep(5e6)
+		count++
+	})
+	for i := 0; i < 3; i++ {
+		signal := NewMessage()
+		signal.Type = SIGNAL
+		signal.Path = "/org/example"
+		signal.Iface = "org.example.Foo"
+		signal.Member = "Changed"
+		if e = server.Emit(signal); e != nil {
+			t.Fatal("#2 Failed", e)
+		}
+	}
+	if e = p.DispatchUntilIdle(CancelAfter(1e9)); e != nil {
+		t.Fatal("#3 Failed", e)
+	}
+	if count != 3 {
+		t.Error("#4 Failed", count)
+	}
+
+	p.SetTestClock(func() int64 { return 0 }, nil)
+	if e = p.DispatchUntilIdleFor(CancelAfter(0), 1e9); e != ErrCanceled {
+		t.Error("#5 Failed", e)
+	}
+}
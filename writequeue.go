@@ -0,0 +1,114 @@
+package dbus
+
+import (
+	"os"
+)
+
+var ErrWriteQueueFull = os.NewError("Write queue full")
+
+// SetWriteQueueSize makes the connection hand the messages it sends to a
+// writer goroutine through a queue of n messages, so a sender does not wait
+// while the bus is slow to read. A message sent while the queue is full is
+// not sent; ErrWriteQueueFull is returned instead. A failed write fails
+// the connection. 0, the default, writes each message before returning.
+// Messages queued before the size changes are written first. The queue
+// holds up to n messages of each priority; see SendWithPriority.
+func (p *Connection) SetWriteQueueSize(n int) {
+	p.writeQueueMutex.Lock()
+	if p.Err() != nil {
+		p.writeQueueMutex.Unlock()
+		return
+	}
+	prev := p.writeDone
+	p.closeWriteQueue()
+	if 0 < n {
+		p.writeQueue = make(chan []byte, n)
+		p.writeQueueHigh = make(chan []byte, n)
+		p.writeDone = make(chan bool)
+		go p.writeLoop(p.writeQueue, p.writeQueueHigh, prev, p.writeDone)
+	}
+	p.writeQueueMutex.Unlock()
+	if n <= 0 && prev != nil {
+		<-prev
+	}
+}
+
+// enqueueWrite queues buff if a write queue is enabled; queued is false if
+// it is not.
+func (p *Connection) enqueueWrite(buff []byte, priority uint8) (queued bool, err os.Error) {
+	p.writeQueueMutex.RLock()
+	defer p.writeQueueMutex.RUnlock()
+	if p.writeQueue == nil {
+		return false, nil
+	}
+	if priority == PRIORITY_HIGH {
+		select {
+		case p.writeQueueHigh <- buff:
+		default:
+			return true, ErrWriteQueueFull
+		}
+		// wake the writer; if the queue is full, the writer is busy and
+		// looks at the high priority queue before its next message anyway
+		select {
+		case p.writeQueue <- nil:
+		default:
+		}
+		return true, nil
+	}
+	select {
+	case p.writeQueue <- buff:
+		return true, nil
+	default:
+	}
+	return true, ErrWriteQueueFull
+}
+
+// writeLoop writes the messages of queue once the writer of the previous
+// queue, if any, is done, writing those of high first. A nil message in
+// queue only wakes the writer.
+func (p *Connection) writeLoop(queue <-chan []byte, high <-chan []byte, prev <-chan bool, done chan bool) {
+	defer close(done)
+	if prev != nil {
+		<-prev
+	}
+	for buff := range queue {
+		p.writeQueued(high)
+		if buff != nil {
+			if err := p.writeNow(buff); err != nil {
+				p.fail(err)
+			}
+		}
+	}
+	p.writeQueued(high)
+}
+
+// writeQueued writes the messages waiting in queue.
+func (p *Connection) writeQueued(queue <-chan []byte) {
+	for {
+		select {
+		case buff := <-queue:
+			if err := p.writeNow(buff); err != nil {
+				p.fail(err)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// stopWriteQueue lets the writer exit without waiting for it.
+func (p *Connection) stopWriteQueue() {
+	p.writeQueueMutex.Lock()
+	p.closeWriteQueue()
+	p.writeQueueMutex.Unlock()
+}
+
+// closeWriteQueue is called under writeQueueMutex.
+func (p *Connection) closeWriteQueue() {
+	if p.writeQueue != nil {
+		close(p.writeQueue)
+	}
+	p.writeQueue = nil
+	p.writeQueueHigh = nil // drained by the writer once writeQueue is closed
+	p.writeDone = nil
+}
@@ -0,0 +1,122 @@
+package dbus
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteQueue(t *testing.T) {
+	p, server := dialTest(t)
+	defer p.Close()
+	defer server.Close()
+	p.ready = true
+
+	signal := func() *Message {
+		msg := NewMessage()
+		msg.Type = SIGNAL
+		msg.Path = "/org/example"
+		msg.Iface = "org.example.Foo"
+		msg.Member = "Bar"
+		return msg
+	}
+
+	// a write in progress holds the writer up
+	p.SetWriteQueueSize(2)
+	p.writeMutex.Lock()
+	serials := make([]uint32, 0, 4)
+	full := false
+	for i := 0; i < 4 && !full; i++ {
+		msg := signal()
+		switch e := p.send(msg); e {
+		case nil:
+			serials = serials[0 : len(serials)+1]
+			serials[len(serials)-1] = msg.Serial()
+		case ErrWriteQueueFull:
+			full = true
+		default:
+			t.Fatal("#4 Failed", e)
+		}
+	}
+	if !full || len(serials) < 2 {
+		t.Error("#5 Failed", serials)
+	}
+	p.writeMutex.Unlock()
+
+	// the queued messages are written in order, before later ones
+	p.SetWriteQueueSize(0)
+	last := signal()
+	if e := p.send(last); e != nil {
+		t.Error("#6 Failed", e)
+	}
+	buff := bytes.NewBuffer([]byte{})
+	for i := 0; i <= len(serials); {
+		msg, n, e := DecodeMessage(buff.Bytes())
+		if e == nil {
+			want := last.Serial()
+			if i < len(serials) {
+				want = serials[i]
+			}
+			if msg.Serial() != want {
+				t.Error("#7 Failed", i, msg.Serial(), want)
+			}
+			buff.Next(n)
+			i++
+			continue
+		}
+		chunk := make([]byte, 256)
+		m, e := server.Read(chunk)
+		if e != nil {
+			t.Fatal("#8 Failed", e)
+		}
+		buff.Write(chunk[0:m])
+	}
+}
+
+func TestSendWithPriority(t *testing.T) {
+	p, server := dialTest(t)
+	defer p.Close()
+	defer server.Close()
+	p.ready = true
+
+	signal := func(member string, priority uint8) *Message {
+		msg := NewMessage()
+		msg.Type = SIGNAL
+		msg.Path = "/org/example"
+		msg.Iface = "org.example.Foo"
+		msg.Member = member
+		msg.Priority = priority
+		return msg
+	}
+
+	// the writer is held up while the messages are queued
+	p.SetWriteQueueSize(4)
+	p.writeMutex.Lock()
+	for _, msg := range []*Message{signal("A", PRIORITY_NORMAL), signal("B", PRIORITY_NORMAL),
+		signal("C", PRIORITY_NORMAL), signal("H", PRIORITY_HIGH)} {
+		if e := p.SendWithPriority(msg); e != nil {
+			t.Fatal("#4 Failed", msg.Member, e)
+		}
+	}
+	p.writeMutex.Unlock()
+
+	// A may be taken by the writer before H is queued
+	order := ""
+	buff := bytes.NewBuffer([]byte{})
+	for len(order) < 4 {
+		msg, n, e := DecodeMessage(buff.Bytes())
+		if e == nil {
+			order += msg.Member
+			buff.Next(n)
+			continue
+		}
+		chunk := make([]byte, 256)
+		m, e := server.Read(chunk)
+		if e != nil {
+			t.Fatal("#5 Failed", e)
+		}
+		buff.Write(chunk[0:m])
+	}
+	if order != "HABC" && order != "AHBC" {
+		t.Error("#6 Failed", order)
+	}
+}